@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+	"github.com/Deali-Axy/ebook-generator/pkg/analytics"
+)
+
+// runBatch 使用相同的参数转换多个txt, 返回退出码, 有文件失败时返回core.ExitConvert
+func runBatch(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	opts := batch.Options{Version: version}
+	book := bookFlags(fs)
+	fs.StringVar(&opts.OutDir, "out-dir", "", "输出目录, 按输入目录的结构生成子目录, 不填时输出到txt所在的目录")
+	fs.IntVar(&opts.Workers, "workers", 0, "同时转换的文件数, 默认为CPU核数")
+	fs.BoolVar(&opts.Force, "force", false, "输出文件比txt新时也重新转换")
+	report := fs.String("report", "", "把JSON格式的汇总写入文件")
+	asJSON := fs.Bool("json", false, "不输出转换过程, 在标准输出输出JSON格式的汇总, 格式和-report相同")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli batch [选项] 目录、txt或\"*.txt\"...")
+		fmt.Fprintln(fs.Output(), "目录会转换其中和子目录中所有的txt, 书名和输出文件名从各自的文件名识别, -bookname和-out不起作用")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	opts.Book = *book
+	opts.Inputs = fs.Args()
+	if *asJSON {
+		opts.Book.Log = utils.NopLogger{}
+	}
+	var done int
+	opts.Progress = func(r batch.Result) {
+		if *asJSON {
+			return
+		}
+		done++
+		if r.Status == batch.StatusFailed {
+			fmt.Printf("[%d] 失败: %s: %s\n", done, r.File, r.Error)
+			return
+		}
+		fmt.Printf("[%d] %s: %s\n", done, r.Status, r.File)
+	}
+	analytics.Analytics(version, secret, measurement, book.Format)
+
+	// Ctrl+C时不再开始新的文件
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary, err := batch.Run(ctx, opts)
+	if err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	if *asJSON {
+		summary.WriteJSON(os.Stdout)
+	} else {
+		fmt.Println()
+		summary.WriteText(os.Stdout)
+	}
+	if *report != "" {
+		f, err := os.Create(*report)
+		if err != nil {
+			fmt.Printf("错误: 保存汇总失败: %s\n", err.Error())
+			return core.ExitConvert
+		}
+		defer f.Close()
+		if err := summary.WriteJSON(f); err != nil {
+			fmt.Printf("错误: 保存汇总失败: %s\n", err.Error())
+			return core.ExitConvert
+		}
+	}
+	if summary.Failed > 0 {
+		return core.ExitConvert
+	}
+	return core.ExitOK
+}
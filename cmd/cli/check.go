@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/epubcheck"
+)
+
+// runCheck 检查epub是否符合规范, 返回退出码: 0 通过, 1 有错误, 2 无法读取文件
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "以json格式输出检查结果")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli check [-json] 书名.epub...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	code := 0
+	results := make(map[string]*epubcheck.Report)
+	for _, path := range fs.Args() {
+		report, err := epubcheck.CheckFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+			code = 2
+			continue
+		}
+		if !report.Valid() && code == 0 {
+			code = 1
+		}
+		if *asJSON {
+			results[path] = report
+			continue
+		}
+		printReport(path, report)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	}
+	return code
+}
+
+func printReport(path string, report *epubcheck.Report) {
+	for _, f := range report.Findings {
+		fmt.Printf("%s: %s\n", path, f.String())
+	}
+	errs, warnings := len(report.Errors()), len(report.Warnings())
+	if errs == 0 {
+		fmt.Printf("%s: 检查通过, EPUB %s, %d个警告\n", path, report.Version, warnings)
+		return
+	}
+	fmt.Printf("%s: 检查未通过, %d个错误, %d个警告\n", path, errs, warnings)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+)
+
+// runConvert 把txt转换为电子书, 和不带子命令时相同, 文件名可以用-filename或放在最后
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	book := bookFlags(fs)
+	asJSON := jsonFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli convert [选项] ebook.txt")
+		fmt.Fprintln(fs.Output(), "退出码: 0成功 1参数错误 2检查文件和参数失败 3识别章节失败 4生成电子书失败 5转换被中断")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return core.ExitOK
+		}
+		return core.ExitArgs
+	}
+	if fs.NArg() == 1 {
+		book.Filename = fs.Arg(0)
+	}
+	return convertFile(book, *asJSON)
+}
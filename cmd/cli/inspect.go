@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+)
+
+// runInspect 分析txt的编码、字数和章节识别情况, 返回退出码
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	book := bookFlags(fs)
+	asJSON := fs.Bool("json", false, "输出JSON格式的结果")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli inspect [选项] ebook.txt")
+		fmt.Fprintln(fs.Output(), "显示txt的编码、字数、识别出的卷和章节数、每条规则匹配的标题数, 以及最长和最短的章节")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 1 {
+		book.Filename = fs.Arg(0)
+	}
+	book.Tips = false
+	if code := parseQuiet(book, true); code != core.ExitOK {
+		return code
+	}
+	result, err := core.Inspect(book)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitParse
+	}
+
+	if *asJSON {
+		bs, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(bs))
+		return core.ExitOK
+	}
+	fmt.Println("文件名:\t", result.Filename)
+	fmt.Println("大小:\t", result.Size)
+	fmt.Println("编码:\t", result.Encoding)
+	fmt.Println("段落数:\t", result.Paragraphs)
+	fmt.Println("字数:\t", result.Words)
+	fmt.Printf("识别到:\t %d卷%d章, 每章平均%d字\n", result.Volumes, result.Chapters, result.Average)
+	fmt.Println("\n匹配的规则:")
+	for _, p := range result.Patterns {
+		if p.Count == 0 {
+			continue
+		}
+		fmt.Printf("  %5d  %s", p.Count, p.Pattern)
+		if p.Example != "" {
+			fmt.Printf("  例: %s", p.Example)
+		}
+		fmt.Println()
+	}
+	fmt.Println("\n最长的章节(可能漏掉了标题):")
+	for _, c := range result.Longest {
+		fmt.Printf("  %8d字  %s\n", c.Words, c.Title)
+	}
+	fmt.Println("\n最短的章节(可能把正文识别成了标题):")
+	for _, c := range result.Shortest {
+		fmt.Printf("  %8d字  %s\n", c.Words, c.Title)
+	}
+	return core.ExitOK
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
 	"time"
@@ -21,77 +24,168 @@ var (
 	version     string
 )
 
-func NewBookArgs() *model.Book {
+// bookFlags 在fs中注册生成书籍的参数
+func bookFlags(fs *flag.FlagSet) *model.Book {
 	var book model.Book
-	flag.StringVar(&book.Filename, "filename", "", "txt 文件名")
-	flag.StringVar(&book.Bookname, "bookname", "", "书名: 默认为txt文件名")
-	flag.StringVar(&book.Author, "author", "YSTYLE", "作者")
-	flag.StringVar(&book.Match, "match", "", "匹配标题的正则表达式, 不写可以自动识别, 如果没生成章节就参考教程。例: -match 第.{1,8}章 表示第和章字之间可以有1-8个任意文字")
-	flag.StringVar(&book.VolumeMatch, "volume-match", model.VolumeMatch, "卷匹配规则,设置为false可以禁用卷识别")
-	flag.StringVar(&book.ExclusionPattern, "exclude", model.DefaultExclusion, "排除无效章节/卷的正则表达式")
-	flag.StringVar(&book.UnknowTitle, "unknow-title", "章节正文", "未知章节默认名称")
-	flag.StringVar(&book.Cover, "cover", "cover.png", "封面图片可为: 本地图片, 和orly。 设置为orly时生成orly风格的封面, 需要连接网络。")
-	flag.StringVar(&book.CoverOrlyColor, "cover-orly-color", "", "orly封面的主题色, 可以为1-16和hex格式的颜色代码, 不填时随机")
-	flag.IntVar(&book.CoverOrlyIdx, "cover-orly-idx", -1, "orly封面的动物, 可以为0-41, 不填时随机, 具体图案可以查看: https://orly.nanmu.me")
-	flag.UintVar(&book.Max, "max", 35, "标题最大字数")
-	flag.UintVar(&book.Indent, "indent", 2, "段落缩进字数")
-	flag.StringVar(&book.Align, "align", utils.GetEnv("KAF_CLI_ALIGN", "center"), "标题对齐方式: left、center、righ。环境变量KAF_CLI_ALIGN可修改默认值")
-	flag.StringVar(&book.Bottom, "bottom", "1em", "段落间距(单位可以为em、px)")
-	flag.StringVar(&book.LineHeight, "line-height", "", "行高(用于设置行间距, 默认为1.5rem)")
-	flag.StringVar(&book.Font, "font", "", "嵌入字体, 之后epub的正文都将使用该字体")
-	flag.StringVar(&book.Lang, "lang", utils.GetEnv("KAF_CLI_LANG", "zh"), "设置语言: en,de,fr,it,es,zh,ja,pt,ru,nl。环境变量KAF_CLI_LANG可修改默认值")
-	flag.StringVar(&book.Format, "format", utils.GetEnv("KAF_CLI_FORMAT", "all"), "书籍格式: all、epub、mobi、azw3。环境变量KAF_CLI_FORMAT可修改默认值")
-	flag.StringVar(&book.Out, "out", "", "输出文件名，不需要包含格式后缀")
-	flag.BoolVar(&book.Tips, "tips", true, "添加本软件教程")
-	flag.Parse()
+	fs.StringVar(&book.Filename, "filename", "", "txt 文件名")
+	fs.StringVar(&book.Bookname, "bookname", "", "书名: 默认为txt文件名")
+	fs.StringVar(&book.Author, "author", "YSTYLE", "作者")
+	fs.StringVar(&book.Description, "description", "", "简介")
+	fs.StringVar(&book.Match, "match", "", "匹配标题的正则表达式, 不写可以自动识别, 如果没生成章节就参考教程。例: -match 第.{1,8}章 表示第和章字之间可以有1-8个任意文字")
+	fs.StringVar(&book.VolumeMatch, "volume-match", model.VolumeMatch, "卷匹配规则,设置为false可以禁用卷识别")
+	fs.StringVar(&book.ExclusionPattern, "exclude", model.DefaultExclusion, "排除无效章节/卷的正则表达式")
+	fs.StringVar(&book.UnknowTitle, "unknow-title", "章节正文", "未知章节默认名称")
+	fs.StringVar(&book.Cover, "cover", "cover.png", "封面图片可为: 本地图片, 和orly。 设置为orly时生成orly风格的封面, 需要连接网络。")
+	fs.StringVar(&book.CoverOrlyColor, "cover-orly-color", "", "orly封面的主题色, 可以为1-16和hex格式的颜色代码, 不填时随机")
+	fs.IntVar(&book.CoverOrlyIdx, "cover-orly-idx", -1, "orly封面的动物, 可以为0-41, 不填时随机, 具体图案可以查看: https://orly.nanmu.me")
+	fs.StringVar(&book.TitleTemplate, "title-template", "", "章节标题模板, 例: -title-template \"第{n:cn}章 {title}\"。{n}为编号, 可写作{n:cn}中文数字、{n:roman}罗马数字、{n:03}补零到3位; {title}为去掉编号后的标题")
+	fs.StringVar(&book.VolumeTemplate, "volume-template", "", "卷标题模板, 写法和-title-template相同, 例: 第{n:cn}卷 {title}")
+	fs.StringVar(&book.Renumber, "renumber", "", "重新编号: volume每卷从1开始、global全书连续编号, 不填时保留原编号")
+	fs.StringVar(&book.TitleJunk, "title-junk", "", "整理标题时去掉标题末尾内容的正则表达式, 默认去掉\"(求月票)\"之类的内容和多余的标点, 设置为false可以禁用")
+	fs.UintVar(&book.Max, "max", 35, "标题最大字数")
+	fs.UintVar(&book.Indent, "indent", 2, "段落缩进字数")
+	fs.StringVar(&book.Align, "align", utils.GetEnv("KAF_CLI_ALIGN", "center"), "标题对齐方式: left、center、righ。环境变量KAF_CLI_ALIGN可修改默认值")
+	fs.StringVar(&book.Bottom, "bottom", "1em", "段落间距(单位可以为em、px)")
+	fs.StringVar(&book.LineHeight, "line-height", "", "行高(用于设置行间距, 默认为1.5rem)")
+	fs.StringVar(&book.Font, "font", "", "嵌入字体, 之后epub和azw3的正文都将使用该字体, 只会嵌入书中用到的字")
+	fs.StringVar(&book.TitleFont, "title-font", "", "标题嵌入字体, 之后epub和azw3的书名和章节标题都将使用该字体")
+	fs.StringVar(&book.Lang, "lang", utils.GetEnv("KAF_CLI_LANG", "zh"), "设置语言: en,de,fr,it,es,zh,ja,pt,ru,nl。环境变量KAF_CLI_LANG可修改默认值")
+	fs.StringVar(&book.Format, "format", utils.GetEnv("KAF_CLI_FORMAT", "all"), "书籍格式: all、epub、mobi、azw3。环境变量KAF_CLI_FORMAT可修改默认值")
+	fs.BoolVar(&book.MobiKF8, "mobi-kf8", false, "mobi格式生成同时包含MOBI7和KF8的双格式文件, 和kindlegen的输出一致, 不再需要kindlegen")
+	fs.StringVar(&book.Split, "split", "", "分册方式: sections按章节数、size按大小、volume按卷、range按章节范围, 所有格式按同样的方式分册。不填时不分册, azw3超过2000章时按章节数分册")
+	fs.IntVar(&book.SplitCount, "split-count", 0, "按章节数分册时每册的章节数(默认2000), 按卷分册时每册的卷数(默认1)")
+	fs.IntVar(&book.SplitSize, "split-size", 0, "按大小分册时每册正文的最大KB数")
+	fs.StringVar(&book.SplitRanges, "split-ranges", "", "按章节范围分册时的范围, 每个范围为一册, 例: 1-500,501-1000,1001-")
+	fs.BoolVar(&book.Reproducible, "reproducible", os.Getenv("SOURCE_DATE_EPOCH") != "", "可重复生成: 相同的txt和参数生成完全相同的文件, 时间取自SOURCE_DATE_EPOCH或-date。设置SOURCE_DATE_EPOCH时默认开启")
+	fs.StringVar(&book.Date, "date", "", "出版日期, 格式为2006-01-02")
+	fs.BoolVar(&book.EpubCheck, "check", false, "生成epub后检查是否符合EPUB规范, 不符合时生成失败。也可以用 kaf-cli check 书名.epub 检查已有的文件")
+	fs.StringVar(&book.Out, "out", "", "输出文件名，不需要包含格式后缀")
+	fs.BoolVar(&book.Tips, "tips", true, "添加本软件教程")
 	return &book
 }
 
+func NewBookArgs() (*model.Book, bool) {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	book := bookFlags(flag.CommandLine)
+	asJSON := jsonFlag(flag.CommandLine)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(core.ExitOK)
+		}
+		os.Exit(core.ExitArgs)
+	}
+	return book, *asJSON
+}
+
+// jsonFlag 在fs中注册转换时的-json参数
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "不输出转换过程, 转换后在标准输出输出一个JSON格式的结果, 包含书籍信息、目录、生成的文件、耗时、警告和错误")
+}
+
+// command 子命令
+type command struct {
+	name  string
+	usage string // 帮助中的用法示例
+	run   func(args []string) int
+}
+
+// commands 所有子命令, 不带子命令时和convert相同
+func commands() []command {
+	return []command{
+		{"convert", "转换: \t\tkaf-cli convert -author 作者 ebook.txt", runConvert},
+		{"preview", "预览目录: \tkaf-cli preview ebook.txt", runPreview},
+		{"inspect", "分析txt: \tkaf-cli inspect ebook.txt", runInspect},
+		{"review", "检查目录: \tkaf-cli review ebook.txt", runReview},
+		{"batch", "批量转换: \tkaf-cli batch -format epub 目录", runBatch},
+		{"watch", "监视目录: \tkaf-cli watch 目录", runWatch},
+		{"serve", "Web服务: \tkaf-cli serve -port 8080", runServe},
+		{"merge", "合并: \t\tkaf-cli merge -bookname 合集 第一部.txt 第二部.txt", runMerge},
+		{"split", "分册: \t\tkaf-cli split -by volume ebook.txt", runSplit},
+		{"check", "检查epub: \tkaf-cli check ebook.epub", runCheck},
+		{"meta", "修改元数据: \tkaf-cli meta -author 作者 ebook.epub", runMeta},
+	}
+}
+
 func printHelp(version string) {
 	fmt.Println("错误: 文件名不能为空")
 	fmt.Println("软件版本: \t", version)
 	fmt.Println("简洁模式: \t把文件拖放到kaf-cli上")
 	fmt.Println("命令行简单模式: kaf-cli ebook.txt")
-	fmt.Println("\n以下为kaf-cli的全部参数")
-	NewBookArgs()
-	flag.PrintDefaults()
+	for _, cmd := range commands() {
+		fmt.Println(cmd.usage)
+	}
+	fmt.Println("\n子命令的参数可以用 kaf-cli 子命令 -h 查看, 以下为kaf-cli的全部参数")
+	fs := flag.NewFlagSet("kaf-cli", flag.ContinueOnError)
+	bookFlags(fs)
+	fs.PrintDefaults()
 	if runtime.GOOS == "windows" {
 		time.Sleep(time.Second * 10)
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		for _, cmd := range commands() {
+			if os.Args[1] == cmd.name {
+				os.Exit(cmd.run(os.Args[2:]))
+			}
+		}
+	}
+	// 兼容原来的用法: 把txt拖放到kaf-cli上, 或者不带子命令直接使用转换参数
 	var book *model.Book
+	var asJSON bool
 	var err error
 	if len(os.Args) == 2 && strings.HasSuffix(os.Args[1], ".txt") {
 		book, err = model.NewBookSimple(os.Args[1])
 		if err != nil {
 			fmt.Printf("错误: %s\n", err.Error())
-			os.Exit(1)
+			os.Exit(core.ExitArgs)
 		}
 	} else {
-		book = NewBookArgs()
+		book, asJSON = NewBookArgs()
+	}
+	os.Exit(convertFile(book, asJSON))
+}
+
+// convertFile 检查参数、识别章节后生成电子书, 返回退出码, asJSON时只输出JSON格式的结果
+//
+// 退出码见core.ExitOK等常量, 和lib中KafConvert的返回值相同。
+func convertFile(book *model.Book, asJSON bool) int {
+	if asJSON {
+		return convertJSON(book)
 	}
 	if err := core.Check(book, version); err != nil {
 		if err.Error() == "不是txt文件" {
 			fmt.Printf("错误: %s\n", err.Error())
-			os.Exit(1)
+			return core.ExitCheck
 		}
 		fmt.Println(err)
 		printHelp(version)
-		os.Exit(1)
+		return core.ExitCheck
 	}
-	analytics.Analytics(version, secret, measurement, book.Format)
 	book.ToString()
 	if err := core.Parse(book); err != nil {
 		fmt.Printf("错误: %s\n", err.Error())
-		os.Exit(2)
+		return core.ExitParse
 	}
+	return convertBook(book)
+}
+
+// convertBook 把解析后的书籍生成电子书, 返回退出码
+func convertBook(book *model.Book) int {
+	analytics.Analytics(version, secret, measurement, book.Format)
+	// Ctrl+C时停止转换
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	conv := converter.Dispatcher{
 		Book: book,
 	}
-	if err := conv.Convert(); err != nil {
+	if err := conv.Convert(ctx); err != nil {
 		fmt.Printf("错误: %s\n", err.Error())
-		os.Exit(1)
+		if ctx.Err() != nil {
+			return core.ExitCanceled
+		}
+		return core.ExitConvert
 	}
+	return core.ExitOK
 }
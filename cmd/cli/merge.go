@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+)
+
+// runMerge 把多个txt合并成一本书, 每个txt为一卷, 返回退出码
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	book := bookFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli merge [选项] 第一部.txt 第二部.txt...")
+		fmt.Fprintln(fs.Output(), "按顺序合并成一本书, 每个txt为一卷, 卷名从文件名识别, 选项和直接转换时相同")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	if err := core.MergeFiles(book, fs.Args(), version); err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitParse
+	}
+	book.ToString()
+	return convertBook(book)
+}
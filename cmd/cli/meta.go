@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/metadata"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// runMeta 查看或修改已生成的书籍的元数据, 返回退出码
+func runMeta(args []string) int {
+	fs := flag.NewFlagSet("meta", flag.ExitOnError)
+	var meta model.Book
+	fs.StringVar(&meta.Bookname, "bookname", "", "书名")
+	fs.StringVar(&meta.Author, "author", "", "作者, 多个作者用&分隔")
+	fs.StringVar(&meta.Series, "series", "", "系列名, azw3和mobi不支持")
+	fs.IntVar(&meta.SeriesIndex, "series-index", 0, "在系列中的序号, 不填时保持原有序号")
+	fs.StringVar(&meta.Description, "description", "", "简介")
+	fs.StringVar(&meta.Cover, "cover", "", "新的封面图片")
+	fs.StringVar(&meta.Lang, "lang", "", "语言")
+	fs.StringVar(&meta.Date, "date", "", "出版日期, 格式为2006-01-02")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli meta [选项] 书名.epub")
+		fmt.Fprintln(fs.Output(), "不带选项时显示元数据, 支持epub、azw3和mobi, 未填写的选项保持不变")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	path := fs.Arg(0)
+
+	if fs.NFlag() > 0 {
+		if err := metadata.Update(path, &meta); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+			return core.ExitConvert
+		}
+	}
+	book, err := metadata.Read(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	fmt.Println("书名:", book.Bookname)
+	fmt.Println("作者:", book.Author)
+	if book.Series != "" {
+		fmt.Printf("系列: %s 第%d册\n", book.Series, book.SeriesIndex)
+	}
+	fmt.Println("简介:", book.Description)
+	fmt.Println("语言:", book.Lang)
+	fmt.Println("出版日期:", book.Date)
+	return core.ExitOK
+}
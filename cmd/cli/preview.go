@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// runPreview 识别章节后输出目录, 不生成电子书, 返回退出码
+func runPreview(args []string) int {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	book := bookFlags(fs)
+	asJSON := fs.Bool("json", false, "输出JSON格式的目录, 格式和lib中KafPreview的结果相同")
+	content := fs.Bool("content", false, "JSON中包含正文")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli preview [选项] ebook.txt")
+		fmt.Fprintln(fs.Output(), "按转换时的参数识别章节并输出目录, 用来调整-match等参数")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 1 {
+		book.Filename = fs.Arg(0)
+	}
+	book.Tips = false
+	if code := parseQuiet(book, *asJSON); code != core.ExitOK {
+		return code
+	}
+
+	if *asJSON {
+		sections := book.SectionList
+		if !*content {
+			sections = withoutContent(sections)
+		}
+		bs, _ := json.Marshal(sections)
+		fmt.Println(string(bs))
+		return core.ExitOK
+	}
+	var volumes, chapters int
+	for _, section := range book.SectionList {
+		if len(section.Sections) > 0 {
+			volumes++
+		} else {
+			chapters++
+		}
+		fmt.Printf("%s  (%d字)\n", html.UnescapeString(section.Title), core.Words(section.Content))
+		for _, sub := range section.Sections {
+			chapters++
+			fmt.Printf("    %s  (%d字)\n", html.UnescapeString(sub.Title), core.Words(sub.Content))
+		}
+	}
+	fmt.Printf("\n共%d卷%d章\n", volumes, chapters)
+	return core.ExitOK
+}
+
+// parseQuiet 检查参数并识别章节, 失败时把原因输出到标准错误, 返回退出码
+//
+// quiet为true时丢弃识别过程中的输出, 避免混入JSON。
+func parseQuiet(book *model.Book, quiet bool) int {
+	if book.Filename == "" {
+		fmt.Fprintln(os.Stderr, "错误: 文件名不能为空")
+		return core.ExitArgs
+	}
+	if quiet {
+		book.Log = utils.NopLogger{}
+	}
+	if err := core.Check(book, version); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	if err := core.Parse(book); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitParse
+	}
+	return core.ExitOK
+}
+
+// withoutContent 去掉正文, 只保留目录
+func withoutContent(sections []model.Section) []model.Section {
+	if len(sections) == 0 {
+		return nil
+	}
+	result := make([]model.Section, len(sections))
+	for i, section := range sections {
+		result[i] = model.Section{Title: section.Title, Sections: withoutContent(section.Sections)}
+	}
+	return result
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+	"github.com/Deali-Axy/ebook-generator/pkg/analytics"
+)
+
+// convertResult -json时输出的转换结果
+type convertResult struct {
+	Version      string          `json:"version"`
+	ExitCode     int             `json:"exit_code"`
+	Error        string          `json:"error,omitempty"`
+	Book         *bookInfo       `json:"book,omitempty"`
+	Volumes      int             `json:"volumes"`
+	Chapters     int             `json:"chapters"`
+	TOC          []model.Section `json:"toc,omitempty"` // 目录, 格式和preview -json相同
+	Formats      []formatResult  `json:"formats,omitempty"`
+	Warnings     []string        `json:"warnings"`
+	ParseSeconds float64         `json:"parse_seconds"`
+	Seconds      float64         `json:"seconds"` // 总耗时
+}
+
+// bookInfo 检查参数后的书籍信息
+type bookInfo struct {
+	Filename string `json:"filename"`
+	Bookname string `json:"bookname"`
+	Author   string `json:"author"`
+	Lang     string `json:"lang"`
+	Format   string `json:"format"`
+	Out      string `json:"out"`
+}
+
+// formatResult 一种格式的生成结果
+type formatResult struct {
+	Format  string       `json:"format"`
+	Files   []outputFile `json:"files"`
+	Error   string       `json:"error,omitempty"`
+	Seconds float64      `json:"seconds"`
+}
+
+// outputFile 生成的文件
+type outputFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// convertJSON 检查参数、识别章节后生成电子书, 标准输出中只有一个JSON格式的结果, 返回退出码
+func convertJSON(book *model.Book) int {
+	start := time.Now()
+	logger := &utils.WarningCollector{}
+	book.Log = logger
+	result := convertResult{Version: version}
+	result.ExitCode = func() int {
+		if err := core.Check(book, version); err != nil {
+			result.Error = err.Error()
+			return core.ExitCheck
+		}
+		result.Book = &bookInfo{
+			Filename: book.Filename,
+			Bookname: book.Bookname,
+			Author:   book.Author,
+			Lang:     book.Lang,
+			Format:   book.Format,
+			Out:      book.Out,
+		}
+		if err := core.Parse(book); err != nil {
+			result.Error = err.Error()
+			return core.ExitParse
+		}
+		result.ParseSeconds = time.Since(start).Seconds()
+		for _, section := range book.SectionList {
+			switch {
+			case len(section.Sections) > 0:
+				result.Volumes++
+				result.Chapters += len(section.Sections)
+			case section.Content != model.Tutorial:
+				result.Chapters++
+			}
+		}
+		result.TOC = withoutContent(book.SectionList)
+
+		analytics.Analytics(version, secret, measurement, book.Format)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		conv := converter.Dispatcher{
+			Book:     book,
+			Progress: converter.LogProgress(logger),
+		}
+		dir := filepath.Dir(book.Out)
+		var errs []error
+		for _, r := range conv.Run(ctx) {
+			f := formatResult{Format: r.Format, Files: []outputFile{}, Seconds: r.Elapsed.Seconds()}
+			for _, name := range r.Files {
+				file := outputFile{Path: filepath.Join(dir, name)}
+				if info, err := os.Stat(file.Path); err == nil {
+					file.Size = info.Size()
+				}
+				f.Files = append(f.Files, file)
+			}
+			if r.Err != nil {
+				f.Error = r.Err.Error()
+				errs = append(errs, fmt.Errorf("生成%s失败: %w", r.Format, r.Err))
+			}
+			result.Formats = append(result.Formats, f)
+		}
+		if err := ctx.Err(); err != nil {
+			result.Error = err.Error()
+			return core.ExitCanceled
+		}
+		if err := errors.Join(errs...); err != nil {
+			result.Error = err.Error()
+			return core.ExitConvert
+		}
+		return core.ExitOK
+	}()
+	result.Warnings = logger.Warnings()
+	if result.Warnings == nil {
+		result.Warnings = []string{}
+	}
+	result.Seconds = time.Since(start).Seconds()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(result)
+	return result.ExitCode
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/review"
+)
+
+// reviewSettings 检查后保存的识别设置, 字段名和命令行参数相同
+type reviewSettings struct {
+	Match            string `json:"match"`
+	VolumeMatch      string `json:"volume-match"`
+	ExclusionPattern string `json:"exclude"`
+	Max              uint   `json:"max"`
+	UnknowTitle      string `json:"unknow-title"`
+	TitleTemplate    string `json:"title-template,omitempty"`
+	VolumeTemplate   string `json:"volume-template,omitempty"`
+	Renumber         string `json:"renumber,omitempty"`
+	TitleJunk        string `json:"title-junk,omitempty"`
+}
+
+// runReview 在终端中检查和修改识别出的目录, 保存后再转换, 返回退出码
+func runReview(args []string) int {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	book := bookFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli review [选项] ebook.txt")
+		fmt.Fprintln(fs.Output(), "在终端中检查识别出的目录, 可以合并、拆分、重命名、删除章节, 把章节设为卷, 修改识别规则并实时预览")
+		fmt.Fprintln(fs.Output(), "保存后把修改后的文本和识别设置写到 ebook_review.txt 和 ebook_review.json, 然后开始转换")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 1 {
+		book.Filename = fs.Arg(0)
+	}
+	if book.Filename == "" {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	if err := core.Check(book, version); err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	if err := core.Parse(book); err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitParse
+	}
+	ok, err := review.Run(book)
+	if err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	if !ok {
+		fmt.Println("已取消转换")
+		return core.ExitOK
+	}
+	if err := saveReview(book); err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitConvert
+	}
+	book.ToString()
+	return convertBook(book)
+}
+
+// saveReview 把修改后的文本和识别设置保存到txt旁边
+func saveReview(book *model.Book) error {
+	base := strings.TrimSuffix(book.Filename, ".txt") + "_review"
+	f, err := os.Create(base + ".txt")
+	if err != nil {
+		return fmt.Errorf("保存修改后的文本失败: %w", err)
+	}
+	defer f.Close()
+	if err := review.WriteText(f, book.SectionList); err != nil {
+		return fmt.Errorf("保存修改后的文本失败: %w", err)
+	}
+
+	settings := reviewSettings{
+		Match:            book.Match,
+		VolumeMatch:      book.VolumeMatch,
+		ExclusionPattern: book.ExclusionPattern,
+		Max:              book.Max,
+		UnknowTitle:      book.UnknowTitle,
+		TitleTemplate:    book.TitleTemplate,
+		VolumeTemplate:   book.VolumeTemplate,
+		Renumber:         book.Renumber,
+		TitleJunk:        book.TitleJunk,
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return fmt.Errorf("保存识别设置失败: %w", err)
+	}
+	fmt.Printf("已保存修改后的文本: %s.txt\n已保存识别设置: %s.json\n", base, base)
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !wasip1
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/web/server"
+)
+
+// runServe 启动Web服务, 和cmd/web相同, 返回退出码
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	opts := server.Options{Version: version}
+	fs.StringVar(&opts.Port, "port", "", "端口, 默认读取环境变量PORT, 没有时为8080")
+	fs.StringVar(&opts.ConfigPath, "config", "", "服务配置文件, 默认为config/services.json, 不存在时使用示例配置")
+	fs.StringVar(&opts.WorkDir, "dir", "", "工作目录, 上传和生成的文件保存在其中的web目录下, 默认为当前目录")
+	fs.StringVar(&opts.WatchDir, "watch", os.Getenv("KAF_WATCH_DIR"), "收件目录, 放入的txt会自动转换, 结果记录到转换历史。环境变量KAF_WATCH_DIR可修改默认值")
+	fs.UintVar(&opts.PresetID, "watch-preset", 0, "收件目录使用的转换预设ID, 需要同时设置-watch-user")
+	fs.UintVar(&opts.UserID, "watch-user", 0, "收件目录的转换记录到这个用户的转换历史, 预设也从这个用户中查找。不填时不记录")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli serve [选项]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// 收到关闭信号时优雅关闭服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.Run(ctx, opts); err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	return core.ExitOK
+}
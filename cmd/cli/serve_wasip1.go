@@ -0,0 +1,16 @@
+//go:build wasip1
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+)
+
+// runServe wasip1不能监听端口, 也不能使用SQLite, 不支持Web服务
+func runServe(args []string) int {
+	fmt.Fprintln(os.Stderr, "错误: wasip1版本不支持serve, 请使用对应系统的kaf-cli或kaf-web")
+	return core.ExitArgs
+}
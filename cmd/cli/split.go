@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// runSplit 把一个txt分成多册生成, 返回退出码
+func runSplit(args []string) int {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	book := bookFlags(fs)
+	fs.StringVar(&book.Split, "by", model.SplitVolume, "分册方式: volume按卷、sections按章节数、size按大小、range按章节范围")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli split [选项] ebook.txt")
+		fmt.Fprintln(fs.Output(), "例: kaf-cli split -by range -split-ranges 1-500,501- ebook.txt")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	book.Filename = fs.Arg(0)
+	if err := core.Check(book, version); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	book.ToString()
+	if err := core.Parse(book); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		return core.ExitParse
+	}
+	return convertBook(book)
+}
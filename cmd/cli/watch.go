@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/watch"
+)
+
+// runWatch 监视收件目录, 自动转换放入的txt, 转换后把txt移到done或failed目录, 返回退出码
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts := watch.Options{Version: version}
+	book := bookFlags(fs)
+	fs.StringVar(&opts.OutDir, "out-dir", "", "输出目录, 默认为收件目录下的out")
+	fs.StringVar(&opts.DoneDir, "done", "", "转换成功的txt移到这个目录, 默认为收件目录下的done")
+	fs.StringVar(&opts.FailedDir, "failed", "", "转换失败的txt移到这个目录, 默认为收件目录下的failed, 失败原因写在同名的log文件中")
+	fs.DurationVar(&opts.Interval, "interval", 2*time.Second, "检查目录的间隔")
+	fs.DurationVar(&opts.Settle, "settle", 5*time.Second, "文件大小和修改时间多久不变后认为已经写完")
+	preset := fs.String("preset", "", "预设文件, JSON格式, 字段名和命令行参数相同, 如review保存的识别设置。命令行中的参数优先")
+	dsn := fs.String("db", "", "数据库(SQLite), 设置后把转换结果记录到-user用户的转换历史, 例: -db ebook_generator.db -user 1")
+	user := fs.Uint("user", 0, "记录转换历史的用户ID, 设置-db时必填")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: kaf-cli watch [选项] 收件目录")
+		fmt.Fprintln(fs.Output(), "放入收件目录的txt写完后会自动转换, 按Ctrl+C停止")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return core.ExitArgs
+	}
+	opts.Inbox = fs.Arg(0)
+	if *preset != "" {
+		if err := applyPreset(fs, *preset); err != nil {
+			fmt.Printf("错误: %s\n", err.Error())
+			return core.ExitArgs
+		}
+	}
+	opts.Book = *book
+	// 转换epub时会关闭默认的log输出, 使用单独的logger
+	opts.Logf = log.New(os.Stderr, "", log.LstdFlags).Printf
+
+	if *dsn != "" {
+		if *user == 0 {
+			fmt.Println("错误: 记录转换历史需要用-user指定用户ID")
+			return core.ExitArgs
+		}
+		options := map[string]interface{}{"format": book.Format}
+		fs.Visit(func(f *flag.Flag) {
+			options[f.Name] = f.Value.String()
+		})
+		recorder, err := historyRecorder(*dsn, *user, book.Format, options)
+		if err != nil {
+			fmt.Printf("错误: %s\n", err.Error())
+			return core.ExitCheck
+		}
+		opts.Recorder = recorder
+	}
+
+	watcher, err := watch.New(opts)
+	if err != nil {
+		fmt.Printf("错误: %s\n", err.Error())
+		return core.ExitCheck
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts = watcher.Options()
+	fmt.Printf("正在监视目录: %s\n输出目录: %s\n", opts.Inbox, opts.OutDir)
+	watcher.Run(ctx)
+	return core.ExitOK
+}
+
+// applyPreset 从预设文件设置命令行中没有写的参数
+func applyPreset(fs *flag.FlagSet, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("读取预设失败: %w", err)
+	}
+	var preset map[string]interface{}
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return fmt.Errorf("预设格式错误: %w", err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range preset {
+		if set[name] {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("预设中有未知的参数: %s", name)
+		}
+		s := fmt.Sprint(value)
+		if n, ok := value.(float64); ok {
+			s = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("预设中的参数%s错误: %w", name, err)
+		}
+	}
+	return nil
+}
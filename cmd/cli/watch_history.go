@@ -0,0 +1,25 @@
+//go:build !wasip1
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/Deali-Axy/ebook-generator/internal/database"
+	"github.com/Deali-Axy/ebook-generator/internal/watch"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// historyRecorder 打开SQLite数据库, 把收件目录的转换记录到userID用户的转换历史
+func historyRecorder(dsn string, userID uint, format string, options map[string]interface{}) (watch.Recorder, error) {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %w", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		return nil, fmt.Errorf("初始化数据库失败: %w", err)
+	}
+	return watch.NewHistoryRecorder(services.NewHistoryService(db), userID, format, options)
+}
@@ -0,0 +1,14 @@
+//go:build wasip1
+
+package main
+
+import (
+	"errors"
+
+	"github.com/Deali-Axy/ebook-generator/internal/watch"
+)
+
+// historyRecorder wasip1不能使用SQLite, 不支持记录转换历史
+func historyRecorder(dsn string, userID uint, format string, options map[string]interface{}) (watch.Recorder, error) {
+	return nil, errors.New("wasip1版本不支持-db")
+}
@@ -0,0 +1,18 @@
+//go:build !wasip1
+
+package main
+
+import (
+	"context"
+
+	webServer "github.com/Deali-Axy/ebook-generator/internal/web/server"
+)
+
+// runHTTP 通过HTTP(SSE)提供服务, 使用Web服务的用户数据库认证
+func runHTTP(ctx context.Context, addr, configPath string) error {
+	return webServer.RunMCP(ctx, webServer.MCPOptions{
+		Addr:       addr,
+		ConfigPath: configPath,
+		Version:    version,
+	})
+}
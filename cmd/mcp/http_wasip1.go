@@ -0,0 +1,13 @@
+//go:build wasip1
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// runHTTP wasip1不能监听端口, 也不能使用SQLite, 只支持stdio
+func runHTTP(ctx context.Context, addr, configPath string) error {
+	return errors.New("wasip1版本不支持-http, 请使用对应系统的kaf-mcp")
+}
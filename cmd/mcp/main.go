@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/mark3labs/mcp-go/server"
 	"github.com/Deali-Axy/ebook-generator/internal/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 var version string = "1.0.0"
 
 func main() {
+	httpAddr := flag.String("http", "", "通过HTTP(SSE)提供服务的监听地址, 如:8081; 用户用Web服务登录得到的token连接, 为空时使用stdio")
+	configPath := flag.String("config", "", "HTTP模式使用的服务配置文件, 和Web服务相同")
+	flag.Parse()
+
 	dir := os.Getenv("KAF_DIR")
 	if dir != "" {
 		err := os.Chdir(dir)
@@ -19,18 +27,35 @@ func main() {
 		}
 	}
 	mcp.InitLogger()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	if *httpAddr != "" {
+		if err := runHTTP(ctx, *httpAddr, *configPath); err != nil {
+			log.Fatal("Server error: ", err)
+		}
+		return
+	}
+
 	srv := server.NewMCPServer(
 		"KAF Converter",
 		version,
 		server.WithLogging(),
+		server.WithResourceCapabilities(false, true),
 	)
 
 	// 注册工具
 	converter := &mcp.ConverterService{}
 	converter.RegisterTools(srv, version)
+	converter.RegisterResources(srv)
+
+	// 标准输出只用于传输消息, 依赖库打印到标准输出的内容改为输出到标准错误
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
 
 	// 启动服务
-	if err := server.ServeStdio(srv); err != nil {
+	if err := mcp.ServeStdio(ctx, srv, os.Stdin, stdout); err != nil && ctx.Err() == nil {
 		log.Fatal("Server error: ", err)
 	}
 }
@@ -0,0 +1,126 @@
+//go:build js && wasm
+
+// kaf.wasm 在浏览器中识别章节和生成epub, txt不需要上传到服务器
+//
+// 编译:
+//
+//	GOOS=js GOARCH=wasm go build -o web/static/kaf.wasm ./cmd/wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" web/static/
+//
+// 加载后在全局对象上注册kaf:
+//
+//	kaf.version                           版本
+//	kaf.preview(data, options)            识别章节, 返回目录, 格式和动态库的KafPreview相同
+//	kaf.convert(data, options, progress)  生成epub, 返回 {name, data, warnings}
+//
+// data为txt内容的Uint8Array, options的字段和model.Book相同, 如 {Filename: "小说.txt", Author: "作者"},
+// Filename只用于识别书名和作者; 函数都返回Promise。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/Deali-Axy/ebook-generator/internal/browser"
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/go-shiori/go-epub"
+)
+
+var version = "v1.0.0"
+
+func main() {
+	// 浏览器中没有文件系统, go-epub的临时文件放在内存中
+	epub.Use(epub.MemoryFS)
+	js.Global().Set("kaf", js.ValueOf(map[string]any{
+		"version": version,
+		"preview": js.FuncOf(preview),
+		"convert": js.FuncOf(convert),
+	}))
+	select {}
+}
+
+// preview 识别章节, 返回不含正文的目录
+func preview(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		book, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		bs, _ := json.Marshal(browser.Contents(book.SectionList))
+		return js.Global().Get("JSON").Call("parse", string(bs)), nil
+	})
+}
+
+// convert 生成epub, 第三个参数为可选的进度回调
+func convert(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		book, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		var progress func(converter.Progress)
+		if len(args) > 2 && args[2].Type() == js.TypeFunction {
+			callback := args[2]
+			progress = func(p converter.Progress) {
+				callback.Invoke(map[string]any{
+					"format":  p.Format,
+					"stage":   p.Stage,
+					"current": p.Current,
+					"total":   p.Total,
+					"message": p.Message,
+				})
+			}
+		}
+		result, err := browser.Convert(context.Background(), book, progress)
+		if err != nil {
+			return nil, err
+		}
+		data := js.Global().Get("Uint8Array").New(len(result.Data))
+		js.CopyBytesToJS(data, result.Data)
+		warnings := make([]any, len(result.Warnings))
+		for i, w := range result.Warnings {
+			warnings[i] = w
+		}
+		return map[string]any{
+			"name":     result.Name,
+			"data":     data,
+			"warnings": warnings,
+		}, nil
+	})
+}
+
+// parse 读取参数并识别章节
+func parse(args []js.Value) (*model.Book, error) {
+	if len(args) == 0 || !args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, errors.New("第一个参数应为txt内容的Uint8Array")
+	}
+	text := make([]byte, args[0].Length())
+	js.CopyBytesToGo(text, args[0])
+
+	options := ""
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		options = js.Global().Get("JSON").Call("stringify", args[1]).String()
+	}
+	return browser.Parse(text, options, version)
+}
+
+// promise 在goroutine中执行fn, 返回JavaScript的Promise
+func promise(fn func() (any, error)) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			result, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
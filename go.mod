@@ -9,7 +9,9 @@ require (
 	github.com/swaggo/files v1.0.1
 	github.com/swaggo/gin-swagger v1.6.0
 	github.com/ystyle/google-analytics v0.0.0-20210425064301-a7f754dd0649
+	golang.org/x/image v0.29.0
 	golang.org/x/net v0.41.0
+	golang.org/x/sys v0.34.0
 	golang.org/x/text v0.27.0
 )
 
@@ -61,7 +63,6 @@ require (
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
-	golang.org/x/sys v0.34.0 // indirect
 	golang.org/x/tools v0.34.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
@@ -0,0 +1,320 @@
+// Package batch 使用相同的参数批量转换多个txt
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// 单个文件的转换状态
+const (
+	StatusSuccess = "success" // 转换成功
+	StatusFailed  = "failed"  // 转换失败
+	StatusSkipped = "skipped" // 输出文件比txt新, 跳过
+)
+
+// Options 批量转换的参数
+type Options struct {
+	Book     model.Book   // 共用的转换参数, Filename、Bookname和Out由每个文件决定
+	Inputs   []string     // 目录、glob或txt文件, 目录会包含子目录中的txt
+	OutDir   string       // 输出目录, 按输入目录的结构生成子目录; 为空时输出到txt所在的目录
+	Workers  int          // 同时转换的文件数, 为0时使用CPU核数
+	Force    bool         // 输出文件比txt新时也重新转换
+	Version  string       // 软件版本
+	Progress func(Result) // 每个文件完成时调用, 不会并发调用
+}
+
+// Result 单个文件的转换结果
+type Result struct {
+	File     string        `json:"file"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Chapters int           `json:"chapters"`
+	Outputs  []string      `json:"outputs,omitempty"`
+	Elapsed  time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+// Summary 批量转换的汇总
+type Summary struct {
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Elapsed   time.Duration `json:"-"`
+	Seconds   float64       `json:"seconds"`
+	Results   []Result      `json:"results"`
+}
+
+// input 一个要转换的txt和它的输出目录
+type input struct {
+	file   string
+	outDir string
+}
+
+// Run 转换所有输入的txt, 单个文件失败不影响其他文件
+//
+// 只有输入无效时返回错误, 每个文件的结果记录在Summary中。ctx取消后不再开始新的文件。
+func Run(ctx context.Context, opts Options) (*Summary, error) {
+	inputs, err := expand(opts.Inputs, opts.OutDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("没有找到txt文件")
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	results := make([]Result, len(inputs))
+	queue := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(workers, len(inputs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = convert(ctx, opts, inputs[i])
+				if opts.Progress != nil {
+					mu.Lock()
+					opts.Progress(results[i])
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range inputs {
+		if ctx.Err() != nil {
+			results[i] = Result{File: inputs[i].file, Status: StatusFailed, Error: ctx.Err().Error()}
+			continue
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	summary := &Summary{Total: len(results), Results: results, Elapsed: time.Since(start)}
+	summary.Seconds = summary.Elapsed.Seconds()
+	for _, r := range results {
+		switch r.Status {
+		case StatusSuccess:
+			summary.Succeeded++
+		case StatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary, nil
+}
+
+// Convert 转换一个txt, 输出到outDir, outDir为空时输出到txt所在的目录; 不使用opts.Inputs和opts.OutDir
+func Convert(ctx context.Context, opts Options, file, outDir string) Result {
+	if outDir == "" {
+		outDir = filepath.Dir(file)
+	}
+	return convert(ctx, opts, input{file: file, outDir: outDir})
+}
+
+// convert 转换一个txt
+func convert(ctx context.Context, opts Options, in input) (result Result) {
+	start := time.Now()
+	result = Result{File: in.file}
+	defer func() {
+		result.Elapsed = time.Since(start)
+		result.Seconds = result.Elapsed.Seconds()
+		if result.Status == "" {
+			result.Status = StatusSuccess
+			if result.Error != "" {
+				result.Status = StatusFailed
+			}
+		}
+	}()
+
+	book := opts.Book
+	book.Filename, book.Bookname, book.Out = in.file, "", ""
+	book.SectionList = nil
+	if err := core.Check(&book, opts.Version); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	book.Out = filepath.Join(in.outDir, filepath.Base(book.Out))
+	if !opts.Force && upToDate(&book) {
+		result.Status = StatusSkipped
+		return result
+	}
+	if err := core.Parse(&book); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Chapters = chapters(book.SectionList)
+
+	conv := converter.Dispatcher{
+		Book:     &book,
+		Progress: func(converter.Progress) {},
+		Workers:  1,
+	}
+	var errs []error
+	for _, r := range conv.Run(ctx) {
+		for _, name := range r.Files {
+			result.Outputs = append(result.Outputs, filepath.Join(in.outDir, name))
+		}
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("生成%s失败: %w", r.Format, r.Err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// expand 展开目录和glob, 目录中的txt按相对路径输出到outDir的子目录
+func expand(paths []string, outDir string) ([]input, error) {
+	var inputs []input
+	seen := make(map[string]bool)
+	add := func(file, dir string) {
+		if !seen[file] {
+			seen[file] = true
+			inputs = append(inputs, input{file: file, outDir: dir})
+		}
+	}
+	target := func(dir string) string {
+		if outDir == "" {
+			return dir
+		}
+		return outDir
+	}
+	for _, path := range paths {
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("文件匹配规则错误: %w", err)
+			}
+			sort.Strings(matches)
+			for _, file := range matches {
+				if isTxt(file) {
+					add(file, target(filepath.Dir(file)))
+				}
+			}
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取文件出错: %w", err)
+		}
+		if !info.IsDir() {
+			add(path, target(filepath.Dir(path)))
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !isTxt(file) {
+				return nil
+			}
+			dir := filepath.Dir(file)
+			if outDir != "" {
+				rel, _ := filepath.Rel(path, dir)
+				dir = filepath.Join(outDir, rel)
+			}
+			add(file, dir)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("读取目录出错: %w", err)
+		}
+	}
+	return inputs, nil
+}
+
+func isTxt(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".txt")
+}
+
+// upToDate 要生成的每种格式都已经有比txt新的输出文件, 分册时检查第一册
+func upToDate(book *model.Book) bool {
+	source, err := os.Stat(book.Filename)
+	if err != nil {
+		return false
+	}
+	var exts []string
+	switch book.Format {
+	case "epub", "mobi", "azw3":
+		exts = []string{book.Format}
+	default:
+		exts = []string{"epub", "mobi", "azw3"}
+	}
+	for _, ext := range exts {
+		fresh := false
+		for _, name := range []string{book.Out + "." + ext, book.Out + "_1." + ext} {
+			if info, err := os.Stat(name); err == nil && !info.ModTime().Before(source.ModTime()) {
+				fresh = true
+				break
+			}
+		}
+		if !fresh {
+			return false
+		}
+	}
+	return true
+}
+
+// chapters 章节数, 不计卷和制作说明
+func chapters(sections []model.Section) int {
+	var n int
+	for _, section := range sections {
+		switch {
+		case len(section.Sections) > 0:
+			n += len(section.Sections)
+		case section.Content != model.Tutorial:
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText 输出文字格式的汇总, 失败的文件列出原因
+func (s *Summary) WriteText(w io.Writer) {
+	for _, r := range s.Results {
+		switch r.Status {
+		case StatusSuccess:
+			fmt.Fprintf(w, "成功  %s  %d章  %.1fs\n", r.File, r.Chapters, r.Seconds)
+		case StatusSkipped:
+			fmt.Fprintf(w, "跳过  %s  输出文件已是最新\n", r.File)
+		default:
+			fmt.Fprintf(w, "失败  %s  %s\n", r.File, r.Error)
+		}
+	}
+	fmt.Fprintf(w, "\n共%d个文件: 成功%d个, 失败%d个, 跳过%d个, 总耗时%.1fs\n", s.Total, s.Succeeded, s.Failed, s.Skipped, s.Seconds)
+}
+
+// WriteJSON 输出JSON格式的汇总
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,92 @@
+// Package browser 在内存中识别章节和生成epub, 是cmd/wasm在浏览器中调用的Go实现
+package browser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// Parse 按选项识别txt内容的章节
+//
+// options为JSON格式, 字段和model.Book相同, 为空时使用默认值; Filename只用于识别书名和作者。
+// 只能生成epub, 并且不能分册。
+func Parse(text []byte, options string, version string) (*model.Book, error) {
+	var book model.Book
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &book); err != nil {
+			return nil, fmt.Errorf("参数错误: %w", err)
+		}
+	}
+	book.Filename = utils.DefaultString(book.Filename, "book.txt")
+	book.Format = utils.DefaultString(book.Format, "epub")
+	if book.Format != "epub" {
+		return nil, fmt.Errorf("浏览器中只能生成epub: %s", book.Format)
+	}
+	if book.Split != model.SplitNone {
+		return nil, errors.New("浏览器中不能分册")
+	}
+	model.SetDefault(&book)
+	book.Log = &utils.WarningCollector{}
+	if err := core.Check(&book, version); err != nil {
+		return nil, err
+	}
+	if err := core.ParseReader(&book, bytes.NewReader(text)); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// Contents 去掉正文, 只保留目录
+func Contents(sections []model.Section) []model.Section {
+	if len(sections) == 0 {
+		return nil
+	}
+	result := make([]model.Section, len(sections))
+	for i, section := range sections {
+		result[i] = model.Section{Title: section.Title, Sections: Contents(section.Sections)}
+	}
+	return result
+}
+
+// Result 生成的epub
+type Result struct {
+	Name     string   // 文件名, 如"小说.epub"
+	Data     []byte   // epub内容
+	Warnings []string // 转换过程中的警告
+}
+
+// Convert 在内存中生成Parse返回的书籍的epub, progress可以为nil
+func Convert(ctx context.Context, book *model.Book, progress func(converter.Progress)) (*Result, error) {
+	logger, ok := book.Log.(*utils.WarningCollector)
+	if !ok {
+		return nil, errors.New("书籍应该由Parse创建")
+	}
+	var buf bytes.Buffer
+	conv := converter.Dispatcher{
+		Book:   book,
+		Output: &converter.WriterOutput{W: &buf},
+		Progress: func(p converter.Progress) {
+			converter.LogProgress(logger)(p)
+			if progress != nil {
+				progress(p)
+			}
+		},
+	}
+	if err := conv.Convert(ctx); err != nil {
+		return nil, err
+	}
+	return &Result{
+		Name:     filepath.Base(book.Out) + ".epub",
+		Data:     buf.Bytes(),
+		Warnings: logger.Warnings(),
+	}, nil
+}
@@ -2,15 +2,16 @@ package converter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"image"
-	"math/rand"
+	"html"
+	"io"
 	"os"
-	"time"
+	"regexp"
 
-	"github.com/leotaku/mobi"
+	"github.com/Deali-Axy/ebook-generator/internal/kf8"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
-	"golang.org/x/text/language"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
 )
 
 type Azw3Converter struct {
@@ -21,84 +22,175 @@ type Azw3Converter struct {
 
 func NewAzw3Converter() *Azw3Converter {
 	return &Azw3Converter{
-		MobiTtmlTitleStart: `<h3 style="text-align:%s;">`,
+		MobiTtmlTitleStart: `<h3 class="title" style="text-align:%s;">`,
 		HTMLTitleEnd:       "</h3>",
 		CSSContent: `
             .title {text-align: %s}
-            .content { margin-bottom: %s; text-indent: %dem; %s }
+            .content { margin-top: 0; margin-bottom: %s; text-indent: %dem; %s }
         `,
 	}
 }
 
-func (convert Azw3Converter) Build(book model.Book) error {
-	fmt.Println("使用第三方库生成azw3, 不保证所有样式都能正常显示")
-	fmt.Println("正在生成azw3...")
-	start := time.Now()
-	chunks := SectionSliceChunk(book.SectionList, 2000)
-	for i, chunk := range chunks {
-		index := i + 1
-		title := fmt.Sprintf("%s_%d", book.Bookname, index)
-		filename := fmt.Sprintf("%s_%d.azw3", book.Out, index)
-		if len(chunks) == 1 {
-			title = fmt.Sprintf("%s", book.Bookname)
-			filename = fmt.Sprintf("%s.azw3", book.Out)
+func (convert Azw3Converter) Build(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) error {
+	tempDir, err := os.MkdirTemp("", "kaf-cli")
+	if err != nil {
+		return fmt.Errorf("创建临时文件夹失败: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	assets, err := loadKF8Assets(book, tempDir, "azw3", progress)
+	if err != nil {
+		return err
+	}
+
+	return buildParts(ctx, book, "azw3", azw3MaxSections, progress, func(ctx context.Context, part *model.Book, progress ProgressFunc) error {
+		kb, err := convert.newKF8Book(part, part.Bookname, part.SectionList, assets)
+		if err != nil {
+			return err
 		}
-		mb := mobi.Book{
-			Title:       title,
-			Authors:     []string{book.Author},
-			CreatedDate: time.Now(),
-			Chapters:    []mobi.Chapter{},
-			Language:    language.MustParse(book.Lang),
-			UniqueID:    rand.Uint32(),
+		total := model.SectionCount(part.SectionList)
+		report(progress, Progress{Format: "azw3", Stage: StageSection, Current: total, Total: total})
+		return writeFile(out, outputName(part, ".azw3"), func(w io.Writer) error {
+			if err := kb.Write(w); err != nil {
+				return fmt.Errorf("保存失败: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// kf8Assets 各分册共用的封面和字体
+type kf8Assets struct {
+	cover     []byte
+	font      []byte // 正文字体子集
+	titleFont []byte // 标题字体子集
+}
+
+// loadKF8Assets 读取封面, 生成字体子集
+func loadKF8Assets(book *model.Book, tempDir, format string, progress ProgressFunc) (kf8Assets, error) {
+	var assets kf8Assets
+	var err error
+	if book.Cover != "" {
+		if assets.cover, err = os.ReadFile(book.Cover); err != nil {
+			return assets, fmt.Errorf("添加封面失败: %w", err)
 		}
-		var excss string
-		if book.LineHeight != "" {
-			excss = fmt.Sprintf("line-height: %s;", book.LineHeight)
+	}
+	load := func(src, name string, runes map[rune]bool) ([]byte, error) {
+		if b, _ := utils.IsExists(src); !b {
+			return nil, nil
 		}
-		css := fmt.Sprintf(convert.CSSContent, book.Align, book.Bottom, book.Indent, excss)
-		for _, section := range chunk {
-			ch := mobi.Chapter{
-				Title:  section.Title,
-				Chunks: mobi.Chunks(convert.wrapTitle(section.Title, section.Content, book.Align)),
-			}
-			mb.Chapters = append(mb.Chapters, ch)
-			if len(section.Sections) > 0 {
-				for _, subsection := range section.Sections {
-					ch := mobi.Chapter{
-						Title:  subsection.Title,
-						Chunks: mobi.Chunks(convert.wrapTitle(subsection.Title, subsection.Content, book.Align)),
-					}
-					mb.Chapters = append(mb.Chapters, ch)
-				}
-			}
+		fontPath, subsetted, err := subsetFont(src, tempDir, name, runes)
+		if err != nil {
+			return nil, err
+		}
+		if !subsetted {
+			report(progress, Progress{Format: format, Stage: StageWarning, Message: fmt.Sprintf("字体 %s 不支持子集化, 将嵌入完整字体", src)})
+		}
+		data, err := os.ReadFile(fontPath)
+		if err != nil {
+			return nil, fmt.Errorf("嵌入字体失败: %w", err)
 		}
+		return data, nil
+	}
+	if assets.font, err = load(book.Font, "embedfont", contentRunes(book)); err != nil {
+		return assets, err
+	}
+	if assets.titleFont, err = load(book.TitleFont, "titlefont", titleRunes(book)); err != nil {
+		return assets, err
+	}
+	return assets, nil
+}
 
-		mb.CSSFlows = []string{css}
-		if book.Cover != "" {
-			f, err := os.Open(book.Cover)
-			if err != nil {
-				return fmt.Errorf("添加封面失败: %w", err)
-			}
-			img, _, err := image.Decode(f)
-			if err != nil {
-				return fmt.Errorf("添加封面失败: %w", err)
-			}
-			mb.CoverImage = img
+// newKF8Book 把章节转换为KF8书籍, 卷和章节生成嵌套目录
+func (convert Azw3Converter) newKF8Book(book *model.Book, title string, sections []model.Section, assets kf8Assets) (*kf8.Book, error) {
+	kb := &kf8.Book{
+		Title:       title,
+		Author:      book.Author,
+		Description: bookDescription(book),
+		Language:    book.Lang,
+		PublishDate: book.Date,
+		UniqueID:    bookUniqueID(book),
+		CreatedDate: kf8Time(buildTime(book)),
+		Cover:       assets.cover,
+	}
+	var excss, fontcss string
+	if book.LineHeight != "" {
+		excss = fmt.Sprintf("line-height: %s;", book.LineHeight)
+	}
+	if assets.font != nil {
+		url, err := kb.AddFont(assets.font)
+		if err != nil {
+			return nil, fmt.Errorf("嵌入字体失败: %w", err)
 		}
+		// Kindle只在元素上直接指定字体时才使用嵌入字体
+		excss += ` font-family: "embedfont";`
+		fontcss += fmt.Sprintf(`@font-face { font-family: "embedfont"; src: url(%s); }
+`, url)
+	}
+	if assets.titleFont != nil {
+		url, err := kb.AddFont(assets.titleFont)
+		if err != nil {
+			return nil, fmt.Errorf("嵌入标题字体失败: %w", err)
+		}
+		fontcss += fmt.Sprintf(`@font-face { font-family: "titlefont"; src: url(%s); }
+.title { font-family: "titlefont"; }
+`, url)
+	}
+	kb.CSS = fontcss + fmt.Sprintf(convert.CSSContent, book.Align, book.Bottom, book.Indent, excss)
 
-		// Convert book to PalmDB database
-		db := mb.Realize()
+	chapters, err := convert.chapters(kb, sections, book.Align, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	kb.Chapters = chapters
+	return kb, nil
+}
 
-		// Write database to file
-		f, _ := os.Create(filename)
-		err := db.Write(f)
+// chapters 把章节转换为KF8章节, 子章节保留层级
+func (convert Azw3Converter) chapters(kb *kf8.Book, sections []model.Section, align string, images map[string]string) ([]kf8.Chapter, error) {
+	var chapters []kf8.Chapter
+	for _, section := range sections {
+		body, err := embedImages(kb, convert.wrapTitle(section.Title, section.Content, align), images)
 		if err != nil {
-			return fmt.Errorf("保存失败: %w", err)
+			return nil, err
 		}
+		children, err := convert.chapters(kb, section.Sections, align, images)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, kf8.Chapter{
+			Title:    html.UnescapeString(section.Title),
+			Body:     body,
+			Children: children,
+		})
 	}
+	return chapters, nil
+}
+
+var imgSrcReg = regexp.MustCompile(`(<img\s[^>]*?src=")([^"]+)(")`)
 
-	fmt.Println("生成azw3电子书耗时:", time.Now().Sub(start))
-	return nil
+// embedImages 把正文中引用的本地图片嵌入书中, images缓存已经嵌入的图片地址
+func embedImages(kb *kf8.Book, content string, images map[string]string) (string, error) {
+	var err error
+	result := imgSrcReg.ReplaceAllStringFunc(content, func(tag string) string {
+		match := imgSrcReg.FindStringSubmatch(tag)
+		src := html.UnescapeString(match[2])
+		if url, ok := images[src]; ok {
+			return match[1] + url + match[3]
+		}
+		if b, _ := utils.IsExists(src); !b || err != nil {
+			return tag
+		}
+		data, e := os.ReadFile(src)
+		if e == nil {
+			images[src], e = kb.AddImage(data)
+		}
+		if e != nil {
+			err = fmt.Errorf("嵌入图片%s失败: %w", src, e)
+			return tag
+		}
+		return match[1] + images[src] + match[3]
+	})
+	return result, err
 }
 
 func (convert Azw3Converter) wrapTitle(title, content, align string) string {
@@ -109,13 +201,3 @@ func (convert Azw3Converter) wrapTitle(title, content, align string) string {
 	buff.WriteString(content)
 	return buff.String()
 }
-
-func SectionSliceChunk(s []model.Section, size int) [][]model.Section {
-	var ret [][]model.Section
-	for size < len(s) {
-		// s[:size:size] 表示 len 为 size，cap 也为 size，第二个冒号后的 size 表示 cap
-		s, ret = s[size:], append(ret, s[:size:size])
-	}
-	ret = append(ret, s)
-	return ret
-}
@@ -1,7 +1,13 @@
 package converter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Deali-Axy/ebook-generator/internal/model"
@@ -9,13 +15,83 @@ import (
 )
 
 type Dispatcher struct {
-	Book *model.Book
+	Book     *model.Book
+	Output   Output       // 输出目标, 为空时写入Book.Out所在的目录
+	Progress ProgressFunc // 进度回调, 为空时输出到Book的Logger
+	Workers  int          // 同时生成的格式数量, 为0时使用CPU核数
 }
 
-func (d *Dispatcher) Convert() error {
+// Result 单个格式的生成结果
+type Result struct {
+	Format  string        // 书籍格式
+	Files   []string      // 生成的文件名
+	Err     error         // 生成失败的原因
+	Elapsed time.Duration // 耗时
+}
+
+// job 一个生成任务, 可能依次生成多个格式
+type job func(ctx context.Context) []Result
+
+func (d *Dispatcher) Convert(ctx context.Context) error {
+	var errs []error
+	for _, result := range d.Run(ctx) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("生成%s失败: %w", result.Format, result.Err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// Run 并发生成所有格式, 返回每个格式的结果, 单个格式失败不影响其他格式
+func (d *Dispatcher) Run(ctx context.Context) []Result {
 	start := time.Now()
-	// 解析文本
-	fmt.Println()
+	out := d.Output
+	if out == nil {
+		out = DirOutput{Dir: filepath.Dir(d.Book.Out)}
+	}
+	progress := d.Progress
+	if progress == nil {
+		progress = LogProgress(d.Book.Logger())
+	}
+	jobs := d.jobs(out, progress)
+
+	workers := d.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	sem := make(chan struct{}, workers)
+	results := make([][]Result, len(jobs))
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = j(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var all []Result
+	failed := false
+	for _, rs := range results {
+		for _, r := range rs {
+			failed = failed || r.Err != nil
+			all = append(all, r)
+		}
+	}
+	if !failed && ctx.Err() == nil {
+		progress(Progress{Stage: StageDone, Message: fmt.Sprintf("转换完成! 总耗时: %v", time.Since(start)), Elapsed: time.Since(start)})
+	}
+	return all
+}
+
+// jobs 根据Book.Format生成任务列表
+func (d *Dispatcher) jobs(out Output, progress ProgressFunc) []job {
 	// 判断要生成的格式
 	var isEpub, isMobi, isAzw3 bool
 	switch d.Book.Format {
@@ -32,35 +108,93 @@ func (d *Dispatcher) Convert() error {
 		isAzw3 = true
 	}
 
+	// kindlegen需要读写本地文件, 只有输出到目录时才能使用
+	dirOut, isDir := out.(DirOutput)
 	hasKinldegen := utils.LookKindlegen()
+	// 双格式mobi由内置的KF8生成器完成, 不需要kindlegen
+	if !isDir || d.Book.MobiKF8 {
+		hasKinldegen = ""
+	}
+	if hasKinldegen != "" && isMobi {
+		progress(Progress{Format: "mobi", Message: "kindlegen: " + hasKinldegen})
+	}
 	if d.Book.Format == "mobi" && hasKinldegen == "" {
 		isEpub = false
 	}
 
-	var convert Converter
-	// 生成epub
+	var jobs []job
 	if isEpub {
-		convert = NewEpubConverter()
-		convert.Build(*d.Book)
-		fmt.Println()
+		// kindlegen依赖生成好的epub, 放在同一个任务里依次执行
+		kindlegen := isMobi && hasKinldegen != ""
+		jobs = append(jobs, func(ctx context.Context) []Result {
+			results := []Result{d.build(ctx, "epub", NewEpubConverter(), out, progress)}
+			if !kindlegen {
+				return results
+			}
+			mobi := Result{Format: "mobi"}
+			start := time.Now()
+			if results[0].Err != nil {
+				mobi.Err = errors.New("epub生成失败, 无法使用kindlegen转换")
+			} else {
+				// 分册时每一册分别转换
+				for _, epubName := range results[0].Files {
+					if mobi.Err = ConverToMobi(ctx, dirOut.Path(epubName), d.Book.Lang, progress); mobi.Err != nil {
+						break
+					}
+					mobi.Files = append(mobi.Files, strings.TrimSuffix(epubName, ".epub")+".mobi")
+				}
+			}
+			mobi.Elapsed = time.Since(start)
+			return append(results, mobi)
+		})
+		if kindlegen {
+			isMobi = false
+		}
 	}
-	// 生成azw3格式
 	if isAzw3 {
-		convert = NewAzw3Converter()
-		// 生成kindle格式
-		convert.Build(*d.Book)
+		jobs = append(jobs, func(ctx context.Context) []Result {
+			return []Result{d.build(ctx, "azw3", NewAzw3Converter(), out, progress)}
+		})
 	}
-	// 生成mobi格式
 	if isMobi {
-		if hasKinldegen == "" {
-			convert = NewMobiConverter()
-			convert.Build(*d.Book)
-		} else {
-			ConverToMobi(fmt.Sprintf("%s.epub", d.Book.Out), d.Book.Lang)
-		}
+		jobs = append(jobs, func(ctx context.Context) []Result {
+			return []Result{d.build(ctx, "mobi", NewMobiConverter(), out, progress)}
+		})
 	}
-	end := time.Now().Sub(start)
-	fmt.Println("\n转换完成! 总耗时:", end)
+	return jobs
+}
 
-	return nil
+// build 调用转换器生成一种格式并记录生成的文件
+func (d *Dispatcher) build(ctx context.Context, format string, conv Converter, out Output, progress ProgressFunc) Result {
+	start := time.Now()
+	record := &RecordOutput{Output: out}
+	err := conv.Build(ctx, d.Book, record, progress)
+	return Result{Format: format, Files: record.Names(), Err: err, Elapsed: time.Since(start)}
+}
+
+// PrintProgress 把转换进度打印到标准输出
+func PrintProgress(p Progress) {
+	LogProgress(utils.StdoutLogger)(p)
+}
+
+// LogProgress 把转换进度输出到logger, 警告用Warnf输出
+func LogProgress(logger utils.Logger) ProgressFunc {
+	return func(p Progress) {
+		if p.Stage == StageWarning {
+			logger.Warnf("%s", p.Message)
+			return
+		}
+		if p.Message != "" {
+			logger.Infof("%s", p.Message)
+		}
+		if p.Format == "" {
+			return
+		}
+		switch p.Stage {
+		case StageStart:
+			logger.Infof("正在生成%s...", p.Format)
+		case StageDone:
+			logger.Infof("生成%s电子书耗时: %v", p.Format, p.Elapsed)
+		}
+	}
 }
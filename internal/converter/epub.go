@@ -1,15 +1,23 @@
 package converter
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-shiori/go-epub"
+	"github.com/Deali-Axy/ebook-generator/internal/epubcheck"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
 	"github.com/Deali-Axy/ebook-generator/internal/utils"
 )
@@ -44,35 +52,61 @@ func (convert EpubConverter) wrapTitle(title, content string) string {
 	return buff.String()
 }
 
-func (convert EpubConverter) Build(book model.Book) error {
+func (convert EpubConverter) Build(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) error {
 	log.Default().SetOutput(io.Discard)
-	fmt.Println("正在生成epub")
+	return buildParts(ctx, book, "epub", 0, progress, func(ctx context.Context, part *model.Book, progress ProgressFunc) error {
+		return convert.buildBook(ctx, part, out, progress)
+	})
+}
+
+// buildBook 生成一册epub
+func (convert EpubConverter) buildBook(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) error {
 	start := time.Now()
-	// 写入样式
-	tempDir, err := os.MkdirTemp("", "kaf-cli")
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			panic(fmt.Sprintf("创建临时文件夹失败: %s", err))
-		}
-	}()
+	report(progress, Progress{Format: "epub", Stage: StageStart})
 
 	// Create a ne EPUB
 	e, err := epub.NewEpub(book.Bookname)
 	if err != nil {
-		return fmt.Errorf("创建小说文件失败")
+		return fmt.Errorf("创建小说文件失败: %w", err)
 	}
 	e.SetLang(book.Lang)
 	// Set the author
 	e.SetAuthor(book.Author)
+	if book.Reproducible {
+		e.SetIdentifier("urn:uuid:" + bookUUID(book))
+	}
+	if desc := bookDescription(book); desc != "" {
+		e.SetDescription(desc)
+	}
+
+	// 只有嵌入字体时需要临时文件夹保存子集化的字体, 其余内容都在内存中生成
+	fontExists, _ := utils.IsExists(book.Font)
+	titleFontExists, _ := utils.IsExists(book.TitleFont)
+	var tempDir string
+	if fontExists || titleFontExists {
+		if tempDir, err = os.MkdirTemp("", "kaf-cli"); err != nil {
+			return fmt.Errorf("创建临时文件夹失败: %w", err)
+		}
+		defer os.RemoveAll(tempDir)
+	}
 
-	pageStylesFile := filepath.Join(tempDir, "page_styles.css")
 	var epubcss = convert.CSSContent
 	var excss string
 	if book.LineHeight != "" {
 		excss = fmt.Sprintf("line-height: %s;", book.LineHeight)
 	}
-	if b, _ := utils.IsExists(book.Font); b {
-		fontfile, _ := e.AddFont(book.Font, "")
+	if fontExists {
+		fontPath, subsetted, err := subsetFont(book.Font, tempDir, "embedfont", contentRunes(book))
+		if err != nil {
+			return err
+		}
+		if !subsetted {
+			report(progress, Progress{Format: "epub", Stage: StageWarning, Message: fmt.Sprintf("字体 %s 不支持子集化, 将嵌入完整字体", book.Font)})
+		}
+		fontfile, err := e.AddFont(fontPath, "")
+		if err != nil {
+			return fmt.Errorf("嵌入字体失败: %w", err)
+		}
 		excss += `
 font-family: "embedfont";
 `
@@ -83,12 +117,30 @@ font-family: "embedfont";
 }
 `, fontfile)
 	}
-
-	err = os.WriteFile(pageStylesFile, fmt.Appendf(nil, epubcss, book.Align, book.Bottom, book.Indent, excss), 0666)
-	if err != nil {
-		return fmt.Errorf("无法写入样式文件: %w", err)
+	if titleFontExists {
+		fontPath, subsetted, err := subsetFont(book.TitleFont, tempDir, "titlefont", titleRunes(book))
+		if err != nil {
+			return err
+		}
+		if !subsetted {
+			report(progress, Progress{Format: "epub", Stage: StageWarning, Message: fmt.Sprintf("字体 %s 不支持子集化, 将嵌入完整字体", book.TitleFont)})
+		}
+		fontfile, err := e.AddFont(fontPath, "")
+		if err != nil {
+			return fmt.Errorf("嵌入标题字体失败: %w", err)
+		}
+		epubcss += fmt.Sprintf(`
+@font-face {
+  font-family: "titlefont";
+  src: url(%s) format('truetype');
+}
+.title { font-family: "titlefont"; }
+`, fontfile)
 	}
-	css, err := e.AddCSS(pageStylesFile, "")
+
+	// 写入样式
+	pageStyles := fmt.Appendf(nil, epubcss, book.Align, book.Bottom, book.Indent, excss)
+	css, err := e.AddCSS("data:text/css;base64,"+base64.StdEncoding.EncodeToString(pageStyles), "page_styles.css")
 	if err != nil {
 		return fmt.Errorf("无法写入样式文件: %w", err)
 	}
@@ -98,40 +150,226 @@ font-family: "embedfont";
 		if err != nil {
 			return fmt.Errorf("添加封面失败: %w", err)
 		}
-		e.SetCover(img, "")
+		if err := e.SetCover(img, ""); err != nil {
+			return fmt.Errorf("添加封面失败: %w", err)
+		}
 	}
 
+	total := model.SectionCount(book.SectionList)
+	var current int
 	for _, section := range book.SectionList {
-		if len(section.Sections) > 0 {
-			internalFilename, _ := e.AddSection(
-				convert.wrapTitle(section.Title, section.Content),
-				section.Title,
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		internalFilename, err := e.AddSection(convert.wrapTitle(section.Title, section.Content), html.UnescapeString(section.Title), "", css)
+		if err != nil {
+			return fmt.Errorf("添加章节失败 %s: %w", section.Title, err)
+		}
+		for _, subsecton := range section.Sections {
+			_, err := e.AddSubSection(
+				internalFilename,
+				convert.wrapTitle(subsecton.Title, subsecton.Content),
+				html.UnescapeString(subsecton.Title),
 				"",
 				css,
 			)
-			for _, subsecton := range section.Sections {
-				e.AddSubSection(
-					internalFilename,
-					convert.wrapTitle(subsecton.Title, subsecton.Content),
-					subsecton.Title,
-					"",
-					css,
-				)
+			if err != nil {
+				return fmt.Errorf("添加章节失败 %s: %w", subsecton.Title, err)
 			}
-		} else {
-			e.AddSection(convert.wrapTitle(section.Title, section.Content), section.Title, "", css)
 		}
+		current += 1 + len(section.Sections)
+		report(progress, Progress{Format: "epub", Stage: StageSection, Current: current, Total: total, Elapsed: time.Since(start)})
 	}
 
 	// Write the EPUB
-	fmt.Println("正在生成电子书...")
-	epubName := book.Out + ".epub"
-	err = e.Write(epubName)
+	report(progress, Progress{Format: "epub", Stage: StageWrite, Current: total, Total: total, Elapsed: time.Since(start)})
+	err = writeFile(out, outputName(book, ".epub"), func(w io.Writer) error {
+		if !book.EpubCheck {
+			return convert.write(w, e, book)
+		}
+		var buf bytes.Buffer
+		if err := convert.write(&buf, e, book); err != nil {
+			return err
+		}
+		if err := checkEpub(buf.Bytes(), progress); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
 	if err != nil {
-		// handle error
+		return err
 	}
-	// 计算耗时
-	end := time.Now().Sub(start)
-	fmt.Println("生成EPUB电子书耗时:", end)
+	report(progress, Progress{Format: "epub", Stage: StageDone, Current: total, Total: total, Elapsed: time.Since(start)})
 	return nil
 }
+
+// write 生成epub写入w, 并补充go-epub不支持的元数据
+func (convert EpubConverter) write(w io.Writer, e *epub.Epub, book *model.Book) error {
+	meta := epubMeta(book)
+	if meta == "" && !book.Reproducible {
+		if _, err := e.WriteTo(w); err != nil {
+			return fmt.Errorf("生成epub失败: %w", err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		return fmt.Errorf("生成epub失败: %w", err)
+	}
+	var modified time.Time
+	if book.Reproducible {
+		modified = zipTime(buildTime(book))
+	}
+	return rewriteEpub(w, buf.Bytes(), meta, modified)
+}
+
+// checkEpub 检查生成的epub是否符合规范, 警告通过progress报告, 有错误时返回错误
+func checkEpub(data []byte, progress ProgressFunc) error {
+	result, err := epubcheck.CheckBytes(data)
+	if err != nil {
+		return fmt.Errorf("检查epub失败: %w", err)
+	}
+	for _, f := range result.Warnings() {
+		report(progress, Progress{Format: "epub", Stage: StageWarning, Message: f.String()})
+	}
+	return result.Err()
+}
+
+// epubMeta go-epub不支持的元数据: 出版日期和分册的系列信息
+func epubMeta(book *model.Book) string {
+	var meta strings.Builder
+	if book.Date != "" {
+		fmt.Fprintf(&meta, "    <dc:date>%s</dc:date>\n", html.EscapeString(book.Date))
+	}
+	if book.Series != "" {
+		// 同时写入EPUB3和calibre的格式
+		series := html.EscapeString(book.Series)
+		fmt.Fprintf(&meta, `    <meta property="belongs-to-collection" id="series">%s</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta refines="#series" property="group-position">%d</meta>
+    <meta name="calibre:series" content="%s"/>
+    <meta name="calibre:series_index" content="%d"/>
+`, series, book.SeriesIndex, series, book.SeriesIndex)
+	}
+	return meta.String()
+}
+
+var (
+	modifiedReg = regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`)
+	manifestReg = regexp.MustCompile(`(?s)<manifest>\n(.*?)\n(\s*)</manifest>`)
+	navPointReg = regexp.MustCompile(`navPoint-\d+`)
+)
+
+// rewriteEpub 在epub的opf文件中添加元数据后写入w, 其余文件原样复制
+//
+// modified不为零时, 文件按名称排序, 修改时间和dcterms:modified统一为modified,
+// 并修正go-epub按map顺序生成的清单和目录序号, 相同内容生成相同的文件
+func rewriteEpub(w io.Writer, data []byte, meta string, modified time.Time) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("读取epub失败: %w", err)
+	}
+	reproducible := !modified.IsZero()
+	files := zr.File
+	if reproducible {
+		files = slices.Clone(files)
+		// mimetype必须是第一个文件
+		slices.SortStableFunc(files, func(a, b *zip.File) int {
+			if (a.Name == "mimetype") != (b.Name == "mimetype") {
+				if a.Name == "mimetype" {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		header := f.FileHeader
+		if reproducible {
+			header.Modified = modified
+			header.ModifiedDate, header.ModifiedTime = msDosTime(modified)
+			header.Extra = nil
+		}
+		var edit func([]byte) []byte
+		switch {
+		case strings.HasSuffix(f.Name, ".opf"):
+			edit = func(opf []byte) []byte {
+				opf = bytes.Replace(opf, []byte("  </metadata>"), []byte(meta+"  </metadata>"), 1)
+				if reproducible {
+					opf = modifiedReg.ReplaceAll(opf, fmt.Appendf(nil, `<meta property="dcterms:modified">%s</meta>`, modified.UTC().Format("2006-01-02T15:04:05Z")))
+					opf = manifestReg.ReplaceAllFunc(opf, func(m []byte) []byte {
+						group := manifestReg.FindSubmatch(m)
+						items := bytes.Split(group[1], []byte("\n"))
+						slices.SortFunc(items, bytes.Compare)
+						return fmt.Appendf(nil, "<manifest>\n%s\n%s</manifest>", bytes.Join(items, []byte("\n")), group[2])
+					})
+				}
+				return opf
+			}
+		case strings.HasSuffix(f.Name, ".ncx") && reproducible:
+			edit = func(ncx []byte) []byte {
+				var index int
+				return navPointReg.ReplaceAllFunc(ncx, func([]byte) []byte {
+					index++
+					return fmt.Appendf(nil, "navPoint-%d", index)
+				})
+			}
+		}
+		if edit == nil {
+			if err := copyZipFile(zw, f, &header); err != nil {
+				return fmt.Errorf("写入epub失败: %w", err)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("读取epub失败: %w", err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("读取epub失败: %w", err)
+		}
+		// 沿用原有的DOS时间, 读取时由DOS时间换算的Modified不准确
+		edited := &zip.FileHeader{Name: f.Name, Method: f.Method, ModifiedDate: header.ModifiedDate, ModifiedTime: header.ModifiedTime}
+		if reproducible {
+			edited.Modified = modified
+		}
+		fw, err := zw.CreateHeader(edited)
+		if err != nil {
+			return fmt.Errorf("写入epub失败: %w", err)
+		}
+		if _, err := fw.Write(edit(content)); err != nil {
+			return fmt.Errorf("写入epub失败: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("写入epub失败: %w", err)
+	}
+	return nil
+}
+
+// msDosTime 转换为zip文件头使用的MS-DOS日期和时间
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = zipTime(t)
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// copyZipFile 按header复制压缩后的数据, 不重新压缩
+func copyZipFile(zw *zip.Writer, f *zip.File, header *zip.FileHeader) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	fw, err := zw.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
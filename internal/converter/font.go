@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Deali-Axy/ebook-generator/internal/font"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// titleRunes 收集书名和所有章节标题用到的字符
+func titleRunes(book *model.Book) map[rune]bool {
+	runes := make(map[rune]bool)
+	addRunes(runes, book.Bookname)
+	walkSections(book.SectionList, func(section model.Section) {
+		addRunes(runes, section.Title)
+	})
+	return runes
+}
+
+// contentRunes 收集所有章节正文用到的字符
+func contentRunes(book *model.Book) map[rune]bool {
+	runes := make(map[rune]bool)
+	walkSections(book.SectionList, func(section model.Section) {
+		addRunes(runes, section.Content)
+	})
+	return runes
+}
+
+func addRunes(runes map[rune]bool, s string) {
+	for _, r := range s {
+		runes[r] = true
+	}
+}
+
+func walkSections(sections []model.Section, fn func(section model.Section)) {
+	for _, section := range sections {
+		fn(section)
+		walkSections(section.Sections, fn)
+	}
+}
+
+// subsetFont 生成字体子集到tempDir, 返回可以嵌入的字体文件路径和是否完成了子集化
+//
+// 字体格式不支持子集化时(如字体集合TTC)退回到嵌入完整字体, 由调用方给出警告。
+func subsetFont(src, tempDir, name string, runes map[rune]bool) (string, bool, error) {
+	// 数字、英文和标点总是保留, 它们很小但经常出现在标题和正文里
+	for r := rune(0x20); r < 0x7F; r++ {
+		runes[r] = true
+	}
+	dst := filepath.Join(tempDir, name+filepath.Ext(src))
+	err := font.SubsetFile(src, dst, runes)
+	if errors.Is(err, font.ErrUnsupportedFont) {
+		return src, false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("生成字体子集失败: %w", err)
+	}
+	return dst, true, nil
+}
@@ -1,7 +1,42 @@
 package converter
 
-import "github.com/Deali-Axy/ebook-generator/internal/model"
+import (
+	"context"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
 
 type Converter interface {
-	Build(book model.Book) error
+	// Build 把解析好的书籍生成电子书写入out, ctx取消时尽快返回ctx.Err()
+	Build(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) error
+}
+
+// 转换阶段
+const (
+	StageStart   = "start"   // 开始生成
+	StageSection = "section" // 正在添加章节
+	StageWrite   = "write"   // 正在写入文件
+	StageDone    = "done"    // 生成完成
+	StageWarning = "warning" // 生成过程中的警告, 不影响结果
+)
+
+// Progress 转换进度
+type Progress struct {
+	Format  string        // 书籍格式
+	Stage   string        // 当前阶段
+	Current int           // 已处理的章节数
+	Total   int           // 章节总数
+	Message string        // 提示信息
+	Elapsed time.Duration // 已耗时
+}
+
+// ProgressFunc 进度回调, 可能在多个goroutine中被调用
+type ProgressFunc func(p Progress)
+
+// report 在progress不为空时报告进度
+func report(progress ProgressFunc, p Progress) {
+	if progress != nil {
+		progress(p)
+	}
 }
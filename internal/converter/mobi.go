@@ -1,10 +1,16 @@
 package converter
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/766b/mobi"
+	"github.com/Deali-Axy/ebook-generator/internal/kf8"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
 )
 
@@ -24,13 +30,39 @@ func NewMobiConverter() *MobiConverter {
 	}
 }
 
-func (convert MobiConverter) Build(book model.Book) error {
-	fmt.Println("使用第三方库生成mobi, 不保证所有样式都能正常显示")
-	fmt.Println("正在生成mobi...")
+func (convert MobiConverter) Build(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) error {
+	return buildParts(ctx, book, "mobi", 0, progress, func(ctx context.Context, part *model.Book, progress ProgressFunc) error {
+		return convert.buildBook(ctx, part, out, progress)
+	})
+}
+
+// buildBook 生成一册mobi
+func (convert MobiConverter) buildBook(ctx context.Context, book *model.Book, out Output, progress ProgressFunc) (err error) {
 	start := time.Now()
-	m, err := mobi.NewWriter(fmt.Sprintf("%s.mobi", book.Out))
+	if book.MobiKF8 {
+		report(progress, Progress{Format: "mobi", Stage: StageStart})
+	} else {
+		report(progress, Progress{Format: "mobi", Stage: StageStart, Message: "使用第三方库生成mobi, 不保证所有样式都能正常显示"})
+	}
+	// 第三方库出错时会panic, 这里转换成错误返回
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("生成mobi失败: %v", r)
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 第三方库只能写入文件, 先写到临时文件再复制到输出
+	tempDir, err := os.MkdirTemp("", "kaf-cli")
+	if err != nil {
+		return fmt.Errorf("创建临时文件夹失败: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	tempFile := filepath.Join(tempDir, "book.mobi")
+	m, err := mobi.NewWriter(tempFile)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("创建mobi文件失败: %w", err)
 	}
 	m.Title(book.Bookname)
 	m.Compression(mobi.CompressionNone)
@@ -39,15 +71,79 @@ func (convert MobiConverter) Build(book model.Book) error {
 	}
 	m.NewExthRecord(mobi.EXTH_DOCTYPE, "EBOK")
 	m.NewExthRecord(mobi.EXTH_AUTHOR, book.Author)
+	if desc := bookDescription(book); desc != "" {
+		m.NewExthRecord(mobi.EXTH_DESCRIPTION, desc)
+	}
+	if book.Date != "" {
+		m.NewExthRecord(mobi.EXTH_PUBLISHINGDATE, book.Date)
+	}
+	total := model.SectionCount(book.SectionList)
+	var current int
 	for _, section := range book.SectionList {
 		m.NewChapter(section.Title, []byte(section.Content))
-		if len(section.Sections) > 0 {
-			for _, subsection := range section.Sections {
-				m.NewChapter(subsection.Title, []byte(subsection.Content))
-			}
+		for _, subsection := range section.Sections {
+			m.NewChapter(subsection.Title, []byte(subsection.Content))
 		}
+		current += 1 + len(section.Sections)
+	}
+	report(progress, Progress{Format: "mobi", Stage: StageSection, Current: current, Total: total, Elapsed: time.Since(start)})
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+
+	report(progress, Progress{Format: "mobi", Stage: StageWrite, Current: total, Total: total, Elapsed: time.Since(start)})
 	m.Write()
-	fmt.Println("生成mobi电子书耗时:", time.Now().Sub(start))
+	if book.Reproducible {
+		if err := setPDBTime(tempFile, buildTime(book)); err != nil {
+			return err
+		}
+	}
+	// 双格式文件: 旧设备读取MOBI7部分, 新设备读取后面追加的KF8部分
+	var kb *kf8.Book
+	if book.MobiKF8 {
+		assets, err := loadKF8Assets(book, tempDir, "mobi", progress)
+		if err != nil {
+			return err
+		}
+		if kb, err = NewAzw3Converter().newKF8Book(book, book.Bookname, book.SectionList, assets); err != nil {
+			return err
+		}
+	}
+	err = writeFile(out, outputName(book, ".mobi"), func(w io.Writer) error {
+		f, err := os.Open(tempFile)
+		if err != nil {
+			return fmt.Errorf("读取mobi文件失败: %w", err)
+		}
+		defer f.Close()
+		if kb != nil {
+			if err := kf8.WriteJoint(w, f, kb); err != nil {
+				return fmt.Errorf("生成双格式mobi失败: %w", err)
+			}
+			return nil
+		}
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	report(progress, Progress{Format: "mobi", Stage: StageDone, Current: total, Total: total, Elapsed: time.Since(start)})
+	return nil
+}
+
+// setPDBTime 修改PalmDB头中的创建和修改时间, 第三方库固定使用当前时间
+func setPDBTime(path string, t time.Time) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("读取mobi文件失败: %w", err)
+	}
+	defer f.Close()
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], unixPDBTime(t))
+	binary.BigEndian.PutUint32(buf[4:], unixPDBTime(t))
+	// 创建时间和修改时间位于偏移36和40
+	if _, err := f.WriteAt(buf[:], 36); err != nil {
+		return fmt.Errorf("写入mobi文件失败: %w", err)
+	}
 	return nil
 }
@@ -1,19 +1,35 @@
 package converter
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Deali-Axy/ebook-generator/internal/utils"
 )
 
-func ConverToMobi(bookname, lang string) {
+// ConverToMobi 调用kindlegen把epub转换为mobi, mobi文件生成在epub同目录
+func ConverToMobi(ctx context.Context, bookname, lang string, progress ProgressFunc) error {
 	command := utils.LookKindlegen()
-	fmt.Printf("\n检测到Kindle格式转换器: %s，正在把书籍转换成Kindle格式...\n", command)
-	fmt.Println("转换mobi比较花时间, 大约耗时1-10分钟, 请等待...")
 	start := time.Now()
-	utils.Run(command, "-dont_append_source", "-locale", lang, "-c1", bookname)
-	// 计算耗时
-	end := time.Now().Sub(start)
-	fmt.Println("转换为mobi格式耗时:", end)
+	report(progress, Progress{
+		Format:  "mobi",
+		Stage:   StageStart,
+		Message: fmt.Sprintf("检测到Kindle格式转换器: %s，正在把书籍转换成Kindle格式...\n转换mobi比较花时间, 大约耗时1-10分钟, 请等待...", command),
+	})
+	err := utils.RunContext(ctx, command, "-dont_append_source", "-locale", lang, "-c1", bookname)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// kindlegen在有警告时也会返回非0状态码, 以是否生成了文件为准
+	mobiName := strings.TrimSuffix(bookname, ".epub") + ".mobi"
+	if exists, _ := utils.IsExists(mobiName); !exists {
+		if err != nil {
+			return fmt.Errorf("kindlegen转换失败: %w", err)
+		}
+		return fmt.Errorf("kindlegen没有生成文件: %s", mobiName)
+	}
+	report(progress, Progress{Format: "mobi", Stage: StageDone, Elapsed: time.Since(start)})
+	return nil
 }
@@ -0,0 +1,108 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// Output 电子书的输出目标, 一本书可能生成多个文件(如分册的azw3)
+type Output interface {
+	// Create 创建名为name的输出文件, name只包含文件名
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirOutput 把文件写入目录
+type DirOutput struct {
+	Dir string
+}
+
+func (o DirOutput) Create(name string) (io.WriteCloser, error) {
+	if o.Dir != "" {
+		if err := os.MkdirAll(o.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建输出目录失败: %w", err)
+		}
+	}
+	return os.Create(filepath.Join(o.Dir, name))
+}
+
+// Path 返回输出文件的完整路径
+func (o DirOutput) Path(name string) string {
+	return filepath.Join(o.Dir, name)
+}
+
+// WriterOutput 把唯一的输出文件写入W, 生成多个文件时返回错误
+type WriterOutput struct {
+	W       io.Writer
+	mu      sync.Mutex
+	created bool
+}
+
+func (o *WriterOutput) Create(name string) (io.WriteCloser, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.created {
+		return nil, errors.New("WriterOutput只能写入一个文件: " + name)
+	}
+	o.created = true
+	return nopWriteCloser{o.W}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// outputName 根据Book.Out生成输出文件名
+func outputName(book *model.Book, ext string) string {
+	return filepath.Base(book.Out) + ext
+}
+
+// writeFile 创建输出文件并调用write写入内容
+func writeFile(out Output, name string, write func(w io.Writer) error) error {
+	w, err := out.Create(name)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %w", err)
+	}
+	if err := write(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("保存%s失败: %w", name, err)
+	}
+	return nil
+}
+
+// RecordOutput 记录通过Output创建过的文件名
+type RecordOutput struct {
+	Output
+	mu    sync.Mutex
+	names []string
+}
+
+func (o *RecordOutput) Create(name string) (io.WriteCloser, error) {
+	w, err := o.Output.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	o.names = append(o.names, name)
+	o.mu.Unlock()
+	return w, nil
+}
+
+// Names 返回已创建的文件名
+func (o *RecordOutput) Names() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.names...)
+}
@@ -0,0 +1,171 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// DateLayout 书籍日期的格式
+const DateLayout = "2006-01-02"
+
+// reproducibleEpoch 可重复生成时的默认时间, zip能表示的最早时间
+var reproducibleEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// zipMaxTime zip能表示的最晚时间, MS-DOS时间到2107年, 扩展时间戳是32位的Unix时间, 到2106年
+var zipMaxTime = time.Date(2106, 2, 7, 6, 28, 14, 0, time.UTC)
+
+// PalmDB头中的时间是32位的秒数, mobi使用Unix时间, KF8生成器从1904年开始计算, 超出范围时会溢出
+var (
+	palmEpoch   = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	palmMaxTime = palmEpoch.Add(math.MaxUint32 * time.Second)
+)
+
+// SourceDateEpoch 读取环境变量SOURCE_DATE_EPOCH指定的时间
+//
+// 参考 https://reproducible-builds.org/specs/source-date-epoch/
+func SourceDateEpoch() (time.Time, bool) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0).UTC(), true
+}
+
+// SourceDateEpochZip 读取SOURCE_DATE_EPOCH指定的时间, 和生成epub时一样限制在zip能表示的范围内
+//
+// 修改已生成的epub时使用, 修改后仍可重复生成。
+func SourceDateEpochZip() (time.Time, bool) {
+	t, ok := SourceDateEpoch()
+	if !ok {
+		return time.Time{}, false
+	}
+	return zipTime(t), true
+}
+
+// buildTime 书籍的生成时间
+//
+// 可重复生成时依次使用SOURCE_DATE_EPOCH和书籍日期, 都没有时使用固定时间
+func buildTime(book *model.Book) time.Time {
+	if !book.Reproducible {
+		return time.Now()
+	}
+	if t, ok := SourceDateEpoch(); ok {
+		return t
+	}
+	if t, err := time.Parse(DateLayout, book.Date); err == nil {
+		return t
+	}
+	return reproducibleEpoch
+}
+
+// zipTime 把时间限制在MS-DOS时间能表示的范围内, 超出范围时年份会溢出
+func zipTime(t time.Time) time.Time {
+	t = t.UTC()
+	if t.Before(reproducibleEpoch) {
+		return reproducibleEpoch
+	}
+	if t.After(zipMaxTime) {
+		return zipMaxTime
+	}
+	return t
+}
+
+// unixPDBTime 把时间转换为mobi的PalmDB头中的Unix时间, 限制在1970年到2106年之间
+func unixPDBTime(t time.Time) uint32 {
+	return uint32(min(max(t.Unix(), 0), math.MaxUint32))
+}
+
+// kf8Time 把时间限制在KF8生成器的PalmDB头能表示的1904年到2040年之间
+func kf8Time(t time.Time) time.Time {
+	t = t.UTC()
+	if t.Before(palmEpoch) {
+		return palmEpoch
+	}
+	if t.After(palmMaxTime) {
+		return palmMaxTime
+	}
+	return t
+}
+
+// bookHash 根据书籍内容、元数据、封面和字体计算哈希, 内容相同的书籍生成相同的ID
+func bookHash(book *model.Book) [sha256.Size]byte {
+	h := sha256.New()
+	field := func(values ...string) {
+		for _, v := range values {
+			io.WriteString(h, v)
+			h.Write([]byte{0})
+		}
+	}
+	field(book.Bookname, book.Author, book.Lang, book.Date, book.Series, strconv.Itoa(book.SeriesIndex))
+	field(book.Align, strconv.Itoa(int(book.Indent)), book.Bottom, book.LineHeight)
+	var sections func([]model.Section)
+	sections = func(list []model.Section) {
+		for _, section := range list {
+			field(section.Title, section.Content)
+			sections(section.Sections)
+			field("")
+		}
+	}
+	sections(book.SectionList)
+	// 封面和字体按文件内容计算, 读取失败时使用路径
+	for _, path := range []string{book.Cover, book.Font, book.TitleFont} {
+		if sum, err := fileSum(path); err == nil {
+			h.Write(sum)
+			h.Write([]byte{0})
+		} else {
+			field(path)
+		}
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// fileSum 计算文件内容的sha256
+func fileSum(path string) ([]byte, error) {
+	if path == "" {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// bookUUID 由书籍内容计算的UUID
+func bookUUID(book *model.Book) string {
+	sum := bookHash(book)
+	b := sum[:16]
+	// 按RFC 4122设置版本号和变体
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// bookUniqueID mobi和azw3头部的书籍ID
+func bookUniqueID(book *model.Book) uint32 {
+	if !book.Reproducible {
+		return rand.Uint32()
+	}
+	sum := bookHash(book)
+	return binary.BigEndian.Uint32(sum[:4])
+}
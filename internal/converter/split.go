@@ -0,0 +1,118 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// azw3MaxSections azw3单个文件的章节上限, 超过后部分设备打不开, 未设置分册方式时按这个数量分册
+const azw3MaxSections = 2000
+
+// SplitBook 按Book.Split把书籍分成多册, 返回每一册的书籍
+//
+// 不需要分册时返回原书籍。maxSections大于0时, 未设置分册方式的书籍也会按这个章节数分册。
+// 按章节范围分册时范围格式错误返回错误。
+func SplitBook(book *model.Book, maxSections int) ([]*model.Book, error) {
+	switch book.Split {
+	case model.SplitSections:
+		count := book.SplitCount
+		if count <= 0 {
+			count = azw3MaxSections
+		}
+		return book.SplitBySections(count), nil
+	case model.SplitSize:
+		if book.SplitSize <= 0 {
+			return []*model.Book{book}, nil
+		}
+		return book.SplitBySize(book.SplitSize), nil
+	case model.SplitVolume:
+		return book.SplitByVolume(max(book.SplitCount, 1)), nil
+	case model.SplitRange:
+		ranges, err := model.ParseRanges(book.SplitRanges)
+		if err != nil {
+			return nil, err
+		}
+		return book.SplitByRanges(ranges), nil
+	}
+	if maxSections > 0 {
+		return book.SplitBySections(maxSections), nil
+	}
+	return []*model.Book{book}, nil
+}
+
+// bookDescription 书籍的简介, 分册时在后面说明属于哪个系列
+func bookDescription(book *model.Book) string {
+	if book.Series == "" {
+		return book.Description
+	}
+	series := fmt.Sprintf("%s 第%d册, 共%d册", book.Series, book.SeriesIndex, book.SeriesTotal)
+	if book.Description == "" {
+		return series
+	}
+	return book.Description + "\n" + series
+}
+
+// buildParts 分册后并发生成每一册, 各册的章节进度合并后再报告
+func buildParts(ctx context.Context, book *model.Book, format string, maxSections int, progress ProgressFunc, build func(ctx context.Context, part *model.Book, progress ProgressFunc) error) error {
+	parts, err := SplitBook(book, maxSections)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	report(progress, Progress{Format: format, Stage: StageStart})
+	total := model.SectionCount(book.SectionList)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		currents = make([]int, len(parts))
+		errs     = make([]error, len(parts))
+		sem      = make(chan struct{}, runtime.NumCPU())
+	)
+	for i, part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = build(ctx, part, func(p Progress) {
+				switch p.Stage {
+				case StageSection:
+					mu.Lock()
+					defer mu.Unlock()
+					currents[i] = p.Current
+					var current int
+					for _, c := range currents {
+						current += c
+					}
+					report(progress, Progress{Format: format, Stage: StageSection, Current: current, Total: total, Elapsed: time.Since(start)})
+				case StageWarning:
+					report(progress, p)
+				case StageStart:
+					// 第三方库的提示只需要报告一次
+					if p.Message != "" && i == 0 {
+						report(progress, Progress{Format: format, Stage: StageWarning, Message: p.Message})
+					}
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	report(progress, Progress{Format: format, Stage: StageDone, Current: total, Total: total, Elapsed: time.Since(start)})
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Deali-Axy/ebook-generator/internal/model"
 	"github.com/Deali-Axy/ebook-generator/internal/utils"
@@ -33,6 +34,35 @@ func validateInput(book *model.Book) error {
 	if !strings.HasSuffix(book.Filename, ".txt") {
 		return errors.New("不是txt文件")
 	}
+	switch book.Split {
+	case model.SplitNone, model.SplitSections, model.SplitSize, model.SplitVolume:
+	case model.SplitRange:
+		if _, err := model.ParseRanges(book.SplitRanges); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("不支持的分册方式: %s", book.Split)
+	}
+	for _, template := range []string{book.TitleTemplate, book.VolumeTemplate} {
+		if _, err := model.ParseTitleTemplate(template); err != nil {
+			return err
+		}
+	}
+	switch book.Renumber {
+	case model.RenumberNone, model.RenumberVolume, model.RenumberGlobal:
+	default:
+		return fmt.Errorf("不支持的编号方式: %s", book.Renumber)
+	}
+	if book.TitleJunk != "" && book.TitleJunk != "false" {
+		if _, err := regexp.Compile(book.TitleJunk); err != nil {
+			return fmt.Errorf("标题去除规则错误: %w", err)
+		}
+	}
+	if book.Date != "" {
+		if _, err := time.Parse("2006-01-02", book.Date); err != nil {
+			return fmt.Errorf("出版日期格式错误, 应为2006-01-02: %s", book.Date)
+		}
+	}
 	return nil
 }
 
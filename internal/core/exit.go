@@ -0,0 +1,11 @@
+package core
+
+// 转换的退出码, kaf-cli转换时的退出码和动态库KafConvert的返回值相同
+const (
+	ExitOK       = 0 // 转换成功
+	ExitArgs     = 1 // 参数格式错误
+	ExitCheck    = 2 // 检查文件和参数失败, 如文件不存在、不是txt、正则表达式错误
+	ExitParse    = 3 // 读取txt或识别章节失败
+	ExitConvert  = 4 // 生成电子书失败, 有一种格式失败时也返回这个值
+	ExitCanceled = 5 // 转换被中断, 如按了Ctrl+C
+)
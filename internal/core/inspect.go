@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+var tagReg = regexp.MustCompile(`<[^>]*>`)
+
+// InspectResult txt的分析结果
+type InspectResult struct {
+	Filename   string          `json:"filename"`
+	Size       int64           `json:"size"`
+	Encoding   string          `json:"encoding"`
+	Paragraphs int             `json:"paragraphs"`
+	Words      int             `json:"words"`
+	Volumes    int             `json:"volumes"`
+	Chapters   int             `json:"chapters"`
+	Average    int             `json:"average"` // 每章平均字数
+	Longest    []ChapterWords  `json:"longest"`
+	Shortest   []ChapterWords  `json:"shortest"`
+	Patterns   []PatternResult `json:"patterns"`
+}
+
+// ChapterWords 章节和字数
+type ChapterWords struct {
+	Title string `json:"title"`
+	Words int    `json:"words"`
+}
+
+// PatternResult 章节规则中的一条规则识别出的标题数
+type PatternResult struct {
+	Pattern string `json:"pattern"`
+	Count   int    `json:"count"`
+	Example string `json:"example,omitempty"`
+}
+
+// Inspect 统计识别后的书籍: 编码、字数、最长和最短的章节、每条规则匹配的标题数
+func Inspect(book *model.Book) (*InspectResult, error) {
+	info, err := os.Stat(book.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	encoding, err := DetectEncoding(book.Filename)
+	if err != nil {
+		return nil, err
+	}
+	result := &InspectResult{
+		Filename: book.Filename,
+		Size:     info.Size(),
+		Encoding: encoding,
+	}
+
+	var chapters []ChapterWords
+	var titles []string
+	add := func(section model.Section) {
+		n := Words(section.Content)
+		result.Words += n
+		result.Paragraphs += len(tagReg.FindAllString(section.Content, -1)) / 2
+		titles = append(titles, html.UnescapeString(section.Title))
+		chapters = append(chapters, ChapterWords{Title: html.UnescapeString(section.Title), Words: n})
+	}
+	for _, section := range book.SectionList {
+		if len(section.Sections) > 0 {
+			result.Volumes++
+			result.Words += Words(section.Content)
+			titles = append(titles, html.UnescapeString(section.Title))
+		} else {
+			add(section)
+		}
+		for _, sub := range section.Sections {
+			add(sub)
+		}
+	}
+	result.Chapters = len(chapters)
+	if result.Chapters > 0 {
+		result.Average = result.Words / result.Chapters
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Words > chapters[j].Words })
+	n := min(3, len(chapters))
+	result.Longest = chapters[:n]
+	for i := len(chapters) - 1; i >= len(chapters)-n; i-- {
+		result.Shortest = append(result.Shortest, chapters[i])
+	}
+
+	rules := append(alternatives(book.Match), book.VolumeMatch)
+	for _, rule := range rules {
+		reg, err := regexp.Compile(rule)
+		if err != nil {
+			continue
+		}
+		p := PatternResult{Pattern: rule}
+		for _, title := range titles {
+			if reg.MatchString(title) {
+				if p.Count == 0 {
+					p.Example = title
+				}
+				p.Count++
+			}
+		}
+		result.Patterns = append(result.Patterns, p)
+	}
+	sort.SliceStable(result.Patterns, func(i, j int) bool { return result.Patterns[i].Count > result.Patterns[j].Count })
+	return result, nil
+}
+
+// Words 正文的字数, 不计标签和空白
+func Words(content string) int {
+	text := html.UnescapeString(tagReg.ReplaceAllString(content, ""))
+	return utf8.RuneCountInString(strings.Join(strings.Fields(text), ""))
+}
+
+// alternatives 把正则按最外层的|拆成多条规则
+func alternatives(pattern string) []string {
+	var result []string
+	var depth, start int
+	var class bool
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			result = append(result, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, pattern[start:])
+}
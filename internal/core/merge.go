@@ -0,0 +1,57 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// MergeFiles 按book的设置解析多个txt文件并合并成一本书, 每个文件为一卷, 卷名从文件名识别
+func MergeFiles(book *model.Book, files []string, version string) error {
+	sources := make([]*model.Book, len(files))
+	for i, file := range files {
+		sources[i] = &model.Book{Filename: file}
+	}
+	return Merge(book, sources, version)
+}
+
+// Merge 按book的设置解析sources中的txt文件并合并成一本书, 每个文件为一卷
+//
+// source.Bookname为卷名, 为空时从文件名识别, 和单独转换时的书名相同;
+// book.Bookname为空时使用"第一本书名 合集"。
+func Merge(book *model.Book, sources []*model.Book, version string) error {
+	if len(sources) < 2 {
+		return errors.New("合并至少需要两个txt文件")
+	}
+	books := make([]*model.Book, len(sources))
+	for i, source := range sources {
+		parsed := *book
+		parsed.Filename = source.Filename
+		parsed.Bookname = source.Bookname
+		parsed.Out = ""
+		parsed.Cover = "none"
+		parsed.Tips = false
+		if err := Check(&parsed, version); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(source.Filename), err)
+		}
+		if err := Parse(&parsed); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(source.Filename), err)
+		}
+		books[i] = &parsed
+	}
+	book.Filename = books[0].Filename
+	if book.Bookname == "" {
+		book.Bookname = books[0].Bookname + " 合集"
+	}
+	if err := Check(book, version); err != nil {
+		return err
+	}
+	book.SectionList = nil
+	book.Merge(books...)
+	if book.Tips {
+		book.AddTips()
+	}
+	return nil
+}
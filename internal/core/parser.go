@@ -17,45 +17,84 @@ import (
 	"golang.org/x/text/transform"
 )
 
-func readBuffer(book *model.Book, filename string) *bufio.Reader {
+// readBuffer 读取txt并转换为utf-8
+func readBuffer(book *model.Book, r io.Reader) (*bufio.Reader, error) {
+	buf := bufio.NewReader(r)
+	bs, _ := buf.Peek(1024)
+	encodig, encodename, _ := charset.DetermineEncoding(bs, "text/plain")
+	if encodename == "utf-8" {
+		return buf, nil
+	}
+	bs, err := io.ReadAll(buf)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	book.Decoder = encodig.NewDecoder()
+	if encodename == "windows-1252" {
+		book.Decoder = simplifiedchinese.GB18030.NewDecoder()
+	}
+	bs, _, _ = transform.Bytes(book.Decoder, bs)
+	return bufio.NewReader(bytes.NewReader(bs)), nil
+}
+
+// DetectEncoding 识别txt文件的编码, 和读取时的判断相同
+func DetectEncoding(filename string) (string, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("读取文件出错: ", err.Error())
-		os.Exit(1)
+		return "", fmt.Errorf("读取文件出错: %w", err)
 	}
-	temBuf := bufio.NewReader(f)
-	bs, _ := temBuf.Peek(1024)
-	encodig, encodename, _ := charset.DetermineEncoding(bs, "text/plain")
-	if encodename != "utf-8" {
-		f.Seek(0, 0)
-		bs, err := io.ReadAll(f)
-		if err != nil {
-			fmt.Println("读取文件出错: ", err.Error())
-			os.Exit(1)
-		}
-		var buf bytes.Buffer
-		book.Decoder = encodig.NewDecoder()
-		if encodename == "windows-1252" {
-			book.Decoder = simplifiedchinese.GB18030.NewDecoder()
-		}
-		bs, _, _ = transform.Bytes(book.Decoder, bs)
-		buf.Write(bs)
-		return bufio.NewReader(&buf)
-	} else {
-		f.Seek(0, 0)
-		buf := bufio.NewReader(f)
-		return buf
+	defer f.Close()
+	bs, _ := bufio.NewReader(f).Peek(1024)
+	_, encodename, _ := charset.DetermineEncoding(bs, "text/plain")
+	if encodename == "windows-1252" {
+		encodename = "gb18030"
+	}
+	return encodename, nil
+}
+
+// ReadText 读取整个txt文件并转换为utf-8
+func ReadText(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("读取文件出错: %w", err)
+	}
+	defer f.Close()
+	buf, err := readBuffer(&model.Book{}, f)
+	if err != nil {
+		return "", err
+	}
+	bs, err := io.ReadAll(buf)
+	if err != nil {
+		return "", fmt.Errorf("读取文件出错: %w", err)
 	}
+	return string(bs), nil
 }
 
 func Parse(book *model.Book) error {
+	if book == nil {
+		return fmt.Errorf("book参数不能为nil")
+	}
+	f, err := os.Open(book.Filename)
+	if err != nil {
+		return fmt.Errorf("读取文件出错: %w", err)
+	}
+	defer f.Close()
+	return ParseReader(book, f)
+}
+
+// ParseReader 从r读取txt并识别章节, 不读取book.Filename, 用于在内存中转换
+func ParseReader(book *model.Book, r io.Reader) error {
 	if book == nil {
 		return fmt.Errorf("book参数不能为nil")
 	}
 	var contentList []model.Section
-	fmt.Println("正在读取txt文件...")
+	log := book.Logger()
+	log.Infof("正在读取txt文件...")
 	start := time.Now()
-	buf := readBuffer(book, book.Filename)
+	buf, err := readBuffer(book, r)
+	if err != nil {
+		return err
+	}
 	var title string
 	var content bytes.Buffer
 	for {
@@ -77,6 +116,7 @@ func Parse(book *model.Book) error {
 			return fmt.Errorf("读取文件出错: %w", err)
 		}
 		line = strings.TrimSpace(line)
+		line = strings.ReplaceAll(line, "&", "&amp;")
 		line = strings.ReplaceAll(line, "<", "&lt;")
 		line = strings.ReplaceAll(line, ">", "&gt;")
 		// 空行直接跳过
@@ -109,6 +149,9 @@ func Parse(book *model.Book) error {
 		}
 		utils.AddPart(&content, line)
 	}
+	if title == "" {
+		log.Warnf("没有识别到章节标题, 全文作为一章, 可以用-match设置匹配标题的正则表达式")
+	}
 	// 没识别到章节又没识别到 EOF 时，把所有的内容写到最后一章
 	if content.Len() != 0 {
 		if title == "" {
@@ -149,17 +192,15 @@ func Parse(book *model.Book) error {
 		volumeSection = nil
 	}
 	end := time.Now().Sub(start)
-	fmt.Println("读取文件耗时:", end)
-	fmt.Println("匹配章节:", model.SectionCount(sectionList))
+	log.Infof("读取文件耗时: %v", end)
+	log.Infof("匹配章节: %d", model.SectionCount(sectionList))
+	book.SectionList = sectionList
+	if err := book.NormalizeTitles(); err != nil {
+		return err
+	}
 	// 添加提示
 	if book.Tips {
-		tuorialSection := model.Section{
-			Title:   "制作说明",
-			Content: model.Tutorial,
-		}
-		sectionList = append([]model.Section{tuorialSection}, sectionList...)
-		sectionList = append(sectionList, tuorialSection)
+		book.AddTips()
 	}
-	book.SectionList = sectionList
 	return nil
 }
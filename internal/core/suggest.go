@@ -0,0 +1,154 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// MatchSuggestion 建议的章节标题规则
+type MatchSuggestion struct {
+	Pattern  string   `json:"pattern"`
+	Count    int      `json:"count"`    // 样本中匹配的行数
+	Examples []string `json:"examples"` // 匹配的前几行
+}
+
+// matchCandidates 常见的章节标题格式
+var matchCandidates = []string{
+	"^第[0-9一二三四五六七八九十零〇百千两 ]+[章回节集幕话]",
+	"^[Cc]hapter\\s*[0-9]+",
+	"^[0-9]+[、.．]",
+	"^[一二三四五六七八九十零〇百千两]+[、.．]",
+	"^[0-9]{1,4}$",
+	"^[【［\\[].{1,30}[】］\\]]$",
+	"^(序章|楔子|引子|尾声|后记|番外)",
+}
+
+const (
+	digitClass   = "[0-9]"
+	numeralClass = "[0-9一二三四五六七八九十零〇百千两]"
+	numerals     = "0123456789一二三四五六七八九十零〇百千两"
+	titleMarks   = "章回节集幕话卷部篇"
+)
+
+// SuggestMatch 根据txt样本建议匹配章节标题的正则表达式, 按匹配的行数从多到少排列
+//
+// 除了常见的标题格式, 还会把样本中以序号开头的短行归纳为规则, 如"Ep.12 风起"归纳为^Ep\.[0-9]+。
+// max为标题的最大字数, 为0时使用35。
+func SuggestMatch(sample string, max int) []MatchSuggestion {
+	if max <= 0 {
+		max = 35
+	}
+	var lines []string
+	for _, line := range strings.Split(sample, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && utf8.RuneCountInString(line) <= max {
+			lines = append(lines, line)
+		}
+	}
+
+	patterns := append([]string{}, matchCandidates...)
+	shapes := map[string]int{}
+	for _, line := range lines {
+		if shape := lineShape(line); shape != "" {
+			shapes[shape]++
+		}
+	}
+	for shape, n := range shapes {
+		if n >= 2 && !slices.Contains(patterns, shape) {
+			patterns = append(patterns, shape)
+		}
+	}
+
+	var result []MatchSuggestion
+	for _, pattern := range patterns {
+		reg, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		s := MatchSuggestion{Pattern: pattern, Examples: []string{}}
+		for _, line := range lines {
+			if reg.MatchString(line) {
+				s.Count++
+				if len(s.Examples) < 3 {
+					s.Examples = append(s.Examples, line)
+				}
+			}
+		}
+		if s.Count > 0 {
+			result = append(result, s)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return len(result[i].Pattern) < len(result[j].Pattern)
+	})
+	return result
+}
+
+// SuggestMatchFile 读取txt的开头部分, 建议匹配章节标题的正则表达式
+func SuggestMatchFile(filename string, max int) ([]MatchSuggestion, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	defer f.Close()
+	buf, err := readBuffer(&model.Book{}, io.LimitReader(f, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	bs, err := io.ReadAll(buf)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	return SuggestMatch(string(bs), max), nil
+}
+
+// lineShape 把以序号开头的行归纳为规则: 序号前最多4个字原样保留, 序号后是标点或"章"之类的字时一并保留
+func lineShape(line string) string {
+	runes := []rune(line)
+	start := -1
+	for i := 0; i < len(runes) && i <= 4; i++ {
+		if strings.ContainsRune(numerals, runes[i]) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return ""
+	}
+	end := start
+	class := digitClass
+	for end < len(runes) && strings.ContainsRune(numerals, runes[end]) {
+		if runes[end] > unicode.MaxASCII {
+			class = numeralClass
+		}
+		end++
+	}
+	prefix := string(runes[:start])
+	if strings.ContainsFunc(prefix, unicode.IsSpace) {
+		return ""
+	}
+	shape := "^" + regexp.QuoteMeta(prefix) + class + "+"
+	if end < len(runes) {
+		next := runes[end]
+		if strings.ContainsRune(titleMarks, next) || unicode.IsPunct(next) {
+			shape += regexp.QuoteMeta(string(next))
+		}
+	}
+	// 只有序号时太宽泛, 交给常见格式中的规则
+	if prefix == "" && shape == "^"+class+"+" {
+		return ""
+	}
+	return shape
+}
@@ -13,6 +13,8 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.ConversionHistory{},
 		&models.ConversionPreset{},
 		&models.DownloadRecord{},
+		&models.ConversionJob{},
+		&models.ConversionBatch{},
 	)
 	if err != nil {
 		return err
@@ -23,6 +25,11 @@ func AutoMigrate(db *gorm.DB) error {
 		return err
 	}
 
+	// 创建内置的转换预设（如果不存在）
+	if err := createSystemPresets(db); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -46,4 +53,54 @@ func createDefaultAdmin(db *gorm.DB) error {
 
 	result := db.Create(admin)
 	return result.Error
-}
\ No newline at end of file
+}
+
+// systemPresets 内置的转换预设
+var systemPresets = []models.ConversionPreset{
+	{
+		Name:         "Kindle",
+		Description:  "适合Kindle阅读器, 生成azw3, 段落间距较小",
+		OutputFormat: "azw3",
+		Options: models.ConvertOptionsJSON{
+			"bottom":      "0.5em",
+			"line_height": "1.5",
+		},
+	},
+	{
+		Name:         "Kobo",
+		Description:  "适合Kobo等支持epub的阅读器",
+		OutputFormat: "epub",
+		Options: models.ConvertOptionsJSON{
+			"bottom":      "0.8em",
+			"line_height": "1.6",
+		},
+	},
+	{
+		Name:         "大字版",
+		Description:  "加大行高和段落间距, 标题左对齐, 适合调大字号阅读",
+		OutputFormat: "epub",
+		Options: models.ConvertOptionsJSON{
+			"align":       "left",
+			"bottom":      "1.5em",
+			"line_height": "2",
+		},
+	},
+}
+
+// createSystemPresets 创建内置的转换预设, 已存在的不修改
+func createSystemPresets(db *gorm.DB) error {
+	for _, preset := range systemPresets {
+		var count int64
+		db.Model(&models.ConversionPreset{}).Where("is_system = ? AND name = ?", true, preset.Name).Count(&count)
+		if count > 0 {
+			continue
+		}
+
+		preset.IsSystem = true
+		preset.IsPublic = true
+		if err := db.Create(&preset).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package epubcheck 检查epub文件是否符合EPUB规范
+//
+// 覆盖商店的epubcheck最常报告的问题: mimetype的位置和压缩方式、container.xml、
+// OPF的元数据、清单和书脊、XHTML是否格式正确、导航和NCX的链接、重复ID、缺失的资源以及语言标签。
+package epubcheck
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Severity 问题的严重程度
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // 不符合规范, 商店会拒绝
+	SeverityWarning Severity = "warning" // 可能导致显示问题
+)
+
+// 问题分类
+const (
+	CodeZip       = "zip"       // 不是有效的zip文件
+	CodeMimetype  = "mimetype"  // mimetype文件
+	CodeContainer = "container" // META-INF/container.xml
+	CodeMetadata  = "metadata"  // OPF元数据
+	CodeManifest  = "manifest"  // OPF清单
+	CodeSpine     = "spine"     // OPF书脊
+	CodeXHTML     = "xhtml"     // XHTML格式错误
+	CodeID        = "id"        // 重复ID
+	CodeLink      = "link"      // 链接目标不存在
+	CodeResource  = "resource"  // 引用的资源不存在或未声明
+	CodeLanguage  = "language"  // 语言标签
+	CodeNav       = "nav"       // 导航文档
+	CodeNCX       = "ncx"       // NCX目录
+)
+
+// ErrInvalid 检查发现错误
+var ErrInvalid = errors.New("epub不符合规范")
+
+// Finding 检查发现的一个问题
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	File     string   `json:"file,omitempty"` // 出现问题的文件, 为epub内的路径
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	if f.File == "" {
+		return fmt.Sprintf("%s [%s] %s", f.Severity, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s [%s] %s: %s", f.Severity, f.Code, f.File, f.Message)
+}
+
+// Report 检查结果
+type Report struct {
+	Version  string    `json:"version,omitempty"` // OPF中声明的EPUB版本
+	Findings []Finding `json:"findings"`
+}
+
+// Valid 没有错误时返回true, 警告不影响结果
+func (r *Report) Valid() bool {
+	return len(r.Errors()) == 0
+}
+
+// Errors 返回所有错误
+func (r *Report) Errors() []Finding {
+	return r.filter(SeverityError)
+}
+
+// Warnings 返回所有警告
+func (r *Report) Warnings() []Finding {
+	return r.filter(SeverityWarning)
+}
+
+// Err 有错误时返回包含所有错误的ErrInvalid
+func (r *Report) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, f := range errs {
+		messages[i] = f.String()
+	}
+	return fmt.Errorf("%w:\n%s", ErrInvalid, strings.Join(messages, "\n"))
+}
+
+func (r *Report) filter(severity Severity) []Finding {
+	var findings []Finding
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+func (r *Report) add(severity Severity, code, file, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{Severity: severity, Code: code, File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) errorf(code, file, format string, args ...any) {
+	r.add(SeverityError, code, file, format, args...)
+}
+
+func (r *Report) warnf(code, file, format string, args ...any) {
+	r.add(SeverityWarning, code, file, format, args...)
+}
+
+// CheckFile 检查epub文件
+func CheckFile(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return Check(f, info.Size())
+}
+
+// CheckBytes 检查内存中的epub
+func CheckBytes(data []byte) (*Report, error) {
+	return Check(bytes.NewReader(data), int64(len(data)))
+}
+
+// Check 检查epub, 返回的错误只表示无法读取, 不符合规范的地方记录在Report中
+func Check(r io.ReaderAt, size int64) (*Report, error) {
+	report := &Report{}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		report.errorf(CodeZip, "", "不是有效的zip文件: %v", err)
+		return report, nil
+	}
+	c := &checker{report: report, files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		if _, ok := c.files[f.Name]; ok {
+			report.errorf(CodeZip, f.Name, "zip中有重复的文件")
+		}
+		c.files[f.Name] = f
+	}
+	c.checkMimetype(zr.File)
+	opfPath, ok := c.checkContainer()
+	if !ok {
+		return report, nil
+	}
+	if err := c.checkPackage(opfPath); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,426 @@
+package epubcheck
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	mimetype      = "application/epub+zip"
+	containerPath = "META-INF/container.xml"
+	opfMediaType  = "application/oebps-package+xml"
+	xhtmlType     = "application/xhtml+xml"
+	ncxType       = "application/x-dtbncx+xml"
+)
+
+// checker 一次检查的状态
+type checker struct {
+	report   *Report
+	files    map[string]*zip.File
+	manifest map[string]manifestItem // 按文件路径索引
+	docs     map[string]*document    // 已解析的XHTML文档, 按文件路径索引
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Identifiers []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"identifier"`
+		Titles    []string `xml:"title"`
+		Languages []string `xml:"language"`
+		Metas     []struct {
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []manifestItem `xml:"manifest>item"`
+	Spine    struct {
+		Toc      string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type manifestItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+	path       string // 在zip中的路径
+}
+
+func (item manifestItem) hasProperty(name string) bool {
+	return slices.Contains(strings.Fields(item.Properties), name)
+}
+
+// checkMimetype mimetype必须是第一个文件, 不压缩, 内容为application/epub+zip
+func (c *checker) checkMimetype(files []*zip.File) {
+	if len(files) == 0 || files[0].Name != "mimetype" {
+		c.report.errorf(CodeMimetype, "mimetype", "mimetype必须是zip中的第一个文件")
+	}
+	f, ok := c.files["mimetype"]
+	if !ok {
+		return
+	}
+	if f.Method != zip.Store {
+		c.report.errorf(CodeMimetype, f.Name, "mimetype不能压缩")
+	}
+	if len(f.Extra) > 0 {
+		c.report.warnf(CodeMimetype, f.Name, "mimetype的文件头不应包含扩展字段")
+	}
+	data, err := c.read(f.Name)
+	if err == nil && string(data) != mimetype {
+		c.report.errorf(CodeMimetype, f.Name, "内容应为%s, 实际为%q", mimetype, data)
+	}
+}
+
+// checkContainer 读取container.xml, 返回OPF文件的路径
+func (c *checker) checkContainer() (string, bool) {
+	data, err := c.read(containerPath)
+	if err != nil {
+		c.report.errorf(CodeContainer, containerPath, "缺少container.xml")
+		return "", false
+	}
+	var ct container
+	if err := xml.Unmarshal(data, &ct); err != nil {
+		c.report.errorf(CodeContainer, containerPath, "无法解析: %v", err)
+		return "", false
+	}
+	if len(ct.Rootfiles) == 0 {
+		c.report.errorf(CodeContainer, containerPath, "没有声明rootfile")
+		return "", false
+	}
+	root := ct.Rootfiles[0]
+	if root.MediaType != opfMediaType {
+		c.report.errorf(CodeContainer, containerPath, "rootfile的media-type应为%s", opfMediaType)
+	}
+	if _, ok := c.files[root.FullPath]; !ok {
+		c.report.errorf(CodeContainer, containerPath, "rootfile %s 不存在", root.FullPath)
+		return "", false
+	}
+	return root.FullPath, true
+}
+
+// checkPackage 检查OPF和它引用的所有文件
+func (c *checker) checkPackage(opfPath string) error {
+	data, err := c.read(opfPath)
+	if err != nil {
+		return err
+	}
+	var pkg opfPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		c.report.errorf(CodeXHTML, opfPath, "无法解析: %v", err)
+		return nil
+	}
+	c.report.Version = pkg.Version
+	c.checkMetadata(opfPath, &pkg)
+	items := c.checkManifest(opfPath, &pkg)
+	c.checkSpine(opfPath, &pkg, items)
+
+	// 先解析所有文档, 再检查文档之间的链接
+	c.docs = make(map[string]*document)
+	for _, item := range pkg.Manifest {
+		if item.MediaType != xhtmlType || c.files[item.path] == nil {
+			continue
+		}
+		doc, err := c.parseDocument(item.path)
+		if err != nil {
+			return err
+		}
+		c.docs[item.path] = doc
+	}
+	for _, item := range pkg.Manifest {
+		if doc := c.docs[item.path]; doc != nil {
+			c.checkLinks(doc)
+		}
+	}
+	c.checkNav(&pkg)
+	return c.checkNCX(&pkg, items)
+}
+
+func (c *checker) checkMetadata(opfPath string, pkg *opfPackage) {
+	if pkg.Version != "2.0" && !strings.HasPrefix(pkg.Version, "3.") {
+		c.report.errorf(CodeMetadata, opfPath, "不支持的EPUB版本: %q", pkg.Version)
+	}
+	meta := pkg.Metadata
+	if len(meta.Titles) == 0 || strings.TrimSpace(meta.Titles[0]) == "" {
+		c.report.errorf(CodeMetadata, opfPath, "缺少dc:title")
+	}
+	found := false
+	for _, id := range meta.Identifiers {
+		if id.ID == pkg.UniqueIdentifier {
+			found = true
+			if strings.TrimSpace(id.Value) == "" {
+				c.report.errorf(CodeMetadata, opfPath, "dc:identifier不能为空")
+			}
+		}
+	}
+	if !found {
+		c.report.errorf(CodeMetadata, opfPath, "unique-identifier %q 没有对应的dc:identifier", pkg.UniqueIdentifier)
+	}
+	if len(meta.Languages) == 0 {
+		c.report.errorf(CodeLanguage, opfPath, "缺少dc:language")
+	}
+	for _, lang := range meta.Languages {
+		if _, err := language.Parse(strings.TrimSpace(lang)); err != nil {
+			c.report.errorf(CodeLanguage, opfPath, "dc:language %q 不是有效的语言标签", lang)
+		}
+	}
+	if strings.HasPrefix(pkg.Version, "3.") {
+		modified := false
+		for _, m := range meta.Metas {
+			modified = modified || m.Property == "dcterms:modified"
+		}
+		if !modified {
+			c.report.errorf(CodeMetadata, opfPath, "EPUB3必须包含dcterms:modified")
+		}
+	}
+}
+
+// checkManifest 检查清单中的文件, 返回按id索引的清单
+func (c *checker) checkManifest(opfPath string, pkg *opfPackage) map[string]manifestItem {
+	dir := path.Dir(opfPath)
+	items := make(map[string]manifestItem)
+	c.manifest = make(map[string]manifestItem)
+	for i, item := range pkg.Manifest {
+		if item.ID == "" {
+			c.report.errorf(CodeManifest, opfPath, "清单项 %s 缺少id", item.Href)
+		} else if _, ok := items[item.ID]; ok {
+			c.report.errorf(CodeID, opfPath, "清单中有重复的id: %s", item.ID)
+		}
+		if item.MediaType == "" {
+			c.report.errorf(CodeManifest, opfPath, "清单项 %s 缺少media-type", item.ID)
+		}
+		target, ok := resolve(dir, item.Href)
+		if !ok {
+			c.report.errorf(CodeManifest, opfPath, "清单项 %s 的地址无效: %s", item.ID, item.Href)
+			continue
+		}
+		item.path = target
+		pkg.Manifest[i] = item
+		if _, ok := c.manifest[target]; ok {
+			c.report.errorf(CodeManifest, opfPath, "清单中重复声明了文件: %s", item.Href)
+		}
+		if _, ok := c.files[target]; !ok {
+			c.report.errorf(CodeResource, opfPath, "清单中的文件不存在: %s", item.Href)
+		}
+		items[item.ID] = item
+		c.manifest[target] = item
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.files)) {
+		if name == "mimetype" || name == opfPath || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if _, ok := c.manifest[name]; !ok {
+			c.report.warnf(CodeManifest, name, "文件没有在清单中声明")
+		}
+	}
+	return items
+}
+
+func (c *checker) checkSpine(opfPath string, pkg *opfPackage, items map[string]manifestItem) {
+	if len(pkg.Spine.Itemrefs) == 0 {
+		c.report.errorf(CodeSpine, opfPath, "书脊为空")
+	}
+	seen := make(map[string]bool)
+	for _, ref := range pkg.Spine.Itemrefs {
+		item, ok := items[ref.IDRef]
+		if !ok {
+			c.report.errorf(CodeSpine, opfPath, "书脊引用的清单项不存在: %s", ref.IDRef)
+			continue
+		}
+		if seen[ref.IDRef] {
+			c.report.errorf(CodeSpine, opfPath, "书脊中重复引用: %s", ref.IDRef)
+		}
+		seen[ref.IDRef] = true
+		if item.MediaType != xhtmlType && item.MediaType != "image/svg+xml" {
+			c.report.warnf(CodeSpine, opfPath, "书脊中的 %s 不是XHTML文档", item.Href)
+		}
+	}
+	if pkg.Spine.Toc != "" {
+		if item, ok := items[pkg.Spine.Toc]; !ok || item.MediaType != ncxType {
+			c.report.errorf(CodeSpine, opfPath, "spine的toc属性应引用NCX文件: %s", pkg.Spine.Toc)
+		}
+	} else if pkg.Version == "2.0" {
+		c.report.errorf(CodeNCX, opfPath, "EPUB2必须包含NCX目录")
+	}
+}
+
+// checkNav EPUB3必须有一个包含toc导航的导航文档
+func (c *checker) checkNav(pkg *opfPackage) {
+	if !strings.HasPrefix(pkg.Version, "3.") {
+		return
+	}
+	var navs []manifestItem
+	for _, item := range pkg.Manifest {
+		if item.hasProperty("nav") {
+			navs = append(navs, item)
+		}
+	}
+	if len(navs) != 1 {
+		c.report.errorf(CodeNav, "", "EPUB3必须有且只有一个导航文档, 实际有%d个", len(navs))
+		return
+	}
+	doc := c.docs[navs[0].path]
+	if doc != nil && !doc.hasTocNav {
+		c.report.errorf(CodeNav, navs[0].path, "导航文档缺少epub:type=\"toc\"的nav元素")
+	}
+}
+
+type ncx struct {
+	Head []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"head>meta"`
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+type ncxNavPoint struct {
+	ID      string `xml:"id,attr"`
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	NavPoints []ncxNavPoint `xml:"navPoint"`
+}
+
+// checkNCX 检查NCX中的链接和重复ID
+func (c *checker) checkNCX(pkg *opfPackage, items map[string]manifestItem) error {
+	item, ok := items[pkg.Spine.Toc]
+	if !ok || c.files[item.path] == nil {
+		return nil
+	}
+	data, err := c.read(item.path)
+	if err != nil {
+		return err
+	}
+	var toc ncx
+	if err := xml.Unmarshal(data, &toc); err != nil {
+		c.report.errorf(CodeXHTML, item.path, "无法解析: %v", err)
+		return nil
+	}
+	for _, meta := range toc.Head {
+		if meta.Name != "dtb:uid" {
+			continue
+		}
+		for _, id := range pkg.Metadata.Identifiers {
+			if id.ID == pkg.UniqueIdentifier && strings.TrimSpace(id.Value) != strings.TrimSpace(meta.Content) {
+				c.report.warnf(CodeNCX, item.path, "dtb:uid和OPF的dc:identifier不一致")
+			}
+		}
+	}
+	ids := make(map[string]bool)
+	var walk func(points []ncxNavPoint)
+	walk = func(points []ncxNavPoint) {
+		for _, p := range points {
+			if p.ID != "" {
+				if ids[p.ID] {
+					c.report.errorf(CodeID, item.path, "重复的id: %s", p.ID)
+				}
+				ids[p.ID] = true
+			}
+			if strings.TrimSpace(p.Label) == "" {
+				c.report.warnf(CodeNCX, item.path, "navPoint %s 没有标题", p.ID)
+			}
+			c.checkTarget(item.path, p.Content.Src, true)
+			walk(p.NavPoints)
+		}
+	}
+	walk(toc.NavPoints)
+	return nil
+}
+
+// checkTarget 检查from中引用的地址, hyperlink为true时目标必须是清单中的文档, 并检查锚点
+func (c *checker) checkTarget(from, href string, hyperlink bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		c.report.errorf(CodeLink, from, "地址无效: %s", href)
+		return
+	}
+	if u.Scheme != "" || u.Host != "" {
+		// 外部链接不检查
+		return
+	}
+	target := from
+	if u.Path != "" {
+		var ok bool
+		if target, ok = resolve(path.Dir(from), u.Path); !ok {
+			c.report.errorf(CodeLink, from, "地址超出了epub: %s", href)
+			return
+		}
+	}
+	code := CodeResource
+	if hyperlink {
+		code = CodeLink
+	}
+	if _, ok := c.files[target]; !ok {
+		c.report.errorf(code, from, "引用的文件不存在: %s", href)
+		return
+	}
+	if _, ok := c.manifest[target]; !ok {
+		c.report.errorf(code, from, "引用的文件没有在清单中声明: %s", href)
+		return
+	}
+	if u.Fragment == "" {
+		return
+	}
+	if doc := c.docs[target]; doc != nil && !doc.ids[u.Fragment] {
+		c.report.errorf(CodeLink, from, "锚点不存在: %s", href)
+	}
+}
+
+// resolve 把相对地址转换为zip中的路径
+func resolve(dir, href string) (string, bool) {
+	p, err := url.PathUnescape(href)
+	if err != nil {
+		return "", false
+	}
+	if i := strings.IndexAny(p, "#?"); i >= 0 {
+		p = p[:i]
+	}
+	if strings.HasPrefix(p, "/") {
+		return "", false
+	}
+	p = path.Join(dir, p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+func (c *checker) read(name string) ([]byte, error) {
+	f, ok := c.files[name]
+	if !ok {
+		return nil, fmt.Errorf("文件不存在: %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("读取%s失败: %w", name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("读取%s失败: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package epubcheck
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const opsNamespace = "http://www.idpf.org/2007/ops"
+
+// document 解析后的XHTML文档
+type document struct {
+	path      string
+	ids       map[string]bool
+	refs      []reference
+	hasTocNav bool
+}
+
+// reference 文档中引用的地址
+type reference struct {
+	href      string
+	hyperlink bool // a标签的链接, 其他为图片、样式等资源
+}
+
+// referenceAttrs 引用资源的元素和属性
+var referenceAttrs = map[string]string{
+	"img":    "src",
+	"link":   "href",
+	"script": "src",
+	"source": "src",
+	"audio":  "src",
+	"video":  "src",
+	"image":  "href", // svg
+}
+
+// parseDocument 检查XHTML是否格式正确, 并记录ID、语言标签和引用
+func (c *checker) parseDocument(name string) (*document, error) {
+	data, err := c.read(name)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{path: name, ids: make(map[string]bool)}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// XHTML只支持XML的实体, &nbsp;等HTML实体会导致阅读器无法打开
+	dec.Strict = true
+	root := true
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.report.errorf(CodeXHTML, name, "格式错误: %v", err)
+			return doc, nil
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root {
+			root = false
+			if el.Name.Local != "html" {
+				c.report.errorf(CodeXHTML, name, "根元素应为html, 实际为%s", el.Name.Local)
+			}
+		}
+		for _, attr := range el.Attr {
+			switch {
+			case attr.Name.Local == "id" && attr.Name.Space == "":
+				if doc.ids[attr.Value] {
+					c.report.errorf(CodeID, name, "重复的id: %s", attr.Value)
+				}
+				doc.ids[attr.Value] = true
+			case attr.Name.Local == "lang":
+				if _, err := language.Parse(attr.Value); attr.Value != "" && err != nil {
+					c.report.errorf(CodeLanguage, name, "%s不是有效的语言标签", attr.Value)
+				}
+			case attr.Name.Local == "type" && attr.Name.Space == opsNamespace:
+				if el.Name.Local == "nav" && strings.Contains(" "+attr.Value+" ", " toc ") {
+					doc.hasTocNav = true
+				}
+			}
+		}
+		if el.Name.Local == "a" {
+			if href := attrValue(el, "href"); href != "" {
+				doc.refs = append(doc.refs, reference{href: href, hyperlink: true})
+			}
+		} else if attr, ok := referenceAttrs[el.Name.Local]; ok {
+			if href := attrValue(el, attr); href != "" {
+				doc.refs = append(doc.refs, reference{href: href})
+			}
+		}
+	}
+	return doc, nil
+}
+
+// checkLinks 检查文档中的链接和引用的资源
+func (c *checker) checkLinks(doc *document) {
+	for _, ref := range doc.refs {
+		if strings.HasPrefix(ref.href, "data:") {
+			continue
+		}
+		c.checkTarget(doc.path, ref.href, ref.hyperlink)
+	}
+}
+
+func attrValue(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return strings.TrimSpace(attr.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,680 @@
+package font
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CFF字典中的操作符, 两字节操作符记为12<<8|第二个字节
+const (
+	opCharset     = 15
+	opEncoding    = 16
+	opCharStrings = 17
+	opPrivate     = 18
+	opSubrs       = 19
+	opFDArray     = 12<<8 | 36
+	opFDSelect    = 12<<8 | 37
+)
+
+// Type 2字形程序的操作符
+const (
+	csHStem     = 1
+	csVStem     = 3
+	csCallSubr  = 10
+	csReturn    = 11
+	csEscape    = 12
+	csEndChar   = 14
+	csHStemHM   = 18
+	csHintMask  = 19
+	csCntrMask  = 20
+	csVStemHM   = 23
+	csShortInt  = 28
+	csCallGSubr = 29
+)
+
+// 子程序最多嵌套的层数
+const maxSubrDepth = 10
+
+var (
+	errInvalidCFF    = fmt.Errorf("%w: CFF表已损坏", ErrInvalidFont)
+	errCFFCharstring = fmt.Errorf("%w: 无法解析CFF字形", ErrUnsupportedFont)
+)
+
+// subsetCFF 清空CFF表中没有保留的字形和没有用到的子程序
+//
+// 和glyf一样字形编号保持不变, 未使用的字形替换为只有endchar的空字形,
+// 未使用的子程序替换为只有return的空程序, 这样子程序编号也不用改写。
+func subsetCFF(cff []byte, keep map[uint16]bool) ([]byte, error) {
+	if len(cff) < 4 || int(cff[2]) < 4 || int(cff[2]) > len(cff) {
+		return nil, errInvalidCFF
+	}
+	hdrSize := int(cff[2])
+	_, nameEnd, err := parseIndex(cff, hdrSize)
+	if err != nil {
+		return nil, err
+	}
+	topDicts, topEnd, err := parseIndex(cff, nameEnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(topDicts) != 1 {
+		return nil, fmt.Errorf("%w: CFF表包含%d个字体", ErrUnsupportedFont, len(topDicts))
+	}
+	_, stringEnd, err := parseIndex(cff, topEnd)
+	if err != nil {
+		return nil, err
+	}
+	gsubrs, _, err := parseIndex(cff, stringEnd)
+	if err != nil {
+		return nil, err
+	}
+	top, err := parseDict(topDicts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	offset, ok := top.int(opCharStrings)
+	if !ok {
+		return nil, fmt.Errorf("%w: 缺少CharStrings", errInvalidCFF)
+	}
+	charStrings, _, err := parseIndex(cff, offset)
+	if err != nil {
+		return nil, err
+	}
+	numGlyphs := len(charStrings)
+
+	// 不需要改写的数据原样复制, 预定义的字符集和编码没有偏移
+	var charset, encoding, fdSelect []byte
+	if offset, ok := top.int(opCharset); ok && offset > 2 {
+		if charset, err = charsetData(cff, offset, numGlyphs); err != nil {
+			return nil, err
+		}
+	}
+	if offset, ok := top.int(opEncoding); ok && offset > 1 {
+		if encoding, err = encodingData(cff, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	// 普通字体只有一个私有字典, CID字体每个FD一个
+	var fontDicts []cffDict
+	var privates []*cffPrivate
+	var fds []int
+	if offset, ok := top.int(opFDArray); ok {
+		items, _, err := parseIndex(cff, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			fd, err := parseDict(item)
+			if err != nil {
+				return nil, err
+			}
+			private, err := parsePrivate(cff, fd)
+			if err != nil {
+				return nil, err
+			}
+			fontDicts = append(fontDicts, fd)
+			privates = append(privates, private)
+		}
+		selectOffset, ok := top.int(opFDSelect)
+		if !ok {
+			return nil, fmt.Errorf("%w: 缺少FDSelect", errInvalidCFF)
+		}
+		if fdSelect, fds, err = parseFDSelect(cff, selectOffset, numGlyphs, len(privates)); err != nil {
+			return nil, err
+		}
+	} else {
+		private, err := parsePrivate(cff, top)
+		if err != nil {
+			return nil, err
+		}
+		privates = append(privates, private)
+	}
+
+	usedGlobal := make(map[int]bool)
+	newCharStrings := make([][]byte, numGlyphs)
+	for gid := range newCharStrings {
+		if !keep[uint16(gid)] {
+			newCharStrings[gid] = []byte{csEndChar}
+			continue
+		}
+		private := privates[0]
+		if fds != nil {
+			private = privates[fds[gid]]
+		}
+		s := subrScanner{global: gsubrs, local: private.subrs, usedGlobal: usedGlobal, usedLocal: private.used}
+		if err := s.scan(charStrings[gid]); err != nil {
+			return nil, err
+		}
+		newCharStrings[gid] = charStrings[gid]
+	}
+
+	// 先用占位的偏移计算各部分的长度, 偏移都用定长编码, 长度和取值无关
+	header := append([]byte(nil), cff[:hdrSize]...)
+	header[3] = 4
+	newGSubrs := buildIndex(pruneSubrs(gsubrs, usedGlobal))
+	charStringIndex := buildIndex(newCharStrings)
+	offsets := make(map[int][]int)
+	if charset != nil {
+		offsets[opCharset] = []int{0}
+	}
+	if encoding != nil {
+		offsets[opEncoding] = []int{0}
+	}
+	offsets[opCharStrings] = []int{0}
+	if fontDicts != nil {
+		offsets[opFDArray] = []int{0}
+		offsets[opFDSelect] = []int{0}
+	} else {
+		offsets[opPrivate] = []int{0, 0}
+	}
+	pos := len(header) + (nameEnd - hdrSize) + len(buildIndex([][]byte{top.encode(offsets)})) +
+		(stringEnd - topEnd) + len(newGSubrs)
+
+	if charset != nil {
+		offsets[opCharset] = []int{pos}
+		pos += len(charset)
+	}
+	if encoding != nil {
+		offsets[opEncoding] = []int{pos}
+		pos += len(encoding)
+	}
+	if fdSelect != nil {
+		offsets[opFDSelect] = []int{pos}
+		pos += len(fdSelect)
+	}
+	offsets[opCharStrings] = []int{pos}
+	pos += len(charStringIndex)
+
+	privateData := make([][]byte, len(privates))
+	for i, private := range privates {
+		privateData[i] = private.build()
+	}
+	var fdArray []byte
+	if fontDicts != nil {
+		placeholder := map[int][]int{opPrivate: {0, 0}}
+		items := make([][]byte, len(fontDicts))
+		for i, fd := range fontDicts {
+			items[i] = fd.encode(placeholder)
+		}
+		offsets[opFDArray] = []int{pos}
+		pos += len(buildIndex(items))
+		for i, fd := range fontDicts {
+			items[i] = fd.encode(map[int][]int{opPrivate: {privates[i].size, pos}})
+			pos += len(privateData[i])
+		}
+		fdArray = buildIndex(items)
+	} else {
+		offsets[opPrivate] = []int{privates[0].size, pos}
+	}
+
+	out := append(header, cff[hdrSize:nameEnd]...)
+	out = append(out, buildIndex([][]byte{top.encode(offsets)})...)
+	out = append(out, cff[topEnd:stringEnd]...)
+	out = append(out, newGSubrs...)
+	out = append(out, charset...)
+	out = append(out, encoding...)
+	out = append(out, fdSelect...)
+	out = append(out, charStringIndex...)
+	out = append(out, fdArray...)
+	for _, data := range privateData {
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// parseIndex 解析offset处的INDEX, 返回各项数据和INDEX结束的位置
+func parseIndex(data []byte, offset int) ([][]byte, int, error) {
+	if offset < 0 || offset+2 > len(data) {
+		return nil, 0, fmt.Errorf("%w: INDEX越界", errInvalidCFF)
+	}
+	count := int(binary.BigEndian.Uint16(data[offset:]))
+	if count == 0 {
+		return nil, offset + 2, nil
+	}
+	if offset+3 > len(data) {
+		return nil, 0, fmt.Errorf("%w: INDEX越界", errInvalidCFF)
+	}
+	offSize := int(data[offset+2])
+	pos := offset + 3
+	if offSize < 1 || offSize > 4 || pos+(count+1)*offSize > len(data) {
+		return nil, 0, fmt.Errorf("%w: INDEX越界", errInvalidCFF)
+	}
+	// 偏移从1开始, 相对于偏移数组之后的前一个字节
+	base := pos + (count+1)*offSize - 1
+	readOffset := func(i int) int {
+		v := 0
+		for _, b := range data[pos+i*offSize : pos+(i+1)*offSize] {
+			v = v<<8 | int(b)
+		}
+		return v
+	}
+	items := make([][]byte, count)
+	prev := readOffset(0)
+	for i := range items {
+		next := readOffset(i + 1)
+		if prev < 1 || next < prev || base+next > len(data) {
+			return nil, 0, fmt.Errorf("%w: INDEX越界", errInvalidCFF)
+		}
+		items[i] = data[base+prev : base+next]
+		prev = next
+	}
+	return items, base + prev, nil
+}
+
+// buildIndex 生成INDEX, 偏移使用能容纳数据的最小长度
+func buildIndex(items [][]byte) []byte {
+	if len(items) == 0 {
+		return []byte{0, 0}
+	}
+	size := 1
+	for _, item := range items {
+		size += len(item)
+	}
+	offSize := 1
+	for limit := 0xFF; size > limit; limit = limit<<8 | 0xFF {
+		offSize++
+	}
+	out := make([]byte, 3, 3+(len(items)+1)*offSize+size-1)
+	binary.BigEndian.PutUint16(out, uint16(len(items)))
+	out[2] = byte(offSize)
+	putOffset := func(v int) {
+		for i := offSize - 1; i >= 0; i-- {
+			out = append(out, byte(v>>(8*i)))
+		}
+	}
+	offset := 1
+	putOffset(offset)
+	for _, item := range items {
+		offset += len(item)
+		putOffset(offset)
+	}
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+type cffDictEntry struct {
+	op       int
+	operands [][]byte // 每个操作数的原始编码
+}
+
+// cffDict 按原来的顺序保存字典的条目, 改写时只替换偏移
+type cffDict []cffDictEntry
+
+func parseDict(data []byte) (cffDict, error) {
+	var dict cffDict
+	var operands [][]byte
+	for pos := 0; pos < len(data); {
+		start := pos
+		switch b := data[pos]; {
+		case b == 12:
+			if pos+1 >= len(data) {
+				return nil, fmt.Errorf("%w: 字典已损坏", errInvalidCFF)
+			}
+			dict = append(dict, cffDictEntry{op: 12<<8 | int(data[pos+1]), operands: operands})
+			operands = nil
+			pos += 2
+			continue
+		case b <= 21:
+			dict = append(dict, cffDictEntry{op: int(b), operands: operands})
+			operands = nil
+			pos++
+			continue
+		case b == 28:
+			pos += 3
+		case b == 29:
+			pos += 5
+		case b == 30:
+			// 实数按半字节编码, 以0xf结束
+			for pos++; pos < len(data) && data[pos]>>4 != 0xF && data[pos]&0xF != 0xF; pos++ {
+			}
+			pos++
+		case b >= 32 && b <= 246:
+			pos++
+		case b >= 247 && b <= 254:
+			pos += 2
+		default:
+			return nil, fmt.Errorf("%w: 字典已损坏", errInvalidCFF)
+		}
+		if pos > len(data) {
+			return nil, fmt.Errorf("%w: 字典已损坏", errInvalidCFF)
+		}
+		operands = append(operands, data[start:pos])
+	}
+	return dict, nil
+}
+
+// ints 返回操作符op的整数操作数
+func (d cffDict) ints(op int) ([]int, bool) {
+	for _, entry := range d {
+		if entry.op != op {
+			continue
+		}
+		values := make([]int, len(entry.operands))
+		for i, operand := range entry.operands {
+			v, ok := dictInt(operand)
+			if !ok {
+				return nil, false
+			}
+			values[i] = v
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// int 返回操作符op的最后一个整数操作数, 偏移总是最后一个操作数
+func (d cffDict) int(op int) (int, bool) {
+	values, ok := d.ints(op)
+	if !ok || len(values) == 0 {
+		return 0, false
+	}
+	return values[len(values)-1], true
+}
+
+// encode 生成字典, offsets中的操作符改写为定长编码的整数
+func (d cffDict) encode(offsets map[int][]int) []byte {
+	var out []byte
+	for _, entry := range d {
+		if values, ok := offsets[entry.op]; ok {
+			for _, v := range values {
+				out = append(out, 29)
+				out = binary.BigEndian.AppendUint32(out, uint32(int32(v)))
+			}
+		} else {
+			for _, operand := range entry.operands {
+				out = append(out, operand...)
+			}
+		}
+		if entry.op > 0xFF {
+			out = append(out, 12, byte(entry.op))
+		} else {
+			out = append(out, byte(entry.op))
+		}
+	}
+	return out
+}
+
+func dictInt(b []byte) (int, bool) {
+	switch v := b[0]; {
+	case v == 28:
+		return int(int16(binary.BigEndian.Uint16(b[1:]))), true
+	case v == 29:
+		return int(int32(binary.BigEndian.Uint32(b[1:]))), true
+	case v >= 32 && v <= 246:
+		return int(v) - 139, true
+	case v >= 247 && v <= 250:
+		return (int(v)-247)*256 + int(b[1]) + 108, true
+	case v >= 251 && v <= 254:
+		return -(int(v)-251)*256 - int(b[1]) - 108, true
+	}
+	return 0, false
+}
+
+// cffPrivate 私有字典和它的局部子程序
+type cffPrivate struct {
+	dict  cffDict
+	subrs [][]byte
+	used  map[int]bool
+	size  int // 改写后的字典长度
+}
+
+func parsePrivate(cff []byte, dict cffDict) (*cffPrivate, error) {
+	values, ok := dict.ints(opPrivate)
+	if !ok || len(values) != 2 || values[0] < 0 || values[1] < 0 || values[0]+values[1] > len(cff) {
+		return nil, fmt.Errorf("%w: 私有字典越界", errInvalidCFF)
+	}
+	size, offset := values[0], values[1]
+	private := &cffPrivate{used: make(map[int]bool)}
+	var err error
+	if private.dict, err = parseDict(cff[offset : offset+size]); err != nil {
+		return nil, err
+	}
+	// 局部子程序的偏移相对于私有字典
+	if subrs, ok := private.dict.int(opSubrs); ok {
+		if private.subrs, _, err = parseIndex(cff, offset+subrs); err != nil {
+			return nil, err
+		}
+	}
+	return private, nil
+}
+
+// build 生成私有字典, 局部子程序紧跟在字典之后
+func (p *cffPrivate) build() []byte {
+	if _, ok := p.dict.int(opSubrs); !ok {
+		out := p.dict.encode(nil)
+		p.size = len(out)
+		return out
+	}
+	p.size = len(p.dict.encode(map[int][]int{opSubrs: {0}}))
+	out := p.dict.encode(map[int][]int{opSubrs: {p.size}})
+	return append(out, buildIndex(pruneSubrs(p.subrs, p.used))...)
+}
+
+// pruneSubrs 没有用到的子程序替换为只有return的空程序
+func pruneSubrs(subrs [][]byte, used map[int]bool) [][]byte {
+	out := make([][]byte, len(subrs))
+	for i, subr := range subrs {
+		if used[i] {
+			out[i] = subr
+		} else {
+			out[i] = []byte{csReturn}
+		}
+	}
+	return out
+}
+
+// charsetData 返回offset处的字符集数据, 0号字形不在字符集中
+func charsetData(cff []byte, offset, numGlyphs int) ([]byte, error) {
+	if offset >= len(cff) {
+		return nil, fmt.Errorf("%w: 字符集越界", errInvalidCFF)
+	}
+	pos := offset + 1
+	switch format := cff[offset]; format {
+	case 0:
+		pos += (numGlyphs - 1) * 2
+	case 1, 2:
+		// 每段是起始SID和之后的字形数, 格式1的字形数为1字节, 格式2为2字节
+		width := 3
+		if format == 2 {
+			width = 4
+		}
+		for covered := 1; covered < numGlyphs; pos += width {
+			if pos+width > len(cff) {
+				return nil, fmt.Errorf("%w: 字符集越界", errInvalidCFF)
+			}
+			left := int(cff[pos+2])
+			if format == 2 {
+				left = int(binary.BigEndian.Uint16(cff[pos+2:]))
+			}
+			covered += left + 1
+		}
+	default:
+		return nil, fmt.Errorf("%w: 未知的字符集格式%d", errInvalidCFF, format)
+	}
+	if pos > len(cff) {
+		return nil, fmt.Errorf("%w: 字符集越界", errInvalidCFF)
+	}
+	return cff[offset:pos], nil
+}
+
+// encodingData 返回offset处的编码数据
+func encodingData(cff []byte, offset int) ([]byte, error) {
+	if offset+2 > len(cff) {
+		return nil, fmt.Errorf("%w: 编码越界", errInvalidCFF)
+	}
+	format := cff[offset]
+	pos := offset + 2
+	switch format & 0x7F {
+	case 0:
+		pos += int(cff[offset+1])
+	case 1:
+		pos += int(cff[offset+1]) * 2
+	default:
+		return nil, fmt.Errorf("%w: 未知的编码格式%d", errInvalidCFF, format&0x7F)
+	}
+	// 最高位表示后面还有补充编码
+	if format&0x80 != 0 {
+		if pos >= len(cff) {
+			return nil, fmt.Errorf("%w: 编码越界", errInvalidCFF)
+		}
+		pos += 1 + int(cff[pos])*3
+	}
+	if pos > len(cff) {
+		return nil, fmt.Errorf("%w: 编码越界", errInvalidCFF)
+	}
+	return cff[offset:pos], nil
+}
+
+// parseFDSelect 返回offset处的FDSelect数据和每个字形所属的FD
+func parseFDSelect(cff []byte, offset, numGlyphs, numFDs int) ([]byte, []int, error) {
+	if offset >= len(cff) {
+		return nil, nil, fmt.Errorf("%w: FDSelect越界", errInvalidCFF)
+	}
+	fds := make([]int, numGlyphs)
+	var end int
+	switch format := cff[offset]; format {
+	case 0:
+		end = offset + 1 + numGlyphs
+		if end > len(cff) {
+			return nil, nil, fmt.Errorf("%w: FDSelect越界", errInvalidCFF)
+		}
+		for gid := range fds {
+			fds[gid] = int(cff[offset+1+gid])
+		}
+	case 3:
+		if offset+3 > len(cff) {
+			return nil, nil, fmt.Errorf("%w: FDSelect越界", errInvalidCFF)
+		}
+		ranges := int(binary.BigEndian.Uint16(cff[offset+1:]))
+		end = offset + 3 + ranges*3 + 2
+		if end > len(cff) {
+			return nil, nil, fmt.Errorf("%w: FDSelect越界", errInvalidCFF)
+		}
+		// 每段是起始字形和FD, 最后是结束字形
+		for i := 0; i < ranges; i++ {
+			rec := cff[offset+3+i*3:]
+			first := int(binary.BigEndian.Uint16(rec))
+			next := int(binary.BigEndian.Uint16(rec[3:]))
+			for gid := first; gid < next && gid < numGlyphs; gid++ {
+				fds[gid] = int(rec[2])
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: 未知的FDSelect格式%d", errInvalidCFF, format)
+	}
+	for _, fd := range fds {
+		if fd >= numFDs {
+			return nil, nil, fmt.Errorf("%w: FD越界", errInvalidCFF)
+		}
+	}
+	return cff[offset:end], fds, nil
+}
+
+// subrScanner 执行字形程序中影响解析的部分, 找出用到的子程序
+//
+// 只跟踪操作数栈和字干数: callsubr的参数来自栈顶, hintmask之后的掩码长度取决于字干数。
+type subrScanner struct {
+	global, local         [][]byte
+	usedGlobal, usedLocal map[int]bool
+	stack                 []int
+	stems                 int
+	depth                 int
+	done                  bool
+}
+
+func (s *subrScanner) scan(cs []byte) error {
+	if s.depth > maxSubrDepth {
+		return fmt.Errorf("%w: 子程序嵌套过深", errCFFCharstring)
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+
+	for pos := 0; pos < len(cs) && !s.done; {
+		b := cs[pos]
+		switch {
+		case b == csShortInt:
+			if pos+3 > len(cs) {
+				return errCFFCharstring
+			}
+			s.stack = append(s.stack, int(int16(binary.BigEndian.Uint16(cs[pos+1:]))))
+			pos += 3
+		case b >= 32 && b <= 246:
+			s.stack = append(s.stack, int(b)-139)
+			pos++
+		case b >= 247 && b <= 254:
+			if pos+2 > len(cs) {
+				return errCFFCharstring
+			}
+			if b <= 250 {
+				s.stack = append(s.stack, (int(b)-247)*256+int(cs[pos+1])+108)
+			} else {
+				s.stack = append(s.stack, -(int(b)-251)*256-int(cs[pos+1])-108)
+			}
+			pos += 2
+		case b == 255:
+			// 16.16定点数, 只保留整数部分
+			if pos+5 > len(cs) {
+				return errCFFCharstring
+			}
+			s.stack = append(s.stack, int(int32(binary.BigEndian.Uint32(cs[pos+1:])))>>16)
+			pos += 5
+		case b == csCallSubr || b == csCallGSubr:
+			if len(s.stack) == 0 {
+				return errCFFCharstring
+			}
+			subrs, used := s.local, s.usedLocal
+			if b == csCallGSubr {
+				subrs, used = s.global, s.usedGlobal
+			}
+			i := s.stack[len(s.stack)-1] + subrBias(len(subrs))
+			s.stack = s.stack[:len(s.stack)-1]
+			if i < 0 || i >= len(subrs) {
+				return fmt.Errorf("%w: 子程序%d不存在", errCFFCharstring, i)
+			}
+			used[i] = true
+			if err := s.scan(subrs[i]); err != nil {
+				return err
+			}
+			pos++
+		case b == csReturn:
+			return nil
+		case b == csEndChar:
+			// 带4个参数的endchar是seac组合字形, 引用的字形要按名字查找
+			if len(s.stack) >= 4 {
+				return fmt.Errorf("%w: 不支持seac组合字形", ErrUnsupportedFont)
+			}
+			s.done = true
+		case b == csHStem || b == csVStem || b == csHStemHM || b == csVStemHM:
+			s.stems += len(s.stack) / 2
+			s.stack = s.stack[:0]
+			pos++
+		case b == csHintMask || b == csCntrMask:
+			// hintmask前栈中剩下的是隐含的vstem
+			s.stems += len(s.stack) / 2
+			s.stack = s.stack[:0]
+			pos += 1 + (s.stems+7)/8
+		case b == csEscape:
+			s.stack = s.stack[:0]
+			pos += 2
+		default:
+			s.stack = s.stack[:0]
+			pos++
+		}
+	}
+	return nil
+}
+
+// subrBias 子程序编号的偏移量, 由子程序数量决定
+func subrBias(count int) int {
+	switch {
+	case count < 1240:
+		return 107
+	case count < 33900:
+		return 1131
+	}
+	return 32768
+}
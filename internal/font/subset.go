@@ -0,0 +1,522 @@
+package font
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var (
+	ErrUnsupportedFont = errors.New("不支持的字体格式")
+	ErrInvalidFont     = errors.New("字体文件已损坏")
+)
+
+// 子集化时直接丢弃的表: 数字签名会失效, 点阵字形和设备度量只会增大体积
+var droppedTables = map[string]bool{
+	"DSIG": true,
+	"EBDT": true,
+	"EBLC": true,
+	"EBSC": true,
+	"hdmx": true,
+	"LTSH": true,
+	"VDMX": true,
+}
+
+// 复合字形标志位
+const (
+	argsAreWords   = 0x0001
+	weHaveAScale   = 0x0008
+	moreComponents = 0x0020
+	weHaveXAndY    = 0x0040
+	weHaveTwoByTwo = 0x0080
+)
+
+// head表checkSumAdjustment的魔数
+const checksumMagic = 0xB1B0AFBA
+
+type sfnt struct {
+	version uint32 // TrueType为0x00010000, CFF轮廓为"OTTO"
+	tables  map[string][]byte
+}
+
+// SubsetFile 读取src字体, 只保留runes中用到的字形并写入dst
+func SubsetFile(src, dst string, runes map[rune]bool) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("读取字体失败: %w", err)
+	}
+	bs, err := Subset(data, runes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, bs, 0666)
+}
+
+// Subset 生成只包含指定字符的字体
+//
+// 字形编号保持不变, 未使用的字形清空, 这样hmtx、GSUB、GPOS等表无需重写。
+// 支持glyf轮廓的TrueType字体和CFF轮廓的OpenType字体,
+// 字体集合(TTC)、CFF2轮廓的可变字体和seac组合字形返回ErrUnsupportedFont。
+func Subset(data []byte, runes map[rune]bool) ([]byte, error) {
+	font, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []string{"head", "maxp", "cmap"} {
+		if _, ok := font.tables[tag]; !ok {
+			return nil, fmt.Errorf("%w: 缺少%s表", ErrInvalidFont, tag)
+		}
+	}
+	head := font.tables["head"]
+	maxp := font.tables["maxp"]
+	if len(head) < 54 || len(maxp) < 6 {
+		return nil, ErrInvalidFont
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	mapping, err := parseCmap(font.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+
+	// 0号字形是.notdef, 必须保留
+	keep := map[uint16]bool{0: true}
+	used := make(map[rune]uint16)
+	for r := range runes {
+		if gid, ok := mapping[r]; ok && int(gid) < numGlyphs {
+			keep[gid] = true
+			used[r] = gid
+		}
+	}
+
+	tables := make(map[string][]byte, len(font.tables))
+	for tag, bs := range font.tables {
+		if !droppedTables[tag] {
+			tables[tag] = bs
+		}
+	}
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+	tables["head"] = newHead
+
+	glyf, hasGlyf := font.tables["glyf"]
+	loca, hasLoca := font.tables["loca"]
+	cff, hasCFF := font.tables["CFF "]
+	switch {
+	case hasGlyf && hasLoca:
+		longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+		offsets, err := parseLoca(loca, numGlyphs, longLoca)
+		if err != nil {
+			return nil, err
+		}
+		if err := closeComposites(glyf, offsets, keep); err != nil {
+			return nil, err
+		}
+		newGlyf, newOffsets := buildGlyf(glyf, offsets, keep)
+		newLoca, long := buildLoca(newOffsets)
+		if long {
+			binary.BigEndian.PutUint16(newHead[50:], 1)
+		} else {
+			binary.BigEndian.PutUint16(newHead[50:], 0)
+		}
+		tables["glyf"] = newGlyf
+		tables["loca"] = newLoca
+	case hasCFF:
+		if tables["CFF "], err = subsetCFF(cff, keep); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedFont
+	}
+
+	tables["cmap"] = buildCmap(used)
+	if post, ok := tables["post"]; ok && len(post) >= 32 {
+		// 只保留post表头, 丢弃逐字形的名字
+		newPost := append([]byte(nil), post[:32]...)
+		binary.BigEndian.PutUint32(newPost, 0x00030000)
+		tables["post"] = newPost
+	}
+	return write(font.version, tables), nil
+}
+
+func parse(data []byte) (*sfnt, error) {
+	if len(data) < 12 {
+		return nil, ErrInvalidFont
+	}
+	switch tag := string(data[:4]); tag {
+	case "\x00\x01\x00\x00", "true", "OTTO":
+	case "ttcf":
+		return nil, ErrUnsupportedFont
+	default:
+		return nil, ErrInvalidFont
+	}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+numTables*16 {
+		return nil, ErrInvalidFont
+	}
+	font := &sfnt{version: binary.BigEndian.Uint32(data), tables: make(map[string][]byte, numTables)}
+	for i := 0; i < numTables; i++ {
+		rec := data[12+i*16:]
+		tag := string(rec[:4])
+		offset := int(binary.BigEndian.Uint32(rec[8:]))
+		length := int(binary.BigEndian.Uint32(rec[12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("%w: %s表越界", ErrInvalidFont, tag)
+		}
+		font.tables[tag] = data[offset : offset+length]
+	}
+	return font, nil
+}
+
+func parseLoca(loca []byte, numGlyphs int, long bool) ([]uint32, error) {
+	offsets := make([]uint32, numGlyphs+1)
+	for i := range offsets {
+		if long {
+			if len(loca) < (i+1)*4 {
+				return nil, fmt.Errorf("%w: loca表过短", ErrInvalidFont)
+			}
+			offsets[i] = binary.BigEndian.Uint32(loca[i*4:])
+		} else {
+			if len(loca) < (i+1)*2 {
+				return nil, fmt.Errorf("%w: loca表过短", ErrInvalidFont)
+			}
+			offsets[i] = uint32(binary.BigEndian.Uint16(loca[i*2:])) * 2
+		}
+	}
+	return offsets, nil
+}
+
+// parseCmap 解析unicode映射, 优先使用完整的format 12子表
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("%w: cmap表过短", ErrInvalidFont)
+	}
+	num := int(binary.BigEndian.Uint16(cmap[2:]))
+	var best []byte
+	bestScore := -1
+	for i := 0; i < num; i++ {
+		if 4+i*8+8 > len(cmap) {
+			return nil, fmt.Errorf("%w: cmap子表数量越界", ErrInvalidFont)
+		}
+		rec := cmap[4+i*8:]
+		platform := binary.BigEndian.Uint16(rec)
+		encoding := binary.BigEndian.Uint16(rec[2:])
+		offset := int(binary.BigEndian.Uint32(rec[4:]))
+		if offset+2 > len(cmap) {
+			continue
+		}
+		sub := cmap[offset:]
+		format := binary.BigEndian.Uint16(sub)
+		score := -1
+		switch {
+		case format == 12 && (platform == 0 || platform == 3 && encoding == 10):
+			score = 2
+		case format == 4 && (platform == 0 || platform == 3 && encoding == 1):
+			score = 1
+		}
+		if score > bestScore {
+			best, bestScore = sub, score
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: 没有unicode映射", ErrUnsupportedFont)
+	}
+	mapping := make(map[rune]uint16)
+	if bestScore == 2 {
+		if len(best) < 16 {
+			return nil, ErrInvalidFont
+		}
+		groups := int(binary.BigEndian.Uint32(best[12:]))
+		for i := 0; i < groups; i++ {
+			if 16+i*12+12 > len(best) {
+				return nil, ErrInvalidFont
+			}
+			g := best[16+i*12:]
+			start := binary.BigEndian.Uint32(g)
+			end := binary.BigEndian.Uint32(g[4:])
+			gid := binary.BigEndian.Uint32(g[8:])
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				mapping[rune(c)] = uint16(gid + c - start)
+			}
+		}
+		return mapping, nil
+	}
+
+	if len(best) < 14 {
+		return nil, ErrInvalidFont
+	}
+	segCount := int(binary.BigEndian.Uint16(best[6:])) / 2
+	if len(best) < 16+segCount*8 {
+		return nil, ErrInvalidFont
+	}
+	ends := best[14:]
+	starts := best[16+segCount*2:]
+	deltas := best[16+segCount*4:]
+	rangeOffsets := best[16+segCount*6:]
+	for i := 0; i < segCount; i++ {
+		end := binary.BigEndian.Uint16(ends[i*2:])
+		start := binary.BigEndian.Uint16(starts[i*2:])
+		delta := binary.BigEndian.Uint16(deltas[i*2:])
+		rangeOffset := int(binary.BigEndian.Uint16(rangeOffsets[i*2:]))
+		for c := uint32(start); c <= uint32(end) && c != 0xFFFF; c++ {
+			var gid uint16
+			if rangeOffset == 0 {
+				gid = uint16(c) + delta
+			} else {
+				pos := 16 + segCount*6 + i*2 + rangeOffset + int(c-uint32(start))*2
+				if pos+2 > len(best) {
+					continue
+				}
+				gid = binary.BigEndian.Uint16(best[pos:])
+				if gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				mapping[rune(c)] = gid
+			}
+		}
+	}
+	return mapping, nil
+}
+
+// closeComposites 把复合字形引用的部件字形加入保留集合
+func closeComposites(glyf []byte, offsets []uint32, keep map[uint16]bool) error {
+	queue := make([]uint16, 0, len(keep))
+	for gid := range keep {
+		queue = append(queue, gid)
+	}
+	for len(queue) > 0 {
+		gid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		start, end := offsets[gid], offsets[gid+1]
+		if end <= start {
+			continue
+		}
+		if int(end) > len(glyf) {
+			return fmt.Errorf("%w: 字形%d越界", ErrInvalidFont, gid)
+		}
+		glyph := glyf[start:end]
+		if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+			continue
+		}
+		pos := 10
+		for {
+			if pos+4 > len(glyph) {
+				return fmt.Errorf("%w: 复合字形%d已损坏", ErrInvalidFont, gid)
+			}
+			flags := binary.BigEndian.Uint16(glyph[pos:])
+			component := binary.BigEndian.Uint16(glyph[pos+2:])
+			if int(component) < len(offsets)-1 && !keep[component] {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+			pos += 4
+			if flags&argsAreWords != 0 {
+				pos += 4
+			} else {
+				pos += 2
+			}
+			switch {
+			case flags&weHaveAScale != 0:
+				pos += 2
+			case flags&weHaveXAndY != 0:
+				pos += 4
+			case flags&weHaveTwoByTwo != 0:
+				pos += 8
+			}
+			if flags&moreComponents == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func buildGlyf(glyf []byte, offsets []uint32, keep map[uint16]bool) ([]byte, []uint32) {
+	var out []byte
+	newOffsets := make([]uint32, len(offsets))
+	for gid := 0; gid < len(offsets)-1; gid++ {
+		newOffsets[gid] = uint32(len(out))
+		start, end := offsets[gid], offsets[gid+1]
+		if !keep[uint16(gid)] || end <= start || int(end) > len(glyf) {
+			continue
+		}
+		out = append(out, glyf[start:end]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	newOffsets[len(offsets)-1] = uint32(len(out))
+	return out, newOffsets
+}
+
+func buildLoca(offsets []uint32) ([]byte, bool) {
+	long := offsets[len(offsets)-1] > 0x1FFFE
+	var out []byte
+	if long {
+		out = make([]byte, len(offsets)*4)
+		for i, off := range offsets {
+			binary.BigEndian.PutUint32(out[i*4:], off)
+		}
+	} else {
+		out = make([]byte, len(offsets)*2)
+		for i, off := range offsets {
+			binary.BigEndian.PutUint16(out[i*2:], uint16(off/2))
+		}
+	}
+	return out, long
+}
+
+// format 4子表最多能容纳的段数, 子表长度不能超过65535
+const maxFormat4Segments = (0xFFFF - 16) / 8
+
+type cmapRange struct {
+	start, end rune
+	gid        uint16
+}
+
+// buildCmap 只为用到的字符生成format 4(BMP)和format 12(全部)两个子表
+func buildCmap(used map[rune]uint16) []byte {
+	codes := make([]rune, 0, len(used))
+	for r := range used {
+		codes = append(codes, r)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	// 连续的字符且连续的字形合并为一段
+	var ranges []cmapRange
+	for _, c := range codes {
+		gid := used[c]
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if c == last.end+1 && gid == last.gid+uint16(c-last.start) {
+				last.end = c
+				continue
+			}
+		}
+		ranges = append(ranges, cmapRange{start: c, end: c, gid: gid})
+	}
+
+	var bmp []cmapRange
+	for _, r := range ranges {
+		if r.start > 0xFFFE {
+			break
+		}
+		if r.end > 0xFFFE {
+			r.end = 0xFFFE
+		}
+		bmp = append(bmp, r)
+	}
+	bmp = append(bmp, cmapRange{start: 0xFFFF, end: 0xFFFF, gid: 1})
+
+	// format 4的长度是16位的, 段数过多时只生成format 12
+	var format4 []byte
+	if segCount := len(bmp); segCount <= maxFormat4Segments {
+		format4 = make([]byte, 16+segCount*8)
+		binary.BigEndian.PutUint16(format4, 4)
+		binary.BigEndian.PutUint16(format4[2:], uint16(len(format4)))
+		searchRange, entrySelector := 1, 0
+		for searchRange*2 <= segCount {
+			searchRange *= 2
+			entrySelector++
+		}
+		binary.BigEndian.PutUint16(format4[6:], uint16(segCount*2))
+		binary.BigEndian.PutUint16(format4[8:], uint16(searchRange*2))
+		binary.BigEndian.PutUint16(format4[10:], uint16(entrySelector))
+		binary.BigEndian.PutUint16(format4[12:], uint16(segCount*2-searchRange*2))
+		for i, r := range bmp {
+			binary.BigEndian.PutUint16(format4[14+i*2:], uint16(r.end))
+			binary.BigEndian.PutUint16(format4[16+segCount*2+i*2:], uint16(r.start))
+			delta := r.gid - uint16(r.start)
+			if r.start == 0xFFFF {
+				delta = 1
+			}
+			binary.BigEndian.PutUint16(format4[16+segCount*4+i*2:], delta)
+		}
+	}
+
+	format12 := make([]byte, 16+len(ranges)*12)
+	binary.BigEndian.PutUint16(format12, 12)
+	binary.BigEndian.PutUint32(format12[4:], uint32(len(format12)))
+	binary.BigEndian.PutUint32(format12[12:], uint32(len(ranges)))
+	for i, r := range ranges {
+		binary.BigEndian.PutUint32(format12[16+i*12:], uint32(r.start))
+		binary.BigEndian.PutUint32(format12[20+i*12:], uint32(r.end))
+		binary.BigEndian.PutUint32(format12[24+i*12:], uint32(r.gid))
+	}
+
+	// 子表按平台和编码排序: (3,1)为format 4, (3,10)为format 12
+	numTables := 2
+	if format4 == nil {
+		numTables = 1
+	}
+	header := make([]byte, 4+numTables*8)
+	binary.BigEndian.PutUint16(header[2:], uint16(numTables))
+	rec := header[4:]
+	if format4 != nil {
+		binary.BigEndian.PutUint16(rec, 3)
+		binary.BigEndian.PutUint16(rec[2:], 1)
+		binary.BigEndian.PutUint32(rec[4:], uint32(len(header)))
+		rec = rec[8:]
+	}
+	binary.BigEndian.PutUint16(rec, 3)
+	binary.BigEndian.PutUint16(rec[2:], 10)
+	binary.BigEndian.PutUint32(rec[4:], uint32(len(header)+len(format4)))
+
+	out := append(header, format4...)
+	return append(out, format12...)
+}
+
+func checksum(bs []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(bs); i += 4 {
+		var word [4]byte
+		copy(word[:], bs[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+func write(version uint32, tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	numTables := len(tags)
+	searchRange, entrySelector := 1, 0
+	for searchRange*2 <= numTables {
+		searchRange *= 2
+		entrySelector++
+	}
+	out := make([]byte, 12+numTables*16)
+	binary.BigEndian.PutUint32(out, version)
+	binary.BigEndian.PutUint16(out[4:], uint16(numTables))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange*16))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(numTables*16-searchRange*16))
+
+	headOffset := -1
+	for i, tag := range tags {
+		data := tables[tag]
+		if tag == "head" {
+			headOffset = len(out)
+		}
+		rec := out[12+i*16:]
+		copy(rec, tag)
+		binary.BigEndian.PutUint32(rec[4:], checksum(data))
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(data)))
+		out = append(out, data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if headOffset >= 0 {
+		binary.BigEndian.PutUint32(out[headOffset+8:], checksumMagic-checksum(out))
+	}
+	return out
+}
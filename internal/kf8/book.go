@@ -0,0 +1,210 @@
+// Package kf8 生成KF8格式(azw3)的电子书, 以及同时包含MOBI7和KF8的双格式mobi文件
+//
+// 记录结构参考kindlegen的输出: 文本记录之后依次是正文块索引、骨架索引、
+// 带层级的目录索引、图片和字体资源、FDST、FLIS、FCIS和EOF记录。
+package kf8
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/leotaku/mobi/pdb"
+	"github.com/leotaku/mobi/records"
+	"github.com/leotaku/mobi/types"
+)
+
+// Book KF8格式的书籍
+type Book struct {
+	Title       string
+	Author      string
+	Publisher   string
+	Description string
+	PublishDate string // 出版日期, 如2006-01-02
+	Language    string // 语言代码, 如zh、en
+	UniqueID    uint32
+	CreatedDate time.Time
+	CSS         string    // 所有章节共用的样式表
+	Chapters    []Chapter // 章节, 子章节会生成嵌套目录
+	Cover       []byte    // 封面图片
+	Thumb       []byte    // 缩略图, 为空时按封面生成
+
+	resources [][]byte
+}
+
+// Chapter 章节
+type Chapter struct {
+	Title    string // 目录中显示的标题, 纯文本
+	Body     string // 正文, 插入到body标签里的XHTML
+	Children []Chapter
+}
+
+// AddImage 添加图片, 返回正文里引用图片的地址
+func (b *Book) AddImage(data []byte) (string, error) {
+	data, mime, err := imageData(data)
+	if err != nil {
+		return "", err
+	}
+	b.resources = append(b.resources, data)
+	return fmt.Sprintf("kindle:embed:%s?mime=%s", base32(len(b.resources)), mime), nil
+}
+
+// AddFont 添加TrueType/OpenType字体, 返回CSS里引用字体的地址
+func (b *Book) AddFont(data []byte) (string, error) {
+	rec, err := fontRecord(data)
+	if err != nil {
+		return "", err
+	}
+	b.resources = append(b.resources, rec)
+	return fmt.Sprintf("kindle:embed:%s", base32(len(b.resources))), nil
+}
+
+// Write 生成azw3文件写入w
+func (b *Book) Write(w io.Writer) error {
+	db, err := b.Database()
+	if err != nil {
+		return err
+	}
+	return db.Write(w)
+}
+
+// Database 生成PalmDB数据库, 所有记录序号都相对于第一条记录
+func (b *Book) Database() (pdb.Database, error) {
+	html, skeletons, chunks, toc := b.layout()
+	text := html + b.CSS
+	db := pdb.NewDatabase(b.Title, b.CreatedDate)
+	null := b.nullRecord()
+	db.AddRecord(null)
+
+	// 文本记录
+	texts := textRecords(text)
+	for _, rec := range texts {
+		db.AddRecord(pdb.RawRecord(rec))
+	}
+	null.PalmDocHeader.TextLength = uint32(len(text))
+	null.PalmDocHeader.TextRecordCount = uint16(len(texts))
+	null.MOBIHeader.ExtraRecordDataFlags = 0b1
+	null.MOBIHeader.FirstNonBookIndex = uint32(db.Idx() + 1)
+
+	// 正文块索引
+	names := &cncx{}
+	chunkRecords := newIndex(types.TAGXTableChunk, chunkIndex(chunks, names), 1)
+	null.MOBIHeader.ChunkIndex = uint32(db.Idx() + 1)
+	addRecords(&db, chunkRecords...)
+	addRecords(&db, names.Records()...)
+
+	// 骨架索引
+	null.MOBIHeader.SkeletonIndex = uint32(db.Idx() + 1)
+	addRecords(&db, newIndex(types.TAGXTableSkeleton, skeletonIndex(skeletons), 0)...)
+
+	// 目录索引
+	names = &cncx{}
+	entries := ncxIndex(toc, names)
+	cncxRecords := names.Records()
+	null.MOBIHeader.INDXRecordOffset = uint32(db.Idx() + 1)
+	addRecords(&db, newIndex(tagxTableNCX, entries, len(cncxRecords))...)
+	addRecords(&db, cncxRecords...)
+
+	// 图片和字体, 封面和缩略图放在最后
+	resources := append([][]byte(nil), b.resources...)
+	if len(b.Cover) > 0 {
+		cover, err := coverData(b.Cover)
+		if err != nil {
+			return db, fmt.Errorf("处理封面失败: %w", err)
+		}
+		thumb := b.Thumb
+		if len(thumb) == 0 {
+			if thumb, err = thumbnail(cover); err != nil {
+				return db, fmt.Errorf("生成缩略图失败: %w", err)
+			}
+		}
+		coverIdx := len(resources)
+		resources = append(resources, cover, thumb)
+		null.EXTHSection.AddInt(types.EXTHCoverOffset, coverIdx)
+		null.EXTHSection.AddInt(types.EXTHThumbOffset, coverIdx+1)
+		null.EXTHSection.AddInt(types.EXTHHasFakeCover, 0)
+		null.EXTHSection.AddString(types.EXTHKF8CoverURI, "kindle:embed:"+base32(coverIdx+1))
+	}
+	if len(resources) > 0 {
+		null.MOBIHeader.FirstImageIndex = uint32(db.Idx() + 1)
+		null.EXTHSection.AddInt(types.EXTHKF8CountResources, len(resources))
+		for _, rec := range resources {
+			db.AddRecord(pdb.RawRecord(rec))
+		}
+	}
+
+	// FDST: 第一个flow是正文, 第二个是样式表
+	flows := []string{html}
+	if b.CSS != "" {
+		flows = append(flows, b.CSS)
+	}
+	db.AddRecord(records.NewFDSTRecord(flows...))
+	null.MOBIHeader.FirstContentRecordNumberOrFDSTNumberMSB = 0
+	null.MOBIHeader.LastContentRecordNumberOrFDSTNumberLSB = uint16(db.Idx())
+	null.MOBIHeader.Unknown3OrFDSTEntryCount = uint32(len(flows))
+
+	db.AddRecord(types.NewFLISRecord())
+	null.MOBIHeader.FLISRecordCount = 1
+	null.MOBIHeader.FLISRecordNumber = uint32(db.Idx())
+	db.AddRecord(types.NewFCISRecord(uint32(len(text))))
+	null.MOBIHeader.FCISRecordCount = 1
+	null.MOBIHeader.FCISRecordNumber = uint32(db.Idx())
+	db.AddRecord(types.EOFRecord)
+
+	db.ReplaceRecord(0, null)
+	return db, nil
+}
+
+// nullRecord 生成第0条记录, 包含MOBI头和EXTH元数据
+func (b *Book) nullRecord() records.NullRecord {
+	null := records.NewNullRecord(b.Title)
+	null.MOBIHeader.UniqueID = b.UniqueID
+	null.MOBIHeader.Locale = locale(b.Language)
+	null.EXTHSection.AddString(types.EXTHTitle, b.Title)
+	null.EXTHSection.AddString(types.EXTHUpdatedTitle, b.Title)
+	null.EXTHSection.AddString(types.EXTHAuthor, b.Author)
+	null.EXTHSection.AddString(types.EXTHPublisher, b.Publisher)
+	null.EXTHSection.AddString(types.EXTHDescription, b.Description)
+	if b.PublishDate != "" {
+		null.EXTHSection.AddString(types.EXTHPublishingDate, b.PublishDate)
+	}
+	// 侧载的书通过ASIN关联缩略图
+	null.EXTHSection.AddString(types.EXTHASIN, fmt.Sprintf("%015x", b.UniqueID))
+	null.EXTHSection.AddString(types.EXTHLanguage, b.Language)
+	null.EXTHSection.AddString(types.EXTHDocType, "EBOK")
+	return null
+}
+
+func addRecords(db *pdb.Database, recs ...pdb.Record) {
+	for _, rec := range recs {
+		db.AddRecord(rec)
+	}
+}
+
+// locale 把语言代码转换成MOBI头使用的Windows语言代码
+func locale(lang string) uint32 {
+	base, region, _ := strings.Cut(strings.ToLower(lang), "-")
+	code, ok := localeCodes[base]
+	if !ok {
+		return 0
+	}
+	if base == "zh" && (region == "tw" || region == "hk" || region == "hant") {
+		return 0x0404
+	}
+	return code
+}
+
+var localeCodes = map[string]uint32{
+	"zh": 0x0804,
+	"en": 0x0409,
+	"ja": 0x0411,
+	"ko": 0x0412,
+	"de": 0x0407,
+	"fr": 0x040c,
+	"it": 0x0410,
+	"es": 0x0c0a,
+	"pt": 0x0416,
+	"ru": 0x0419,
+	"nl": 0x0413,
+}
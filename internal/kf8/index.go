@@ -0,0 +1,192 @@
+package kf8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"unicode/utf8"
+
+	"github.com/leotaku/mobi/pdb"
+	"github.com/leotaku/mobi/types"
+)
+
+// recordLimit 单个索引记录和CNCX记录的最大长度, 和kindlegen一样给PDB记录上限留出1024字节
+const recordLimit = 0x10000 - 1024
+
+// cncxStringLimit 写入CNCX的字符串最大字节数
+const cncxStringLimit = 500
+
+// tagxTableNCX 带层级关系的目录索引
+var tagxTableNCX = types.TAGXTagTable{
+	types.TAGXTagEntryPosition,
+	types.TAGXTagEntryLength,
+	types.TAGXTagEntryNameOffset,
+	types.TAGXTagEntryDepthLevel,
+	types.TAGXTagEntryParent,
+	types.TAGXTagEntryChild1,
+	types.TAGXTagEntryChildN,
+	types.TAGXTagEntryPosFid,
+	types.TAGXTagEnd,
+}
+
+// indexEntry 索引条目, values按TAGX表的顺序保存每个标签的值, 为nil表示没有这个标签
+type indexEntry struct {
+	label  string
+	values [][]int
+}
+
+// encode 编码为 标签长度+标签+控制字节+标签值
+func (e indexEntry) encode(table types.TAGXTagTable) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(len(e.label)))
+	buf.WriteString(e.label)
+	buf.WriteByte(controlByte(table, e.values))
+	for _, values := range e.values {
+		for _, v := range values {
+			buf.Write(encodeVwi(v))
+		}
+	}
+	return buf.Bytes()
+}
+
+// controlByte 计算条目中包含哪些标签, 以及每个标签有几组值
+func controlByte(table types.TAGXTagTable, values [][]int) byte {
+	var cb byte
+	for i, tag := range table {
+		if tag == types.TAGXTagEnd || i >= len(values) || values[i] == nil {
+			continue
+		}
+		nvals := byte(tag >> 16)
+		mask := byte(tag >> 8)
+		count := byte(len(values[i])) / nvals
+		cb |= mask & (count << bits.TrailingZeros8(mask))
+	}
+	return cb
+}
+
+// newIndex 生成索引头记录和索引数据记录, 条目太多时拆分成多个数据记录
+func newIndex(table types.TAGXTagTable, entries []indexEntry, cncxCount int) []pdb.Record {
+	type group struct {
+		entries [][]byte
+		last    string
+	}
+	var groups []group
+	var current group
+	size := types.INDXHeaderLength + types.IDXTHeaderLength
+	for _, entry := range entries {
+		raw := entry.encode(table)
+		if len(current.entries) > 0 && size+len(raw)+2+3 > recordLimit {
+			groups = append(groups, current)
+			current = group{}
+			size = types.INDXHeaderLength + types.IDXTHeaderLength
+		}
+		current.entries = append(current.entries, raw)
+		current.last = entry.label
+		size += len(raw) + 2
+	}
+	if len(current.entries) > 0 {
+		groups = append(groups, current)
+	}
+
+	// 索引头记录里每个数据记录用 最后一个条目的标签+条目数 描述
+	var geometry [][]byte
+	for _, g := range groups {
+		var buf bytes.Buffer
+		buf.WriteByte(byte(len(g.last)))
+		buf.WriteString(g.last)
+		binary.Write(&buf, pdb.Endian, uint16(len(g.entries)))
+		geometry = append(geometry, buf.Bytes())
+	}
+	header := types.NewINDXHeader(uint32(len(groups)), uint32(len(entries)))
+	header.CNCXCount = uint32(cncxCount)
+	tagx := types.NewTAGXHeader()
+	tagx.HeaderLength += uint32(len(table) * types.TAGXTagLength)
+	var prefix bytes.Buffer
+	binary.Write(&prefix, pdb.Endian, tagx)
+	binary.Write(&prefix, pdb.Endian, table)
+
+	records := []pdb.Record{indexRecord(header, prefix.Bytes(), geometry)}
+	for _, g := range groups {
+		header := types.NewINDXHeader(uint32(len(g.entries)), 0)
+		header.HeaderType = 1
+		header.TAGXOffset = 0
+		records = append(records, indexRecord(header, nil, g.entries))
+	}
+	return records
+}
+
+// indexRecord 按 INDX头+TAGX+条目+IDXT 的结构生成索引记录
+func indexRecord(header types.INDXHeader, tagx []byte, entries [][]byte) pdb.RawRecord {
+	var body bytes.Buffer
+	body.Write(tagx)
+	offsets := make([]uint16, 0, len(entries))
+	for _, entry := range entries {
+		offsets = append(offsets, uint16(types.INDXHeaderLength+body.Len()))
+		body.Write(entry)
+	}
+	pad(&body)
+	header.IDXTStart = uint32(types.INDXHeaderLength + body.Len())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, pdb.Endian, header)
+	buf.Write(body.Bytes())
+	binary.Write(&buf, pdb.Endian, types.NewIDXTHeader())
+	binary.Write(&buf, pdb.Endian, offsets)
+	pad(&buf)
+	return buf.Bytes()
+}
+
+// cncx 保存索引里引用的字符串, 偏移量的高16位是记录序号
+type cncx struct {
+	records [][]byte
+	buf     bytes.Buffer
+}
+
+func (c *cncx) add(s string) int {
+	for len(s) > cncxStringLimit {
+		_, size := utf8.DecodeLastRuneInString(s[:cncxStringLimit])
+		s = s[:cncxStringLimit-size]
+	}
+	raw := append(encodeVwi(len(s)), s...)
+	if c.buf.Len()+len(raw) > recordLimit {
+		c.flush()
+	}
+	offset := len(c.records)<<16 | c.buf.Len()
+	c.buf.Write(raw)
+	return offset
+}
+
+func (c *cncx) flush() {
+	if c.buf.Len() == 0 {
+		return
+	}
+	pad(&c.buf)
+	c.records = append(c.records, bytes.Clone(c.buf.Bytes()))
+	c.buf.Reset()
+}
+
+// Records 返回CNCX记录
+func (c *cncx) Records() []pdb.Record {
+	c.flush()
+	records := make([]pdb.Record, 0, len(c.records))
+	for _, rec := range c.records {
+		records = append(records, pdb.RawRecord(rec))
+	}
+	return records
+}
+
+// encodeVwi 编码正向变长整数, 最后一个字节的最高位为1
+func encodeVwi(x int) []byte {
+	buf := []byte{byte(x&0x7f) | 0x80}
+	for x >>= 7; x > 0; x >>= 7 {
+		buf = append([]byte{byte(x & 0x7f)}, buf...)
+	}
+	return buf
+}
+
+// pad 把长度补齐到4的倍数
+func pad(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
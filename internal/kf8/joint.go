@@ -0,0 +1,75 @@
+package kf8
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/leotaku/mobi/pdb"
+	"github.com/leotaku/mobi/types"
+)
+
+// ErrInvalidMobi 不是有效的MOBI文件
+var ErrInvalidMobi = errors.New("无效的mobi文件")
+
+// boundaryRecord MOBI7和KF8两部分之间的分隔记录
+var boundaryRecord = pdb.RawRecord("BOUNDARY")
+
+// record 0 中MOBI头的字段偏移
+const (
+	mobiHeaderStart       = types.PalmDocHeaderLength
+	fullNameOffsetField   = mobiHeaderStart + 0x44
+	fullNameLengthField   = mobiHeaderStart + 0x48
+	mobiHeaderLengthField = mobiHeaderStart + 4
+	firstImageIndexField  = mobiHeaderStart + 0x5C
+)
+
+// WriteJoint 把MOBI7文件和book合并成一个双格式mobi文件写入w
+//
+// 旧设备只读取前面的MOBI7部分, 支持KF8的设备通过EXTH 121找到后面的KF8部分,
+// 和kindlegen生成的mobi文件结构一致。
+func WriteJoint(w io.Writer, mobi7 io.Reader, book *Book) error {
+	old, err := pdb.ReadDatabase(mobi7)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMobi, err)
+	}
+	kf8, err := book.Database()
+	if err != nil {
+		return err
+	}
+	boundary := len(old.Records)
+	rec0, err := recordBytes(old.Records[0])
+	if err != nil {
+		return err
+	}
+	rec0, err = addEXTHInt(rec0, types.EXTHKF8Boundary, boundary+1)
+	if err != nil {
+		return err
+	}
+
+	db := pdb.NewDatabase(book.Title, book.CreatedDate)
+	db.AddRecord(pdb.RawRecord(rec0))
+	addRecords(&db, old.Records[1:]...)
+	db.AddRecord(boundaryRecord)
+	addRecords(&db, kf8.Records...)
+	return db.Write(w)
+}
+
+func recordBytes(rec pdb.Record) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := rec.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addEXTHInt 在MOBI7的第0条记录中追加一个整数EXTH条目, 并重新计算书名的位置
+func addEXTHInt(rec0 []byte, tp types.EXTHEntryType, value int) ([]byte, error) {
+	rec, err := parseRecord0(rec0)
+	if err != nil {
+		return nil, err
+	}
+	rec.setInt(tp, value)
+	return rec.bytes()
+}
@@ -0,0 +1,289 @@
+package kf8
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/leotaku/mobi/pdb"
+	"github.com/leotaku/mobi/records"
+	"github.com/leotaku/mobi/types"
+)
+
+// ErrNoCover 书籍没有封面记录, 无法替换封面
+var ErrNoCover = errors.New("书籍没有封面")
+
+// Metadata mobi和azw3文件中可以修改的元数据
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Description string
+	PublishDate string // 出版日期, 如2006-01-02
+	Language    string // 语言代码, 如zh、en
+}
+
+// ReadMetadata 读取mobi或azw3文件的元数据
+func ReadMetadata(data []byte) (*Metadata, error) {
+	db, err := parsePalmDB(data)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := parseRecord0(db.records[0])
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{
+		Title:       string(rec.name),
+		Authors:     rec.strings(types.EXTHAuthor),
+		Description: rec.string(types.EXTHDescription),
+		PublishDate: rec.string(types.EXTHPublishingDate),
+		Language:    rec.string(types.EXTHLanguage),
+	}
+	if title := rec.string(types.EXTHUpdatedTitle); title != "" {
+		meta.Title = title
+	}
+	return meta, nil
+}
+
+// UpdateMetadata 修改mobi或azw3文件的元数据, 返回修改后的文件
+//
+// meta中为空的字段保持不变; cover不为空时替换封面并重新生成缩略图。
+// 双格式mobi的MOBI7和KF8两部分都会修改, 其它记录原样保留。
+func UpdateMetadata(data []byte, meta Metadata, cover []byte) ([]byte, error) {
+	db, err := parsePalmDB(data)
+	if err != nil {
+		return nil, err
+	}
+	var thumb []byte
+	if len(cover) > 0 {
+		if cover, err = coverData(cover); err != nil {
+			return nil, fmt.Errorf("处理封面失败: %w", err)
+		}
+		if thumb, err = thumbnail(cover); err != nil {
+			return nil, fmt.Errorf("生成缩略图失败: %w", err)
+		}
+	}
+
+	// 双格式文件的KF8部分有自己的第0条记录, 其中的记录序号都相对于这条记录
+	starts := []int{0}
+	for i := 0; i < len(starts); i++ {
+		start := starts[i]
+		rec, err := parseRecord0(db.records[start])
+		if err != nil {
+			return nil, err
+		}
+		if boundary, ok := rec.int(types.EXTHKF8Boundary); ok && i == 0 {
+			if boundary <= 0 || boundary >= len(db.records) {
+				return nil, fmt.Errorf("%w: KF8记录越界", ErrInvalidMobi)
+			}
+			starts = append(starts, boundary)
+		}
+
+		if meta.Title != "" {
+			rec.name = []byte(meta.Title)
+			rec.setString(types.EXTHUpdatedTitle, meta.Title)
+			if len(rec.strings(types.EXTHTitle)) > 0 {
+				rec.setString(types.EXTHTitle, meta.Title)
+			}
+		}
+		if len(meta.Authors) > 0 {
+			rec.setString(types.EXTHAuthor, meta.Authors...)
+		}
+		if meta.Description != "" {
+			rec.setString(types.EXTHDescription, meta.Description)
+		}
+		if meta.PublishDate != "" {
+			rec.setString(types.EXTHPublishingDate, meta.PublishDate)
+		}
+		if meta.Language != "" {
+			rec.setString(types.EXTHLanguage, meta.Language)
+		}
+
+		if len(cover) > 0 {
+			offset, ok := rec.int(types.EXTHCoverOffset)
+			if !ok {
+				return nil, ErrNoCover
+			}
+			first := start + int(pdb.Endian.Uint32(rec.header[firstImageIndexField:]))
+			if err := db.replace(first+offset, cover); err != nil {
+				return nil, err
+			}
+			if offset, ok := rec.int(types.EXTHThumbOffset); ok {
+				if err := db.replace(first+offset, thumb); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if db.records[start], err = rec.bytes(); err != nil {
+			return nil, err
+		}
+	}
+	return db.bytes(), nil
+}
+
+// palmDB 按原样解析的PalmDB文件, 修改记录时保留头部和记录属性
+type palmDB struct {
+	header  []byte // PalmDB头、记录列表和补齐
+	records [][]byte
+}
+
+func parsePalmDB(data []byte) (*palmDB, error) {
+	if len(data) < pdb.PalmDBHeaderLength {
+		return nil, ErrInvalidMobi
+	}
+	count := int(pdb.Endian.Uint16(data[pdb.PalmDBHeaderLength-2:]))
+	listEnd := pdb.PalmDBHeaderLength + count*pdb.RecordHeaderLength
+	if count == 0 || len(data) < listEnd {
+		return nil, ErrInvalidMobi
+	}
+	offsets := make([]int, count+1)
+	for i := range count {
+		offsets[i] = int(pdb.Endian.Uint32(data[pdb.PalmDBHeaderLength+i*pdb.RecordHeaderLength:]))
+	}
+	offsets[count] = len(data)
+	if offsets[0] < listEnd {
+		return nil, fmt.Errorf("%w: 记录越界", ErrInvalidMobi)
+	}
+	db := &palmDB{header: slices.Clone(data[:offsets[0]])}
+	for i := range count {
+		if offsets[i+1] < offsets[i] {
+			return nil, fmt.Errorf("%w: 记录越界", ErrInvalidMobi)
+		}
+		db.records = append(db.records, data[offsets[i]:offsets[i+1]])
+	}
+	return db, nil
+}
+
+func (db *palmDB) replace(i int, data []byte) error {
+	if i <= 0 || i >= len(db.records) {
+		return fmt.Errorf("%w: 封面记录越界", ErrInvalidMobi)
+	}
+	db.records[i] = data
+	return nil
+}
+
+// bytes 重新计算记录的偏移并生成文件
+func (db *palmDB) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(db.header)
+	for i, rec := range db.records {
+		pdb.Endian.PutUint32(buf.Bytes()[pdb.PalmDBHeaderLength+i*pdb.RecordHeaderLength:], uint32(buf.Len()))
+		buf.Write(rec)
+	}
+	return buf.Bytes()
+}
+
+// record0 解析后的第0条记录
+type record0 struct {
+	header  []byte // EXTH之前的PalmDOC头和MOBI头
+	entries []exthEntry
+	name    []byte
+}
+
+type exthEntry struct {
+	tp   types.EXTHEntryType
+	data []byte
+}
+
+func parseRecord0(rec []byte) (*record0, error) {
+	if len(rec) < mobiHeaderStart+8 || string(rec[mobiHeaderStart:mobiHeaderStart+4]) != "MOBI" {
+		return nil, ErrInvalidMobi
+	}
+	exthStart := mobiHeaderStart + int(pdb.Endian.Uint32(rec[mobiHeaderLengthField:]))
+	if exthStart < firstImageIndexField+4 || len(rec) < exthStart+types.EXTHHeaderLength || string(rec[exthStart:exthStart+4]) != "EXTH" {
+		return nil, fmt.Errorf("%w: 缺少EXTH", ErrInvalidMobi)
+	}
+	r := &record0{header: rec[:exthStart]}
+	// 逐条读取原有条目, 不依赖EXTH头里的长度, 部分生成器的长度和补齐不准确
+	count := int(pdb.Endian.Uint32(rec[exthStart+8:]))
+	pos := exthStart + types.EXTHHeaderLength
+	for range count {
+		if pos+types.EXTHEntryHeaderLength > len(rec) {
+			return nil, fmt.Errorf("%w: EXTH条目越界", ErrInvalidMobi)
+		}
+		tp := types.EXTHEntryType(pdb.Endian.Uint32(rec[pos:]))
+		length := int(pdb.Endian.Uint32(rec[pos+4:]))
+		if length < types.EXTHEntryHeaderLength || pos+length > len(rec) {
+			return nil, fmt.Errorf("%w: EXTH条目越界", ErrInvalidMobi)
+		}
+		r.entries = append(r.entries, exthEntry{tp: tp, data: rec[pos+types.EXTHEntryHeaderLength : pos+length]})
+		pos += length
+	}
+
+	nameOffset := int(pdb.Endian.Uint32(rec[fullNameOffsetField:]))
+	nameLength := int(pdb.Endian.Uint32(rec[fullNameLengthField:]))
+	if nameOffset+nameLength > len(rec) {
+		return nil, fmt.Errorf("%w: 书名越界", ErrInvalidMobi)
+	}
+	r.name = rec[nameOffset : nameOffset+nameLength]
+	return r, nil
+}
+
+func (r *record0) strings(tp types.EXTHEntryType) []string {
+	var values []string
+	for _, e := range r.entries {
+		if e.tp == tp {
+			values = append(values, string(e.data))
+		}
+	}
+	return values
+}
+
+func (r *record0) string(tp types.EXTHEntryType) string {
+	if values := r.strings(tp); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (r *record0) int(tp types.EXTHEntryType) (int, bool) {
+	for _, e := range r.entries {
+		if e.tp == tp && len(e.data) == 4 {
+			return int(pdb.Endian.Uint32(e.data)), true
+		}
+	}
+	return 0, false
+}
+
+// set 用values替换tp类型的所有条目
+func (r *record0) set(tp types.EXTHEntryType, values ...[]byte) {
+	r.entries = slices.DeleteFunc(r.entries, func(e exthEntry) bool { return e.tp == tp })
+	for _, v := range values {
+		r.entries = append(r.entries, exthEntry{tp: tp, data: v})
+	}
+}
+
+func (r *record0) setString(tp types.EXTHEntryType, values ...string) {
+	data := make([][]byte, len(values))
+	for i, v := range values {
+		data[i] = []byte(v)
+	}
+	r.set(tp, data...)
+}
+
+func (r *record0) setInt(tp types.EXTHEntryType, value int) {
+	data := make([]byte, 4)
+	pdb.Endian.PutUint32(data, uint32(value))
+	r.set(tp, data)
+}
+
+// bytes 重新生成记录, 并更新书名的位置和长度
+func (r *record0) bytes() ([]byte, error) {
+	exth := records.NewEXTHSection()
+	for _, e := range r.entries {
+		exth.AddString(e.tp, string(e.data))
+	}
+	var buf bytes.Buffer
+	buf.Write(r.header)
+	if err := exth.Write(&buf); err != nil {
+		return nil, err
+	}
+	pdb.Endian.PutUint32(buf.Bytes()[fullNameOffsetField:], uint32(buf.Len()))
+	pdb.Endian.PutUint32(buf.Bytes()[fullNameLengthField:], uint32(len(r.name)))
+	buf.Write(r.name)
+	// 和kindlegen一样在记录末尾留出空间, 方便阅读器修改元数据
+	buf.Write(make([]byte, records.NullPaddingLength))
+	return buf.Bytes(), nil
+}
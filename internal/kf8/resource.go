@@ -0,0 +1,98 @@
+package kf8
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"github.com/leotaku/mobi/jfif"
+	"github.com/leotaku/mobi/pdb"
+	"golang.org/x/image/draw"
+)
+
+// ErrUnsupportedImage 图片格式无法识别
+var ErrUnsupportedImage = errors.New("不支持的图片格式")
+
+// thumbHeight 缩略图高度, 和kindlegen生成的缩略图一致
+const thumbHeight = 330
+
+// imageData 检查图片格式, Kindle只支持JPEG、PNG和GIF, 其它格式转换成JPEG
+func imageData(data []byte) ([]byte, string, error) {
+	mime := http.DetectContentType(data)
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif":
+		return data, mime, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedImage, mime)
+	}
+	data, err = encodeJPEG(img)
+	return data, "image/jpeg", err
+}
+
+// coverData 封面统一使用JPEG, 部分设备不显示PNG封面
+func coverData(data []byte) ([]byte, error) {
+	if http.DetectContentType(data) == "image/jpeg" {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	return encodeJPEG(img)
+}
+
+// thumbnail 按封面生成缩略图
+func thumbnail(cover []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(cover))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dy() <= thumbHeight {
+		return encodeJPEG(img)
+	}
+	width := max(1, bounds.Dx()*thumbHeight/bounds.Dy())
+	thumb := image.NewRGBA(image.Rect(0, 0, width, thumbHeight))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+	return encodeJPEG(thumb)
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jfif.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("编码图片失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fontRecord 生成FONT记录, 字体用zlib压缩, 不做混淆
+//
+// 记录头: FONT, 原始大小, 标志位(1为压缩), 数据偏移, 混淆密钥长度, 混淆密钥偏移
+func fontRecord(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("压缩字体失败: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("压缩字体失败: %w", err)
+	}
+	const headerLength = 24
+	var buf bytes.Buffer
+	buf.WriteString("FONT")
+	binary.Write(&buf, pdb.Endian, [5]uint32{uint32(len(data)), 1, headerLength, 0, headerLength})
+	buf.Write(compressed.Bytes())
+	return buf.Bytes(), nil
+}
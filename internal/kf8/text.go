@@ -0,0 +1,208 @@
+package kf8
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/leotaku/mobi/records"
+)
+
+// skeletonHead 每个章节文件的骨架, 章节正文插入到body标签里
+const skeletonHead = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>%s</title><meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>%s</head><body aid="%s">`
+
+const skeletonTail = `</body></html>`
+
+const cssLink = `<link rel="stylesheet" type="text/css" href="kindle:flow:0001?mime=text/css"/>`
+
+// skeleton 章节文件的骨架在文本中的位置
+type skeleton struct {
+	start  int
+	length int
+}
+
+// chunk 章节正文, 阅读时插入到骨架的insertPos位置
+type chunk struct {
+	insertPos int
+	selector  string
+	file      int
+	length    int
+}
+
+// tocEntry 目录条目
+type tocEntry struct {
+	title    string
+	offset   int
+	length   int
+	depth    int
+	file     int
+	parent   int
+	children []int
+}
+
+// layout 把章节排版成KF8文本, 每个章节(包括子章节)是一个文件, 文件由骨架和一个正文块组成
+func (b *Book) layout() (string, []skeleton, []chunk, []tocEntry) {
+	var text strings.Builder
+	var skeletons []skeleton
+	var chunks []chunk
+	var toc []tocEntry
+	link := ""
+	if b.CSS != "" {
+		link = cssLink
+	}
+
+	var walk func(chapters []Chapter, depth, parent int)
+	walk = func(chapters []Chapter, depth, parent int) {
+		for _, chapter := range chapters {
+			file := len(skeletons)
+			aid := base32(file)
+			head := fmt.Sprintf(skeletonHead, html.EscapeString(chapter.Title), link, aid)
+			body := escapeAmpersands(chapter.Body)
+			start := text.Len()
+			text.WriteString(head)
+			text.WriteString(skeletonTail)
+			text.WriteString(body)
+			skeletons = append(skeletons, skeleton{start: start, length: len(head) + len(skeletonTail)})
+			chunks = append(chunks, chunk{
+				insertPos: start + len(head),
+				selector:  fmt.Sprintf(`P-//*[@aid="%s"]`, aid),
+				file:      file,
+				length:    len(body),
+			})
+
+			idx := len(toc)
+			toc = append(toc, tocEntry{title: chapter.Title, offset: start, depth: depth, file: file, parent: parent})
+			if parent >= 0 {
+				toc[parent].children = append(toc[parent].children, idx)
+			}
+			walk(chapter.Children, depth+1, idx)
+			toc[idx].length = text.Len() - start
+		}
+	}
+	walk(b.Chapters, 0, -1)
+	return text.String(), skeletons, chunks, toc
+}
+
+var entityReg = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+// escapeAmpersands 转义不属于实体的&, 阅读器按XHTML解析正文, 单独的&会导致整个文件无法显示
+func escapeAmpersands(body string) string {
+	if !strings.Contains(body, "&") {
+		return body
+	}
+	var buf strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] == '&' && !entityReg.MatchString(body[i:]) {
+			buf.WriteString("&amp;")
+			continue
+		}
+		buf.WriteByte(body[i])
+	}
+	return buf.String()
+}
+
+// skeletonIndex 生成骨架索引, 和kindlegen一样每个值都写两遍
+func skeletonIndex(skeletons []skeleton) []indexEntry {
+	entries := make([]indexEntry, 0, len(skeletons))
+	for i, s := range skeletons {
+		entries = append(entries, indexEntry{
+			label: fmt.Sprintf("SKEL%010d", i),
+			values: [][]int{
+				{1, 1},
+				{s.start, s.length, s.start, s.length},
+			},
+		})
+	}
+	return entries
+}
+
+// chunkIndex 生成正文块索引
+func chunkIndex(chunks []chunk, names *cncx) []indexEntry {
+	entries := make([]indexEntry, 0, len(chunks))
+	for i, c := range chunks {
+		entries = append(entries, indexEntry{
+			label: fmt.Sprintf("%010d", c.insertPos),
+			values: [][]int{
+				{names.add(c.selector)},
+				{c.file},
+				{i},
+				{0, c.length},
+			},
+		})
+	}
+	return entries
+}
+
+// ncxIndex 生成目录索引, Kindle要求条目按层级和位置排序
+func ncxIndex(toc []tocEntry, names *cncx) []indexEntry {
+	order := make([]int, len(toc))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := toc[order[i]], toc[order[j]]
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		return a.offset < b.offset
+	})
+	position := make([]int, len(toc))
+	for pos, idx := range order {
+		position[idx] = pos
+	}
+
+	entries := make([]indexEntry, 0, len(toc))
+	for pos, idx := range order {
+		entry := toc[idx]
+		values := [][]int{
+			{entry.offset},
+			{entry.length},
+			{names.add(entry.title)},
+			{entry.depth},
+			nil,
+			nil,
+			nil,
+			{entry.file, 0},
+		}
+		if entry.parent >= 0 {
+			values[4] = []int{position[entry.parent]}
+		}
+		if len(entry.children) > 0 {
+			values[5] = []int{position[entry.children[0]]}
+			values[6] = []int{position[entry.children[len(entry.children)-1]]}
+		}
+		entries = append(entries, indexEntry{label: fmt.Sprintf("%04X", pos), values: values})
+	}
+	return entries
+}
+
+// textRecords 把文本切分成记录, 被截断的多字节字符剩余的字节附加在记录末尾
+func textRecords(text string) [][]byte {
+	var result [][]byte
+	for start := 0; start < len(text); start += records.TextRecordMaxSize {
+		end := min(start+records.TextRecordMaxSize, len(text))
+		overlap := 0
+		for overlap < 3 && end+overlap < len(text) && !utf8.RuneStart(text[end+overlap]) {
+			overlap++
+		}
+		rec := make([]byte, 0, end-start+overlap+1)
+		rec = append(rec, text[start:end+overlap]...)
+		rec = append(rec, byte(overlap))
+		result = append(result, rec)
+	}
+	return result
+}
+
+// base32 生成kindle:embed和aid使用的4位32进制编号
+func base32(i int) string {
+	s := strings.ToUpper(strconv.FormatInt(int64(i), 32))
+	if len(s) < 4 {
+		s = strings.Repeat("0", 4-len(s)) + s
+	}
+	return s
+}
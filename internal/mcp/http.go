@@ -0,0 +1,152 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/middleware"
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HTTPOptions 通过HTTP提供服务的参数
+type HTTPOptions struct {
+	Version           string // 服务版本
+	BasePath          string // 路由前缀, 默认为/mcp, 客户端连接<BasePath>/sse
+	BaseURL           string // 返回给客户端的消息地址的前缀, 如https://kaf.example.com, 为空时使用相对地址
+	WorkDir           string // 用户工作目录的根目录, 每个用户的文件在其中以用户ID命名的子目录下
+	RequestsPerMinute int    // 每个用户每分钟的请求数, 为0时不限制
+	BurstSize         int    // 每个用户的突发请求数, 为0时和RequestsPerMinute相同
+}
+
+// Authenticator 验证bearer token并查询用户, 由Web服务的AuthService实现
+type Authenticator interface {
+	ValidateToken(token string) (*services.JWTClaims, error)
+	GetUserByID(userID uint) (*models.User, error)
+}
+
+// httpServer 通过SSE提供MCP服务, 每个用户使用自己的工作目录
+type httpServer struct {
+	sse     *server.SSEServer
+	auth    Authenticator
+	opts    HTTPOptions
+	limiter *middleware.AdvancedRateLimiter
+
+	owners sync.Map // SSE会话ID -> 用户ID, 消息只能发到自己的会话
+}
+
+// NewHTTPHandler 创建通过SSE提供MCP服务的http.Handler
+//
+// 客户端需要在Authorization头中带上Web服务登录后得到的token: "Bearer <token>"。
+// 远程用户只能读写自己工作目录下的文件, 可以用kaf_upload上传txt。
+func NewHTTPHandler(auth Authenticator, opts HTTPOptions) (http.Handler, error) {
+	if opts.BasePath == "" {
+		opts.BasePath = "/mcp"
+	}
+	if opts.WorkDir == "" {
+		return nil, fmt.Errorf("工作目录不能为空")
+	}
+	workDir, err := filepath.Abs(opts.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("获取工作目录失败: %w", err)
+	}
+	opts.WorkDir = workDir
+	if err := os.MkdirAll(opts.WorkDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建工作目录失败: %w", err)
+	}
+
+	h := &httpServer{auth: auth, opts: opts}
+	if opts.RequestsPerMinute > 0 {
+		h.limiter = middleware.NewAdvancedRateLimiter(middleware.RateLimiterConfig{
+			RequestsPerMinute: opts.RequestsPerMinute,
+			BurstSize:         opts.BurstSize,
+		})
+	}
+
+	// 会话注册时的context来自建立SSE连接的请求, 记录会话所属的用户
+	hooks := &server.Hooks{}
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		if ws := workspaceFrom(ctx); ws != nil {
+			h.owners.Store(session.SessionID(), ws.UserID)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		h.owners.Delete(session.SessionID())
+	})
+	srv := server.NewMCPServer(
+		"KAF Converter",
+		opts.Version,
+		server.WithLogging(),
+		server.WithHooks(hooks),
+	)
+	converter := &ConverterService{}
+	converter.RegisterTools(srv, opts.Version)
+	converter.registerUpload(srv)
+	registerResourceTemplates(srv, &library{srv: srv})
+
+	sseOpts := []server.SSEOption{
+		server.WithStaticBasePath(opts.BasePath),
+		server.WithKeepAlive(true),
+	}
+	if opts.BaseURL != "" {
+		sseOpts = append(sseOpts, server.WithBaseURL(opts.BaseURL))
+	}
+	h.sse = server.NewSSEServer(srv, sseOpts...)
+	return h, nil
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeHTTPError(w, http.StatusUnauthorized, "缺少认证token")
+		return
+	}
+	claims, err := h.auth.ValidateToken(token)
+	if err != nil {
+		writeHTTPError(w, http.StatusUnauthorized, "无效的token")
+		return
+	}
+	user, err := h.auth.GetUserByID(claims.UserID)
+	if err != nil || !user.IsActive {
+		writeHTTPError(w, http.StatusUnauthorized, "用户不存在或已被禁用")
+		return
+	}
+	if h.limiter != nil && !h.limiter.Allow(fmt.Sprintf("mcp:%d", user.ID)) {
+		w.Header().Set("Retry-After", "60")
+		writeHTTPError(w, http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+		return
+	}
+	if sessionID := r.URL.Query().Get("sessionId"); sessionID != "" {
+		if owner, ok := h.owners.Load(sessionID); ok && owner.(uint) != user.ID {
+			writeHTTPError(w, http.StatusForbidden, "不能访问其他用户的会话")
+			return
+		}
+	}
+
+	dir := filepath.Join(h.opts.WorkDir, strconv.FormatUint(uint64(user.ID), 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Error("create workspace failed", "error", err, "user", user.Username)
+		writeHTTPError(w, http.StatusInternalServerError, "创建工作目录失败")
+		return
+	}
+	ctx := withWorkspace(r.Context(), &workspace{UserID: user.ID, Username: user.Username, Dir: dir})
+	h.sse.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// writeHTTPError 返回和Web接口相同格式的错误
+func writeHTTPError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.APIResponse{
+		Code:    status,
+		Message: message,
+	})
+}
@@ -6,7 +6,8 @@ import (
 	"path/filepath"
 )
 
-var logger *slog.Logger
+// logger 默认输出到标准错误, 作为Web服务的一部分运行时不需要调用InitLogger
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 
 func InitLogger() {
 	if os.Getenv("LOGGER") != "true" {
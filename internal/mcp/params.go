@@ -0,0 +1,160 @@
+package mcp
+
+import (
+	"fmt"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// bookParam 工具参数, 对应model.Book的一个字段, 参数名和Web接口的转换参数相同
+type bookParam struct {
+	name   string
+	option func(name string, opts ...mcp.PropertyOption) mcp.ToolOption
+	opts   []mcp.PropertyOption
+	set    func(book *model.Book, v any) error
+}
+
+func stringParam(name string, set func(book *model.Book, v string), opts ...mcp.PropertyOption) bookParam {
+	return bookParam{name: name, option: mcp.WithString, opts: opts, set: func(book *model.Book, v any) error {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("参数%s应为字符串", name)
+		}
+		set(book, s)
+		return nil
+	}}
+}
+
+func numberParam(name string, set func(book *model.Book, v int), opts ...mcp.PropertyOption) bookParam {
+	return bookParam{name: name, option: mcp.WithNumber, opts: opts, set: func(book *model.Book, v any) error {
+		n, ok := v.(float64)
+		if !ok || n != float64(int(n)) {
+			return fmt.Errorf("参数%s应为整数", name)
+		}
+		set(book, int(n))
+		return nil
+	}}
+}
+
+func boolParam(name string, set func(book *model.Book, v bool), opts ...mcp.PropertyOption) bookParam {
+	return bookParam{name: name, option: mcp.WithBoolean, opts: opts, set: func(book *model.Book, v any) error {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("参数%s应为true或false", name)
+		}
+		set(book, b)
+		return nil
+	}}
+}
+
+// metaParams 书籍信息和封面
+var metaParams = []bookParam{
+	stringParam("bookname", func(b *model.Book, v string) { b.Bookname = v },
+		mcp.Description("书名, 为空时从文件名识别, 可以自动识别的文件名格式: 《(.*)》.*作者[：:](.*).txt")),
+	stringParam("author", func(b *model.Book, v string) { b.Author = v },
+		mcp.Description("作者, 为空时从文件名识别, 可以自动识别的文件名格式: 《(.*)》.*作者[：:](.*).txt")),
+	stringParam("description", func(b *model.Book, v string) { b.Description = v },
+		mcp.Description("简介")),
+	stringParam("lang", func(b *model.Book, v string) { b.Lang = v },
+		mcp.Description("语言, 如zh、en、ja"), mcp.DefaultString("zh")),
+	stringParam("date", func(b *model.Book, v string) { b.Date = v },
+		mcp.Description("出版日期, 格式为2006-01-02"), mcp.Pattern(`^\d{4}-\d{2}-\d{2}$`)),
+	stringParam("cover", func(b *model.Book, v string) { b.Cover = v },
+		mcp.Description("封面: 本地图片路径; gen或orly生成orly风格的封面(需要联网); none不使用封面")),
+	stringParam("cover_orly_color", func(b *model.Book, v string) { b.CoverOrlyColor = v },
+		mcp.Description("orly封面的颜色, 1-16或hex格式的颜色, 为空时随机")),
+	numberParam("cover_orly_idx", func(b *model.Book, v int) { b.CoverOrlyIdx = v },
+		mcp.Description("orly封面的动物, 0-41, -1时随机"), mcp.Min(-1), mcp.Max(41)),
+}
+
+// matchParams 识别章节, 会影响识别出的目录
+var matchParams = []bookParam{
+	stringParam("match", func(b *model.Book, v string) { b.Match = v },
+		mcp.Description("匹配章节标题的正则表达式, 为空时自动识别常见的章节标题; 可以先用kaf_suggest_match获取建议")),
+	stringParam("volume_match", func(b *model.Book, v string) { b.VolumeMatch = v },
+		mcp.Description("匹配卷标题的正则表达式, 为false时不识别卷"), mcp.DefaultString(model.VolumeMatch)),
+	stringParam("exclusion_pattern", func(b *model.Book, v string) { b.ExclusionPattern = v },
+		mcp.Description("排除误识别为标题的行的正则表达式, 为false时不排除"), mcp.DefaultString(model.DefaultExclusion)),
+	numberParam("max", func(b *model.Book, v int) { b.Max = uint(v) },
+		mcp.Description("标题的最大字数, 超过的行不作为标题"), mcp.DefaultNumber(35), mcp.Min(1)),
+	stringParam("unknow_title", func(b *model.Book, v string) { b.UnknowTitle = v },
+		mcp.Description("第一个标题之前的内容的章节名"), mcp.DefaultString("章节正文")),
+	stringParam("title_template", func(b *model.Book, v string) { b.TitleTemplate = v },
+		mcp.Description("章节标题模板, 如\"第{n:cn}章 {title}\", {n}为序号, {n:cn}为中文序号, {title}为去掉序号后的标题, 为空时保留原标题")),
+	stringParam("volume_template", func(b *model.Book, v string) { b.VolumeTemplate = v },
+		mcp.Description("卷标题模板, 如\"第{n:cn}卷 {title}\", 为空时保留原标题")),
+	stringParam("renumber", func(b *model.Book, v string) { b.Renumber = v },
+		mcp.Description("使用标题模板时重新编号: volume每卷从1开始, global全书连续编号, 为空时使用原标题中的序号"),
+		mcp.Enum(model.RenumberVolume, model.RenumberGlobal)),
+	stringParam("title_junk", func(b *model.Book, v string) { b.TitleJunk = v },
+		mcp.Description("整理标题时去掉的标题末尾内容的正则表达式, 为false时不去掉")),
+}
+
+// styleParams 排版
+var styleParams = []bookParam{
+	numberParam("indent", func(b *model.Book, v int) { b.Indent = uint(v) },
+		mcp.Description("段落缩进的字数"), mcp.DefaultNumber(2), mcp.Min(0)),
+	stringParam("align", func(b *model.Book, v string) { b.Align = v },
+		mcp.Description("标题对齐方式"), mcp.Enum("left", "center", "right"), mcp.DefaultString("center")),
+	stringParam("bottom", func(b *model.Book, v string) { b.Bottom = v },
+		mcp.Description("段落间距, 如1em、10px"), mcp.DefaultString("1em")),
+	stringParam("line_height", func(b *model.Book, v string) { b.LineHeight = v },
+		mcp.Description("行高, 如1.5rem")),
+	stringParam("font", func(b *model.Book, v string) { b.Font = v },
+		mcp.Description("嵌入的正文字体文件路径, 只嵌入书中用到的字")),
+	stringParam("title_font", func(b *model.Book, v string) { b.TitleFont = v },
+		mcp.Description("嵌入的书名和章节标题字体文件路径")),
+	boolParam("tips", func(b *model.Book, v bool) { b.Tips = v },
+		mcp.Description("在书的开头和结尾添加制作说明"), mcp.DefaultBool(false)),
+}
+
+// outputParams 生成的格式和文件
+var outputParams = []bookParam{
+	stringParam("format", func(b *model.Book, v string) { b.Format = v },
+		mcp.Description("生成的格式, all同时生成epub、mobi和azw3"), mcp.Enum("epub", "mobi", "azw3", "all"), mcp.DefaultString("epub")),
+	stringParam("out", func(b *model.Book, v string) { b.Out = v },
+		mcp.Description("输出的文件名, 不包含格式后缀, 可以包含目录, 默认为书名")),
+	stringParam("split", func(b *model.Book, v string) { b.Split = v },
+		mcp.Description("分册方式: sections按章节数, size按正文大小, volume按卷, range按章节范围, 为空时不分册"),
+		mcp.Enum(model.SplitSections, model.SplitSize, model.SplitVolume, model.SplitRange)),
+	numberParam("split_count", func(b *model.Book, v int) { b.SplitCount = v },
+		mcp.Description("按章节数或卷分册时每册的章节数或卷数"), mcp.Min(1)),
+	numberParam("split_size", func(b *model.Book, v int) { b.SplitSize = v },
+		mcp.Description("按正文大小分册时每册的最大KB数"), mcp.Min(1)),
+	stringParam("split_ranges", func(b *model.Book, v string) { b.SplitRanges = v },
+		mcp.Description("按章节范围分册时的范围, 如1-500,501-")),
+	boolParam("mobi_kf8", func(b *model.Book, v bool) { b.MobiKF8 = v },
+		mcp.Description("mobi生成同时包含MOBI7和KF8的双格式文件"), mcp.DefaultBool(false)),
+	boolParam("reproducible", func(b *model.Book, v bool) { b.Reproducible = v },
+		mcp.Description("相同的txt和参数生成完全相同的文件"), mcp.DefaultBool(false)),
+	boolParam("epub_check", func(b *model.Book, v bool) { b.EpubCheck = v },
+		mcp.Description("生成epub后检查是否符合规范, 不符合时转换失败"), mcp.DefaultBool(false)),
+}
+
+// withParams 把参数添加到工具的JSON Schema中
+func withParams(params ...[]bookParam) []mcp.ToolOption {
+	var opts []mcp.ToolOption
+	for _, group := range params {
+		for _, p := range group {
+			opts = append(opts, p.option(p.name, p.opts...))
+		}
+	}
+	return opts
+}
+
+// applyParams 把请求中的参数写入book, 没有传的参数保持默认值
+func applyParams(book *model.Book, args map[string]any, params ...[]bookParam) error {
+	for _, group := range params {
+		for _, p := range group {
+			v, ok := args[p.name]
+			if !ok || v == nil {
+				continue
+			}
+			if err := p.set(book, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// progressInterval 发送章节进度通知的最小间隔, 避免大量章节时通知塞满通道
+const progressInterval = 200 * time.Millisecond
+
+// progressReporter 把识别和转换的进度作为notifications/progress发送给客户端
+//
+// 客户端请求时没有带progressToken时不发送通知。进度为识别章节算1步, 每个格式每添加一章算1步。
+type progressReporter struct {
+	ctx   context.Context
+	token mcp.ProgressToken
+
+	mu       sync.Mutex
+	total    int
+	progress int
+	formats  map[string]int // 每个格式已添加的章节数
+	sent     time.Time
+}
+
+func newProgressReporter(ctx context.Context, req mcp.CallToolRequest) *progressReporter {
+	r := &progressReporter{ctx: ctx, formats: map[string]int{}}
+	if req.Params.Meta != nil {
+		r.token = req.Params.Meta.ProgressToken
+	}
+	return r
+}
+
+// Parsing 开始识别章节
+func (r *progressReporter) Parsing(filename string) {
+	r.notify(fmt.Sprintf("正在识别章节: %s", filename), true)
+}
+
+// Parsed 识别章节完成, formats为要生成的格式数
+func (r *progressReporter) Parsed(chapters, formats int) {
+	r.mu.Lock()
+	r.progress = 1
+	r.total = 1 + chapters*formats
+	r.mu.Unlock()
+	r.notify(fmt.Sprintf("识别到%d章, 正在生成电子书", chapters), true)
+}
+
+// Convert 转换器的进度回调, 同时输出到logger
+func (r *progressReporter) Convert(log *utils.WarningCollector) converter.ProgressFunc {
+	logProgress := converter.LogProgress(log)
+	return func(p converter.Progress) {
+		logProgress(p)
+		if p.Stage == converter.StageWarning {
+			return
+		}
+		r.mu.Lock()
+		if p.Format != "" && p.Current > r.formats[p.Format] {
+			r.progress += p.Current - r.formats[p.Format]
+			r.formats[p.Format] = p.Current
+		}
+		r.mu.Unlock()
+
+		message := p.Message
+		switch p.Stage {
+		case converter.StageStart:
+			message = fmt.Sprintf("正在生成%s", p.Format)
+		case converter.StageSection:
+			message = fmt.Sprintf("正在生成%s: %d/%d", p.Format, p.Current, p.Total)
+		case converter.StageWrite:
+			message = fmt.Sprintf("正在保存%s", p.Format)
+		case converter.StageDone:
+			if p.Format != "" {
+				message = fmt.Sprintf("%s生成完成", p.Format)
+			}
+		}
+		r.notify(message, p.Stage != converter.StageSection)
+	}
+}
+
+// notify 发送进度通知, force为false时按progressInterval限制频率
+func (r *progressReporter) notify(message string, force bool) {
+	// 请求被取消后客户端不再需要进度
+	if r.token == nil || r.ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	if !force && time.Since(r.sent) < progressInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.sent = time.Now()
+	params := map[string]any{
+		"progressToken": r.token,
+		"progress":      r.progress,
+		"message":       message,
+	}
+	if r.total > 0 {
+		params["total"] = max(r.total, r.progress)
+	}
+	r.mu.Unlock()
+
+	srv := server.ServerFromContext(r.ctx)
+	if srv == nil {
+		return
+	}
+	if err := srv.SendNotificationToClient(r.ctx, "notifications/progress", params); err != nil {
+		logger.Warn("send progress failed", "error", err)
+	}
+}
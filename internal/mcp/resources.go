@@ -0,0 +1,172 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// 资源的URI前缀, 后面是相对配置目录(KAF_DIR)的路径
+const (
+	booksURI   = "kaf://books/"   // 生成的电子书
+	libraryURI = "kaf://library/" // 配置目录下的txt
+)
+
+// resourceDepth 扫描配置目录的最大深度
+const resourceDepth = 3
+
+// library 配置目录下的txt和电子书, 作为MCP资源提供给不和服务共享文件系统的客户端
+//
+// 远程连接时不提供资源列表, 用户通过资源模板读取自己工作目录下的文件。
+type library struct {
+	srv *server.MCPServer
+
+	mu   sync.Mutex
+	uris map[string]bool // 已注册的资源
+}
+
+// RegisterResources 注册配置目录下的txt和电子书资源, 转换完成后会更新资源列表
+func (s *ConverterService) RegisterResources(srv *server.MCPServer) {
+	s.library = &library{srv: srv, uris: map[string]bool{}}
+	registerResourceTemplates(srv, s.library)
+	s.library.refresh()
+}
+
+// registerResourceTemplates 注册读取电子书和txt的资源模板
+func registerResourceTemplates(srv *server.MCPServer, l *library) {
+	srv.AddResourceTemplate(
+		mcp.NewResourceTemplate(booksURI+"{+path}", "电子书",
+			mcp.WithTemplateDescription("配置目录下生成的epub、mobi、azw3电子书, path为相对配置目录的路径, 远程连接时为相对用户工作目录的路径"),
+		),
+		l.read,
+	)
+	srv.AddResourceTemplate(
+		mcp.NewResourceTemplate(libraryURI+"{+path}", "txt小说",
+			mcp.WithTemplateDescription("配置目录下的txt小说, 内容转换为utf-8, path为相对配置目录的路径, 远程连接时为相对用户工作目录的路径"),
+			mcp.WithTemplateMIMEType("text/plain"),
+		),
+		l.read,
+	)
+}
+
+// refresh 扫描配置目录, 添加新文件的资源, 删除已不存在的文件的资源
+func (l *library) refresh() {
+	found := map[string]mcp.Resource{}
+	root := "."
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || strings.Count(path, string(filepath.Separator)) >= resourceDepth-1) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		uri, mimeType := resourceURI(path)
+		if uri == "" {
+			return nil
+		}
+		description := ""
+		if info, err := d.Info(); err == nil {
+			description = fmt.Sprintf("%s, %d字节", filepath.ToSlash(path), info.Size())
+		}
+		found[uri] = mcp.NewResource(uri, d.Name(),
+			mcp.WithResourceDescription(description),
+			mcp.WithMIMEType(mimeType),
+		)
+		return nil
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for uri := range l.uris {
+		if _, ok := found[uri]; !ok {
+			l.srv.RemoveResource(uri)
+			delete(l.uris, uri)
+		}
+	}
+	for uri, resource := range found {
+		if !l.uris[uri] {
+			l.srv.AddResource(resource, l.read)
+			l.uris[uri] = true
+		}
+	}
+}
+
+// read 读取资源, 电子书返回base64编码的内容, txt返回utf-8文本
+func (l *library) read(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	logger.Info("read resource", "uri", req.Params.URI)
+	path, err := resourcePath(req.Params.URI)
+	if err != nil {
+		return nil, err
+	}
+	uri, mimeType := resourceURI(path)
+	if uri == "" || strings.HasPrefix(uri, libraryURI) != strings.HasPrefix(req.Params.URI, libraryURI) {
+		return nil, fmt.Errorf("不支持的资源: %s", req.Params.URI)
+	}
+	path = filepath.Join(baseDir(ctx), path)
+	if strings.HasPrefix(uri, libraryURI) {
+		text, err := core.ReadText(path)
+		if err != nil {
+			return nil, err
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: mimeType, Text: text}}, nil
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	return []mcp.ResourceContents{mcp.BlobResourceContents{
+		URI:      uri,
+		MIMEType: mimeType,
+		Blob:     base64.StdEncoding.EncodeToString(bs),
+	}}, nil
+}
+
+// resourceURI 相对配置目录的路径对应的资源URI和MIME类型, 不是txt或电子书时返回空字符串
+func resourceURI(path string) (uri, mimeType string) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	segments := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	if ext == "txt" {
+		return libraryURI + strings.Join(segments, "/"), "text/plain"
+	}
+	if mimeType, ok := mimeTypes[ext]; ok {
+		return booksURI + strings.Join(segments, "/"), mimeType
+	}
+	return "", ""
+}
+
+// resourcePath 资源URI对应的相对路径, 只能访问配置目录或工作目录下的文件
+func resourcePath(uri string) (string, error) {
+	rest, ok := strings.CutPrefix(uri, booksURI)
+	if !ok {
+		rest, ok = strings.CutPrefix(uri, libraryURI)
+	}
+	if !ok {
+		return "", fmt.Errorf("不支持的资源: %s", uri)
+	}
+	path, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", fmt.Errorf("资源路径错误: %w", err)
+	}
+	path = filepath.FromSlash(path)
+	if !filepath.IsLocal(path) {
+		return "", errors.New("只能读取配置目录下的文件")
+	}
+	return path, nil
+}
@@ -2,40 +2,27 @@ package mcp
 
 import (
 	"context"
-	"errors"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 
-	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/mark3labs/mcp-go/server"
 	"github.com/Deali-Axy/ebook-generator/internal/converter"
 	"github.com/Deali-Axy/ebook-generator/internal/core"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 type ConverterService struct {
 	version string
+	library *library // 配置目录下的资源, 调用RegisterResources后才有
 }
 
 func (s *ConverterService) RegisterTools(srv *server.MCPServer, version string) {
 	s.version = version
-	tool := mcp.NewTool("kaf_convert",
-		mcp.WithDescription("电子书格式转换器，支持把txt文件转换成epub电子书格式\n若转换成功，AI助手在返回结果给用户时应该使用markdorn: `[/home/user/documents/book.epub](/home/user/documents/book.epub)`格式, 两个URI都使用完整路径，以方便用户查看和点击跳转"),
-		mcp.WithString("filename",
-			mcp.Required(),
-			mcp.Description("txt小说文件, 支持相对路径，相对路径默认会从配置目录读取小说文件"),
-			mcp.Pattern(`\.txt$`),
-		),
-		mcp.WithString("bookname",
-			mcp.Description("书名, 为空可以自动识别的文件名格式: 《(.*)》.*作者[：:](.*).txt"),
-		),
-		mcp.WithString("author",
-			mcp.Description("作者, 为空可以自动识别的文件名格式: 《(.*)》.*作者[：:](.*).txt"),
-		),
-		mcp.WithString("match",
-			mcp.Description("章节匹配规则的正则表达式, 不填会自动识别常见章节名称"),
-		),
-	)
 	website := mcp.NewPrompt("kaf-mcp", mcp.WithPromptDescription("kaf-mcp官方网站,代码仓库"))
 	srv.AddPrompt(website, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
 		return mcp.NewGetPromptResult(
@@ -49,66 +36,201 @@ func (s *ConverterService) RegisterTools(srv *server.MCPServer, version string)
 		), nil
 	})
 
-	srv.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		// 记录请求参数
-		logger.Info("request received",
-			"tool", "kaf_convert",
-			"params", req.Params.Arguments,
-		)
-		filename, ok := req.Params.Arguments["filename"].(string)
-		if !ok {
-			err := errors.New("filename is required")
-			logger.Error("parameter validation failed", "error", err)
-			return nil, err
-		}
+	options := []mcp.ToolOption{
+		mcp.WithDescription("电子书格式转换器，支持把txt文件转换成epub、mobi、azw3电子书格式，可以设置书籍信息、封面、排版、章节标题和分册; 生成的电子书会嵌入在结果中, 在配置目录下时也可以通过结果中的kaf://books/资源读取\n若转换成功，AI助手在返回结果给用户时应该使用markdorn: `[/home/user/documents/book.epub](/home/user/documents/book.epub)`格式, 两个URI都使用完整路径，以方便用户查看和点击跳转"),
+		filenameParam(),
+	}
+	options = append(options, withParams(metaParams, matchParams, styleParams, outputParams)...)
+	srv.AddTool(mcp.NewTool("kaf_convert", options...), s.convert)
 
-		author, _ := req.Params.Arguments["author"].(string)
-		bookname, _ := req.Params.Arguments["bookname"].(string)
-		match, _ := req.Params.Arguments["match"].(string)
+	s.registerPreview(srv)
+	s.registerInspect(srv)
+	s.registerSuggestMatch(srv)
+	s.registerListFiles(srv)
+}
 
-		book, _ := model.NewBookSimple(filename)
-		book.Format = "epub"
-		if author != "" {
-			logger.Info("use author", "author", author)
-			book.Author = author
-		}
-		if bookname != "" {
-			logger.Info("use bookname", "bookname", bookname)
-			book.Bookname = bookname
+// convertResult kaf_convert的返回结果
+type convertResult struct {
+	Bookname string            `json:"bookname"`
+	Author   string            `json:"author"`
+	Volumes  int               `json:"volumes"`
+	Chapters int               `json:"chapters"`
+	Files    []bookFile        `json:"files"`
+	Warnings []string          `json:"warnings,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"` // 生成失败的格式和原因
+}
+
+// bookFile 生成的电子书文件
+type bookFile struct {
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	URI    string `json:"uri,omitempty"` // 在配置目录下时可以用resources/read读取
+	Size   int64  `json:"size"`
+}
+
+func (s *ConverterService) convert(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// 记录请求参数
+	logger.Info("request received",
+		"tool", "kaf_convert",
+		"params", req.Params.Arguments,
+	)
+	book, log, err := s.newBook(ctx, req.Params.Arguments, metaParams, matchParams, styleParams, outputParams)
+	if err != nil {
+		logger.Error("check failed", "error", err, "params", req.Params.Arguments)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	progress := newProgressReporter(ctx, req)
+	progress.Parsing(filepath.Base(book.Filename))
+	logger.Info("parse start", "filename", book.Filename)
+	if err := core.Parse(book); err != nil {
+		logger.Error("parse failed", "error", err, "filename", book.Filename)
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	formats := 1
+	if book.Format == "all" {
+		formats = 3
+	}
+	progress.Parsed(model.SectionCount(book.SectionList), formats)
+
+	conv := converter.Dispatcher{
+		Book:     book,
+		Progress: progress.Convert(log),
+	}
+	logger.Info("convert start", "filename", book.Filename, "format", book.Format)
+	results := conv.Run(ctx)
+	if err := ctx.Err(); err != nil {
+		logger.Info("convert cancelled", "filename", book.Filename)
+		return nil, err
+	}
+	if s.library != nil {
+		s.library.refresh()
+	}
+
+	volumes, chapters := countSections(book.SectionList)
+	result := convertResult{
+		Bookname: book.Bookname,
+		Author:   book.Author,
+		Volumes:  volumes,
+		Chapters: chapters,
+		Files:    []bookFile{},
+		Warnings: log.Warnings(),
+	}
+	var resources []mcp.Content
+	for _, r := range results {
+		if r.Err != nil {
+			logger.Error("convert failed", "error", r.Err, "filename", book.Filename, "format", r.Format)
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[r.Format] = r.Err.Error()
+			continue
 		}
-		if match != "" {
-			book.Match = match
+		for _, name := range r.Files {
+			file, resource, err := s.bookFile(ctx, r.Format, filepath.Join(filepath.Dir(book.Out), name))
+			if err != nil {
+				logger.Error("read book failed", "error", err, "filename", name)
+				continue
+			}
+			result.Files = append(result.Files, file)
+			resources = append(resources, resource)
 		}
+	}
+	bs, _ := json.MarshalIndent(result, "", "  ")
+	if len(result.Files) == 0 {
+		return mcp.NewToolResultError(string(bs)), nil
+	}
+	return &mcp.CallToolResult{
+		Content: append([]mcp.Content{mcp.NewTextContent(string(bs))}, resources...),
+	}, nil
+}
 
-		logger.Info("check start")
-		if err := core.Check(book, s.version); err != nil {
-			logger.Error("check failed", "error", err, "filename", filename)
-			return nil, err
+// bookFile 读取生成的电子书, 返回文件信息和嵌入结果中的资源
+//
+// 文件在配置目录或用户的工作目录下时资源使用kaf://books/的URI, 否则使用完整路径。
+func (s *ConverterService) bookFile(ctx context.Context, format, path string) (bookFile, mcp.Content, error) {
+	file := bookFile{Format: format, Path: displayPath(ctx, path)}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return file, nil, fmt.Errorf("读取文件出错: %w", err)
+	}
+	file.Size = int64(len(bs))
+	uri := file.Path
+	abs, _ := filepath.Abs(path)
+	if root, err := filepath.Abs(baseDir(ctx)); err == nil {
+		if rel, err := filepath.Rel(root, abs); err == nil && filepath.IsLocal(rel) {
+			file.URI, _ = resourceURI(rel)
+			uri = file.URI
 		}
+	}
+	return file, mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+		URI:      uri,
+		MIMEType: mimeTypes[format],
+		Blob:     base64.StdEncoding.EncodeToString(bs),
+	}), nil
+}
 
-		logger.Info("parse start")
-		if err := core.Parse(book); err != nil {
-			logger.Error("parse failed", "error", err, "filename", filename)
-			return nil, err
+// newBook 根据请求参数创建书籍并检查参数, 提示信息输出到日志
+//
+// 远程用户的文件名、封面、字体和输出文件都在用户的工作目录下。
+func (s *ConverterService) newBook(ctx context.Context, args map[string]any, params ...[]bookParam) (*model.Book, *utils.WarningCollector, error) {
+	filename, ok := args["filename"].(string)
+	if !ok || filename == "" {
+		return nil, nil, fmt.Errorf("filename is required")
+	}
+	book := model.Book{Filename: filename, Format: "epub"}
+	if err := applyParams(&book, args, params...); err != nil {
+		return nil, nil, err
+	}
+	model.SetDefault(&book)
+	defaultOut := book.Out == ""
+	if err := resolveBookPaths(ctx, &book); err != nil {
+		return nil, nil, err
+	}
+	// stdio模式下标准输出用于传输消息, 提示信息不能打印到标准输出
+	log := &utils.WarningCollector{Next: slogLogger{}}
+	book.Log = log
+	if err := core.Check(&book, s.version); err != nil {
+		return nil, nil, err
+	}
+	// 没有指定输出文件时Check使用书名, 同样要放在工作目录下
+	if defaultOut {
+		out, err := resolvePath(ctx, book.Out)
+		if err != nil {
+			return nil, nil, err
 		}
+		book.Out = out
+	}
+	return &book, log, nil
+}
 
-		conv := converter.Dispatcher{
-			Book: book,
+// resolveBookPaths 转换书籍参数中的文件路径
+func resolveBookPaths(ctx context.Context, book *model.Book) error {
+	paths := []*string{&book.Filename, &book.Font, &book.TitleFont, &book.Out}
+	switch book.Cover {
+	case "gen", "orly", "none":
+	default:
+		paths = append(paths, &book.Cover)
+	}
+	for _, path := range paths {
+		if *path == "" {
+			continue
 		}
-		logger.Info("convert start")
-		if err := conv.Convert(); err != nil {
-			logger.Error("convert failed", "error", err, "filename", filename)
-			return nil, err
+		resolved, err := resolvePath(ctx, *path)
+		if err != nil {
+			return err
 		}
+		*path = resolved
+	}
+	return nil
+}
 
-		bookfile := fmt.Sprintf("%s.epub", book.Out)
-		bookfile, _ = filepath.Abs(bookfile)
-		// 按文档示例返回结果
-		return mcp.NewToolResultResource(book.Bookname, mcp.BlobResourceContents{
-			URI: fmt.Sprintf("%s", bookfile),
-			// MIMEType: "application/epub+zip",
-			// Blob:     blob,
-		}), nil
-
-	})
+// mimeTypes 电子书格式对应的MIME类型
+var mimeTypes = map[string]string{
+	"epub": "application/epub+zip",
+	"mobi": "application/x-mobipocket-ebook",
+	"azw3": "application/vnd.amazon.ebook",
 }
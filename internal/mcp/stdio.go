@@ -0,0 +1,134 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// stdioSession stdio只有一个客户端, 只有一个会话
+type stdioSession struct {
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+}
+
+func (s *stdioSession) SessionID() string {
+	return "stdio"
+}
+
+func (s *stdioSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *stdioSession) Initialize() {
+	s.initialized.Store(true)
+}
+
+func (s *stdioSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+// ServeStdio 通过标准输入输出提供服务
+//
+// 和server.ServeStdio不同, 工具调用在单独的goroutine中执行, 转换时仍然可以收到客户端的取消通知,
+// 收到notifications/cancelled后取消对应请求的context, 并且不再返回结果。
+func ServeStdio(ctx context.Context, srv *server.MCPServer, in io.Reader, out io.Writer) error {
+	session := &stdioSession{notifications: make(chan mcp.JSONRPCNotification, 100)}
+	if err := srv.RegisterSession(ctx, session); err != nil {
+		return fmt.Errorf("注册会话失败: %w", err)
+	}
+	defer srv.UnregisterSession(ctx, session.SessionID())
+	ctx = srv.WithContext(ctx, session)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	write := func(msg any) {
+		bs, err := json.Marshal(msg)
+		if err != nil {
+			logger.Error("marshal message failed", "error", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := fmt.Fprintf(out, "%s\n", bs); err != nil {
+			logger.Error("write message failed", "error", err)
+		}
+	}
+	go func() {
+		for {
+			select {
+			case notification := <-session.notifications:
+				write(notification)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var requests sync.Map // 请求ID -> context.CancelFunc
+	srv.AddNotificationHandler("notifications/cancelled", func(ctx context.Context, notification mcp.JSONRPCNotification) {
+		id, _ := json.Marshal(notification.Params.AdditionalFields["requestId"])
+		logger.Info("request cancelled", "id", string(id), "reason", notification.Params.AdditionalFields["reason"])
+		if cancel, ok := requests.Load(string(id)); ok {
+			cancel.(context.CancelFunc)()
+		}
+	})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			handle(ctx, srv, line, &requests, &wg, write)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取请求失败: %w", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// handle 处理一条消息, 工具调用在goroutine中执行, 其他消息按顺序处理
+func handle(ctx context.Context, srv *server.MCPServer, line []byte, requests *sync.Map, wg *sync.WaitGroup, write func(any)) {
+	var message struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.Unmarshal(line, &message); err != nil || message.Method != string(mcp.MethodToolsCall) {
+		if response := srv.HandleMessage(ctx, line); response != nil {
+			write(response)
+		}
+		return
+	}
+
+	id := string(bytes.TrimSpace(message.ID))
+	reqCtx, cancel := context.WithCancel(ctx)
+	requests.Store(id, cancel)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer requests.Delete(id)
+		defer cancel()
+		response := srv.HandleMessage(reqCtx, line)
+		// 被取消的请求不返回结果
+		if response != nil && reqCtx.Err() == nil {
+			write(response)
+		}
+	}()
+}
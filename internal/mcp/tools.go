@@ -0,0 +1,357 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// filenameParam txt文件参数, 所有工具都使用
+func filenameParam() mcp.ToolOption {
+	return mcp.WithString("filename",
+		mcp.Required(),
+		mcp.Description("txt小说文件, 支持相对路径，相对路径默认会从配置目录读取小说文件; 可以用kaf_list_files查找"),
+		mcp.Pattern(`\.txt$`),
+	)
+}
+
+// registerPreview 识别章节并返回目录, 用来在转换前调整章节规则
+func (s *ConverterService) registerPreview(srv *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription("按转换时的参数识别txt的章节, 返回卷和章节的目录以及每章的字数, 不生成电子书; 用来在转换前检查章节规则是否合适"),
+		filenameParam(),
+		mcp.WithNumber("limit",
+			mcp.Description("最多返回的目录行数, 超过时只返回开头部分"),
+			mcp.DefaultNumber(200),
+			mcp.Min(1),
+		),
+	}
+	options = append(options, withParams(matchParams)...)
+	srv.AddTool(mcp.NewTool("kaf_preview", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger.Info("request received",
+			"tool", "kaf_preview",
+			"params", req.Params.Arguments,
+		)
+		book, err := s.parse(ctx, req.Params.Arguments, matchParams)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		limit := 200
+		if n, ok := req.Params.Arguments["limit"].(float64); ok && n >= 1 {
+			limit = int(n)
+		}
+
+		var sb strings.Builder
+		lines := 0
+		line := func(indent, title string, content string) {
+			if lines < limit {
+				fmt.Fprintf(&sb, "%s%s  (%d字)\n", indent, html.UnescapeString(title), core.Words(content))
+			}
+			lines++
+		}
+		for _, section := range book.SectionList {
+			line("", section.Title, section.Content)
+			for _, sub := range section.Sections {
+				line("    ", sub.Title, sub.Content)
+			}
+		}
+		if lines > limit {
+			fmt.Fprintf(&sb, "...省略%d行\n", lines-limit)
+		}
+		volumes, chapters := countSections(book.SectionList)
+		fmt.Fprintf(&sb, "\n书名: %s\n作者: %s\n共%d卷%d章\n", book.Bookname, book.Author, volumes, chapters)
+		return mcp.NewToolResultText(sb.String()), nil
+	})
+}
+
+// registerInspect 统计txt的编码、字数和章节识别情况
+func (s *ConverterService) registerInspect(srv *server.MCPServer) {
+	options := []mcp.ToolOption{
+		mcp.WithDescription("分析txt的编码、大小、段落数、字数、识别出的卷和章节数、每条章节规则匹配的标题数, 以及最长和最短的章节; 最长的章节可能漏掉了标题, 最短的章节可能把正文识别成了标题"),
+		filenameParam(),
+	}
+	options = append(options, withParams(matchParams)...)
+	srv.AddTool(mcp.NewTool("kaf_inspect", options...), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger.Info("request received",
+			"tool", "kaf_inspect",
+			"params", req.Params.Arguments,
+		)
+		book, err := s.parse(ctx, req.Params.Arguments, matchParams)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		result, err := core.Inspect(book)
+		if err != nil {
+			logger.Error("inspect failed", "error", err, "filename", book.Filename)
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if workspaceFrom(ctx) != nil {
+			result.Filename = displayPath(ctx, result.Filename)
+		}
+		bs, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(bs)), nil
+	})
+}
+
+// registerSuggestMatch 根据txt的内容建议章节标题的正则表达式
+func (s *ConverterService) registerSuggestMatch(srv *server.MCPServer) {
+	tool := mcp.NewTool("kaf_suggest_match",
+		mcp.WithDescription("根据txt的开头部分或一段样本建议匹配章节标题的正则表达式, 按匹配的行数从多到少排列; 结果可以作为kaf_convert、kaf_preview的match参数"),
+		mcp.WithString("filename",
+			mcp.Description("txt小说文件, 读取开头的1MB; 和sample二选一"),
+			mcp.Pattern(`\.txt$`),
+		),
+		mcp.WithString("sample",
+			mcp.Description("包含几个章节标题的文本样本; 和filename二选一"),
+		),
+		mcp.WithNumber("max",
+			mcp.Description("标题的最大字数, 超过的行不作为标题"),
+			mcp.DefaultNumber(35),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("最多返回的建议数"),
+			mcp.DefaultNumber(5),
+			mcp.Min(1),
+		),
+	)
+	srv.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger.Info("request received",
+			"tool", "kaf_suggest_match",
+			"params", req.Params.Arguments,
+		)
+		filename, _ := req.Params.Arguments["filename"].(string)
+		sample, _ := req.Params.Arguments["sample"].(string)
+		max, _ := req.Params.Arguments["max"].(float64)
+		limit := 5
+		if n, ok := req.Params.Arguments["limit"].(float64); ok && n >= 1 {
+			limit = int(n)
+		}
+
+		var suggestions []core.MatchSuggestion
+		switch {
+		case sample != "":
+			suggestions = core.SuggestMatch(sample, int(max))
+		case filename != "":
+			path, err := resolvePath(ctx, filename)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			suggestions, err = core.SuggestMatchFile(path, int(max))
+			if err != nil {
+				logger.Error("suggest failed", "error", err, "filename", filename)
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		default:
+			return mcp.NewToolResultError("filename和sample不能都为空"), nil
+		}
+		if len(suggestions) == 0 {
+			return mcp.NewToolResultText("没有找到像章节标题的行, 可以不填match使用自动识别, 或者提供包含章节标题的样本"), nil
+		}
+		if len(suggestions) > limit {
+			suggestions = suggestions[:limit]
+		}
+		bs, _ := json.MarshalIndent(suggestions, "", "  ")
+		return mcp.NewToolResultText(string(bs)), nil
+	})
+}
+
+// txtFile 配置目录或用户工作目录下的txt文件
+type txtFile struct {
+	Path     string    `json:"path"` // 相对配置目录或工作目录的路径, 可以直接作为filename参数
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// registerListFiles 列出配置目录(KAF_DIR)下的txt文件, 远程用户列出自己的工作目录
+func (s *ConverterService) registerListFiles(srv *server.MCPServer) {
+	tool := mcp.NewTool("kaf_list_files",
+		mcp.WithDescription("列出配置目录(KAF_DIR)下的txt文件, 远程连接时列出用户的工作目录, 返回的path可以直接作为其他工具的filename参数"),
+		mcp.WithString("dir",
+			mcp.Description("配置目录下的子目录, 为空时列出配置目录"),
+		),
+		mcp.WithBoolean("recursive",
+			mcp.Description("是否包含子目录中的文件"),
+			mcp.DefaultBool(false),
+		),
+	)
+	srv.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger.Info("request received",
+			"tool", "kaf_list_files",
+			"params", req.Params.Arguments,
+		)
+		dir, _ := req.Params.Arguments["dir"].(string)
+		recursive, _ := req.Params.Arguments["recursive"].(bool)
+		dir = filepath.Clean(dir)
+		if filepath.IsAbs(dir) || !filepath.IsLocal(dir) {
+			return mcp.NewToolResultError("dir只能是配置目录下的子目录"), nil
+		}
+
+		root := baseDir(ctx)
+		files := []txtFile{}
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != filepath.Join(root, dir) && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.EqualFold(filepath.Ext(path), ".txt") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, txtFile{Path: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			logger.Error("list files failed", "error", err, "dir", dir)
+			return mcp.NewToolResultError(fmt.Sprintf("读取目录失败: %s", dir)), nil
+		}
+		bs, _ := json.MarshalIndent(map[string]any{"dir": displayPath(ctx, filepath.Join(root, dir)), "files": files}, "", "  ")
+		return mcp.NewToolResultText(string(bs)), nil
+	})
+}
+
+// parse 根据请求参数识别章节
+func (s *ConverterService) parse(ctx context.Context, args map[string]any, params ...[]bookParam) (*model.Book, error) {
+	book, _, err := s.newBook(ctx, args, params...)
+	if err != nil {
+		logger.Error("check failed", "error", err, "params", args)
+		return nil, err
+	}
+	if err := core.Parse(book); err != nil {
+		logger.Error("parse failed", "error", err, "filename", book.Filename)
+		return nil, err
+	}
+	return book, nil
+}
+
+// countSections 统计卷数和章节数
+func countSections(sections []model.Section) (volumes, chapters int) {
+	for _, section := range sections {
+		if len(section.Sections) > 0 {
+			volumes++
+		} else {
+			chapters++
+		}
+		chapters += len(section.Sections)
+	}
+	return volumes, chapters
+}
+
+// slogLogger 把转换过程的提示信息输出到日志, 和utils.WarningCollector一起使用时同时记录警告
+type slogLogger struct{}
+
+func (slogLogger) Infof(format string, args ...any) {
+	logger.Info(fmt.Sprintf(format, args...))
+}
+
+func (slogLogger) Warnf(format string, args ...any) {
+	logger.Warn(fmt.Sprintf(format, args...))
+}
+
+// maxUploadSize 上传txt的最大字节数
+const maxUploadSize = 50 << 20
+
+// registerUpload 远程用户把txt上传到自己的工作目录, 之后用其他工具转换
+func (s *ConverterService) registerUpload(srv *server.MCPServer) {
+	tool := mcp.NewTool("kaf_upload",
+		mcp.WithDescription("把txt小说上传到服务器上用户的工作目录, 返回的path可以作为其他工具的filename参数; 文本用content, 非UTF-8编码的文件用content_base64上传原始内容"),
+		mcp.WithString("filename",
+			mcp.Required(),
+			mcp.Description("保存的文件名, 可以包含子目录"),
+			mcp.Pattern(`\.txt$`),
+		),
+		mcp.WithString("content",
+			mcp.Description("文件内容; 和content_base64二选一"),
+		),
+		mcp.WithString("content_base64",
+			mcp.Description("base64编码的文件内容; 和content二选一"),
+		),
+		mcp.WithBoolean("overwrite",
+			mcp.Description("文件已存在时是否覆盖"),
+			mcp.DefaultBool(false),
+		),
+	)
+	srv.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filename, _ := req.Params.Arguments["filename"].(string)
+		content, hasContent := req.Params.Arguments["content"].(string)
+		encoded, hasEncoded := req.Params.Arguments["content_base64"].(string)
+		overwrite, _ := req.Params.Arguments["overwrite"].(bool)
+		logger.Info("request received",
+			"tool", "kaf_upload",
+			"filename", filename,
+		)
+		if workspaceFrom(ctx) == nil {
+			return mcp.NewToolResultError("只有远程连接时可以上传文件"), nil
+		}
+		if !strings.EqualFold(filepath.Ext(filename), ".txt") {
+			return mcp.NewToolResultError("只能上传txt文件"), nil
+		}
+		path, err := resolvePath(ctx, filename)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var data []byte
+		switch {
+		case hasEncoded:
+			data, err = base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("content_base64解码失败: %s", err)), nil
+			}
+		case hasContent:
+			data = []byte(content)
+		default:
+			return mcp.NewToolResultError("content和content_base64不能都为空"), nil
+		}
+		if len(data) > maxUploadSize {
+			return mcp.NewToolResultError(fmt.Sprintf("文件不能超过%dMB", maxUploadSize>>20)), nil
+		}
+
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if !overwrite {
+			flag |= os.O_EXCL
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			logger.Error("upload failed", "error", err, "filename", filename)
+			return mcp.NewToolResultError("创建目录失败"), nil
+		}
+		f, err := os.OpenFile(path, flag, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			return mcp.NewToolResultError(fmt.Sprintf("文件已存在: %s, 可以设置overwrite覆盖", filename)), nil
+		}
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			logger.Error("upload failed", "error", err, "filename", filename)
+			return mcp.NewToolResultError("保存文件失败"), nil
+		}
+		bs, _ := json.MarshalIndent(txtFile{Path: displayPath(ctx, path), Size: int64(len(data)), Modified: time.Now()}, "", "  ")
+		return mcp.NewToolResultText(string(bs)), nil
+	})
+}
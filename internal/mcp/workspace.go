@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+type workspaceKey struct{}
+
+// workspace 远程用户的工作目录, 工具只能读写其中的文件
+type workspace struct {
+	UserID   uint
+	Username string
+	Dir      string // 绝对路径
+}
+
+func withWorkspace(ctx context.Context, ws *workspace) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, ws)
+}
+
+// workspaceFrom 返回请求所属用户的工作目录, 通过stdio使用时返回nil
+func workspaceFrom(ctx context.Context) *workspace {
+	ws, _ := ctx.Value(workspaceKey{}).(*workspace)
+	return ws
+}
+
+// baseDir 相对路径的基准目录, 远程用户为工作目录, 通过stdio使用时为当前目录(KAF_DIR)
+func baseDir(ctx context.Context) string {
+	if ws := workspaceFrom(ctx); ws != nil {
+		return ws.Dir
+	}
+	return "."
+}
+
+// resolvePath 把工具参数中的路径转换为实际路径
+//
+// 通过stdio使用时原样返回; 远程用户只能使用工作目录下的相对路径。
+func resolvePath(ctx context.Context, name string) (string, error) {
+	ws := workspaceFrom(ctx)
+	if ws == nil {
+		return name, nil
+	}
+	path := filepath.FromSlash(name)
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("只能使用工作目录下的相对路径: %s", name)
+	}
+	return filepath.Join(ws.Dir, path), nil
+}
+
+// displayPath 返回给客户端的文件路径, 远程用户为相对工作目录的路径, 不暴露服务器的目录结构
+func displayPath(ctx context.Context, path string) string {
+	ws := workspaceFrom(ctx)
+	abs, _ := filepath.Abs(path)
+	if ws == nil {
+		return abs
+	}
+	if rel, err := filepath.Rel(ws.Dir, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(path)
+}
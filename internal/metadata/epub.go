@@ -0,0 +1,507 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+var (
+	// ErrInvalidEpub 不是有效的epub文件
+	ErrInvalidEpub = errors.New("无效的epub文件")
+	// ErrUnsupportedImage 封面图片格式无法识别
+	ErrUnsupportedImage = errors.New("不支持的封面格式")
+)
+
+var (
+	metaReg = regexp.MustCompile(`(?s)\n?[ \t]*<meta\b([^>]*?)(?:/>|>(.*?)</meta>)`)
+	attrReg = regexp.MustCompile(`([\w:-]+)\s*=\s*"([^"]*)"`)
+	idReg   = regexp.MustCompile(`\sid\s*=\s*["']([^"']*)["']`)
+)
+
+type container struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Version  string `xml:"version,attr"`
+	Metadata struct {
+		Titles       []string `xml:"title"`
+		Creators     []string `xml:"creator"`
+		Descriptions []string `xml:"description"`
+		Languages    []string `xml:"language"`
+		Dates        []string `xml:"date"`
+		Metas        []struct {
+			ID       string `xml:"id,attr"`
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			Refines  string `xml:"refines,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []manifestItem `xml:"manifest>item"`
+}
+
+type manifestItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// epubFile 打开的epub
+type epubFile struct {
+	zr      *zip.Reader
+	opfPath string
+	opf     []byte
+	pkg     opfPackage
+}
+
+func openEpub(data []byte) (*epubFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEpub, err)
+	}
+	e := &epubFile{zr: zr}
+	raw, err := e.read("META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var c container
+	if err := xml.Unmarshal(raw, &c); err != nil || len(c.Rootfiles) == 0 {
+		return nil, fmt.Errorf("%w: container.xml中没有OPF文件", ErrInvalidEpub)
+	}
+	e.opfPath = c.Rootfiles[0].FullPath
+	if e.opf, err = e.read(e.opfPath); err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(e.opf, &e.pkg); err != nil {
+		return nil, fmt.Errorf("%w: 解析OPF失败: %v", ErrInvalidEpub, err)
+	}
+	return e, nil
+}
+
+func (e *epubFile) read(name string) ([]byte, error) {
+	f, err := e.zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 缺少%s", ErrInvalidEpub, name)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// series 读取EPUB3或calibre格式的系列信息
+func (e *epubFile) series() (string, int) {
+	metas := e.pkg.Metadata.Metas
+	for _, m := range metas {
+		if m.Property != "belongs-to-collection" {
+			continue
+		}
+		var index int
+		for _, r := range metas {
+			if r.Refines == "#"+m.ID && r.Property == "group-position" {
+				index, _ = strconv.Atoi(strings.TrimSpace(r.Value))
+			}
+		}
+		return strings.TrimSpace(m.Value), index
+	}
+	var name string
+	var index int
+	for _, m := range metas {
+		switch m.Name {
+		case "calibre:series":
+			name = m.Content
+		case "calibre:series_index":
+			f, _ := strconv.ParseFloat(m.Content, 64)
+			index = int(f)
+		}
+	}
+	return name, index
+}
+
+// coverItem 查找封面图片, 依次查找EPUB3的cover-image和EPUB2的cover元数据
+func (e *epubFile) coverItem() (manifestItem, bool) {
+	for _, item := range e.pkg.Manifest {
+		if slices.Contains(strings.Fields(item.Properties), "cover-image") {
+			return item, true
+		}
+	}
+	for _, m := range e.pkg.Metadata.Metas {
+		if m.Name != "cover" {
+			continue
+		}
+		for _, item := range e.pkg.Manifest {
+			if item.ID == m.Content {
+				return item, true
+			}
+		}
+	}
+	return manifestItem{}, false
+}
+
+func readEpub(data []byte) (*model.Book, error) {
+	e, err := openEpub(data)
+	if err != nil {
+		return nil, err
+	}
+	m := e.pkg.Metadata
+	book := &model.Book{
+		Bookname:    first(m.Titles),
+		Author:      JoinAuthors(m.Creators),
+		Description: first(m.Descriptions),
+		Lang:        first(m.Languages),
+		Date:        first(m.Dates),
+	}
+	book.Series, book.SeriesIndex = e.series()
+	return book, nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+// updateEpub 修改OPF中的元数据, 其它文件原样复制
+func updateEpub(data []byte, meta *model.Book, cover []byte) ([]byte, error) {
+	e, err := openEpub(data)
+	if err != nil {
+		return nil, err
+	}
+	opf := string(e.opf)
+	if meta.Bookname != "" {
+		opf = setElement(opf, "dc:title", meta.Bookname)
+	}
+	if authors := Authors(meta.Author); len(authors) > 0 {
+		opf = setCreators(opf, authors)
+	}
+	if meta.Description != "" {
+		opf = setElement(opf, "dc:description", meta.Description)
+	}
+	if meta.Lang != "" {
+		opf = setElement(opf, "dc:language", meta.Lang)
+	}
+	if meta.Date != "" {
+		opf = setElement(opf, "dc:date", meta.Date)
+	}
+	if meta.Series != "" {
+		name, index := e.series()
+		if meta.SeriesIndex > 0 {
+			index = meta.SeriesIndex
+		} else if name == "" || index == 0 {
+			index = 1
+		}
+		opf = setSeries(opf, meta.Series, index)
+	}
+
+	// 替换或添加的文件
+	added := make(map[string][]byte)
+	if len(cover) > 0 {
+		if opf, err = e.setCover(opf, cover, added); err != nil {
+			return nil, err
+		}
+	}
+	if strings.Contains(opf, `property="dcterms:modified"`) {
+		opf = removeMetas(opf, func(attrs map[string]string) bool {
+			return attrs["property"] == "dcterms:modified"
+		})
+		// 设置了SOURCE_DATE_EPOCH时使用指定的时间, 可重复生成的书修改后仍可重复生成
+		modified, ok := converter.SourceDateEpochZip()
+		if !ok {
+			modified = time.Now()
+		}
+		opf = appendElement(opf, "metadata", fmt.Sprintf(`<meta property="dcterms:modified">%s</meta>`, modified.UTC().Format("2006-01-02T15:04:05Z")))
+	}
+	added[e.opfPath] = []byte(opf)
+	return e.rewrite(added)
+}
+
+// setCover 替换封面图片, 没有封面时添加
+//
+// 替换时保持原有的文件名和格式, 不需要修改引用封面的页面
+func (e *epubFile) setCover(opf string, cover []byte, files map[string][]byte) (string, error) {
+	item, ok := e.coverItem()
+	if ok {
+		data, err := convertImage(cover, item.MediaType)
+		if err != nil {
+			return opf, err
+		}
+		files[path.Join(path.Dir(e.opfPath), item.Href)] = data
+		return opf, nil
+	}
+
+	mediaType := http.DetectContentType(cover)
+	ext, ok := imageExts[mediaType]
+	if !ok {
+		return opf, fmt.Errorf("%w: %s", ErrUnsupportedImage, mediaType)
+	}
+	href := "images/cover" + ext
+	for i := 1; ; i++ {
+		if _, err := e.zr.Open(path.Join(path.Dir(e.opfPath), href)); err != nil {
+			break
+		}
+		href = fmt.Sprintf("images/cover-%d%s", i, ext)
+	}
+	files[path.Join(path.Dir(e.opfPath), href)] = cover
+	properties := ""
+	if strings.HasPrefix(e.pkg.Version, "3") {
+		properties = ` properties="cover-image"`
+	}
+	id := newID(usedIDs(opf), "kaf-cover-image", 0)
+	opf = appendElement(opf, "manifest", fmt.Sprintf(`<item id="%s" href="%s" media-type="%s"%s></item>`, id, href, mediaType, properties))
+	opf = appendElement(opf, "metadata", fmt.Sprintf(`<meta name="cover" content="%s"/>`, id))
+	return opf, nil
+}
+
+var imageExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// convertImage 把图片转换成mediaType指定的格式
+func convertImage(data []byte, mediaType string) ([]byte, error) {
+	if http.DetectContentType(data) == mediaType {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	var buf bytes.Buffer
+	switch mediaType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("%w: 无法转换为%s", ErrUnsupportedImage, mediaType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("编码封面失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// rewrite 重新打包epub, files中的文件替换原有内容或添加到最后
+func (e *epubFile) rewrite(files map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range e.zr.File {
+		data, ok := files[f.Name]
+		if !ok {
+			if err := copyZipFile(zw, f); err != nil {
+				return nil, fmt.Errorf("写入epub失败: %w", err)
+			}
+			continue
+		}
+		delete(files, f.Name)
+		// 沿用原有的DOS时间, 读取时由DOS时间换算的Modified不准确
+		header := &zip.FileHeader{Name: f.Name, Method: f.Method, ModifiedDate: f.ModifiedDate, ModifiedTime: f.ModifiedTime}
+		if err := writeZipFile(zw, header, data); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := writeZipFile(zw, &zip.FileHeader{Name: name, Method: zip.Deflate}, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("写入epub失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeZipFile(zw *zip.Writer, header *zip.FileHeader, data []byte) error {
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("写入epub失败: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("写入epub失败: %w", err)
+	}
+	return nil
+}
+
+// copyZipFile 复制压缩后的数据, 不重新压缩
+func copyZipFile(zw *zip.Writer, f *zip.File) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateRaw(&f.FileHeader)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// elementReg 匹配元素及其前面的缩进
+func elementReg(tag string) *regexp.Regexp {
+	return regexp.MustCompile(`(?s)\n?[ \t]*<` + regexp.QuoteMeta(tag) + `\b([^>]*)>.*?</` + regexp.QuoteMeta(tag) + `>`)
+}
+
+// setElement 修改第一个tag元素的内容并删除其余的, 没有时添加
+func setElement(opf, tag, value string) string {
+	reg := elementReg(tag)
+	var found bool
+	opf = reg.ReplaceAllStringFunc(opf, func(m string) string {
+		if found {
+			return ""
+		}
+		found = true
+		attrs := reg.FindStringSubmatch(m)[1]
+		indent := m[:strings.Index(m, "<")]
+		return fmt.Sprintf("%s<%s%s>%s</%s>", indent, tag, attrs, html.EscapeString(value), tag)
+	})
+	if !found {
+		opf = appendElement(opf, "metadata", fmt.Sprintf("<%s>%s</%s>", tag, html.EscapeString(value), tag))
+	}
+	return opf
+}
+
+// usedIDs opf中已使用的id
+func usedIDs(opf string) map[string]bool {
+	used := make(map[string]bool)
+	for _, m := range idReg.FindAllStringSubmatch(opf, -1) {
+		used[m[1]] = true
+	}
+	return used
+}
+
+// newID 生成没有使用过的id, n为0时先尝试prefix, 之后依次尝试prefix加上序号, 生成的id记为已使用
+func newID(used map[string]bool, prefix string, n int) string {
+	id := prefix
+	if n > 0 {
+		id += strconv.Itoa(n)
+	}
+	for used[id] {
+		n++
+		id = prefix + strconv.Itoa(n)
+	}
+	used[id] = true
+	return id
+}
+
+// setCreators 替换所有作者, 第一个作者沿用原有的属性, 删除其余作者的附加元数据
+func setCreators(opf string, authors []string) string {
+	reg := elementReg("dc:creator")
+	used := usedIDs(opf)
+	var removed []string
+	var found bool
+	opf = reg.ReplaceAllStringFunc(opf, func(m string) string {
+		attrs := reg.FindStringSubmatch(m)[1]
+		if found {
+			if id := attrValue(attrs, "id"); id != "" {
+				removed = append(removed, "#"+id)
+			}
+			return ""
+		}
+		found = true
+		indent := m[:strings.Index(m, "<")]
+		creators := []string{fmt.Sprintf("%s<dc:creator%s>%s</dc:creator>", indent, attrs, html.EscapeString(authors[0]))}
+		for _, author := range authors[1:] {
+			creators = append(creators, fmt.Sprintf("%s<dc:creator id=\"%s\">%s</dc:creator>", indent, newID(used, "creator", 2), html.EscapeString(author)))
+		}
+		return strings.Join(creators, "")
+	})
+	if !found {
+		for _, author := range authors {
+			opf = appendElement(opf, "metadata", fmt.Sprintf("<dc:creator>%s</dc:creator>", html.EscapeString(author)))
+		}
+	}
+	return removeMetas(opf, func(attrs map[string]string) bool {
+		return slices.Contains(removed, attrs["refines"])
+	})
+}
+
+// setSeries 替换系列信息, 同时写入EPUB3和calibre的格式
+func setSeries(opf, series string, index int) string {
+	var ids []string
+	opf = removeMetas(opf, func(attrs map[string]string) bool {
+		if attrs["property"] == "belongs-to-collection" {
+			ids = append(ids, "#"+attrs["id"])
+			return true
+		}
+		return attrs["name"] == "calibre:series" || attrs["name"] == "calibre:series_index"
+	})
+	opf = removeMetas(opf, func(attrs map[string]string) bool {
+		return slices.Contains(ids, attrs["refines"])
+	})
+	id := newID(usedIDs(opf), "series", 0)
+	series = html.EscapeString(series)
+	for _, meta := range []string{
+		fmt.Sprintf(`<meta property="belongs-to-collection" id="%s">%s</meta>`, id, series),
+		fmt.Sprintf(`<meta refines="#%s" property="collection-type">series</meta>`, id),
+		fmt.Sprintf(`<meta refines="#%s" property="group-position">%d</meta>`, id, index),
+		fmt.Sprintf(`<meta name="calibre:series" content="%s"/>`, series),
+		fmt.Sprintf(`<meta name="calibre:series_index" content="%d"/>`, index),
+	} {
+		opf = appendElement(opf, "metadata", meta)
+	}
+	return opf
+}
+
+// removeMetas 删除match返回true的meta元素
+func removeMetas(opf string, match func(attrs map[string]string) bool) string {
+	return metaReg.ReplaceAllStringFunc(opf, func(m string) string {
+		attrs := make(map[string]string)
+		for _, a := range attrReg.FindAllStringSubmatch(metaReg.FindStringSubmatch(m)[1], -1) {
+			attrs[a[1]] = html.UnescapeString(a[2])
+		}
+		if match(attrs) {
+			return ""
+		}
+		return m
+	})
+}
+
+// appendElement 在parent的最后添加一个元素, 缩进和前一个元素一致
+func appendElement(opf, parent, element string) string {
+	end := strings.LastIndex(opf, "</"+parent+">")
+	if end < 0 {
+		return opf
+	}
+	last := len(strings.TrimRight(opf[:end], " \t\r\n"))
+	indent := "\n    "
+	if start := strings.LastIndex(opf[:last], "\n"); start >= 0 {
+		if i := strings.Index(opf[start:last], "<"); i > 0 {
+			indent = opf[start : start+i]
+		}
+	}
+	return opf[:last] + indent + element + opf[last:]
+}
+
+func attrValue(attrs, name string) string {
+	for _, a := range attrReg.FindAllStringSubmatch(attrs, -1) {
+		if a[1] == name {
+			return a[2]
+		}
+	}
+	return ""
+}
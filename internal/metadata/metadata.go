@@ -0,0 +1,166 @@
+// Package metadata 读取和修改已生成的epub、azw3和mobi文件的元数据
+//
+// 元数据使用和model.Book相同的字段: 书名、作者、系列、简介、封面、语言和出版日期,
+// 修改时为空的字段保持不变, 不需要从txt重新转换。多个作者用&分隔。
+// azw3和mobi没有系列字段, 修改时忽略系列。
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/kf8"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// ErrUnsupported 不支持的文件格式
+var ErrUnsupported = errors.New("不支持的文件格式, 只支持epub、azw3和mobi")
+
+// authorSeparator 多个作者之间的分隔符, 和calibre一致
+const authorSeparator = "&"
+
+// Authors 拆分用&分隔的多个作者
+func Authors(author string) []string {
+	var authors []string
+	for _, a := range strings.Split(author, authorSeparator) {
+		if a = strings.TrimSpace(a); a != "" {
+			authors = append(authors, a)
+		}
+	}
+	return authors
+}
+
+// JoinAuthors 合并多个作者
+func JoinAuthors(authors []string) string {
+	return strings.Join(authors, " "+authorSeparator+" ")
+}
+
+// Format 按扩展名判断书籍格式
+func Format(path string) (string, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	switch format {
+	case "epub", "azw3", "mobi":
+		return format, nil
+	}
+	return "", ErrUnsupported
+}
+
+// Read 读取书籍的元数据, 返回的Book只填写元数据字段
+func Read(path string) (*model.Book, error) {
+	format, err := Format(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	var book *model.Book
+	if format == "epub" {
+		book, err = readEpub(data)
+	} else {
+		book, err = readMobi(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	book.Filename = path
+	book.Format = format
+	return book, nil
+}
+
+// Update 按meta修改书籍的元数据并覆盖原文件
+//
+// meta.Cover为封面图片的路径, 会替换原有的封面。
+func Update(path string, meta *model.Book) error {
+	format, err := Format(path)
+	if err != nil {
+		return err
+	}
+	if meta.Date != "" {
+		if _, err := time.Parse("2006-01-02", meta.Date); err != nil {
+			return fmt.Errorf("出版日期格式错误, 应为2006-01-02: %s", meta.Date)
+		}
+	}
+	if meta.SeriesIndex < 0 {
+		return fmt.Errorf("系列序号不能小于0: %d", meta.SeriesIndex)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	var cover []byte
+	if meta.Cover != "" {
+		if cover, err = os.ReadFile(meta.Cover); err != nil {
+			return fmt.Errorf("读取封面失败: %w", err)
+		}
+	}
+	if format == "epub" {
+		data, err = updateEpub(data, meta, cover)
+	} else {
+		data, err = updateMobi(data, meta, cover)
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, data)
+}
+
+func readMobi(data []byte) (*model.Book, error) {
+	meta, err := kf8.ReadMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Book{
+		Bookname:    meta.Title,
+		Author:      JoinAuthors(meta.Authors),
+		Description: meta.Description,
+		Lang:        meta.Language,
+		Date:        meta.PublishDate,
+	}, nil
+}
+
+func updateMobi(data []byte, meta *model.Book, cover []byte) ([]byte, error) {
+	data, err := kf8.UpdateMetadata(data, kf8.Metadata{
+		Title:       meta.Bookname,
+		Authors:     Authors(meta.Author),
+		Description: meta.Description,
+		PublishDate: meta.Date,
+		Language:    meta.Lang,
+	}, cover)
+	if err != nil {
+		return nil, fmt.Errorf("修改元数据失败: %w", err)
+	}
+	return data, nil
+}
+
+// replaceFile 先写入同目录的临时文件再替换, 写入失败时不会损坏原文件
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".kaf-*"+filepath.Ext(path))
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	if err := os.Chmod(f.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package model
 
 import (
 	"errors"
-	"fmt"
 	"regexp"
 
 	"github.com/Deali-Axy/ebook-generator/internal/utils"
@@ -31,10 +30,20 @@ func NewBookSimple(filename string) (*Book, error) {
 	return &book, nil
 }
 
+// 分册方式
+const (
+	SplitNone     = ""         // 不分册
+	SplitSections = "sections" // 按章节数分册
+	SplitSize     = "size"     // 按正文大小分册
+	SplitVolume   = "volume"   // 按卷分册
+	SplitRange    = "range"    // 按章节范围分册
+)
+
 type Book struct {
 	Filename         string    // 目录
 	Bookname         string    // 书名
 	Author           string    // 作者
+	Description      string    // 简介
 	SectionList      []Section // 章节
 	Match            string    // 正则
 	VolumeMatch      string    // 卷匹配规则
@@ -43,22 +52,39 @@ type Book struct {
 	Indent           uint      // 段落缩进字段
 	Align            string    // 标题对齐方式
 	UnknowTitle      string    // 未知章节名称
+	TitleTemplate    string    // 章节标题模板, 如"第{n:cn}章 {title}", 为空时保留原标题
+	VolumeTemplate   string    // 卷标题模板, 如"第{n:cn}卷 {title}"
+	Renumber         string    // 重新编号: 空为保留原编号, volume每卷从1开始, global全书连续编号
+	TitleJunk        string    // 整理标题时去掉的标题末尾内容(正则), 为空时使用默认规则, false为不去掉
 	Cover            string    // 封面图片
 	CoverOrlyColor   string    // 生成封面图片的颜色
 	CoverOrlyIdx     int       // 生成封面图片的动物
 	Font             string    // 嵌入字体
+	TitleFont        string    // 标题嵌入字体
 	Bottom           string    // 段阿落间距
 	LineHeight       string    // 行高
 	Tips             bool      // 是否添加教程文本
 	Lang             string    // 设置语言
 	Out              string    // 输出文件名
 	Format           string    // 书籍格式
+	MobiKF8          bool      // mobi格式生成MOBI7+KF8双格式文件, 不使用kindlegen
+	Split            string    // 分册方式: 空为不分册, sections按章节数、size按大小、volume按卷、range按章节范围
+	SplitCount       int       // 按章节数分册时每册的章节数, 按卷分册时每册的卷数
+	SplitSize        int       // 按大小分册时每册正文的最大KB数
+	SplitRanges      string    // 按章节范围分册时的范围, 如1-500,501-1000
+	Reproducible     bool      // 可重复生成, ID由内容计算, 时间取自SOURCE_DATE_EPOCH或Date, 相同输入生成相同文件
+	Date             string    // 出版日期, 格式为2006-01-02
+	EpubCheck        bool      // 生成epub后检查是否符合规范, 不符合时生成失败
+	Series           string    // 分册所属的系列, 即原书名
+	SeriesIndex      int       // 分册在系列中的序号, 从1开始
+	SeriesTotal      int       // 系列的分册数量
 	Decoder          *encoding.Decoder
 	PageStylesFile   string
 	Reg              *regexp.Regexp
 	VolumeReg        *regexp.Regexp
 	ExclusionReg     *regexp.Regexp // 动态生成的正则，用于排除无效标题
 	Version          string
+	Log              utils.Logger `json:"-"` // 提示信息的输出, 为空时打印到标准输出
 }
 
 type Section struct {
@@ -92,21 +118,30 @@ func SetDefault(book *Book) {
 }
 
 func (book *Book) ToString() {
-	fmt.Println("转换信息:")
-	fmt.Println("软件版本:", book.Version)
-	fmt.Println("文件名:\t", book.Filename)
-	fmt.Println("书籍书名:", book.Bookname)
-	fmt.Println("书籍作者:", book.Author)
+	log := book.Logger()
+	log.Infof("转换信息:")
+	log.Infof("软件版本: %s", book.Version)
+	log.Infof("文件名:\t %s", book.Filename)
+	log.Infof("书籍书名: %s", book.Bookname)
+	log.Infof("书籍作者: %s", book.Author)
 	if book.Cover != "" {
-		fmt.Println("书籍封面:", book.Cover)
+		log.Infof("书籍封面: %s", book.Cover)
 	}
-	fmt.Println("书籍语言:", book.Lang)
+	log.Infof("书籍语言: %s", book.Lang)
 	if book.Match == DefaultMatchTips {
-		fmt.Println("匹配条件:", "自动匹配")
+		log.Infof("匹配条件: %s", "自动匹配")
 	} else {
-		fmt.Println("匹配条件:", book.Match)
+		log.Infof("匹配条件: %s", book.Match)
+	}
+	log.Infof("卷匹配条件: %s", book.VolumeMatch)
+	log.Infof("转换格式: %s", book.Format)
+	log.Infof("")
+}
+
+// Logger 转换过程中输出提示信息的Logger, 没有设置Log时打印到标准输出
+func (book *Book) Logger() utils.Logger {
+	if book.Log != nil {
+		return book.Log
 	}
-	fmt.Println("卷匹配条件:", book.VolumeMatch)
-	fmt.Println("转换格式:", book.Format)
-	fmt.Println()
+	return utils.StdoutLogger
 }
@@ -0,0 +1,30 @@
+package model
+
+import "html"
+
+// Merge 把多本书合并到book中, 每本书为一卷, 卷名为书名
+//
+// 卷只有一层, 原书中的卷展开为这一卷中的章节, 后面跟着卷里的章节; 原书的制作说明会被去掉。
+func (book *Book) Merge(books ...*Book) {
+	for _, source := range books {
+		volume := Section{Title: html.EscapeString(source.Bookname)}
+		for _, section := range source.SectionList {
+			if section.Content == Tutorial {
+				continue
+			}
+			volume.Sections = append(volume.Sections, Section{Title: section.Title, Content: section.Content})
+			volume.Sections = append(volume.Sections, section.Sections...)
+		}
+		book.SectionList = append(book.SectionList, volume)
+	}
+}
+
+// AddTips 在开头和结尾添加本软件的制作说明
+func (book *Book) AddTips() {
+	tuorialSection := Section{
+		Title:   "制作说明",
+		Content: Tutorial,
+	}
+	book.SectionList = append([]Section{tuorialSection}, book.SectionList...)
+	book.SectionList = append(book.SectionList, tuorialSection)
+}
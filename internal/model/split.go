@@ -0,0 +1,263 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// ChapterRange 章节范围, 从1开始, End为0表示到最后一章
+type ChapterRange struct {
+	Start int
+	End   int
+}
+
+// Contains 第n章是否在范围内
+func (r ChapterRange) Contains(n int) bool {
+	return n >= r.Start && (r.End == 0 || n <= r.End)
+}
+
+// ParseRanges 解析章节范围, 如"1-500,501-1000,1001-", 每个范围为一册
+func ParseRanges(s string) ([]ChapterRange, error) {
+	var ranges []ChapterRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		start, end, found := strings.Cut(part, "-")
+		var r ChapterRange
+		var err error
+		if r.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil || r.Start < 1 {
+			return nil, fmt.Errorf("章节范围格式错误: %s", part)
+		}
+		switch {
+		case !found:
+			r.End = r.Start
+		case strings.TrimSpace(end) != "":
+			if r.End, err = strconv.Atoi(strings.TrimSpace(end)); err != nil || r.End < r.Start {
+				return nil, fmt.Errorf("章节范围格式错误: %s", part)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("章节范围不能为空")
+	}
+	return ranges, nil
+}
+
+// SplitBySections 每count章为一册, 卷本身也算一章
+func (book *Book) SplitBySections(count int) []*Book {
+	return book.parts(pack(book.SectionList, sectionWeight, count))
+}
+
+// SplitBySize 按正文大小分册, 每册不超过kb
+func (book *Book) SplitBySize(kb int) []*Book {
+	return book.parts(pack(book.SectionList, sizeWeight, kb*1024))
+}
+
+// SplitByVolume 每count卷为一册, 卷之前的章节放在第一册
+func (book *Book) SplitByVolume(count int) []*Book {
+	return book.parts(splitVolumes(book.SectionList, count))
+}
+
+// SplitByRanges 每个章节范围为一册
+//
+// 章节按顺序从1编号, 卷和制作说明不占编号。一卷被拆到多册时每册都保留卷标题,
+// 卷的正文放在包含卷第一章的那一册。开头和结尾的制作说明分别放在第一册和最后一册。
+func (book *Book) SplitByRanges(ranges []ChapterRange) []*Book {
+	groups := make([][]Section, len(ranges))
+	var leading, trailing []Section
+	var n int
+	for _, section := range book.SectionList {
+		if section.Content == Tutorial {
+			if n == 0 {
+				leading = append(leading, section)
+			} else {
+				trailing = append(trailing, section)
+			}
+			continue
+		}
+		if len(section.Sections) == 0 {
+			n++
+			for i, r := range ranges {
+				if r.Contains(n) {
+					groups[i] = append(groups[i], section)
+				}
+			}
+			continue
+		}
+		first := n + 1
+		for i, r := range ranges {
+			volume := Section{Title: section.Title}
+			if r.Contains(first) {
+				volume.Content = section.Content
+			}
+			for j, sub := range section.Sections {
+				if r.Contains(first + j) {
+					volume.Sections = append(volume.Sections, sub)
+				}
+			}
+			if len(volume.Sections) > 0 {
+				groups[i] = append(groups[i], volume)
+			}
+		}
+		n += len(section.Sections)
+	}
+
+	var result [][]Section
+	for _, group := range groups {
+		if len(group) > 0 {
+			result = append(result, group)
+		}
+	}
+	if len(result) > 0 {
+		result[0] = append(leading, result[0]...)
+		result[len(result)-1] = append(result[len(result)-1], trailing...)
+	}
+	return book.parts(result)
+}
+
+// parts 按分组生成每一册
+//
+// 只有一组时返回原书籍; 分册时书名为"书名 卷三–卷五", 文件名加上"_序号"后缀,
+// 并设置Series和SeriesIndex把各册关联为一个系列。
+func (book *Book) parts(groups [][]Section) []*Book {
+	if len(groups) <= 1 {
+		return []*Book{book}
+	}
+	parts := make([]*Book, len(groups))
+	for i, sections := range groups {
+		part := *book
+		part.SectionList = sections
+		part.Bookname = partTitle(book.Bookname, sections, book.SectionList, i+1)
+		part.Out = fmt.Sprintf("%s_%d", book.Out, i+1)
+		part.Series = book.Bookname
+		part.SeriesIndex = i + 1
+		part.SeriesTotal = len(groups)
+		parts[i] = &part
+	}
+	return parts
+}
+
+// sectionWeight 章节数, 卷本身也算一章
+func sectionWeight(section Section) int {
+	return SectionCount([]Section{section})
+}
+
+// sizeWeight 标题和正文的字节数
+func sizeWeight(section Section) int {
+	size := len(section.Title) + len(section.Content)
+	for _, sub := range section.Sections {
+		size += sizeWeight(sub)
+	}
+	return size
+}
+
+// pack 依次把章节放入分册, 放不下时开始新的一册; 单独一卷就超出限制时, 把卷拆开放到多册,
+// 每一册都保留卷标题
+func pack(sections []Section, weight func(Section) int, limit int) [][]Section {
+	var groups [][]Section
+	var current []Section
+	var size int
+	add := func(section Section) {
+		w := weight(section)
+		if len(current) > 0 && size+w > limit {
+			groups = append(groups, current)
+			current, size = nil, 0
+		}
+		current = append(current, section)
+		size += w
+	}
+	for _, section := range sections {
+		if len(section.Sections) == 0 || weight(section) <= limit {
+			add(section)
+			continue
+		}
+		volume := section
+		volume.Sections = nil
+		// 第一部分先填满当前分册的剩余空间
+		room := limit - size
+		for _, sub := range section.Sections {
+			if len(volume.Sections) > 0 && weight(volume)+weight(sub) > room {
+				add(volume)
+				// 续卷只保留标题
+				volume = Section{Title: section.Title}
+				room = limit
+			}
+			volume.Sections = append(volume.Sections, sub)
+		}
+		add(volume)
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+// splitVolumes 每count卷为一册, 卷之前的章节放在第一册
+func splitVolumes(sections []Section, count int) [][]Section {
+	var groups [][]Section
+	var current []Section
+	var volumes int
+	for _, section := range sections {
+		if len(section.Sections) > 0 {
+			if volumes == count {
+				groups = append(groups, current)
+				current, volumes = nil, 0
+			}
+			volumes++
+		}
+		current = append(current, section)
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	return groups
+}
+
+// partTitle 用分册的第一章和最后一章生成书名, 如"书名 卷三–卷五", all为原书的章节
+func partTitle(bookname string, sections, all []Section, index int) string {
+	var chapters []Section
+	for _, section := range sections {
+		// 跳过教程说明
+		if section.Content == Tutorial {
+			continue
+		}
+		chapters = append(chapters, section)
+	}
+	if len(chapters) == 0 {
+		return fmt.Sprintf("%s %d", bookname, index)
+	}
+	if len(chapters) == 1 {
+		// 一卷被拆到多册时, 加上这一册的章节范围
+		title := fmt.Sprintf("%s %s", bookname, html.UnescapeString(chapters[0].Title))
+		if subs := chapters[0].Sections; len(subs) > 1 && partialVolume(all, chapters[0]) {
+			title += fmt.Sprintf(" (%s–%s)", html.UnescapeString(subs[0].Title), html.UnescapeString(subs[len(subs)-1].Title))
+		}
+		return title
+	}
+	first, last := chapters[0].Title, chapters[len(chapters)-1].Title
+	return fmt.Sprintf("%s %s–%s", bookname, html.UnescapeString(first), html.UnescapeString(last))
+}
+
+// partialVolume 分册中的卷是否只包含原卷的一部分章节
+//
+// 卷名可能重复(如多个"番外"), 按卷的第一章找到原书中对应的卷。
+func partialVolume(all []Section, volume Section) bool {
+	first := volume.Sections[0]
+	for _, section := range all {
+		if section.Title != volume.Title {
+			continue
+		}
+		for _, sub := range section.Sections {
+			if sub.Title == first.Title && sub.Content == first.Content {
+				return len(volume.Sections) != len(section.Sections)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// 重新编号方式
+const (
+	RenumberNone   = ""       // 保留原来的编号
+	RenumberVolume = "volume" // 每卷的章节从1开始编号
+	RenumberGlobal = "global" // 全书的章节连续编号
+)
+
+// DefaultTitleJunk 整理标题时默认去掉的标题末尾内容, 如"（求月票）"、"【加更】"和多余的标点
+const DefaultTitleJunk = `[(（【\[][^)）】\]]*(求|票|加更|爆更|补更|收藏|推荐|订阅|打赏|盟主)[^)）】\]]*[)）】\]]$|[\s,，。~～_]+$`
+
+var (
+	cnTitleReg   = regexp.MustCompile(`^第\s*([0-9０-９一二三四五六七八九十零〇百千万两 ]+?)\s*([章回节集幕卷部篇])[\s:：.、，,_-]*(.*)$`)
+	enTitleReg   = regexp.MustCompile(`(?i)^(chapter|section|part|volume|vol\.|book|page)\s*([0-9]+|[ivxlcdm]+)\b[\s:：.、，,_-]*(.*)$`)
+	numTitleReg  = regexp.MustCompile(`^([0-9]{1,4})(?:([、.．:：])|\s+|$)\s*(.*)$`)
+	templateReg  = regexp.MustCompile(`\{(\w+)(?::([^{}]*))?\}`)
+	zeroPadReg   = regexp.MustCompile(`^0[1-9]$`)
+	fullWidthNum = strings.NewReplacer("０", "0", "１", "1", "２", "2", "３", "3", "４", "4", "５", "5", "６", "6", "７", "7", "８", "8", "９", "9")
+)
+
+// TitleTemplate 标题模板, 如"第{n:cn}章 {title}"
+//
+// {n}为编号, 可以写作{n:cn}中文数字、{n:roman}罗马数字、{n:03}补零到3位;
+// {title}为去掉编号后的标题。
+type TitleTemplate struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	text   string // 模板中的文字, 已转义
+	field  string // n或title, 为空时是文字
+	format string // 编号格式
+}
+
+// ParseTitleTemplate 解析标题模板
+func ParseTitleTemplate(s string) (*TitleTemplate, error) {
+	var t TitleTemplate
+	last := 0
+	for _, m := range templateReg.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > last {
+			t.parts = append(t.parts, templatePart{text: html.EscapeString(s[last:m[0]])})
+		}
+		last = m[1]
+		part := templatePart{field: s[m[2]:m[3]]}
+		if m[4] >= 0 {
+			part.format = s[m[4]:m[5]]
+		}
+		switch part.field {
+		case "n":
+			if part.format != "" && part.format != "cn" && part.format != "roman" && !zeroPadReg.MatchString(part.format) {
+				return nil, fmt.Errorf("标题模板中的编号格式错误: %s", s[m[0]:m[1]])
+			}
+		case "title":
+			if part.format != "" {
+				return nil, fmt.Errorf("标题模板中的{title}不支持格式: %s", s[m[0]:m[1]])
+			}
+		default:
+			return nil, fmt.Errorf("标题模板中不支持%s, 只能使用{n}和{title}", s[m[0]:m[1]])
+		}
+		t.parts = append(t.parts, part)
+	}
+	if last < len(s) {
+		t.parts = append(t.parts, templatePart{text: html.EscapeString(s[last:])})
+	}
+	return &t, nil
+}
+
+// Render 用编号n和标题生成新标题, title为已转义的html文本
+func (t *TitleTemplate) Render(n int, title string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch part.field {
+		case "n":
+			b.WriteString(formatNumber(n, part.format))
+		case "title":
+			b.WriteString(title)
+		default:
+			b.WriteString(part.text)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// formatNumber 按格式输出编号: cn中文数字, roman罗马数字, 03补零到3位, 为空时为阿拉伯数字
+func formatNumber(n int, format string) string {
+	switch format {
+	case "":
+		return strconv.Itoa(n)
+	case "cn":
+		return utils.FormatChineseNumber(n)
+	case "roman":
+		return utils.FormatRoman(n)
+	default:
+		width, _ := strconv.Atoi(format)
+		return fmt.Sprintf("%0*d", width, n)
+	}
+}
+
+// chapterTitle 识别出编号的标题, 如"第003章 标题"
+type chapterTitle struct {
+	prefix string // 编号前的文字, 如"第"、"Chapter "
+	number int
+	format string // 原来的编号格式, 和模板中{n:格式}相同
+	suffix string // 编号后的文字, 如"章"、"、"
+	name   string // 去掉编号后的标题
+}
+
+// parseChapterTitle 识别标题中的编号, 支持"第一百零五章"、"第 12 回"、"Chapter IV"和"12、"等写法
+func parseChapterTitle(title string) (chapterTitle, bool) {
+	if m := cnTitleReg.FindStringSubmatch(title); m != nil {
+		t := chapterTitle{prefix: "第", suffix: m[2], name: m[3]}
+		num := strings.ReplaceAll(fullWidthNum.Replace(m[1]), " ", "")
+		if n, err := strconv.Atoi(num); err == nil {
+			t.number, t.format = n, zeroPadFormat(num)
+			return t, true
+		}
+		if n, ok := utils.ParseChineseNumber(num); ok {
+			t.number, t.format = n, "cn"
+			return t, true
+		}
+		return chapterTitle{}, false
+	}
+	if m := enTitleReg.FindStringSubmatch(title); m != nil {
+		t := chapterTitle{prefix: m[1] + " ", name: m[3]}
+		if n, err := strconv.Atoi(m[2]); err == nil {
+			t.number, t.format = n, zeroPadFormat(m[2])
+			return t, true
+		}
+		if n, ok := utils.ParseRoman(m[2]); ok {
+			t.number, t.format = n, "roman"
+			return t, true
+		}
+		return chapterTitle{}, false
+	}
+	if m := numTitleReg.FindStringSubmatch(title); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return chapterTitle{number: n, format: zeroPadFormat(m[1]), suffix: m[2], name: m[3]}, true
+	}
+	return chapterTitle{}, false
+}
+
+// zeroPadFormat 有前导零的编号保留原来的位数, 如"003"为03
+func zeroPadFormat(num string) string {
+	if len(num) > 1 && len(num) < 10 && num[0] == '0' {
+		return "0" + strconv.Itoa(len(num))
+	}
+	return ""
+}
+
+// String 用原来的写法生成标题
+func (t chapterTitle) String() string {
+	s := t.prefix + formatNumber(t.number, t.format) + t.suffix
+	if t.name == "" {
+		return s
+	}
+	if t.suffix == "、" {
+		return s + t.name
+	}
+	return s + " " + t.name
+}
+
+// titleNormalizer 整理一组标题, 连续编号时记录当前编号
+type titleNormalizer struct {
+	template *TitleTemplate
+	junk     *regexp.Regexp
+	renumber bool
+	count    int
+}
+
+// normalize 去掉标题末尾的无用内容后按模板重写, 没有编号的标题只去掉无用内容
+func (n *titleNormalizer) normalize(title string) string {
+	if n.junk != nil {
+		for {
+			trimmed := strings.TrimSpace(n.junk.ReplaceAllString(title, ""))
+			if trimmed == title || trimmed == "" {
+				break
+			}
+			title = trimmed
+		}
+	}
+	t, ok := parseChapterTitle(title)
+	if !ok {
+		return title
+	}
+	if n.renumber {
+		n.count++
+		t.number = n.count
+	}
+	if n.template != nil {
+		return n.template.Render(t.number, t.name)
+	}
+	if n.renumber {
+		return t.String()
+	}
+	return title
+}
+
+// NormalizeTitles 按TitleTemplate、VolumeTemplate、Renumber和TitleJunk整理卷和章节标题
+//
+// 识别标题中的阿拉伯数字、中文数字和罗马数字编号, 去掉标题末尾的无用内容后按模板重写。
+// 没有编号的标题(如"楔子"、"番外")只去掉无用内容, 也不占编号。模板为空时保留原来的写法。
+// TitleJunk为空时使用DefaultTitleJunk, 为false时不去掉。
+func (book *Book) NormalizeTitles() error {
+	if book.TitleTemplate == "" && book.VolumeTemplate == "" && book.Renumber == RenumberNone &&
+		(book.TitleJunk == "" || book.TitleJunk == "false") {
+		return nil
+	}
+	var chapters, volumes titleNormalizer
+	var err error
+	if book.TitleTemplate != "" {
+		if chapters.template, err = ParseTitleTemplate(book.TitleTemplate); err != nil {
+			return err
+		}
+	}
+	if book.VolumeTemplate != "" {
+		if volumes.template, err = ParseTitleTemplate(book.VolumeTemplate); err != nil {
+			return err
+		}
+	}
+	switch book.TitleJunk {
+	case "false":
+	case "":
+		chapters.junk = regexp.MustCompile(DefaultTitleJunk)
+	default:
+		if chapters.junk, err = regexp.Compile(book.TitleJunk); err != nil {
+			return fmt.Errorf("标题去除规则错误: %w", err)
+		}
+	}
+	volumes.junk = chapters.junk
+	chapters.renumber = book.Renumber != RenumberNone
+	volumes.renumber = chapters.renumber
+
+	for i := range book.SectionList {
+		section := &book.SectionList[i]
+		if section.Content == Tutorial {
+			continue
+		}
+		if len(section.Sections) == 0 {
+			section.Title = chapters.normalize(section.Title)
+			continue
+		}
+		section.Title = volumes.normalize(section.Title)
+		if book.Renumber == RenumberVolume {
+			chapters.count = 0
+		}
+		for j := range section.Sections {
+			section.Sections[j].Title = chapters.normalize(section.Sections[j].Title)
+		}
+	}
+	return nil
+}
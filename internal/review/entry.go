@@ -0,0 +1,157 @@
+package review
+
+import (
+	"bytes"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// entry 目录中的一行, 卷和章节展开为一个列表方便编辑
+type entry struct {
+	title   string // 已转义的标题
+	content string
+	volume  bool // 是卷
+	nested  bool // 在卷中的章节
+}
+
+// flatten 把章节树展开为列表
+func flatten(sections []model.Section) []entry {
+	var entries []entry
+	for _, section := range sections {
+		entries = append(entries, entry{
+			title:   section.Title,
+			content: section.Content,
+			volume:  len(section.Sections) > 0,
+		})
+		for _, sub := range section.Sections {
+			entries = append(entries, entry{title: sub.Title, content: sub.Content, nested: true})
+		}
+	}
+	return entries
+}
+
+// build 把列表还原为章节树, 卷中的章节放到前面最近的一卷中, 卷外的章节结束当前卷
+func build(entries []entry) []model.Section {
+	var sections []model.Section
+	volume := -1
+	for _, e := range entries {
+		section := model.Section{Title: e.title, Content: e.content}
+		switch {
+		case e.volume:
+			sections = append(sections, section)
+			volume = len(sections) - 1
+		case e.nested && volume >= 0:
+			sections[volume].Sections = append(sections[volume].Sections, section)
+		default:
+			sections = append(sections, section)
+			volume = -1
+		}
+	}
+	return sections
+}
+
+// rename 修改标题, title为用户输入的文本
+func rename(entries []entry, i int, title string) {
+	entries[i].title = html.EscapeString(strings.TrimSpace(title))
+}
+
+// remove 删除一行和它的正文, 删除卷时卷中的章节归入上一卷
+func remove(entries []entry, i int) []entry {
+	return append(entries[:i], entries[i+1:]...)
+}
+
+// mergeUp 把第i行并入上一行: 标题变为上一行正文的一段, 正文接在后面
+func mergeUp(entries []entry, i int) []entry {
+	if i == 0 {
+		return entries
+	}
+	var content strings.Builder
+	content.WriteString(entries[i-1].content)
+	if entries[i].title != "" {
+		content.WriteString(paragraph(entries[i].title))
+	}
+	content.WriteString(entries[i].content)
+	entries[i-1].content = content.String()
+	return remove(entries, i)
+}
+
+// split 从第p段开始把正文拆成新的章节, 第p段作为新章节的标题, 后面的段落为新章节的正文
+func split(entries []entry, i, p int) []entry {
+	paragraphs := paragraphs(entries[i].content)
+	if p < 0 || p >= len(paragraphs) {
+		return entries
+	}
+	e := entry{
+		title:   strings.TrimSpace(stripParagraph(paragraphs[p])),
+		content: strings.Join(paragraphs[p+1:], ""),
+		nested:  entries[i].nested || entries[i].volume,
+	}
+	entries[i].content = strings.Join(paragraphs[:p], "")
+	entries = append(entries[:i+1], append([]entry{e}, entries[i+1:]...)...)
+	return entries
+}
+
+// toggleVolume 把章节升为卷, 后面到下一卷为止的章节都放到这一卷中; 对卷则降为章节
+func toggleVolume(entries []entry, i int) {
+	e := &entries[i]
+	if e.volume {
+		e.volume = false
+		e.nested = false
+		for j := i - 1; j >= 0; j-- {
+			if entries[j].volume {
+				e.nested = true
+				break
+			}
+		}
+		return
+	}
+	e.volume, e.nested = true, false
+	for j := i + 1; j < len(entries) && !entries[j].volume; j++ {
+		if entries[j].content == model.Tutorial {
+			break
+		}
+		entries[j].nested = true
+	}
+}
+
+// paragraph 把一行文本生成正文段落
+func paragraph(text string) string {
+	var buf bytes.Buffer
+	utils.AddPart(&buf, text)
+	return buf.String()
+}
+
+// paragraphs 把正文拆成段落
+func paragraphs(content string) []string {
+	var result []string
+	for _, p := range strings.SplitAfter(content, "</p>") {
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// stripParagraph 去掉段落的标签, 返回仍为转义后的文本
+func stripParagraph(p string) string {
+	p = strings.TrimPrefix(p, `<p class="content">`)
+	return strings.TrimSuffix(p, "</p>")
+}
+
+// text 段落显示的文本
+func text(p string) string {
+	return html.UnescapeString(stripParagraph(p))
+}
+
+// length 正文字数
+func length(content string) int {
+	var n int
+	for _, p := range paragraphs(content) {
+		n += utf8.RuneCountInString(text(p))
+	}
+	return n
+}
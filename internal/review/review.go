@@ -0,0 +1,430 @@
+// Package review 在终端中检查和修改识别出的目录, 在转换前修正章节识别的错误
+//
+// 只使用ANSI转义序列, 可以在SSH等普通终端中使用。
+package review
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+const (
+	listHelp    = "↑↓移动 回车预览 r重命名 d删除 m并入上一章 v卷/章节 u撤销 /章节规则 V卷规则 s保存并转换 q退出"
+	previewHelp = "↑↓选择段落 x从这一段拆出新章节 r重命名 ←/Esc返回目录"
+)
+
+// session 一次检查的状态
+type session struct {
+	book    *model.Book
+	entries []entry
+	history [][]entry // 撤销用的历史
+	in      *bufio.Reader
+	out     *bufio.Writer
+	width   int
+	height  int
+
+	cursor  int // 目录中的当前行
+	offset  int // 目录中显示的第一行
+	preview bool
+	para    int // 预览中的当前段落
+	top     int // 预览中显示的第一段
+
+	input    *input
+	status   string
+	modified bool
+	quit     bool // 有修改时再按一次q退出
+}
+
+// input 底部的输入框
+type input struct {
+	label  string
+	value  []rune
+	change func(value string) // 每次修改后调用, 用于实时重新识别
+	done   func(value string)
+	cancel func()
+}
+
+// Run 在终端中显示book的目录供用户检查和修改, 修改后的结果写回book.SectionList
+//
+// 修改章节规则和卷规则时会重新识别全书, 之前的修改会丢失。返回false表示用户放弃转换。
+func Run(book *model.Book) (bool, error) {
+	restore, err := makeRaw(os.Stdin, os.Stdout)
+	if err != nil {
+		return false, err
+	}
+	s := &session{
+		book:    book,
+		entries: flatten(book.SectionList),
+		in:      bufio.NewReader(os.Stdin),
+		out:     bufio.NewWriter(os.Stdout),
+	}
+	// 使用备用屏幕, 退出后恢复原来的终端内容
+	s.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		s.out.WriteString("\x1b[?25h\x1b[?1049l")
+		s.out.Flush()
+		restore()
+	}()
+
+	for {
+		s.render()
+		k, err := s.readKey()
+		if err != nil {
+			return false, fmt.Errorf("读取按键失败: %w", err)
+		}
+		if k.name == "ctrl-c" {
+			return false, nil
+		}
+		save, quit := s.handle(k)
+		if save {
+			book.SectionList = build(s.entries)
+			return true, nil
+		}
+		if quit {
+			return false, nil
+		}
+	}
+}
+
+// handle 处理一次按键, 返回是否保存或退出
+func (s *session) handle(k key) (save, quit bool) {
+	s.status = ""
+	if s.input != nil {
+		s.edit(k)
+		return false, false
+	}
+	if k.r != 'q' {
+		s.quit = false
+	}
+	if s.preview {
+		s.handlePreview(k)
+		return false, false
+	}
+	rows := s.height - 3
+	switch {
+	case k.name == "up" || k.r == 'k':
+		s.cursor--
+	case k.name == "down" || k.r == 'j':
+		s.cursor++
+	case k.name == "pgup":
+		s.cursor -= rows
+	case k.name == "pgdown" || k.r == ' ':
+		s.cursor += rows
+	case k.name == "home" || k.r == 'g':
+		s.cursor = 0
+	case k.name == "end" || k.r == 'G':
+		s.cursor = len(s.entries) - 1
+	case k.name == "enter" || k.name == "right" || k.r == 'l':
+		if len(s.entries) > 0 {
+			s.preview, s.para, s.top = true, 0, 0
+		}
+	case k.r == 'r':
+		s.rename()
+	case k.r == 'd':
+		if len(s.entries) > 0 {
+			s.change(func() { s.entries = remove(s.entries, s.cursor) })
+			s.status = "已删除, 按u撤销"
+		}
+	case k.r == 'm':
+		if s.cursor > 0 {
+			s.change(func() { s.entries = mergeUp(s.entries, s.cursor) })
+			s.cursor--
+		}
+	case k.r == 'v':
+		if len(s.entries) > 0 {
+			s.change(func() { toggleVolume(s.entries, s.cursor) })
+		}
+	case k.r == 'u':
+		s.undo()
+	case k.r == '/':
+		s.editRule("章节规则", &s.book.Match)
+	case k.r == 'V':
+		s.editRule("卷规则", &s.book.VolumeMatch)
+	case k.r == 's':
+		return true, false
+	case k.r == 'q':
+		if !s.modified || s.quit {
+			return false, true
+		}
+		s.quit = true
+		s.status = "修改还没有保存, 再按一次q退出"
+	}
+	s.cursor = max(0, min(s.cursor, len(s.entries)-1))
+	return false, false
+}
+
+// handlePreview 处理预览中的按键
+func (s *session) handlePreview(k key) {
+	paragraphs := paragraphs(s.entries[s.cursor].content)
+	switch {
+	case k.name == "esc" || k.name == "left" || k.name == "enter" || k.r == 'h' || k.r == 'q':
+		s.preview = false
+	case k.name == "up" || k.r == 'k':
+		s.para--
+	case k.name == "down" || k.r == 'j':
+		s.para++
+	case k.name == "pgup":
+		s.para -= s.height / 2
+	case k.name == "pgdown" || k.r == ' ':
+		s.para += s.height / 2
+	case k.name == "home" || k.r == 'g':
+		s.para = 0
+	case k.name == "end" || k.r == 'G':
+		s.para = len(paragraphs) - 1
+	case k.r == 'r':
+		s.rename()
+	case k.r == 'x':
+		if len(paragraphs) > 0 {
+			s.change(func() { s.entries = split(s.entries, s.cursor, s.para) })
+			s.cursor++
+			s.preview = false
+			s.status = "已拆出新章节, 按u撤销"
+		}
+	}
+	s.para = max(0, min(s.para, len(paragraphs)-1))
+}
+
+// change 修改目录, 修改前保存历史用于撤销
+func (s *session) change(fn func()) {
+	s.history = append(s.history, append([]entry(nil), s.entries...))
+	fn()
+	s.modified = true
+}
+
+// undo 撤销上一次修改
+func (s *session) undo() {
+	if len(s.history) == 0 {
+		s.status = "没有可以撤销的修改"
+		return
+	}
+	s.entries = s.history[len(s.history)-1]
+	s.history = s.history[:len(s.history)-1]
+	s.status = "已撤销"
+}
+
+// rename 修改当前行的标题
+func (s *session) rename() {
+	if len(s.entries) == 0 {
+		return
+	}
+	s.input = &input{
+		label: "新标题: ",
+		value: []rune(html.UnescapeString(s.entries[s.cursor].title)),
+		done: func(value string) {
+			s.change(func() { rename(s.entries, s.cursor, value) })
+		},
+	}
+}
+
+// editRule 修改章节规则或卷规则, 输入时实时重新识别
+func (s *session) editRule(label string, rule *string) {
+	old := *rule
+	entries, history, modified := s.entries, s.history, s.modified
+	in := &input{label: label + ": ", value: []rune(old)}
+	in.change = func(value string) {
+		if err := s.reparse(rule, value); err != nil {
+			s.status = err.Error()
+			return
+		}
+		s.status = fmt.Sprintf("识别到%d章, 回车确认, Esc取消", model.SectionCount(s.book.SectionList))
+	}
+	in.cancel = func() {
+		s.reparse(rule, old)
+		s.entries, s.history, s.modified = entries, history, modified
+	}
+	in.done = func(value string) {
+		if value == old {
+			in.cancel()
+			return
+		}
+		if *rule != value {
+			in.cancel()
+			s.status = "规则错误, 已恢复原来的规则"
+			return
+		}
+		s.history, s.modified = nil, false
+		s.cursor = 0
+	}
+	s.input = in
+	if modified {
+		s.status = "修改规则后会重新识别, 之前的修改会丢失"
+	}
+}
+
+// reparse 使用新的规则重新识别全书
+func (s *session) reparse(rule *string, value string) error {
+	match, volumeMatch := s.book.Match, s.book.VolumeMatch
+	if rule == &s.book.Match {
+		match = value
+	} else {
+		volumeMatch = value
+	}
+	if match == "" {
+		match = model.DefaultMatchTips
+	}
+	reg, err := regexp.Compile(match)
+	if err != nil {
+		return fmt.Errorf("章节规则错误: %w", err)
+	}
+	volumeReg, err := regexp.Compile(volumeMatch)
+	if err != nil {
+		return fmt.Errorf("卷规则错误: %w", err)
+	}
+	*rule = value
+	s.book.Reg, s.book.VolumeReg = reg, volumeReg
+
+	// 识别时的输出会打乱屏幕
+	log := s.book.Log
+	s.book.Log = utils.NopLogger{}
+	defer func() { s.book.Log = log }()
+	if err := core.Parse(s.book); err != nil {
+		return err
+	}
+	s.entries = flatten(s.book.SectionList)
+	s.cursor = max(0, min(s.cursor, len(s.entries)-1))
+	return nil
+}
+
+// edit 处理输入框中的按键
+func (s *session) edit(k key) {
+	in := s.input
+	switch {
+	case k.name == "enter":
+		s.input = nil
+		in.done(string(in.value))
+		return
+	case k.name == "esc" || k.name == "ctrl-c":
+		s.input = nil
+		if in.cancel != nil {
+			in.cancel()
+		}
+		return
+	case k.name == "backspace":
+		if len(in.value) == 0 {
+			return
+		}
+		in.value = in.value[:len(in.value)-1]
+	case k.name == "" && k.r >= 0x20:
+		in.value = append(in.value, k.r)
+	default:
+		return
+	}
+	if in.change != nil {
+		in.change(string(in.value))
+	}
+}
+
+// render 重画整个屏幕
+func (s *session) render() {
+	s.width, s.height = terminalSize(os.Stdout)
+	if s.preview {
+		s.renderPreview()
+	} else {
+		s.renderList()
+	}
+	s.line(s.height-1, s.status, "\x1b[33m")
+	if s.input != nil {
+		s.line(s.height, s.input.label+string(s.input.value), "")
+		fmt.Fprint(s.out, "\x1b[?25h")
+	} else {
+		help := listHelp
+		if s.preview {
+			help = previewHelp
+		}
+		s.line(s.height, help, "\x1b[7m")
+		fmt.Fprint(s.out, "\x1b[?25l")
+	}
+	s.out.Flush()
+}
+
+// renderList 显示目录
+func (s *session) renderList() {
+	rows := s.height - 3
+	var volumes int
+	for _, e := range s.entries {
+		if e.volume {
+			volumes++
+		}
+	}
+	s.line(1, fmt.Sprintf(" %s  %d卷 %d章  章节规则: %s", s.book.Bookname, volumes, len(s.entries)-volumes, s.book.Match), "\x1b[7m")
+
+	if s.cursor < s.offset {
+		s.offset = s.cursor
+	}
+	if s.cursor >= s.offset+rows {
+		s.offset = s.cursor - rows + 1
+	}
+	for row := 0; row < rows; row++ {
+		i := s.offset + row
+		if i >= len(s.entries) {
+			s.line(row+2, "", "")
+			continue
+		}
+		e := s.entries[i]
+		indent := ""
+		if e.nested {
+			indent = "  "
+		}
+		marker := " "
+		if e.volume {
+			marker = "#"
+		}
+		size := fmt.Sprintf(" %d字", length(e.content))
+		title := truncate(fmt.Sprintf("%5d %s%s%s", i+1, marker, indent, html.UnescapeString(e.title)), s.width-textWidth(size))
+		text := title + fmt.Sprintf("%*s", s.width-textWidth(title), size)
+		style := ""
+		if e.volume {
+			style = "\x1b[1m"
+		}
+		if i == s.cursor {
+			style = "\x1b[7m"
+		}
+		s.line(row+2, text, style)
+	}
+}
+
+// renderPreview 显示当前章节的正文, 当前段落反色显示
+func (s *session) renderPreview() {
+	e := s.entries[s.cursor]
+	s.line(1, fmt.Sprintf(" %s  %d字", html.UnescapeString(e.title), length(e.content)), "\x1b[7m")
+	paragraphs := paragraphs(e.content)
+	rows := s.height - 3
+	if s.para < s.top {
+		s.top = s.para
+	}
+	// 当前段落显示不全时向下滚动
+	for s.top < s.para {
+		var used int
+		for p := s.top; p <= s.para; p++ {
+			used += len(wrap(text(paragraphs[p]), s.width))
+		}
+		if used <= rows {
+			break
+		}
+		s.top++
+	}
+	row := 2
+	for p := s.top; p < len(paragraphs) && row < rows+2; p++ {
+		style := ""
+		if p == s.para {
+			style = "\x1b[7m"
+		}
+		for _, l := range wrap(text(paragraphs[p]), s.width) {
+			if row >= rows+2 {
+				break
+			}
+			s.line(row, l, style)
+			row++
+		}
+	}
+	for ; row < rows+2; row++ {
+		s.line(row, "", "")
+	}
+}
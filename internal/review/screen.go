@@ -0,0 +1,146 @@
+package review
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// key 一次按键, name为特殊键的名称, 普通字符为r
+type key struct {
+	name string
+	r    rune
+}
+
+// readKey 读取一次按键, 方向键等转义序列合并为一个键
+func (s *session) readKey() (key, error) {
+	b, err := s.in.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	switch b {
+	case 0x1b:
+		// 单独的Esc后面没有紧跟其他字节
+		if s.in.Buffered() == 0 {
+			return key{name: "esc"}, nil
+		}
+		next, _ := s.in.ReadByte()
+		if next != '[' && next != 'O' {
+			return key{name: "esc"}, nil
+		}
+		var seq []byte
+		for {
+			c, err := s.in.ReadByte()
+			if err != nil {
+				return key{}, err
+			}
+			seq = append(seq, c)
+			if c >= 0x40 && c <= 0x7e {
+				break
+			}
+		}
+		switch string(seq) {
+		case "A":
+			return key{name: "up"}, nil
+		case "B":
+			return key{name: "down"}, nil
+		case "C":
+			return key{name: "right"}, nil
+		case "D":
+			return key{name: "left"}, nil
+		case "H", "1~", "7~":
+			return key{name: "home"}, nil
+		case "F", "4~", "8~":
+			return key{name: "end"}, nil
+		case "5~":
+			return key{name: "pgup"}, nil
+		case "6~":
+			return key{name: "pgdown"}, nil
+		}
+		return key{name: "unknown"}, nil
+	case '\r', '\n':
+		return key{name: "enter"}, nil
+	case 0x7f, 0x08:
+		return key{name: "backspace"}, nil
+	case 0x03:
+		return key{name: "ctrl-c"}, nil
+	}
+	if b < 0x80 {
+		return key{r: rune(b)}, nil
+	}
+	s.in.UnreadByte()
+	r, _, err := s.in.ReadRune()
+	return key{r: r}, err
+}
+
+// runeWidth 字符在终端中占的列数, 中文等全角字符占两列
+func runeWidth(r rune) int {
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// textWidth 文本在终端中占的列数
+func textWidth(s string) int {
+	var w int
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// truncate 截断文本使其不超过w列
+func truncate(s string, w int) string {
+	var n int
+	for i, r := range s {
+		n += runeWidth(r)
+		if n > w {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// wrap 按w列折行
+func wrap(s string, w int) []string {
+	var lines []string
+	var line strings.Builder
+	var n int
+	for _, r := range s {
+		if n+runeWidth(r) > w {
+			lines = append(lines, line.String())
+			line.Reset()
+			n = 0
+		}
+		line.WriteRune(r)
+		n += runeWidth(r)
+	}
+	return append(lines, line.String())
+}
+
+// clean 把制表符等控制字符换成空格, 避免打乱屏幕
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// line 在第row行(从1开始)输出文本, 超出宽度的部分截断, style为ANSI样式
+func (s *session) line(row int, text, style string) {
+	text = truncate(clean(text), s.width)
+	fmt.Fprintf(s.out, "\x1b[%d;1H\x1b[2K", row)
+	if style != "" {
+		s.out.WriteString(style)
+		text += strings.Repeat(" ", s.width-textWidth(text))
+	}
+	s.out.WriteString(text)
+	if style != "" {
+		s.out.WriteString("\x1b[0m")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package review
+
+import (
+	"errors"
+	"os"
+)
+
+func makeRaw(in, out *os.File) (func(), error) {
+	return nil, errors.New("当前系统不支持交互模式")
+}
+
+func terminalSize(out *os.File) (int, int) {
+	return 80, 24
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package review
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw 把控制台设为原始模式并开启ANSI转义序列, 返回恢复控制台的函数
+func makeRaw(in, out *os.File) (func(), error) {
+	inHandle, outHandle := windows.Handle(in.Fd()), windows.Handle(out.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(inHandle, &inMode); err != nil {
+		return nil, errors.New("交互模式需要在终端中运行")
+	}
+	if err := windows.GetConsoleMode(outHandle, &outMode); err != nil {
+		return nil, errors.New("交互模式需要在终端中运行")
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(inHandle, raw); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(outHandle, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		windows.SetConsoleMode(inHandle, inMode)
+		return nil, err
+	}
+	return func() {
+		windows.SetConsoleMode(inHandle, inMode)
+		windows.SetConsoleMode(outHandle, outMode)
+	}, nil
+}
+
+// terminalSize 控制台的列数和行数
+func terminalSize(out *os.File) (int, int) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(out.Fd()), &info); err != nil {
+		return 80, 24
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1
+}
@@ -0,0 +1,30 @@
+package review
+
+import (
+	"bufio"
+	"html"
+	"io"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// WriteText 把章节写成txt, 每个标题和段落一行, 不包含制作说明
+func WriteText(w io.Writer, sections []model.Section) error {
+	bw := bufio.NewWriter(w)
+	var write func(sections []model.Section)
+	write = func(sections []model.Section) {
+		for _, section := range sections {
+			if section.Content == model.Tutorial {
+				continue
+			}
+			bw.WriteString(html.UnescapeString(section.Title) + "\n")
+			for _, p := range paragraphs(section.Content) {
+				bw.WriteString(text(p) + "\n")
+			}
+			bw.WriteString("\n")
+			write(section.Sections)
+		}
+	}
+	write(sections)
+	return bw.Flush()
+}
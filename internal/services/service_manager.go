@@ -2,7 +2,9 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/Deali-Axy/ebook-generator/internal/monitoring"
 	"github.com/Deali-Axy/ebook-generator/internal/upload"
 	"github.com/Deali-Axy/ebook-generator/internal/validation"
+	"github.com/Deali-Axy/ebook-generator/internal/watch"
 	"github.com/Deali-Axy/ebook-generator/internal/web/middleware"
 	"github.com/Deali-Axy/ebook-generator/internal/web/services"
 	"github.com/glebarez/sqlite"
@@ -39,11 +42,15 @@ type ServiceManager struct {
 	DownloadMgr  *download.DownloadManager
 	Validator    *validation.FileValidator
 	HistorySvc   *services.HistoryService
+	WatchSvc     *watch.Watcher
 
 	// 中间件
 	RateLimiter  *middleware.AdvancedRateLimiter
 	LoadBalancer *loadbalancer.LoadBalancer
 
+	// 软件版本, 收件目录转换时使用
+	Version      string
+
 	// 配置
 	config       *ServiceConfig
 	mutex        sync.RWMutex
@@ -89,6 +96,23 @@ type ServiceConfig struct {
 
 	// 配置管理配置
 	ConfigManager ConfigManagerOptions `json:"config_manager"`
+
+	// 收件目录监视配置
+	Watch WatchConfig `json:"watch"`
+
+	// 远程MCP服务配置
+	MCP MCPConfig `json:"mcp"`
+
+	// 转换任务队列配置
+	TaskQueue services.TaskQueueConfig `json:"task_queue"`
+
+	// 登录认证配置
+	Auth AuthConfig `json:"auth"`
+}
+
+// AuthConfig 登录认证配置
+type AuthConfig struct {
+	JWTSecret string `json:"jwt_secret"` // 登录token的签名密钥, 远程MCP服务必须设置; 环境变量KAF_JWT_SECRET优先
 }
 
 // DatabaseConfig 数据库配置
@@ -107,6 +131,30 @@ type ValidationConfig struct {
 	RequireUTF8   bool     `json:"require_utf8"`
 }
 
+// WatchConfig 收件目录监视配置, 放入收件目录的txt会自动转换
+type WatchConfig struct {
+	Enabled   bool                   `json:"enabled"`
+	Inbox     string                 `json:"inbox"`      // 收件目录
+	OutputDir string                 `json:"output_dir"` // 输出目录, 为空时为收件目录下的out
+	DoneDir   string                 `json:"done_dir"`   // 转换成功的txt移到这个目录, 为空时为收件目录下的done
+	FailedDir string                 `json:"failed_dir"` // 转换失败的txt移到这个目录, 为空时为收件目录下的failed
+	Interval  string                 `json:"interval"`   // 检查收件目录的间隔, 如"2s"
+	Settle    string                 `json:"settle"`     // 文件多久没有变化后认为已经写完, 如"5s"
+	UserID    uint                   `json:"user_id"`    // 转换历史记录到这个用户名下, 也用于查找预设; 为0时不记录转换历史
+	PresetID  uint                   `json:"preset_id"`  // 使用的转换预设, 需要数据库
+	Options   map[string]interface{} `json:"options"`    // 转换选项, 字段和转换接口相同, 会覆盖预设中的同名选项
+}
+
+// MCPConfig 远程MCP服务配置, 用户用登录得到的token连接, 每个用户使用自己的工作目录
+type MCPConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Path              string `json:"path"`                // 路由前缀, 客户端连接<Path>/sse
+	BaseURL           string `json:"base_url"`            // 返回给客户端的消息地址的前缀, 为空时使用相对地址
+	WorkDir           string `json:"work_dir"`            // 用户工作目录的根目录, 为空时为工作目录下的web/mcp
+	RequestsPerMinute int    `json:"requests_per_minute"` // 每个用户每分钟的请求数, 为0时不限制
+	BurstSize         int    `json:"burst_size"`
+}
+
 // ConfigManagerOptions 配置管理器选项
 type ConfigManagerOptions struct {
 	WatchChanges bool                  `json:"watch_changes"`
@@ -161,6 +209,10 @@ func (sm *ServiceManager) loadConfig(configPath string) error {
 		// 使用默认配置
 		sm.config = sm.getDefaultConfig()
 	}
+	if err := loadFileSections(configPath, sm.config); err != nil {
+		return err
+	}
+	applyEnv(sm.config)
 
 	// 监听配置变化
 	sm.ConfigMgr.AddChangeHook(sm.onConfigChange)
@@ -168,6 +220,59 @@ func (sm *ServiceManager) loadConfig(configPath string) error {
 	return nil
 }
 
+// loadFileSections 用配置文件中services下的部分覆盖默认配置, 没有写的字段保持默认值
+// 其它部分的时长字段是time.Duration, 不能从"30s"这样的字符串解析, 仍使用默认配置
+func loadFileSections(configPath string, cfg *ServiceConfig) error {
+	if configPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	var file struct {
+		Services struct {
+			Database  *DatabaseConfig           `json:"database"`
+			Watch     *WatchConfig              `json:"watch"`
+			MCP       *MCPConfig                `json:"mcp"`
+			Auth      *AuthConfig               `json:"auth"`
+			TaskQueue *services.TaskQueueConfig `json:"task_queue"`
+		} `json:"services"`
+	}
+	file.Services.Database = &cfg.Database
+	file.Services.Watch = &cfg.Watch
+	file.Services.MCP = &cfg.MCP
+	file.Services.Auth = &cfg.Auth
+	file.Services.TaskQueue = &cfg.TaskQueue
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// applyEnv 环境变量覆盖配置文件中的设置
+func applyEnv(cfg *ServiceConfig) {
+	if dir := os.Getenv("KAF_WATCH_DIR"); dir != "" {
+		cfg.Watch.Enabled = true
+		cfg.Watch.Inbox = dir
+	}
+	if os.Getenv("KAF_MCP_ENABLED") == "true" {
+		cfg.MCP.Enabled = true
+	}
+	if baseURL := os.Getenv("KAF_MCP_BASE_URL"); baseURL != "" {
+		cfg.MCP.BaseURL = baseURL
+	}
+	if dir := os.Getenv("KAF_MCP_DIR"); dir != "" {
+		cfg.MCP.WorkDir = dir
+	}
+	if secret := os.Getenv("KAF_JWT_SECRET"); secret != "" {
+		cfg.Auth.JWTSecret = secret
+	}
+}
+
 // getDefaultConfig 获取默认配置
 func (sm *ServiceManager) getDefaultConfig() *ServiceConfig {
 	return &ServiceConfig{
@@ -273,6 +378,16 @@ func (sm *ServiceManager) getDefaultConfig() *ServiceConfig {
 			WatchChanges: true,
 			Format:       config.ConfigFormatJSON,
 		},
+		Watch: WatchConfig{
+			Interval: "2s",
+			Settle:   "5s",
+		},
+		MCP: MCPConfig{
+			Path:              "/mcp",
+			RequestsPerMinute: 60,
+			BurstSize:         20,
+		},
+		TaskQueue: services.DefaultTaskQueueConfig(),
 	}
 }
 
@@ -490,6 +605,77 @@ func (sm *ServiceManager) initLoadBalancer() error {
 	return nil
 }
 
+// parseDuration 解析配置中的时间, 如"5s", 为空时为0
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// initWatch 初始化收件目录监视, 在启动服务时调用, 以便启动前修改配置
+func (sm *ServiceManager) initWatch() error {
+	cfg := sm.config.Watch
+	options := make(map[string]interface{})
+	interval, err := parseDuration(cfg.Interval)
+	if err != nil {
+		return fmt.Errorf("检查间隔格式错误: %w", err)
+	}
+	settle, err := parseDuration(cfg.Settle)
+	if err != nil {
+		return fmt.Errorf("等待写完的时间格式错误: %w", err)
+	}
+	if cfg.PresetID != 0 {
+		if sm.HistorySvc == nil || sm.DB == nil {
+			return fmt.Errorf("使用预设需要数据库")
+		}
+		if cfg.UserID == 0 {
+			return fmt.Errorf("使用预设需要设置user_id")
+		}
+		preset, err := sm.HistorySvc.GetPreset(cfg.UserID, cfg.PresetID)
+		if err != nil {
+			return fmt.Errorf("读取预设失败: %w", err)
+		}
+		options = preset.ConvertOptions()
+		sm.HistorySvc.UsePreset(preset.ID)
+	}
+	for k, v := range cfg.Options {
+		options[k] = v
+	}
+	book, err := services.BookFromOptions(options)
+	if err != nil {
+		return err
+	}
+
+	opts := watch.Options{
+		Book:      *book,
+		Inbox:     cfg.Inbox,
+		OutDir:    cfg.OutputDir,
+		DoneDir:   cfg.DoneDir,
+		FailedDir: cfg.FailedDir,
+		Interval:  interval,
+		Settle:    settle,
+		Version:   sm.Version,
+		Logf: func(format string, args ...any) {
+			sm.Logger.Info(fmt.Sprintf(format, args...), map[string]interface{}{"inbox": cfg.Inbox})
+		},
+	}
+	if sm.DB != nil && sm.HistorySvc != nil {
+		recorder, err := watch.NewHistoryRecorder(sm.HistorySvc, cfg.UserID, book.Format, options)
+		if err == nil {
+			opts.Recorder = recorder
+		} else {
+			opts.Logf("%v, 不记录转换历史", err)
+		}
+	}
+	watcher, err := watch.New(opts)
+	if err != nil {
+		return err
+	}
+	sm.WatchSvc = watcher
+	return nil
+}
+
 // Start 启动所有服务
 func (sm *ServiceManager) Start() error {
 	sm.mutex.Lock()
@@ -507,6 +693,19 @@ func (sm *ServiceManager) Start() error {
 		}
 	}
 
+	// 启动收件目录监视
+	if sm.config.Watch.Enabled {
+		if err := sm.initWatch(); err != nil {
+			return fmt.Errorf("failed to init watch: %w", err)
+		}
+		sm.WatchSvc.Start(sm.ctx)
+		if sm.Logger != nil {
+			sm.Logger.Info("Watch service started", map[string]interface{}{
+				"inbox": sm.config.Watch.Inbox,
+			})
+		}
+	}
+
 	sm.started = true
 
 	// 记录服务启动完成
@@ -529,6 +728,11 @@ func (sm *ServiceManager) Stop() error {
 	sm.Logger.Info("Stopping all services...")
 
 	// 停止服务（逆序）
+	if sm.WatchSvc != nil {
+		sm.WatchSvc.Stop()
+		sm.Logger.Info("Watch service stopped")
+	}
+
 	if sm.UploadSvc != nil {
 		sm.UploadSvc.Stop()
 		sm.Logger.Info("Upload service stopped")
@@ -648,6 +852,7 @@ func (sm *ServiceManager) GetServiceStatus() map[string]interface{} {
 		"download":     sm.DownloadMgr != nil,
 		"validator":    sm.Validator != nil,
 		"history":      sm.HistorySvc != nil,
+		"watch":        sm.WatchSvc != nil,
 		"rate_limiter": sm.RateLimiter != nil,
 		"load_balancer": sm.LoadBalancer != nil,
 	}
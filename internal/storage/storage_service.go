@@ -92,13 +92,27 @@ func (s *StorageService) GetUploadedFilePath(taskID string) (string, error) {
 	return matches[0], nil
 }
 
+// CopyUploadedFile 把已上传的文件复制给另一个任务, 用于重新转换
+func (s *StorageService) CopyUploadedFile(fromTaskID, toTaskID string) (string, error) {
+	srcPath, err := s.GetUploadedFilePath(fromTaskID)
+	if err != nil {
+		return "", err
+	}
+	filename := strings.TrimPrefix(filepath.Base(srcPath), fromTaskID+"_")
+	destPath := filepath.Join(s.uploadDir, toTaskID+"_"+filename)
+	if err := s.copyFile(srcPath, destPath); err != nil {
+		return "", fmt.Errorf("复制文件失败: %w", err)
+	}
+	return destPath, nil
+}
+
 // SaveConvertedFiles 保存转换后的文件
 func (s *StorageService) SaveConvertedFiles(taskID string, convertedFiles []types.ConvertedFileInfo) ([]models.ConvertedFile, error) {
 	var results []models.ConvertedFile
 
-	for _, file := range convertedFiles {
-		// 生成文件ID
-		fileID := s.generateFileID(taskID, file.Format)
+	for i, file := range convertedFiles {
+		// 生成文件ID, 分册时同一格式有多个文件
+		fileID := s.generateFileID(taskID, file.Format, i)
 
 		// 目标路径
 		destPath := filepath.Join(s.outputDir, fileID+"_"+file.Filename)
@@ -208,8 +222,18 @@ func (s *StorageService) sanitizeFilename(filename string) string {
 }
 
 // generateFileID 生成文件ID
-func (s *StorageService) generateFileID(taskID, format string) string {
-	return fmt.Sprintf("%s_%s_%d", taskID, format, time.Now().Unix())
+func (s *StorageService) generateFileID(taskID, format string, index int) string {
+	return fmt.Sprintf("%s_%s_%d_%d", taskID, format, time.Now().Unix(), index)
+}
+
+// TaskIDFromFileID 从文件ID中取出任务ID
+func (s *StorageService) TaskIDFromFileID(fileID string) string {
+	// 文件ID为"任务ID_格式_时间_序号"
+	parts := strings.Split(fileID, "_")
+	if len(parts) <= 3 {
+		return ""
+	}
+	return strings.Join(parts[:len(parts)-3], "_")
 }
 
 // copyFile 复制文件
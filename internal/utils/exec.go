@@ -1,13 +1,20 @@
 package utils
 
 import (
+	"context"
 	"os"
 	"os/exec"
 )
 
 func Run(command string, args ...string) error {
-	cmd := exec.Command(command, args...)
+	return RunContext(context.Background(), command, args...)
+}
+
+// RunContext 执行外部命令, ctx取消时结束进程
+func RunContext(ctx context.Context, command string, args ...string) error {
+	cmd := exec.CommandContext(ctx, command, args...)
+	// 外部命令的输出都是提示信息, 写到标准错误, 不影响-json等标准输出中的结果
 	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = os.Stderr
 	return cmd.Run()
 }
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,7 +22,6 @@ func LookKindlegen() string {
 		if exist, _ := IsExists(kindlegen); !exist {
 			return ""
 		}
-		fmt.Println("kindlegen: ", kindlegen)
 	}
 	return kindlegen
 }
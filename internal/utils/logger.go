@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Logger 转换过程中的提示信息和警告, 识别章节和生成电子书时通过它输出, 不直接打印到标准输出
+type Logger interface {
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// StdoutLogger 打印到标准输出, 没有设置Logger时使用
+var StdoutLogger Logger = stdoutLogger{}
+
+// stdoutLogger 每次输出时才取os.Stdout, 临时替换os.Stdout也能生效
+type stdoutLogger struct{}
+
+func (stdoutLogger) Infof(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func (stdoutLogger) Warnf(format string, args ...any) {
+	fmt.Printf("警告: "+format+"\n", args...)
+}
+
+// WriterLogger 写到W, 如标准错误
+type WriterLogger struct {
+	W io.Writer
+}
+
+func (l WriterLogger) Infof(format string, args ...any) {
+	fmt.Fprintf(l.W, format+"\n", args...)
+}
+
+func (l WriterLogger) Warnf(format string, args ...any) {
+	fmt.Fprintf(l.W, "警告: "+format+"\n", args...)
+}
+
+// NopLogger 不输出任何信息
+type NopLogger struct{}
+
+func (NopLogger) Infof(format string, args ...any) {}
+
+func (NopLogger) Warnf(format string, args ...any) {}
+
+// WarningCollector 记录警告, 并转发给Next; Next为nil时丢弃提示信息。可以在多个goroutine中使用
+type WarningCollector struct {
+	Next     Logger
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (l *WarningCollector) Infof(format string, args ...any) {
+	if l.Next != nil {
+		l.Next.Infof(format, args...)
+	}
+}
+
+func (l *WarningCollector) Warnf(format string, args ...any) {
+	l.mu.Lock()
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+	if l.Next != nil {
+		l.Next.Warnf(format, args...)
+	}
+}
+
+// Warnings 返回已记录的警告的副本
+func (l *WarningCollector) Warnings() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.warnings...)
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+var (
+	chineseDigits = map[rune]int{
+		'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4,
+		'五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+	}
+	chineseUnits = map[rune]int{'十': 10, '百': 100, '千': 1000}
+	romanValues  = []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+)
+
+// ParseChineseNumber 解析中文数字, 如"一百零五"、"两千"、"十二"、"二〇二一", 忽略空格
+func ParseChineseNumber(s string) (int, bool) {
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return 0, false
+	}
+	// 没有单位时按位读, 如"二〇二一"
+	if !strings.ContainsAny(s, "十百千万") {
+		var n int
+		for _, r := range s {
+			d, ok := chineseDigits[r]
+			if !ok {
+				return 0, false
+			}
+			n = n*10 + d
+		}
+		return n, true
+	}
+	var total, section int
+	digit := -1
+	for _, r := range s {
+		if d, ok := chineseDigits[r]; ok {
+			digit = d
+			continue
+		}
+		if unit, ok := chineseUnits[r]; ok {
+			// "十五"的十前面没有数字
+			if digit < 0 {
+				digit = 1
+			}
+			section += digit * unit
+			digit = -1
+			continue
+		}
+		if r != '万' {
+			return 0, false
+		}
+		if digit > 0 {
+			section += digit
+		}
+		if section == 0 {
+			return 0, false
+		}
+		total += section * 10000
+		section, digit = 0, -1
+	}
+	if digit > 0 {
+		section += digit
+	}
+	return total + section, true
+}
+
+// FormatChineseNumber 把数字转为中文数字, 如105为"一百零五", 10为"十"
+func FormatChineseNumber(n int) string {
+	if n < 0 {
+		return "负" + FormatChineseNumber(-n)
+	}
+	if n == 0 {
+		return "零"
+	}
+	if n < 10000 {
+		return formatChineseSection(n, false)
+	}
+	s := FormatChineseNumber(n/10000) + "万"
+	low := n % 10000
+	if low == 0 {
+		return s
+	}
+	if low < 1000 {
+		s += "零"
+	}
+	return s + formatChineseSection(low, true)
+}
+
+// formatChineseSection 转换10000以内的数字, inner为true时10-19写作"一十"
+func formatChineseSection(n int, inner bool) string {
+	const digits = "零一二三四五六七八九"
+	var b strings.Builder
+	var started, zero bool
+	for i, unit := range []string{"千", "百", "十", ""} {
+		d := n / []int{1000, 100, 10, 1}[i] % 10
+		if d == 0 {
+			zero = started
+			continue
+		}
+		if zero {
+			b.WriteString("零")
+			zero = false
+		}
+		if !(unit == "十" && d == 1 && !started && !inner) {
+			b.WriteString(string([]rune(digits)[d]))
+		}
+		b.WriteString(unit)
+		started = true
+	}
+	return b.String()
+}
+
+// ParseRoman 解析罗马数字, 不区分大小写, 只接受规范写法, 如"IV"而不是"IIII"
+func ParseRoman(s string) (int, bool) {
+	s = strings.ToUpper(s)
+	var n int
+	rest := s
+	for _, v := range romanValues {
+		for strings.HasPrefix(rest, v.symbol) {
+			n += v.value
+			rest = rest[len(v.symbol):]
+		}
+	}
+	if rest != "" || n == 0 || FormatRoman(n) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// FormatRoman 把数字转为罗马数字, 超出1-3999时使用阿拉伯数字
+func FormatRoman(n int) string {
+	if n < 1 || n > 3999 {
+		return strconv.Itoa(n)
+	}
+	var b strings.Builder
+	for _, v := range romanValues {
+		for n >= v.value {
+			b.WriteString(v.symbol)
+			n -= v.value
+		}
+	}
+	return b.String()
+}
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/Deali-Axy/ebook-generator/internal/epubcheck"
 )
 
 // FileValidationResult 文件验证结果
@@ -194,6 +196,24 @@ func (fv *FileValidator) validateEPUBFile(file multipart.File, result *FileValid
 		return result, nil
 	}
 
+	// 检查是否符合EPUB规范
+	report, err := epubcheck.Check(file, result.Size)
+	if err != nil {
+		result.Error = fmt.Sprintf("检查EPUB失败: %v", err)
+		return result, nil
+	}
+	for _, f := range report.Warnings() {
+		result.Warnings = append(result.Warnings, f.String())
+	}
+	if errs := report.Errors(); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, f := range errs {
+			messages[i] = f.String()
+		}
+		result.Error = "文件不符合EPUB规范: " + strings.Join(messages, "; ")
+		return result, nil
+	}
+
 	result.IsValid = true
 	return result, nil
 }
@@ -0,0 +1,53 @@
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// HistoryRecorder 把收件目录的转换记录到转换历史
+type HistoryRecorder struct {
+	history *services.HistoryService
+	userID  uint
+	format  string
+	options map[string]interface{}
+}
+
+// ErrNoUser 没有指定记录转换历史的用户
+var ErrNoUser = errors.New("记录转换历史需要指定用户ID")
+
+// NewHistoryRecorder 创建转换历史记录器, 记录到userID用户名下, options为记录的转换选项
+func NewHistoryRecorder(history *services.HistoryService, userID uint, format string, options map[string]interface{}) (*HistoryRecorder, error) {
+	if userID == 0 {
+		return nil, ErrNoUser
+	}
+	return &HistoryRecorder{history: history, userID: userID, format: format, options: options}, nil
+}
+
+// Start 创建转换中的历史记录
+func (r *HistoryRecorder) Start(job *Job) error {
+	_, err := r.history.CreateHistory(r.userID, job.ID, filepath.Base(job.File), job.Size, job.Hash, r.format, r.options)
+	return err
+}
+
+// Finish 记录转换结果, 输出文件记录第一个, 大小为所有输出文件之和
+func (r *HistoryRecorder) Finish(job *Job) error {
+	if job.Result.Status == batch.StatusFailed {
+		return r.history.UpdateHistoryStatus(job.ID, "failed", job.Result.Error)
+	}
+	var name string
+	var size int64
+	for i, output := range job.Result.Outputs {
+		if i == 0 {
+			name = filepath.Base(output)
+		}
+		if info, err := os.Stat(output); err == nil {
+			size += info.Size()
+		}
+	}
+	return r.history.CompleteHistory(job.ID, name, size)
+}
@@ -0,0 +1,321 @@
+// Package watch 监视收件目录, 自动转换放入其中的txt
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// Options 监视收件目录的参数
+type Options struct {
+	Book      model.Book                       // 转换参数, Filename、Bookname和Out由每个文件决定
+	Inbox     string                           // 收件目录, 不检查子目录
+	OutDir    string                           // 输出目录, 为空时为收件目录下的out
+	DoneDir   string                           // 转换成功的txt移到这个目录, 为空时为收件目录下的done
+	FailedDir string                           // 转换失败的txt移到这个目录, 为空时为收件目录下的failed
+	Interval  time.Duration                    // 检查收件目录的间隔, 为0时为2秒
+	Settle    time.Duration                    // 文件大小和修改时间多久不变后认为已经写完, 为0时为5秒
+	Version   string                           // 软件版本
+	Recorder  Recorder                         // 记录每个文件的转换, 可以为nil
+	Logf      func(format string, args ...any) // 输出日志, 为nil时不输出
+}
+
+// Job 收件目录中一个txt的转换
+type Job struct {
+	ID     string       // 任务ID, 以watch_开头
+	File   string       // 收件目录中的txt
+	Size   int64        // txt的大小
+	Hash   string       // txt的SHA-256
+	Start  time.Time    // 开始转换的时间
+	Result batch.Result // 转换结果, Finish时才有
+	Moved  string       // txt移到的位置, 移动失败时为空
+}
+
+// Recorder 记录转换, 例如写入数据库的转换历史
+type Recorder interface {
+	Start(job *Job) error  // 开始转换时调用
+	Finish(job *Job) error // 转换完成并移动txt后调用
+}
+
+// file 收件目录中等待写完的文件
+type file struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // 大小和修改时间从这时起没有变化
+}
+
+// Watcher 收件目录监视器
+type Watcher struct {
+	opts    Options
+	pending map[string]*file     // 等待写完的文件
+	stuck   map[string]time.Time // 转换后没能移走的文件和当时的修改时间, 修改前不再转换
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New 创建收件目录监视器, 会创建输出目录和done、failed目录
+func New(opts Options) (*Watcher, error) {
+	if opts.Inbox == "" {
+		return nil, errors.New("没有设置收件目录")
+	}
+	info, err := os.Stat(opts.Inbox)
+	if err != nil {
+		return nil, fmt.Errorf("读取收件目录失败: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s 不是目录", opts.Inbox)
+	}
+	if opts.OutDir == "" {
+		opts.OutDir = filepath.Join(opts.Inbox, "out")
+	}
+	if opts.DoneDir == "" {
+		opts.DoneDir = filepath.Join(opts.Inbox, "done")
+	}
+	if opts.FailedDir == "" {
+		opts.FailedDir = filepath.Join(opts.Inbox, "failed")
+	}
+	for _, dir := range []string{opts.OutDir, opts.DoneDir, opts.FailedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建目录失败: %w", err)
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 2 * time.Second
+	}
+	if opts.Settle <= 0 {
+		opts.Settle = 5 * time.Second
+	}
+	return &Watcher{
+		opts:    opts,
+		pending: make(map[string]*file),
+		stuck:   make(map[string]time.Time),
+	}, nil
+}
+
+// Options 补全默认值后的参数
+func (w *Watcher) Options() Options {
+	return w.opts
+}
+
+// Run 监视收件目录直到ctx取消, 取消时正在转换的文件会转换完
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		w.Scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start 在后台监视收件目录, 用Stop停止
+func (w *Watcher) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.Run(ctx)
+	}()
+}
+
+// Stop 停止后台监视, 等待正在转换的文件完成
+func (w *Watcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// Scan 检查一次收件目录, 转换已经写完的txt, 返回这次转换的文件
+func (w *Watcher) Scan(ctx context.Context) []*Job {
+	entries, err := os.ReadDir(w.opts.Inbox)
+	if err != nil {
+		w.logf("读取收件目录失败: %v", err)
+		return nil
+	}
+	now := time.Now()
+	seen := make(map[string]bool)
+	var ready []string
+	for _, entry := range entries {
+		name := entry.Name()
+		// 跳过隐藏文件和编辑器、下载工具的临时文件
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") ||
+			!strings.EqualFold(filepath.Ext(name), ".txt") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(w.opts.Inbox, name)
+		seen[path] = true
+		if modTime, ok := w.stuck[path]; ok {
+			if modTime.Equal(info.ModTime()) {
+				continue
+			}
+			delete(w.stuck, path)
+		}
+		f := w.pending[path]
+		if f == nil || f.size != info.Size() || !f.modTime.Equal(info.ModTime()) {
+			w.pending[path] = &file{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(f.since) >= w.opts.Settle {
+			ready = append(ready, path)
+		}
+	}
+	for path := range w.pending {
+		if !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+	for path := range w.stuck {
+		if !seen[path] {
+			delete(w.stuck, path)
+		}
+	}
+
+	sort.Strings(ready)
+	var jobs []*Job
+	for _, path := range ready {
+		if ctx.Err() != nil {
+			break
+		}
+		delete(w.pending, path)
+		jobs = append(jobs, w.process(ctx, path))
+	}
+	return jobs
+}
+
+// process 转换一个txt, 然后移到done或failed目录
+func (w *Watcher) process(ctx context.Context, path string) *Job {
+	job := &Job{
+		ID:    fmt.Sprintf("watch_%d", time.Now().UnixNano()),
+		File:  path,
+		Start: time.Now(),
+	}
+	if info, err := os.Stat(path); err == nil {
+		job.Size = info.Size()
+	}
+	job.Hash, _ = hashFile(path)
+	if w.opts.Recorder != nil {
+		if err := w.opts.Recorder.Start(job); err != nil {
+			w.logf("记录转换历史失败: %v", err)
+		}
+	}
+
+	w.logf("开始转换: %s", path)
+	opts := batch.Options{Book: w.opts.Book, Force: true, Version: w.opts.Version}
+	// 停止监视时让正在转换的文件转换完, 避免被当作失败
+	job.Result = batch.Convert(context.WithoutCancel(ctx), opts, path, w.opts.OutDir)
+
+	dir := w.opts.DoneDir
+	if job.Result.Status == batch.StatusFailed {
+		dir = w.opts.FailedDir
+	}
+	modTime := time.Time{}
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	moved, err := move(path, dir)
+	if err != nil {
+		w.stuck[path] = modTime
+		w.logf("移动文件失败, 文件修改前不再转换: %v", err)
+	} else {
+		job.Moved = moved
+	}
+	if job.Result.Status == batch.StatusFailed {
+		w.logf("转换失败: %s: %s", path, job.Result.Error)
+		if moved != "" {
+			reason := strings.TrimSuffix(moved, filepath.Ext(moved)) + ".log"
+			if err := os.WriteFile(reason, []byte(job.Result.Error+"\n"), 0644); err != nil {
+				w.logf("保存失败原因失败: %v", err)
+			}
+		}
+	} else {
+		w.logf("转换完成: %s, %d章, 用时%.1fs", path, job.Result.Chapters, job.Result.Seconds)
+	}
+
+	if w.opts.Recorder != nil {
+		if err := w.opts.Recorder.Finish(job); err != nil {
+			w.logf("记录转换历史失败: %v", err)
+		}
+	}
+	return job
+}
+
+func (w *Watcher) logf(format string, args ...any) {
+	if w.opts.Logf != nil {
+		w.opts.Logf(format, args...)
+	}
+}
+
+// hashFile 计算文件的SHA-256
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// move 把文件移到dir中, 重名时在文件名后加上时间, 返回新的路径
+func move(path, dir string) (string, error) {
+	name := filepath.Base(path)
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		ext := filepath.Ext(name)
+		target = filepath.Join(dir, fmt.Sprintf("%s_%s%s", strings.TrimSuffix(name, ext), time.Now().Format("20060102150405"), ext))
+	}
+	if err := os.Rename(path, target); err == nil {
+		return target, nil
+	}
+	// 不在同一个文件系统时改为复制后删除
+	if err := copyFile(path, target); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		os.Remove(target)
+		return "", err
+	}
+	return target, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
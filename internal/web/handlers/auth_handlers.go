@@ -379,6 +379,19 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// OptionalAuthMiddleware 可选的JWT认证中间件, 没有token时匿名访问, 有token时必须有效
+// 用于匿名也可以使用的接口, 登录后使用用户的预设等功能
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 没有数据库时不能登录
+		if c.GetHeader("Authorization") == "" || authService == nil {
+			c.Next()
+			return
+		}
+		AuthMiddleware()(c)
+	}
+}
+
 // getUserIDFromContext 从上下文获取用户ID
 func getUserIDFromContext(c *gin.Context) (uint, error) {
 	userIDInterface, exists := c.Get("user_id")
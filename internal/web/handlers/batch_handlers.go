@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 全局批量转换服务实例
+var batchService *services.BatchService
+
+// InitBatchService 初始化批量转换服务
+func InitBatchService(bs *services.BatchService) {
+	batchService = bs
+}
+
+// BatchConvert 批量转换
+// @Summary 批量转换
+// @Description 上传多个txt并分别转换, 每个文件一个任务; request为JSON格式的批量转换参数, 没有files时转换所有上传的文件, 预设的选项被common_options覆盖, common_options被custom_options覆盖
+// @Tags 批量转换
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param files formData file true "txt文件, 可以有多个"
+// @Param request formData string false "批量转换参数, 格式同models.BatchConversionRequest"
+// @Param output_format formData string false "输出格式, 没有request时使用"
+// @Param book_title formData string false "书名, 没有request时使用"
+// @Param author formData string false "作者, 没有request时使用"
+// @Param preset_id formData int false "转换预设ID, 没有request时使用, 为空时使用默认预设"
+// @Success 200 {object} models.APIResponse{data=models.BatchConversionResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /batch/convert [post]
+func BatchConvert(c *gin.Context) {
+	// 获取用户ID
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Code:    401,
+			Message: "用户未认证",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["files"]) == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请上传要转换的文件",
+			Error:   "files is required",
+		})
+		return
+	}
+	files := form.File["files"]
+
+	// 绑定请求参数
+	var req models.BatchConversionRequest
+	if data := c.PostForm("request"); data != "" {
+		if err := json.Unmarshal([]byte(data), &req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    400,
+				Message: "请求参数错误",
+				Error:   err.Error(),
+			})
+			return
+		}
+	} else {
+		req.OutputFormat = c.PostForm("output_format")
+		req.BookTitle = c.PostForm("book_title")
+		req.Author = c.PostForm("author")
+		if id := c.PostForm("preset_id"); id != "" {
+			presetID, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.APIResponse{
+					Code:    400,
+					Message: "无效的预设ID",
+					Error:   err.Error(),
+				})
+				return
+			}
+			req.PresetID = uint(presetID)
+		}
+	}
+	if len(req.Files) == 0 {
+		for _, file := range files {
+			req.Files = append(req.Files, models.BatchFileInfo{FileName: file.Filename, FileSize: file.Size})
+		}
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	response, err := batchService.CreateBatch(userID, &req, files)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "创建批量转换失败",
+			Data:    response,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "批量转换任务已创建",
+		Data:    response,
+	})
+}
+
+// GetBatchStatus 获取批量转换状态
+// @Summary 查询批量转换状态
+// @Description 汇总批次中每个文件的转换状态
+// @Tags 批量转换
+// @Produce json
+// @Security BearerAuth
+// @Param batchId path string true "批次ID"
+// @Success 200 {object} models.APIResponse{data=models.BatchStatusResponse}
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /batch/{batchId} [get]
+func GetBatchStatus(c *gin.Context) {
+	status, ok := batchStatus(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "获取状态成功",
+		Data:    status,
+	})
+}
+
+// GetBatchEvents SSE接口，实时获取批量转换的汇总状态
+// @Summary 获取批量转换事件流
+// @Description 批次状态变化时发送事件, data中的batch为汇总状态, 所有文件结束后发送complete事件并关闭
+// @Tags 批量转换
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param batchId path string true "批次ID"
+// @Success 200 {string} string "SSE事件流"
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /batch/{batchId}/events [get]
+func GetBatchEvents(c *gin.Context) {
+	status, ok := batchStatus(c)
+	if !ok {
+		return
+	}
+	userID, _ := getUserIDFromContext(c)
+
+	// 设置SSE响应头
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "Streaming not supported",
+		})
+		return
+	}
+
+	// 批次的状态由各任务汇总, 定时检查, 有变化时发送
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastSent := time.Now()
+	var last []byte
+	for {
+		current, _ := json.Marshal(status)
+		if string(current) != string(last) {
+			last = current
+			lastSent = time.Now()
+			event := batchEvent(status)
+			jsonData, _ := json.Marshal(event)
+			fmt.Fprintf(c.Writer, "data: %s\n\n", string(jsonData))
+			flusher.Flush()
+			if event.EventType == models.EventTypeComplete {
+				return
+			}
+		} else if time.Since(lastSent) >= 30*time.Second {
+			// 超时，发送心跳
+			lastSent = time.Now()
+			fmt.Fprintf(c.Writer, "event: ping\ndata: heartbeat\n\n")
+			flusher.Flush()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.Request.Context().Done():
+			// 客户端断开连接
+			return
+		}
+		next, err := batchService.GetBatchStatus(userID, status.BatchID)
+		if err != nil {
+			return
+		}
+		status = next
+	}
+}
+
+// batchEvent 批次状态对应的事件
+func batchEvent(status *models.BatchStatusResponse) models.TaskEvent {
+	event := models.TaskEvent{
+		TaskID:    status.BatchID,
+		EventType: models.EventTypeProgress,
+		Message:   fmt.Sprintf("已完成%d/%d个文件", status.Completed+status.Failed, status.TotalFiles),
+		Progress:  status.Progress,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"batch": status},
+	}
+	switch status.Status {
+	case models.TaskStatusCompleted, models.TaskStatusFailed, models.BatchStatusPartial:
+		event.EventType = models.EventTypeComplete
+		event.Message = fmt.Sprintf("批量转换结束, 成功%d个, 失败%d个", status.Completed, status.Failed)
+	}
+	return event
+}
+
+// DownloadBatch 下载批量转换的所有结果
+// @Summary 下载批量转换结果
+// @Description 把批次中转换成功的电子书打包成zip下载, 还在转换的文件不包含在内
+// @Tags 批量转换
+// @Produce application/zip
+// @Security BearerAuth
+// @Param batchId path string true "批次ID"
+// @Success 200 {file} binary
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /batch/{batchId}/download [get]
+func DownloadBatch(c *gin.Context) {
+	status, ok := batchStatus(c)
+	if !ok {
+		return
+	}
+	if status.Completed == 0 {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    404,
+			Message: "没有转换成功的文件",
+			Error:   "No completed files",
+		})
+		return
+	}
+	userID, _ := getUserIDFromContext(c)
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", status.BatchID))
+	c.Header("Content-Type", "application/zip")
+	if err := batchService.WriteZip(userID, status.BatchID, c.Writer); err != nil {
+		// 已经开始发送文件, 只能中断连接
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// batchStatus 读取当前用户的批次状态, 失败时返回错误响应
+func batchStatus(c *gin.Context) (*models.BatchStatusResponse, bool) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Code:    401,
+			Message: "用户未认证",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+
+	status, err := batchService.GetBatchStatus(userID, c.Param("batchId"))
+	if errors.Is(err, services.ErrBatchNotFound) {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    404,
+			Message: "批次不存在",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "获取批次状态失败",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	return status, true
+}
@@ -3,15 +3,19 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Deali-Axy/ebook-generator/internal/model"
 	"github.com/Deali-Axy/ebook-generator/internal/storage"
 	"github.com/Deali-Axy/ebook-generator/internal/web/models"
 	"github.com/Deali-Axy/ebook-generator/internal/web/services"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // 全局服务实例
@@ -90,15 +94,141 @@ func UploadFile(c *gin.Context) {
 // @Router /convert [post]
 func ConvertBook(c *gin.Context) {
 	var req models.ConvertRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if !bindConvertRequest(c, &req) {
+		return
+	}
+
+	startConversion(c, &req, nil)
+}
+
+// MergeBooks 合并多个txt
+// @Summary 合并txt文件
+// @Description 把多个已上传的txt按顺序合并成一本书, 每个txt为一卷, 卷名为上传时的文件名
+// @Tags 转换管理
+// @Accept json
+// @Produce json
+// @Param request body models.MergeRequest true "合并参数"
+// @Success 200 {object} models.APIResponse{data=models.ConvertResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /merge [post]
+func MergeBooks(c *gin.Context) {
+	var req models.MergeRequest
+	if !bindConvertRequest(c, &req) {
+		return
+	}
+	startConversion(c, &req.ConvertRequest, req.Sources)
+}
+
+// SplitBook 分册转换
+// @Summary 分册转换
+// @Description 把已上传的txt按卷、章节数、大小或章节范围分成多册生成
+// @Tags 转换管理
+// @Accept json
+// @Produce json
+// @Param request body models.SplitRequest true "分册参数"
+// @Success 200 {object} models.APIResponse{data=models.ConvertResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /split [post]
+func SplitBook(c *gin.Context) {
+	var req models.SplitRequest
+	if !bindConvertRequest(c, &req) {
+		return
+	}
+	req.Split = req.By
+	if req.Split == model.SplitRange {
+		if _, err := model.ParseRanges(req.SplitRanges); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    400,
+				Message: "章节范围错误",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	startConversion(c, &req.ConvertRequest, nil)
+}
+
+// bindConvertRequest 绑定转换请求, 先使用预设的选项, 再用请求中的字段覆盖
+// 没有指定preset_id时登录用户使用自己的默认预设, 请求错误时返回错误响应和false
+func bindConvertRequest(c *gin.Context, obj any) bool {
+	body, err := c.GetRawData()
+	var fields map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(body, &fields)
+	}
+	var ref struct {
+		PresetID uint `json:"preset_id"`
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &ref)
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Code:    400,
 			Message: "请求参数错误",
 			Error:   err.Error(),
 		})
-		return
+		return false
+	}
+
+	preset, err := resolvePreset(c, ref.PresetID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "读取预设失败",
+			Error:   err.Error(),
+		})
+		return false
+	}
+	options := make(map[string]interface{})
+	if preset != nil {
+		options = preset.ConvertOptions()
+	}
+	maps.Copy(options, fields)
+	if preset != nil {
+		// 记录实际使用的预设, 包括默认预设
+		options["preset_id"] = preset.ID
+	}
+	return decodeConvertRequest(c, options, obj)
+}
+
+// decodeConvertRequest 把转换选项解析为请求并验证, 请求错误时返回错误响应和false
+func decodeConvertRequest(c *gin.Context, options map[string]interface{}, obj any) bool {
+	data, err := json.Marshal(options)
+	if err == nil {
+		err = json.Unmarshal(data, obj)
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(obj)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return false
 	}
+	return true
+}
 
+// resolvePreset 获取请求使用的预设, 没有数据库时不能使用预设
+func resolvePreset(c *gin.Context, presetID uint) (*models.ConversionPreset, error) {
+	if historyService == nil {
+		if presetID != 0 {
+			return nil, fmt.Errorf("使用预设需要数据库")
+		}
+		return nil, nil
+	}
+	// 匿名用户的ID为0, 只能使用公开的预设
+	userID, _ := getUserIDFromContext(c)
+	return historyService.ResolvePreset(userID, presetID)
+}
+
+// startConversion 检查参数和上传的文件后开始转换, sources不为空时为合并任务
+func startConversion(c *gin.Context, req *models.ConvertRequest, sources []string) {
 	// 验证格式
 	if !converterService.ValidateFormat(req.Format) {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -119,18 +249,29 @@ func ConvertBook(c *gin.Context) {
 		return
 	}
 
-	// 检查上传文件是否存在
-	if _, err := storageService.GetUploadedFilePath(req.TaskID); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Code:    400,
-			Message: "未找到上传文件",
-			Error:   err.Error(),
-		})
-		return
+	// 检查上传文件是否存在, 合并任务检查所有源文件
+	uploads := sources
+	if len(uploads) == 0 {
+		uploads = []string{req.TaskID}
+	}
+	for _, id := range uploads {
+		if _, err := storageService.GetUploadedFilePath(id); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    400,
+				Message: "未找到上传文件",
+				Error:   err.Error(),
+			})
+			return
+		}
 	}
 
-	// 创建任务
-	task := taskService.CreateTask(req.TaskID, &req)
+	// 创建任务, 登录用户的任务记录到转换历史
+	userID, _ := getUserIDFromContext(c)
+	if len(sources) > 0 {
+		taskService.CreateMergeTask(req.TaskID, userID, req, sources)
+	} else {
+		taskService.CreateUserTask(req.TaskID, userID, req)
+	}
 
 	// 开始转换
 	if err := taskService.StartConversion(req.TaskID); err != nil {
@@ -142,14 +283,31 @@ func ConvertBook(c *gin.Context) {
 		return
 	}
 
+	if req.PresetID != 0 && historyService != nil {
+		if err := historyService.UsePreset(req.PresetID); err != nil {
+			log.Printf("更新预设 %d 的使用次数失败: %v", req.PresetID, err)
+		}
+	}
+
+	// 任务已在后台执行, 通过任务服务读取当前状态
+	status, err := taskService.GetTaskStatus(req.TaskID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "获取任务状态失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Code:    200,
 		Message: "转换任务已开始",
 		Data: models.ConvertResponse{
-			TaskID:    task.ID,
-			Status:    task.Status,
-			Message:   task.Message,
-			StartedAt: task.StartedAt.Format(time.RFC3339),
+			TaskID:    status.TaskID,
+			Status:    status.Status,
+			Message:   status.Message,
+			StartedAt: status.StartedAt,
 		},
 	})
 }
@@ -224,6 +382,11 @@ func DownloadFile(c *gin.Context) {
 		return
 	}
 
+	sendFile(c, storageService.TaskIDFromFileID(fileID), file)
+}
+
+// sendFile 发送转换后的文件, 任务有转换历史时记录下载
+func sendFile(c *gin.Context, taskID string, file *models.ConvertedFile) {
 	// 设置响应头
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
@@ -233,6 +396,22 @@ func DownloadFile(c *gin.Context) {
 
 	// 发送文件
 	c.File(file.Path)
+
+	recordDownload(c, taskID, file)
+}
+
+// recordDownload 记录下载到任务所属用户的转换历史, 匿名任务没有转换历史
+func recordDownload(c *gin.Context, taskID string, file *models.ConvertedFile) {
+	if historyService == nil || taskID == "" || c.Writer.Status() != http.StatusOK {
+		return
+	}
+	history, err := historyService.GetHistoryByTaskID(taskID)
+	if err != nil {
+		return
+	}
+	if err := historyService.RecordDownload(history.UserID, taskID, file.Filename, file.Size, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		log.Printf("记录下载失败: %v", err)
+	}
 }
 
 // CleanupTask 清理任务
@@ -294,7 +473,8 @@ func GetTaskEvents(c *gin.Context) {
 	}
 
 	// 检查任务是否存在
-	if _, exists := taskService.GetTask(taskID); !exists {
+	status, err := taskService.GetTaskStatus(taskID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, models.APIResponse{
 			Code:    404,
 			Message: "任务不存在",
@@ -303,8 +483,16 @@ func GetTaskEvents(c *gin.Context) {
 		return
 	}
 
-	// 获取事件通道
+	// 获取事件通道, 已结束的任务(包括服务重启前结束的)只发送最后的状态
 	eventChan, exists := taskService.GetEventChannel(taskID)
+	if !exists && isFinished(status.Status) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Access-Control-Allow-Origin", "*")
+		jsonData, _ := json.Marshal(finalEvent(status))
+		fmt.Fprintf(c.Writer, "data: %s\n\n", string(jsonData))
+		return
+	}
 	if !exists {
 		c.JSON(http.StatusNotFound, models.APIResponse{
 			Code:    404,
@@ -355,3 +543,31 @@ func GetTaskEvents(c *gin.Context) {
 		}
 	}
 }
+
+// isFinished 任务是否已结束
+func isFinished(status string) bool {
+	return status == models.TaskStatusCompleted || status == models.TaskStatusFailed || status == models.TaskStatusCancelled
+}
+
+// finalEvent 已结束任务的最后一个事件, 和任务结束时发送的事件相同
+func finalEvent(status *models.TaskStatusResponse) models.TaskEvent {
+	event := models.TaskEvent{
+		TaskID:    status.TaskID,
+		Message:   status.Message,
+		Progress:  status.Progress,
+		Timestamp: time.Now(),
+	}
+	switch status.Status {
+	case models.TaskStatusCompleted:
+		event.EventType = models.EventTypeComplete
+		event.Data = map[string]interface{}{"files": status.Files}
+	case models.TaskStatusCancelled:
+		event.EventType = models.EventTypeCancel
+	default:
+		event.EventType = models.EventTypeError
+		if status.Error != "" {
+			event.Message = status.Message + ": " + status.Error
+		}
+	}
+	return event
+}
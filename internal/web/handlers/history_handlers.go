@@ -3,9 +3,9 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/Deali-Axy/ebook-generator/internal/web/models"
 	"github.com/Deali-Axy/ebook-generator/internal/web/services"
@@ -184,6 +184,201 @@ func DeleteHistory(c *gin.Context) {
 	})
 }
 
+// GetHistory 获取转换历史详情
+// @Summary 获取转换历史详情
+// @Description 获取一条转换历史和还可以下载的输出文件, 文件被清理后files为空
+// @Tags 转换历史
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "历史记录ID"
+// @Success 200 {object} models.APIResponse{data=models.HistoryDetailResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /history/{id} [get]
+func GetHistory(c *gin.Context) {
+	history, ok := userHistory(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "获取成功",
+		Data: models.HistoryDetailResponse{
+			ConversionHistory: *history,
+			Files:             historyFiles(history),
+		},
+	})
+}
+
+// RerunHistory 重新转换
+// @Summary 重新转换
+// @Description 使用转换历史中的原文件和转换选项重新转换, 请求中的字段覆盖原来的选项, 原文件被清理后不能重新转换
+// @Tags 转换历史
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "历史记录ID"
+// @Param request body object false "覆盖的转换选项, 字段同models.ConvertRequest"
+// @Success 200 {object} models.APIResponse{data=models.ConvertResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /history/{id}/rerun [post]
+func RerunHistory(c *gin.Context) {
+	history, ok := userHistory(c)
+	if !ok {
+		return
+	}
+
+	// 绑定请求参数, 可以没有请求体
+	var fields map[string]interface{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&fields); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    400,
+				Message: "请求参数错误",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	options := maps.Clone(map[string]interface{}(history.ConvertOptions))
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	// 合并任务重新合并原来的源文件, 源文件还在上传目录中
+	var sources []string
+	if list, ok := options["sources"].([]interface{}); ok {
+		for _, source := range list {
+			sources = append(sources, fmt.Sprint(source))
+		}
+	}
+	delete(options, "sources")
+	maps.Copy(options, fields)
+	options["task_id"] = generateTaskID()
+
+	var req models.ConvertRequest
+	if !decodeConvertRequest(c, options, &req) {
+		return
+	}
+
+	// 单个文件的任务复制原文件给新任务
+	if len(sources) == 0 {
+		if _, err := storageService.CopyUploadedFile(history.TaskID, req.TaskID); err != nil {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Code:    404,
+				Message: "原文件已被清理, 请重新上传",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	startConversion(c, &req, sources)
+}
+
+// DownloadHistory 重新下载
+// @Summary 下载转换历史的文件
+// @Description 下载转换历史的输出文件, 没有file_id时下载第一个文件
+// @Tags 转换历史
+// @Produce application/octet-stream
+// @Security BearerAuth
+// @Param id path int true "历史记录ID"
+// @Param file_id query string false "文件ID"
+// @Success 200 {file} binary
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /history/{id}/download [get]
+func DownloadHistory(c *gin.Context) {
+	history, ok := userHistory(c)
+	if !ok {
+		return
+	}
+
+	fileID := c.Query("file_id")
+	for _, file := range historyFiles(history) {
+		if fileID == "" || file.FileID == fileID {
+			sendFile(c, history.TaskID, &file)
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, models.APIResponse{
+		Code:    404,
+		Message: "文件不存在或已被清理",
+		Error:   "File not found",
+	})
+}
+
+// userHistory 读取当前用户的转换历史, 失败时返回错误响应
+func userHistory(c *gin.Context) (*models.ConversionHistory, bool) {
+	// 获取用户ID
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Code:    401,
+			Message: "用户未认证",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+
+	// 获取历史记录ID
+	historyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "无效的历史记录ID",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+
+	history, err := historyService.GetUserHistory(userID, uint(historyID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    404,
+			Message: "历史记录不存在",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "获取历史记录失败",
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	return history, true
+}
+
+// historyFiles 转换历史中还存在的输出文件
+func historyFiles(history *models.ConversionHistory) []models.ConvertedFile {
+	status, err := taskService.GetTaskStatus(history.TaskID)
+	if err != nil {
+		return []models.ConvertedFile{}
+	}
+	files := []models.ConvertedFile{}
+	for _, file := range status.Files {
+		current, err := storageService.GetConvertedFile(file.FileID)
+		if err != nil {
+			continue
+		}
+		// 文件名使用转换时的文件名
+		current.Filename = file.Filename
+		files = append(files, *current)
+	}
+	return files
+}
+
 // CreatePreset 创建转换预设
 // @Summary 创建转换预设
 // @Description 创建新的转换预设配置
@@ -471,20 +666,66 @@ func DeletePreset(c *gin.Context) {
 	})
 }
 
-// BatchConvert 批量转换
-// @Summary 批量转换
-// @Description 批量转换多个文件
-// @Tags 批量转换
+// GetPublicPresets 获取公开的转换预设列表
+// @Summary 浏览公开的转换预设
+// @Description 获取内置预设和其他用户公开的预设, 内置预设在前, 其余按使用次数排序, 不需要登录
+// @Tags 转换预设
+// @Accept json
+// @Produce json
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param format query string false "输出格式"
+// @Param keyword query string false "关键词搜索"
+// @Success 200 {object} models.APIResponse{data=[]models.ConversionPreset}
+// @Failure 400 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /presets/public [get]
+func GetPublicPresets(c *gin.Context) {
+	// 绑定查询参数, 没有分页参数时使用默认值
+	req := models.PresetListRequest{Page: 1, PageSize: 20}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 获取预设列表
+	presets, err := historyService.GetPublicPresets(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "获取预设列表失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "获取成功",
+		Data:    presets,
+	})
+}
+
+// ClonePreset 复制转换预设
+// @Summary 复制转换预设
+// @Description 把自己的或公开的预设复制为自己的预设, 复制后可以修改
+// @Tags 转换预设
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param request body models.BatchConversionRequest true "批量转换请求"
-// @Success 200 {object} models.APIResponse{data=models.BatchConversionResponse}
+// @Param id path int true "预设ID"
+// @Param request body models.PresetCloneRequest false "新预设的名称"
+// @Success 200 {object} models.APIResponse{data=models.ConversionPreset}
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
-// @Router /convert/batch [post]
-func BatchConvert(c *gin.Context) {
+// @Router /presets/{id}/clone [post]
+func ClonePreset(c *gin.Context) {
 	// 获取用户ID
 	userID, err := getUserIDFromContext(c)
 	if err != nil {
@@ -496,41 +737,53 @@ func BatchConvert(c *gin.Context) {
 		return
 	}
 
-	// 绑定请求参数
-	var req models.BatchConversionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	// 获取预设ID
+	presetIDStr := c.Param("id")
+	presetID, err := strconv.ParseUint(presetIDStr, 10, 32)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Code:    400,
-			Message: "请求参数错误",
+			Message: "无效的预设ID",
 			Error:   err.Error(),
 		})
 		return
 	}
 
-	// TODO: 实现批量转换逻辑
-	// 这里需要根据实际的转换服务来实现
-	// 暂时返回一个示例响应
-	response := &models.BatchConversionResponse{
-		BatchID:    "batch_" + strconv.FormatInt(time.Now().Unix(), 10),
-		TotalFiles: len(req.Files),
-		Status:     "processing",
-		CreatedAt:  time.Now(),
-		Tasks:      make([]models.BatchTaskInfo, len(req.Files)),
+	// 绑定请求参数, 可以没有请求体
+	var req models.PresetCloneRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    400,
+				Message: "请求参数错误",
+				Error:   err.Error(),
+			})
+			return
+		}
 	}
 
-	// 为每个文件创建任务信息
-	for i, file := range req.Files {
-		taskID := fmt.Sprintf("task_%d_%d", userID, time.Now().UnixNano())
-		response.Tasks[i] = models.BatchTaskInfo{
-			TaskID:   taskID,
-			FileName: file.FileName,
-			Status:   "pending",
-		}
+	// 复制预设
+	preset, err := historyService.ClonePreset(userID, uint(presetID), req.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    404,
+			Message: "预设不存在",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "复制预设失败",
+			Error:   err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Code:    200,
-		Message: "批量转换任务已创建",
-		Data:    response,
+		Message: "复制成功",
+		Data:    preset,
 	})
-}
\ No newline at end of file
+}
@@ -98,6 +98,12 @@ type HistoryListResponse struct {
 	TotalPages int                 `json:"total_pages"`
 }
 
+// HistoryDetailResponse 历史记录详情, Files为还可以下载的输出文件
+type HistoryDetailResponse struct {
+	ConversionHistory
+	Files []ConvertedFile `json:"files"`
+}
+
 // HistoryStatsResponse 历史统计响应
 type HistoryStatsResponse struct {
 	TotalConversions    int64                    `json:"total_conversions"`
@@ -123,10 +129,11 @@ type MonthlyStats struct {
 // BatchConversionRequest 批量转换请求
 type BatchConversionRequest struct {
 	Files          []BatchFileInfo `json:"files" binding:"required,min=1,max=10"`
-	OutputFormat   string          `json:"output_format" binding:"required,oneof=epub mobi azw3 pdf"`
+	OutputFormat   string          `json:"output_format" binding:"omitempty,oneof=epub mobi azw3 pdf"` // 使用预设时可以为空
 	BookTitle      string          `json:"book_title"`
 	Author         string          `json:"author"`
 	CommonOptions  map[string]interface{} `json:"common_options"`
+	PresetID       uint            `json:"preset_id"` // 使用的转换预设, 为0时使用用户的默认预设
 }
 
 // BatchFileInfo 批量文件信息
@@ -148,10 +155,72 @@ type BatchConversionResponse struct {
 
 // BatchTaskInfo 批量任务信息
 type BatchTaskInfo struct {
-	TaskID       string `json:"task_id"`
-	FileName     string `json:"file_name"`
-	Status       string `json:"status"`
-	ErrorMessage string `json:"error_message,omitempty"`
+	TaskID       string          `json:"task_id"`
+	FileName     string          `json:"file_name"`
+	Status       string          `json:"status"`
+	Progress     int             `json:"progress"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	Files        []ConvertedFile `json:"files,omitempty"`
+}
+
+// BatchStatusResponse 批量转换状态, 由各文件的任务状态汇总
+type BatchStatusResponse struct {
+	BatchID    string          `json:"batch_id"`
+	Status     string          `json:"status"`   // 全部成功为completed, 部分失败为partial
+	Progress   int             `json:"progress"` // 所有任务的平均进度, 已结束的任务按100计算
+	TotalFiles int             `json:"total_files"`
+	Completed  int             `json:"completed"`
+	Failed     int             `json:"failed"`
+	Tasks      []BatchTaskInfo `json:"tasks"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// BatchStatusPartial 批量转换结束, 部分文件转换失败
+const BatchStatusPartial = "partial"
+
+// ConversionBatch 批量转换的批次, 记录批次中的文件和任务
+type ConversionBatch struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	BatchID      string         `json:"batch_id" gorm:"uniqueIndex;size:255"`
+	UserID       uint           `json:"user_id" gorm:"index"`
+	OutputFormat string         `json:"output_format" gorm:"size:10"`
+	Tasks        BatchTaskList  `json:"tasks" gorm:"type:text"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// BatchTaskList 批次中的任务, 以JSON保存, 只保存任务ID、文件名和创建时的错误
+type BatchTaskList []BatchTaskInfo
+
+// Value 实现 driver.Valuer 接口
+func (l BatchTaskList) Value() (driver.Value, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner 接口
+func (l *BatchTaskList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into BatchTaskList", value)
+	}
+	if len(data) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(data, l)
 }
 
 // ConversionPreset 转换预设
@@ -164,6 +233,7 @@ type ConversionPreset struct {
 	Options     ConvertOptionsJSON     `json:"options" gorm:"type:text"`
 	IsDefault   bool                   `json:"is_default" gorm:"default:false"`
 	IsPublic    bool                   `json:"is_public" gorm:"default:false"`
+	IsSystem    bool                   `json:"is_system" gorm:"default:false;index"` // 内置预设, 所有用户可用, 不能修改
 	UsageCount  int64                  `json:"usage_count" gorm:"default:0"`
 	CreatedAt   time.Time              `json:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at"`
@@ -184,6 +254,11 @@ type PresetListRequest struct {
 	SortOrder  string `json:"sort_order" form:"sort_order"`
 }
 
+// PresetCloneRequest 复制预设请求
+type PresetCloneRequest struct {
+	Name string `json:"name" binding:"max=100"` // 新预设的名称, 为空时使用原预设的名称
+}
+
 // PresetCreateRequest 创建预设请求
 type PresetCreateRequest struct {
 	Name         string                 `json:"name" binding:"required,min=1,max=100"`
@@ -234,6 +309,10 @@ func (DownloadRecord) TableName() string {
 	return "download_records"
 }
 
+func (ConversionBatch) TableName() string {
+	return "conversion_batches"
+}
+
 // BeforeCreate 创建前钩子
 func (h *ConversionHistory) BeforeCreate(tx *gorm.DB) error {
 	if h.StartTime.IsZero() {
@@ -286,4 +365,19 @@ func (h *ConversionHistory) IsExpired(ttl time.Duration) bool {
 		return false
 	}
 	return time.Since(*h.EndTime) > ttl
-}
\ No newline at end of file
+}
+
+// ConvertOptions 预设的转换选项, 字段和转换请求相同, 包括输出格式
+func (p *ConversionPreset) ConvertOptions() map[string]interface{} {
+	options := make(map[string]interface{}, len(p.Options)+1)
+	for k, v := range p.Options {
+		options[k] = v
+	}
+	if p.OutputFormat != "" {
+		options["format"] = p.OutputFormat
+	}
+	// 任务ID由每次转换指定
+	delete(options, "task_id")
+	delete(options, "preset_id")
+	return options
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ConversionJob 持久化的转换任务, 服务重启后可以继续执行未完成的任务并查询已完成任务的状态
+type ConversionJob struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	TaskID      string     `json:"task_id" gorm:"uniqueIndex;size:255"`
+	UserID      uint       `json:"user_id" gorm:"index"`           // 匿名任务为0
+	BatchID     string     `json:"batch_id" gorm:"size:255;index"` // 批量转换的任务所属的批次
+	Status      string     `json:"status" gorm:"size:20;index"`
+	Priority    int        `json:"priority" gorm:"index"` // 数值大的先执行
+	Attempts    int        `json:"attempts"`              // 已执行的次数
+	MaxAttempts int        `json:"max_attempts"`          // 最多执行的次数
+	NextRunAt   time.Time  `json:"next_run_at"`           // 重试的任务在这个时间之后执行
+	Progress    int        `json:"progress"`
+	Message     string     `json:"message" gorm:"size:500"`
+	Error       string     `json:"error,omitempty" gorm:"type:text"`
+	Payload     JobPayload `json:"payload" gorm:"type:text"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// JobPayload 任务的请求参数和结果, 以JSON保存在一列中
+type JobPayload struct {
+	Request  *ConvertRequest        `json:"request,omitempty"`
+	Sources  []string               `json:"sources,omitempty"` // 合并任务的源文件的任务ID
+	Files    []ConvertedFile        `json:"files,omitempty"`
+	Logs     []string               `json:"logs,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Value 实现 driver.Valuer 接口
+func (p JobPayload) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner 接口
+func (p *JobPayload) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = JobPayload{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into JobPayload", value)
+	}
+	if len(data) == 0 {
+		*p = JobPayload{}
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+// TableName 指定表名
+func (ConversionJob) TableName() string {
+	return "conversion_jobs"
+}
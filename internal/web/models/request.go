@@ -14,6 +14,7 @@ type ConvertRequest struct {
 	TaskID           string `json:"task_id" binding:"required" example:"task_123456789"`                    // 任务ID
 	Bookname         string `json:"bookname" binding:"required" example:"示例小说"`                          // 书名
 	Author           string `json:"author" example:"作者名"`                                               // 作者
+	Description      string `json:"description" example:"简介"`                                          // 简介
 	Format           string `json:"format" binding:"required,oneof=epub mobi azw3 all" example:"epub"` // 输出格式
 	Match            string `json:"match" example:"^第[0-9一二三四五六七八九十零〇百千两 ]+[章回节集幕卷部]"`              // 章节匹配规则
 	VolumeMatch      string `json:"volume_match" example:"^第[0-9一二三四五六七八九十零〇百千两 ]+[卷部]"`         // 卷匹配规则
@@ -22,14 +23,41 @@ type ConvertRequest struct {
 	Indent           uint   `json:"indent" example:"2"`                                                 // 段落缩进
 	Align            string `json:"align" example:"center"`                                             // 标题对齐方式
 	UnknowTitle      string `json:"unknow_title" example:"章节正文"`                                       // 未知章节名称
+	TitleTemplate    string `json:"title_template" example:"第{n:cn}章 {title}"`                         // 章节标题模板
+	VolumeTemplate   string `json:"volume_template" example:"第{n:cn}卷 {title}"`                        // 卷标题模板
+	Renumber         string `json:"renumber" binding:"omitempty,oneof=volume global" example:"volume"` // 重新编号方式
+	TitleJunk        string `json:"title_junk" example:""`                                              // 去掉标题末尾内容的正则
 	Cover            string `json:"cover" example:"gen"`                                                // 封面设置
 	CoverOrlyColor   string `json:"cover_orly_color" example:"#FF6B6B"`                                // 封面颜色
 	CoverOrlyIdx     int    `json:"cover_orly_idx" example:"1"`                                        // 封面动物索引
 	Font             string `json:"font" example:""`                                                    // 嵌入字体
+	TitleFont        string `json:"title_font" example:""`                                              // 标题嵌入字体
 	Bottom           string `json:"bottom" example:"1em"`                                               // 段落间距
 	LineHeight       string `json:"line_height" example:"1.5"`                                         // 行高
 	Tips             bool   `json:"tips" example:"true"`                                                // 是否添加教程文本
 	Lang             string `json:"lang" example:"zh"`                                                  // 语言设置
+	MobiKF8          bool   `json:"mobi_kf8" example:"false"`                                           // mobi生成MOBI7+KF8双格式文件
+	Split            string `json:"split" binding:"omitempty,oneof=sections size volume range" example:"volume"` // 分册方式
+	SplitCount       int    `json:"split_count" example:"1"`                                            // 每册的章节数或卷数
+	SplitSize        int    `json:"split_size" example:"0"`                                             // 每册正文的最大KB数
+	SplitRanges      string `json:"split_ranges" example:"1-500,501-"`                                  // 按章节范围分册时的范围
+	Reproducible     bool   `json:"reproducible" example:"false"`                                      // 可重复生成
+	Date             string `json:"date" example:"2024-01-01"`                                          // 出版日期
+	EpubCheck        bool   `json:"epub_check" example:"false"`                                        // 生成epub后检查是否符合规范
+	Priority         int    `json:"priority" binding:"omitempty,min=0,max=9" example:"0"`               // 任务优先级, 数值大的先执行, 匿名任务不使用
+	PresetID         uint   `json:"preset_id,omitempty" example:"1"`                                    // 使用的转换预设, 请求中的字段覆盖预设的选项
+}
+
+// MergeRequest 合并请求, 把多个已上传的txt按顺序合并成一本书, 每个txt为一卷
+type MergeRequest struct {
+	ConvertRequest
+	Sources []string `json:"sources" binding:"required,min=2" example:"task_1,task_2"` // 已上传文件的任务ID
+}
+
+// SplitRequest 分册请求, 把已上传的txt分成多册生成
+type SplitRequest struct {
+	ConvertRequest
+	By string `json:"by" binding:"required,oneof=sections size volume range" example:"range"` // 分册方式
 }
 
 // TaskStatusRequest 任务状态查询请求
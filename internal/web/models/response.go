@@ -47,6 +47,7 @@ type TaskStatusResponse struct {
 	Files       []ConvertedFile        `json:"files,omitempty"`                        // 转换后的文件列表
 	Logs        []string               `json:"logs,omitempty"`                         // 处理日志
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`                     // 元数据
+	Attempts    int                    `json:"attempts,omitempty" example:"1"`         // 已执行的次数, 失败后会自动重试
 }
 
 // ConvertedFile 转换后的文件信息
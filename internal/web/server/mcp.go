@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/mcp"
+	"github.com/Deali-Axy/ebook-generator/internal/services"
+	webServices "github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// mcpVersion 远程MCP服务的版本
+const mcpVersion = "1.0.0"
+
+var (
+	mcpHandler http.Handler // 远程MCP服务, 没有启用时为nil
+	mcpPath    string
+)
+
+// MCPOptions 单独启动远程MCP服务的参数
+type MCPOptions struct {
+	Addr       string // 监听地址, 如:8081
+	ConfigPath string // 服务配置文件, 和Web服务相同, 用户数据库也相同
+	WorkDir    string // 工作目录, 为空时为当前目录
+	Version    string // 服务版本
+}
+
+// initMCP 根据配置创建远程MCP服务, 由NewRouter挂载
+func initMCP(cfg services.MCPConfig, auth mcp.Authenticator, workDir string) error {
+	handler, err := newMCPHandler(cfg, auth, workDir, mcpVersion)
+	if err != nil {
+		return err
+	}
+	mcpHandler = handler
+	mcpPath = cfg.Path
+	if mcpPath == "" {
+		mcpPath = "/mcp"
+	}
+	log.Printf("MCP服务地址: %s/sse", mcpPath)
+	return nil
+}
+
+func newMCPHandler(cfg services.MCPConfig, auth mcp.Authenticator, workDir, version string) (http.Handler, error) {
+	dir := cfg.WorkDir
+	if dir == "" {
+		dir = filepath.Join(workDir, "web", "mcp")
+	}
+	return mcp.NewHTTPHandler(auth, mcp.HTTPOptions{
+		Version:           version,
+		BasePath:          cfg.Path,
+		BaseURL:           cfg.BaseURL,
+		WorkDir:           dir,
+		RequestsPerMinute: cfg.RequestsPerMinute,
+		BurstSize:         cfg.BurstSize,
+	})
+}
+
+// RunMCP 单独启动远程MCP服务, 使用Web服务的用户数据库认证, ctx取消时关闭
+func RunMCP(ctx context.Context, opts MCPOptions) error {
+	if opts.WorkDir == "" {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("获取工作目录失败: %w", err)
+		}
+		opts.WorkDir = workDir
+	}
+	serviceManager, err := initServiceManager(Options{ConfigPath: opts.ConfigPath, WorkDir: opts.WorkDir})
+	if err != nil {
+		return fmt.Errorf("初始化服务管理器失败: %w", err)
+	}
+	if serviceManager.DB == nil {
+		return fmt.Errorf("数据库未初始化, 无法认证用户")
+	}
+	secret := serviceManager.GetConfig().Auth.JWTSecret
+	if secret == "" {
+		return errNoJWTSecret
+	}
+	cfg := serviceManager.GetConfig().MCP
+	authService := webServices.NewAuthService(serviceManager.DB, secret, tokenTTL)
+	handler, err := newMCPHandler(cfg, authService, opts.WorkDir, opts.Version)
+	if err != nil {
+		return fmt.Errorf("初始化MCP服务失败: %w", err)
+	}
+
+	// 和挂载在Web服务中时使用相同的路由前缀
+	path := cfg.Path
+	if path == "" {
+		path = "/mcp"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path+"/", handler)
+	srv := &http.Server{Addr: opts.Addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("MCP服务启动在: %s, 连接地址: %s/sse", opts.Addr, path)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("启动MCP服务失败: %w", err)
+	}
+	return nil
+}
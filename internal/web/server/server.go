@@ -0,0 +1,309 @@
+// Package server 组装并启动Web服务, 供cmd/web和kaf-cli serve使用
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/Deali-Axy/ebook-generator/api-docs" // 导入生成的Swagger文档
+	"github.com/Deali-Axy/ebook-generator/internal/services"
+	"github.com/Deali-Axy/ebook-generator/internal/storage"
+	"github.com/Deali-Axy/ebook-generator/internal/web/handlers"
+	"github.com/Deali-Axy/ebook-generator/internal/web/middleware"
+	webServices "github.com/Deali-Axy/ebook-generator/internal/web/services"
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+)
+
+// 没有配置签名密钥时Web服务使用的默认密钥和登录token的有效期, 远程MCP服务使用相同的token
+const (
+	defaultJWTSecret = "ebook-generator-secret"
+	tokenTTL         = 24 * time.Hour
+)
+
+// errNoJWTSecret 远程MCP服务的token可以读写用户的文件, 不能使用公开的默认密钥签名
+var errNoJWTSecret = errors.New("远程MCP服务需要设置登录token的签名密钥: 环境变量KAF_JWT_SECRET或服务配置auth.jwt_secret")
+
+// Options Web服务的启动参数
+type Options struct {
+	Port       string // 端口, 为空时读取环境变量PORT, 默认8080
+	ConfigPath string // 服务配置文件, 为空时使用config/services.json, 不存在时使用示例配置
+	WorkDir    string // 工作目录, 上传、输出和静态文件都在其中的web目录下, 为空时为当前目录
+	WatchDir   string // 收件目录, 不为空时自动转换放入其中的txt, 覆盖服务配置中的设置
+	PresetID   uint   // 收件目录使用的转换预设
+	UserID     uint   // 收件目录的转换记录到这个用户的转换历史, 为0时不记录
+	Version    string // 软件版本
+}
+
+// Run 启动Web服务, ctx取消时优雅关闭
+func Run(ctx context.Context, opts Options) error {
+	if opts.Port == "" {
+		opts.Port = os.Getenv("PORT")
+	}
+	if opts.Port == "" {
+		opts.Port = "8080"
+	}
+	if opts.WorkDir == "" {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("获取工作目录失败: %w", err)
+		}
+		opts.WorkDir = workDir
+	}
+
+	// 设置Gin模式
+	if os.Getenv("GIN_MODE") == "" {
+		gin.SetMode(gin.DebugMode)
+	}
+
+	// 初始化服务管理器
+	serviceManager, err := initServiceManager(opts)
+	if err != nil {
+		return fmt.Errorf("初始化服务管理器失败: %w", err)
+	}
+
+	serviceManager.Version = opts.Version
+	if opts.WatchDir != "" {
+		cfg := serviceManager.GetConfig()
+		cfg.Watch.Enabled = true
+		cfg.Watch.Inbox = opts.WatchDir
+		if opts.PresetID != 0 {
+			cfg.Watch.PresetID = opts.PresetID
+		}
+		if opts.UserID != 0 {
+			cfg.Watch.UserID = opts.UserID
+		}
+	}
+
+	// 启动所有服务
+	if err := serviceManager.Start(); err != nil {
+		return fmt.Errorf("启动服务失败: %w", err)
+	}
+
+	// 设置优雅关闭
+	defer func() {
+		if err := serviceManager.Stop(); err != nil {
+			log.Printf("停止服务时出错: %v", err)
+		}
+	}()
+
+	// 初始化Web服务相关组件
+	taskService := initWebServices(serviceManager, opts.WorkDir)
+	defer taskService.Stop()
+
+	srv := &http.Server{
+		Addr:    ":" + opts.Port,
+		Handler: NewRouter(opts.WorkDir),
+	}
+	go func() {
+		<-ctx.Done()
+		log.Println("收到关闭信号，正在优雅关闭服务...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("服务启动在端口: %s", opts.Port)
+	log.Printf("Swagger文档地址: http://localhost:%s/swagger/index.html", opts.Port)
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("启动服务失败: %w", err)
+	}
+	return nil
+}
+
+// NewRouter 创建Gin引擎并注册所有路由, 需要先初始化处理器使用的服务
+func NewRouter(workDir string) *gin.Engine {
+	r := gin.Default()
+
+	// 添加中间件
+	r.Use(middleware.CORS())
+	r.Use(middleware.Logger())
+	r.Use(middleware.Recovery())
+
+	// 设置文件上传大小限制 (50MB)
+	r.MaxMultipartMemory = 50 << 20
+
+	// API路由组
+	api := r.Group("/api")
+	{
+		// 基础转换功能
+		api.POST("/upload", handlers.UploadFile)
+		// 登录后可以使用自己的预设
+		api.POST("/convert", handlers.OptionalAuthMiddleware(), handlers.ConvertBook)
+		api.POST("/merge", handlers.OptionalAuthMiddleware(), handlers.MergeBooks)
+		api.POST("/split", handlers.OptionalAuthMiddleware(), handlers.SplitBook)
+		api.GET("/status/:taskId", handlers.GetTaskStatus)
+		api.GET("/download/:fileId", handlers.DownloadFile)
+		api.DELETE("/cleanup/:taskId", handlers.CleanupTask)
+		api.GET("/events/:taskId", handlers.GetTaskEvents)
+
+		// 用户认证相关路由
+		auth := api.Group("/auth")
+		{
+			auth.POST("/register", handlers.Register)
+			auth.POST("/login", handlers.Login)
+			auth.GET("/profile", handlers.AuthMiddleware(), handlers.GetProfile)
+			auth.PUT("/profile", handlers.AuthMiddleware(), handlers.UpdateProfile)
+			auth.POST("/logout", handlers.AuthMiddleware(), handlers.Logout)
+			auth.POST("/refresh", handlers.AuthMiddleware(), handlers.RefreshToken)
+		}
+
+		// 转换历史相关路由（需要认证）
+		history := api.Group("/history")
+		history.Use(handlers.AuthMiddleware())
+		{
+			history.GET("", handlers.GetHistories)
+			history.GET("/stats", handlers.GetHistoryStats)
+			history.GET("/:id", handlers.GetHistory)
+			history.DELETE("/:id", handlers.DeleteHistory)
+			history.POST("/:id/rerun", handlers.RerunHistory)
+			history.GET("/:id/download", handlers.DownloadHistory)
+		}
+
+		// 浏览公开的转换预设不需要认证
+		api.GET("/presets/public", handlers.GetPublicPresets)
+
+		// 转换预设相关路由（需要认证）
+		presets := api.Group("/presets")
+		presets.Use(handlers.AuthMiddleware())
+		{
+			presets.POST("", handlers.CreatePreset)
+			presets.GET("", handlers.GetPresets)
+			presets.GET("/:id", handlers.GetPreset)
+			presets.PUT("/:id", handlers.UpdatePreset)
+			presets.DELETE("/:id", handlers.DeletePreset)
+			presets.POST("/:id/clone", handlers.ClonePreset)
+		}
+
+		// 批量转换相关路由（需要认证）
+		batch := api.Group("/batch")
+		batch.Use(handlers.AuthMiddleware())
+		{
+			batch.POST("/convert", handlers.BatchConvert)
+			batch.GET("/:batchId", handlers.GetBatchStatus)
+			batch.GET("/:batchId/events", handlers.GetBatchEvents)
+			batch.GET("/:batchId/download", handlers.DownloadBatch)
+		}
+	}
+
+	// 远程MCP服务
+	if mcpHandler != nil {
+		r.Any(mcpPath+"/*any", gin.WrapH(mcpHandler))
+	}
+
+	// 集成Swagger文档
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+	// 静态文件服务
+	static := filepath.Join(workDir, "web", "static")
+	r.Static("/static", static)
+	r.StaticFile("/", filepath.Join(static, "index.html"))
+	r.StaticFile("/demo", filepath.Join(static, "index.html"))
+
+	// 健康检查接口
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	return r
+}
+
+// initServiceManager 初始化服务管理器
+// 创建并配置服务管理器，加载配置文件
+func initServiceManager(opts Options) (*services.ServiceManager, error) {
+	configPath := opts.ConfigPath
+	if configPath == "" {
+		// 检查配置文件是否存在
+		configPath = filepath.Join(opts.WorkDir, "config", "services.json")
+		if _, err := os.Stat(configPath); os.IsNotExist(err) {
+			// 如果不存在，使用示例配置文件
+			configPath = filepath.Join(opts.WorkDir, "config", "services.example.json")
+			log.Printf("使用示例配置文件: %s", configPath)
+		}
+	}
+
+	// 创建服务管理器
+	serviceManager, err := services.NewServiceManager(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceManager, nil
+}
+
+// initWebServices 初始化Web服务相关组件
+// 使用服务管理器中的服务来初始化Web处理器, 返回已启动的任务服务
+func initWebServices(serviceManager *services.ServiceManager, workDir string) *webServices.TaskService {
+	// 设置目录路径
+	uploadDir := filepath.Join(workDir, "web", "uploads")
+	outputDir := filepath.Join(workDir, "web", "outputs")
+
+	// 创建存储服务（用于Web处理器）
+	storageService := storage.NewStorageService(uploadDir, outputDir, 50<<20) // 50MB限制
+
+	// 创建转换器服务（用于Web处理器）
+	converterService := webServices.NewConverterService(outputDir)
+
+	// 创建任务服务（用于Web处理器）, 有数据库时任务保存在数据库中, 重启后继续执行
+	taskService := webServices.NewTaskService(storageService, converterService, serviceManager.DB, serviceManager.GetConfig().TaskQueue)
+
+	// 初始化基础处理器
+	handlers.InitServices(taskService, storageService, converterService)
+
+	// 如果服务管理器中有数据库服务，初始化需要数据库的处理器
+	if serviceManager.DB != nil {
+		// 创建认证服务
+		secret := serviceManager.GetConfig().Auth.JWTSecret
+		if secret == "" {
+			log.Println("警告: 没有设置登录token的签名密钥, 使用默认密钥, 请设置环境变量KAF_JWT_SECRET")
+			secret = defaultJWTSecret
+		}
+		authService := webServices.NewAuthService(serviceManager.DB, secret, tokenTTL)
+		handlers.InitAuthService(authService)
+
+		// 远程MCP服务使用登录的token认证
+		if cfg := serviceManager.GetConfig(); cfg.MCP.Enabled {
+			if cfg.Auth.JWTSecret == "" {
+				log.Printf("警告: 没有启动MCP服务: %v", errNoJWTSecret)
+			} else if err := initMCP(cfg.MCP, authService, workDir); err != nil {
+				log.Printf("警告: 初始化MCP服务失败: %v", err)
+			}
+		}
+
+		// 初始化历史服务
+		historyService := serviceManager.HistorySvc
+		if historyService == nil {
+			// 如果服务管理器中没有历史服务，手动创建一个
+			historyService = webServices.NewHistoryService(serviceManager.DB)
+		}
+		handlers.InitHistoryService(historyService)
+
+		// 登录用户的任务记录到转换历史
+		taskService.SetHistoryService(historyService)
+
+		// 批量转换的任务属于用户, 需要数据库保存批次
+		handlers.InitBatchService(webServices.NewBatchService(serviceManager.DB, taskService, storageService, converterService, historyService))
+
+		log.Println("数据库相关服务初始化完成")
+	} else {
+		log.Println("警告: 数据库未初始化，认证和历史功能将不可用")
+	}
+
+	// 设置好转换历史后再开始执行任务
+	if err := taskService.Start(); err != nil {
+		log.Printf("警告: 恢复未完成的任务失败: %v", err)
+	}
+
+	log.Println("Web服务初始化完成")
+	log.Printf("上传目录: %s", uploadDir)
+	log.Printf("输出目录: %s", outputDir)
+	return taskService
+}
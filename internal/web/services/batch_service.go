@@ -0,0 +1,358 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/storage"
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+// ErrBatchNotFound 批次不存在或不属于当前用户
+var ErrBatchNotFound = errors.New("批次不存在")
+
+// BatchService 批量转换服务, 批次中每个文件一个转换任务, 由任务服务排队执行
+type BatchService struct {
+	db               *gorm.DB
+	taskService      *TaskService
+	storageService   *storage.StorageService
+	converterService *ConverterService
+	historyService   *HistoryService
+}
+
+// NewBatchService 创建批量转换服务
+func NewBatchService(db *gorm.DB, taskService *TaskService, storageService *storage.StorageService, converterService *ConverterService, historyService *HistoryService) *BatchService {
+	return &BatchService{
+		db:               db,
+		taskService:      taskService,
+		storageService:   storageService,
+		converterService: converterService,
+		historyService:   historyService,
+	}
+}
+
+// CreateBatch 保存上传的文件并为每个文件创建转换任务
+//
+// req.Files按文件名对应上传的文件, 没有上传、大小或哈希(sha256)不一致的文件不转换。
+// 每个文件的转换选项依次为预设的选项、CommonOptions和CustomOptions, 后面的覆盖前面的,
+// 没有指定预设时使用用户的默认预设。
+// 没有文件可以转换时返回错误, 返回的批次中有每个文件失败的原因。
+func (bs *BatchService) CreateBatch(userID uint, req *models.BatchConversionRequest, files []*multipart.FileHeader) (*models.BatchConversionResponse, error) {
+	preset, err := bs.historyService.ResolvePreset(userID, req.PresetID)
+	if err != nil {
+		return nil, err
+	}
+	var presetOptions map[string]interface{}
+	if preset != nil {
+		presetOptions = preset.ConvertOptions()
+		presetOptions["preset_id"] = preset.ID
+		if req.OutputFormat == "" {
+			req.OutputFormat = preset.OutputFormat
+		}
+	}
+	if !bs.converterService.ValidateFormat(req.OutputFormat) {
+		return nil, fmt.Errorf("不支持的格式: %s, 支持的格式: %s", req.OutputFormat, strings.Join(bs.converterService.GetSupportedFormats(), ", "))
+	}
+	uploads := make(map[string]*multipart.FileHeader, len(files))
+	for _, file := range files {
+		if _, exists := uploads[file.Filename]; exists {
+			return nil, fmt.Errorf("上传了重名的文件: %s", file.Filename)
+		}
+		uploads[file.Filename] = file
+	}
+	if len(req.Files) == 0 {
+		return nil, fmt.Errorf("没有要转换的文件")
+	}
+
+	batch := &models.ConversionBatch{
+		BatchID:      fmt.Sprintf("batch_%d", time.Now().UnixNano()),
+		UserID:       userID,
+		OutputFormat: req.OutputFormat,
+		CreatedAt:    time.Now(),
+	}
+	// 按文件的顺序保存可以转换的文件的请求, 不能转换的为nil
+	requests := make([]*models.ConvertRequest, len(req.Files))
+	for i, info := range req.Files {
+		taskID := fmt.Sprintf("%s_%d", batch.BatchID, i+1)
+		entry := models.BatchTaskInfo{
+			TaskID:   taskID,
+			FileName: info.FileName,
+			Status:   models.TaskStatusPending,
+		}
+		request, err := bs.prepareTask(taskID, req, presetOptions, info, uploads[info.FileName])
+		if err != nil {
+			entry.Status = models.TaskStatusFailed
+			entry.ErrorMessage = err.Error()
+		} else {
+			requests[i] = request
+		}
+		batch.Tasks = append(batch.Tasks, entry)
+	}
+
+	// 先保存批次再开始转换, 任务开始后一定能查到所属的批次
+	if err := bs.db.Create(batch).Error; err != nil {
+		for _, request := range requests {
+			if request != nil {
+				bs.storageService.CleanupTask(request.TaskID)
+			}
+		}
+		return nil, fmt.Errorf("保存批次失败: %w", err)
+	}
+	started, prepared := 0, 0
+	for i, request := range requests {
+		if request == nil {
+			continue
+		}
+		prepared++
+		bs.taskService.CreateBatchTask(request.TaskID, userID, batch.BatchID, request)
+		if err := bs.taskService.StartConversion(request.TaskID); err != nil {
+			batch.Tasks[i].Status = models.TaskStatusFailed
+			batch.Tasks[i].ErrorMessage = fmt.Sprintf("启动转换任务失败: %s", err)
+			continue
+		}
+		started++
+	}
+	if started < prepared {
+		if err := bs.db.Save(batch).Error; err != nil {
+			return nil, fmt.Errorf("保存批次失败: %w", err)
+		}
+	}
+
+	response := &models.BatchConversionResponse{
+		BatchID:    batch.BatchID,
+		TotalFiles: len(batch.Tasks),
+		Tasks:      batch.Tasks,
+		Status:     models.TaskStatusPending,
+		CreatedAt:  batch.CreatedAt,
+	}
+	if started == 0 {
+		response.Status = models.TaskStatusFailed
+		return response, fmt.Errorf("没有可以转换的文件")
+	}
+	if preset != nil {
+		bs.historyService.UsePreset(preset.ID)
+	}
+	return response, nil
+}
+
+// prepareTask 检查并保存一个文件, 返回这个文件的转换请求
+func (bs *BatchService) prepareTask(taskID string, req *models.BatchConversionRequest, presetOptions map[string]interface{}, info models.BatchFileInfo, file *multipart.FileHeader) (*models.ConvertRequest, error) {
+	if file == nil {
+		return nil, fmt.Errorf("没有上传这个文件")
+	}
+	if info.FileSize > 0 && info.FileSize != file.Size {
+		return nil, fmt.Errorf("文件大小不一致: 上传了%d字节, 请求中为%d字节", file.Size, info.FileSize)
+	}
+	request, err := bs.buildRequest(taskID, req, presetOptions, info)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bs.storageService.SaveUploadedFile(taskID, file); err != nil {
+		return nil, err
+	}
+	if info.FileHash != "" {
+		if err := bs.checkHash(taskID, info.FileHash); err != nil {
+			bs.storageService.CleanupTask(taskID)
+			return nil, err
+		}
+	}
+	return request, nil
+}
+
+// buildRequest 合并批次和文件的转换选项
+//
+// 书名默认为文件名, 设置了BookTitle时只有一个文件的书名为BookTitle, 多个文件为"BookTitle 文件名"。
+func (bs *BatchService) buildRequest(taskID string, req *models.BatchConversionRequest, presetOptions map[string]interface{}, info models.BatchFileInfo) (*models.ConvertRequest, error) {
+	bookname := strings.TrimSuffix(info.FileName, filepath.Ext(info.FileName))
+	if req.BookTitle != "" {
+		if len(req.Files) == 1 {
+			bookname = req.BookTitle
+		} else {
+			bookname = req.BookTitle + " " + bookname
+		}
+	}
+	options := maps.Clone(presetOptions)
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	options["bookname"] = bookname
+	options["format"] = req.OutputFormat
+	if req.Author != "" {
+		options["author"] = req.Author
+	}
+	maps.Copy(options, req.CommonOptions)
+	maps.Copy(options, info.CustomOptions)
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("转换选项格式错误: %w", err)
+	}
+	var request models.ConvertRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("转换选项格式错误: %w", err)
+	}
+	request.TaskID = taskID
+	// 和单个文件的转换接口使用相同的验证规则
+	if err := binding.Validator.ValidateStruct(&request); err != nil {
+		return nil, fmt.Errorf("转换选项错误: %w", err)
+	}
+	if request.Split == model.SplitRange {
+		if _, err := model.ParseRanges(request.SplitRanges); err != nil {
+			return nil, fmt.Errorf("章节范围错误: %w", err)
+		}
+	}
+	if request.Bookname == "" {
+		return nil, fmt.Errorf("书名不能为空")
+	}
+	if !bs.converterService.ValidateFormat(request.Format) {
+		return nil, fmt.Errorf("不支持的格式: %s", request.Format)
+	}
+	return &request, nil
+}
+
+// checkHash 检查上传的文件的sha256
+func (bs *BatchService) checkHash(taskID, expected string) error {
+	path, err := bs.storageService.GetUploadedFilePath(taskID)
+	if err != nil {
+		return err
+	}
+	actual, err := fileHash(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("文件哈希不一致: %s", actual)
+	}
+	return nil
+}
+
+// getBatch 读取用户的批次
+func (bs *BatchService) getBatch(userID uint, batchID string) (*models.ConversionBatch, error) {
+	var batch models.ConversionBatch
+	err := bs.db.Where("batch_id = ? AND user_id = ?", batchID, userID).First(&batch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取批次失败: %w", err)
+	}
+	return &batch, nil
+}
+
+// GetBatchStatus 汇总批次中每个任务的状态
+func (bs *BatchService) GetBatchStatus(userID uint, batchID string) (*models.BatchStatusResponse, error) {
+	batch, err := bs.getBatch(userID, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.BatchStatusResponse{
+		BatchID:    batch.BatchID,
+		TotalFiles: len(batch.Tasks),
+		Tasks:      make([]models.BatchTaskInfo, len(batch.Tasks)),
+		CreatedAt:  batch.CreatedAt,
+	}
+	progress, running, pending := 0, 0, 0
+	for i, entry := range batch.Tasks {
+		// 创建时就失败的文件没有任务
+		if entry.Status != models.TaskStatusFailed {
+			if status, err := bs.taskService.GetTaskStatus(entry.TaskID); err == nil {
+				entry.Status = status.Status
+				entry.Progress = status.Progress
+				entry.ErrorMessage = status.Error
+				entry.Files = status.Files
+			} else {
+				entry.Status = models.TaskStatusFailed
+				entry.ErrorMessage = "任务已被清理"
+			}
+		}
+		switch entry.Status {
+		case models.TaskStatusCompleted:
+			response.Completed++
+			entry.ErrorMessage = ""
+		case models.TaskStatusFailed, models.TaskStatusCancelled:
+			response.Failed++
+		case models.TaskStatusProcessing:
+			running++
+		default:
+			pending++
+		}
+		if entry.Status == models.TaskStatusProcessing || entry.Status == models.TaskStatusPending {
+			progress += entry.Progress
+		} else {
+			progress += 100
+		}
+		response.Tasks[i] = entry
+	}
+	if response.TotalFiles > 0 {
+		response.Progress = progress / response.TotalFiles
+	}
+
+	switch {
+	case running > 0 || (pending > 0 && response.Completed+response.Failed > 0):
+		response.Status = models.TaskStatusProcessing
+	case pending > 0:
+		response.Status = models.TaskStatusPending
+	case response.Failed == 0:
+		response.Status = models.TaskStatusCompleted
+	case response.Completed == 0:
+		response.Status = models.TaskStatusFailed
+	default:
+		response.Status = models.BatchStatusPartial
+	}
+	return response, nil
+}
+
+// WriteZip 把批次中转换成功的文件打包写入w, 重名的文件在文件名后加序号
+func (bs *BatchService) WriteZip(userID uint, batchID string, w io.Writer) error {
+	status, err := bs.GetBatchStatus(userID, batchID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	names := make(map[string]bool)
+	for _, task := range status.Tasks {
+		for _, file := range task.Files {
+			name := file.Filename
+			ext := filepath.Ext(name)
+			for i := 2; names[name]; i++ {
+				name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(file.Filename, ext), i, ext)
+			}
+			names[name] = true
+			if err := addZipFile(zw, name, file.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// addZipFile 把文件添加到zip中, 电子书已经是压缩格式, 只存储不压缩
+func addZipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	defer f.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("写入压缩包失败: %w", err)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("写入压缩包失败: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,91 +24,47 @@ func NewConverterService(outputDir string) *ConverterService {
 	}
 }
 
-// ConvertBook 转换电子书
-func (s *ConverterService) ConvertBook(book *model.Book, format string) ([]types.ConvertedFileInfo, error) {
+// ConvertBook 转换电子书, 多个格式并发生成, ctx取消时停止转换
+func (s *ConverterService) ConvertBook(ctx context.Context, book *model.Book, format string, progress converter.ProgressFunc) ([]types.ConvertedFileInfo, error) {
 	var results []types.ConvertedFileInfo
-	var formats []string
 
-	// 确定要转换的格式
-	if format == "all" {
-		formats = []string{"epub", "mobi", "azw3"}
-	} else {
-		formats = []string{format}
+	book.Format = strings.ToLower(format)
+	conv := converter.Dispatcher{
+		Book:     book,
+		Output:   converter.DirOutput{Dir: s.outputDir},
+		Progress: progress,
 	}
-
-	// 设置输出目录
-	originalOut := book.Out
-	baseOut := filepath.Join(s.outputDir, originalOut)
-
-	// 为每种格式进行转换
-	for _, formatType := range formats {
-		// 设置当前格式
-		book.Format = formatType
-		book.Out = baseOut
-
-		// 获取对应的转换器
-		conv, err := s.getConverter(formatType)
-		if err != nil {
-			return nil, fmt.Errorf("获取%s转换器失败: %w", formatType, err)
+	for _, result := range conv.Run(ctx) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-
-		// 执行转换
-		if err := conv.Build(*book); err != nil {
-			return nil, fmt.Errorf("%s转换失败: %w", formatType, err)
+		if result.Err != nil {
+			return nil, fmt.Errorf("%s转换失败: %w", result.Format, result.Err)
 		}
 
-		// 构建输出文件路径
-		outputFile := s.getOutputFilePath(baseOut, formatType)
-
-		// 获取文件大小
-		size, err := s.getFileSize(outputFile)
-		if err != nil {
-			return nil, fmt.Errorf("获取%s文件大小失败: %w", formatType, err)
+		// azw3可能按大小拆分成多个文件
+		for _, name := range result.Files {
+			outputFile := filepath.Join(s.outputDir, name)
+
+			// 获取文件大小
+			size, err := s.getFileSize(outputFile)
+			if err != nil {
+				return nil, fmt.Errorf("获取%s文件大小失败: %w", result.Format, err)
+			}
+
+			// 添加到结果列表
+			results = append(results, types.ConvertedFileInfo{
+				Format:   result.Format,
+				Filename: name,
+				Path:     outputFile,
+				Size:     size,
+			})
 		}
-
-		// 添加到结果列表
-		results = append(results, types.ConvertedFileInfo{
-			Format:   formatType,
-			Filename: filepath.Base(outputFile),
-			Path:     outputFile,
-			Size:     size,
-		})
 	}
 
-	// 恢复原始输出设置
-	book.Out = originalOut
-
 	return results, nil
 }
 
-// getConverter 获取指定格式的转换器
-func (s *ConverterService) getConverter(format string) (converter.Converter, error) {
-	switch strings.ToLower(format) {
-	case "epub":
-		return &converter.EpubConverter{}, nil
-	case "mobi":
-		return &converter.MobiConverter{}, nil
-	case "azw3":
-		return &converter.Azw3Converter{}, nil
-	default:
-		return nil, fmt.Errorf("不支持的格式: %s", format)
-	}
-}
-
-// getOutputFilePath 获取输出文件路径
-func (s *ConverterService) getOutputFilePath(basePath, format string) string {
-	switch strings.ToLower(format) {
-	case "epub":
-		return basePath + ".epub"
-	case "mobi":
-		return basePath + ".mobi"
-	case "azw3":
-		return basePath + ".azw3"
-	default:
-		return basePath + "." + format
-	}
-}
-
 // getFileSize 获取文件大小
 func (s *ConverterService) getFileSize(filePath string) (int64, error) {
 	stat, err := os.Stat(filePath)
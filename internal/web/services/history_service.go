@@ -2,7 +2,9 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
 	"time"
 
 	"github.com/Deali-Axy/ebook-generator/internal/web/models"
@@ -45,7 +47,7 @@ func (hs *HistoryService) UpdateHistoryStatus(taskID string, status string, erro
 		"status": status,
 	}
 
-	if status == "completed" {
+	if status == "completed" || status == "cancelled" {
 		now := time.Now()
 		updates["end_time"] = &now
 	} else if status == "failed" {
@@ -54,6 +56,14 @@ func (hs *HistoryService) UpdateHistoryStatus(taskID string, status string, erro
 		updates["error_message"] = errorMessage
 	}
 
+	// 结束时计算持续时间
+	if endTime, ok := updates["end_time"].(*time.Time); ok {
+		var history models.ConversionHistory
+		if err := hs.db.Where("task_id = ?", taskID).First(&history).Error; err == nil {
+			updates["duration"] = endTime.Sub(history.StartTime).Milliseconds()
+		}
+	}
+
 	result := hs.db.Model(&models.ConversionHistory{}).Where("task_id = ?", taskID).Updates(updates)
 	return result.Error
 }
@@ -88,6 +98,16 @@ func (hs *HistoryService) GetHistoryByTaskID(taskID string) (*models.ConversionH
 	return &history, nil
 }
 
+// GetUserHistory 获取用户的一条历史记录
+func (hs *HistoryService) GetUserHistory(userID uint, historyID uint) (*models.ConversionHistory, error) {
+	var history models.ConversionHistory
+	result := hs.db.Where("id = ? AND user_id = ? AND is_deleted = ?", historyID, userID, false).First(&history)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &history, nil
+}
+
 // GetUserHistories 获取用户历史记录列表
 func (hs *HistoryService) GetUserHistories(userID uint, req *models.HistoryListRequest) (*models.HistoryListResponse, error) {
 	// 设置默认值
@@ -341,8 +361,8 @@ func (hs *HistoryService) GetUserPresets(userID uint, req *models.PresetListRequ
 		req.SortOrder = "desc"
 	}
 
-	// 构建查询
-	query := hs.db.Model(&models.ConversionPreset{}).Where("user_id = ? OR is_public = ?", userID, true)
+	// 构建查询, 内置预设在公开预设列表中浏览
+	query := hs.db.Model(&models.ConversionPreset{}).Where("(user_id = ? OR is_public = ?) AND is_system = ?", userID, true, false)
 
 	// 添加过滤条件
 	if req.Format != "" {
@@ -411,4 +431,83 @@ func (hs *HistoryService) DeletePreset(userID uint, presetID uint) error {
 func (hs *HistoryService) UsePreset(presetID uint) error {
 	result := hs.db.Model(&models.ConversionPreset{}).Where("id = ?", presetID).Update("usage_count", gorm.Expr("usage_count + 1"))
 	return result.Error
-}
\ No newline at end of file
+}
+
+// GetDefaultPreset 获取用户的默认预设, 没有默认预设时返回nil
+func (hs *HistoryService) GetDefaultPreset(userID uint) (*models.ConversionPreset, error) {
+	var preset models.ConversionPreset
+	result := hs.db.Where("user_id = ? AND is_default = ?", userID, true).Order("updated_at DESC").First(&preset)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &preset, nil
+}
+
+// ResolvePreset 获取转换使用的预设
+// presetID不为0时使用指定的预设(自己的或公开的), 否则使用用户的默认预设, 匿名用户只能使用公开的预设
+func (hs *HistoryService) ResolvePreset(userID uint, presetID uint) (*models.ConversionPreset, error) {
+	if presetID == 0 {
+		if userID == 0 {
+			return nil, nil
+		}
+		return hs.GetDefaultPreset(userID)
+	}
+
+	preset, err := hs.GetPreset(userID, presetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("预设不存在: %d", presetID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取预设失败: %w", err)
+	}
+	return preset, nil
+}
+
+// GetPublicPresets 获取公开的预设列表, 内置预设在前, 其余按使用次数排序
+func (hs *HistoryService) GetPublicPresets(req *models.PresetListRequest) ([]models.ConversionPreset, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 20
+	}
+
+	query := hs.db.Model(&models.ConversionPreset{}).Where("is_public = ?", true)
+	if req.Format != "" {
+		query = query.Where("output_format = ?", req.Format)
+	}
+	if req.Keyword != "" {
+		query = query.Where("name LIKE ? OR description LIKE ?", "%"+req.Keyword+"%", "%"+req.Keyword+"%")
+	}
+
+	var presets []models.ConversionPreset
+	result := query.Order("is_system DESC, usage_count DESC, id ASC").
+		Offset((req.Page - 1) * req.PageSize).
+		Limit(req.PageSize).
+		Find(&presets)
+	return presets, result.Error
+}
+
+// ClonePreset 把自己的或公开的预设复制为用户自己的预设, 复制的预设不公开也不是默认预设
+func (hs *HistoryService) ClonePreset(userID uint, presetID uint, name string) (*models.ConversionPreset, error) {
+	source, err := hs.GetPreset(userID, presetID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = source.Name
+	}
+
+	preset := &models.ConversionPreset{
+		UserID:       userID,
+		Name:         name,
+		Description:  source.Description,
+		OutputFormat: source.OutputFormat,
+		Options:      models.ConvertOptionsJSON(maps.Clone(source.Options)),
+	}
+	result := hs.db.Create(preset)
+	return preset, result.Error
+}
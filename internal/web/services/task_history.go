@@ -0,0 +1,140 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+)
+
+// SetHistoryService 设置转换历史服务, 设置后用户的任务会记录到转换历史, 匿名任务不记录
+func (s *TaskService) SetHistoryService(history *HistoryService) {
+	s.history = history
+}
+
+// startHistory 创建任务的转换历史记录, 记录上传的文件和转换选项
+//
+// 合并任务的原文件名为所有源文件名, 大小为源文件大小之和, 不记录哈希。
+func (s *TaskService) startHistory(task *TaskInfo) {
+	if s.history == nil {
+		return
+	}
+	s.mu.RLock()
+	userID, request, sources := task.UserID, task.Request, task.Sources
+	s.mu.RUnlock()
+	if userID == 0 {
+		return
+	}
+
+	uploads := sources
+	if len(uploads) == 0 {
+		uploads = []string{task.ID}
+	}
+	var names []string
+	var size int64
+	var hash string
+	for _, id := range uploads {
+		path, err := s.storageService.GetUploadedFilePath(id)
+		if err != nil {
+			continue
+		}
+		// 上传的文件名为"任务ID_原文件名"
+		names = append(names, strings.TrimPrefix(filepath.Base(path), id+"_"))
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+		if len(sources) == 0 {
+			if hash, err = fileHash(path); err != nil {
+				log.Printf("计算任务 %s 的文件哈希失败: %v", task.ID, err)
+			}
+		}
+	}
+
+	options, err := historyOptions(request, sources)
+	if err != nil {
+		log.Printf("记录任务 %s 的转换选项失败: %v", task.ID, err)
+	}
+	if _, err := s.history.CreateHistory(userID, task.ID, strings.Join(names, ", "), size, hash, request.Format, options); err != nil {
+		log.Printf("创建任务 %s 的转换历史失败: %v", task.ID, err)
+	}
+}
+
+// finishHistory 记录任务的转换结果, 输出文件记录第一个, 大小为所有输出文件之和
+func (s *TaskService) finishHistory(taskID string) {
+	if s.history == nil {
+		return
+	}
+	s.mu.RLock()
+	task, exists := s.tasks[taskID]
+	if !exists || task.UserID == 0 {
+		s.mu.RUnlock()
+		return
+	}
+	status, errorMsg, files := task.Status, task.Error, task.Files
+	s.mu.RUnlock()
+
+	var err error
+	switch status {
+	case models.TaskStatusCompleted:
+		var name string
+		var size int64
+		for i, file := range files {
+			if i == 0 {
+				name = file.Filename
+			}
+			size += file.Size
+		}
+		err = s.history.CompleteHistory(taskID, name, size)
+	case models.TaskStatusFailed, models.TaskStatusCancelled:
+		err = s.history.UpdateHistoryStatus(taskID, status, errorMsg)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("更新任务 %s 的转换历史失败: %v", taskID, err)
+	}
+}
+
+// historyOptions 转换历史中记录的转换选项, 只记录设置了的字段, 合并任务记录源文件的任务ID
+func historyOptions(request *models.ConvertRequest, sources []string) (map[string]interface{}, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var options map[string]interface{}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	for k, v := range options {
+		if v == nil || v == "" || v == false || v == float64(0) {
+			delete(options, k)
+		}
+	}
+	// 任务ID每次转换都不同
+	delete(options, "task_id")
+	if len(sources) > 0 {
+		options["sources"] = sources
+	}
+	return options, nil
+}
+
+// fileHash 计算文件的sha256
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("读取文件失败: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("读取文件失败: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
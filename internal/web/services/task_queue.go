@@ -0,0 +1,360 @@
+package services
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"gorm.io/gorm/clause"
+)
+
+// TaskQueueConfig 任务队列配置
+type TaskQueueConfig struct {
+	Workers      int    `json:"workers"`       // 同时执行的任务数
+	MaxPerUser   int    `json:"max_per_user"`  // 每个用户同时执行的任务数, 为0时不限制, 不限制匿名任务
+	MaxAttempts  int    `json:"max_attempts"`  // 每个任务最多执行的次数, 包括第一次
+	RetryBackoff string `json:"retry_backoff"` // 第一次重试前的等待时间, 之后每次翻倍, 如"5s"
+	MaxBackoff   string `json:"max_backoff"`   // 重试前的最长等待时间, 如"5m"
+}
+
+// DefaultTaskQueueConfig 默认的任务队列配置
+func DefaultTaskQueueConfig() TaskQueueConfig {
+	return TaskQueueConfig{
+		Workers:      2,
+		MaxPerUser:   1,
+		MaxAttempts:  3,
+		RetryBackoff: "5s",
+		MaxBackoff:   "5m",
+	}
+}
+
+// backoff 解析重试的等待时间, 为空或格式错误时使用默认值, 最长等待时间不小于第一次的等待时间
+func (c TaskQueueConfig) backoff() (retry, maxDelay time.Duration) {
+	parse := func(name, value string, def time.Duration) time.Duration {
+		if value == "" {
+			return def
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("警告: 任务队列配置%s格式错误: %q, 使用默认值%s", name, value, def)
+			return def
+		}
+		return d
+	}
+	retry = parse("retry_backoff", c.RetryBackoff, 5*time.Second)
+	maxDelay = parse("max_backoff", c.MaxBackoff, 5*time.Minute)
+	return retry, max(maxDelay, retry)
+}
+
+// taskQueue 等待执行的任务, 优先级高的先执行, 优先级相同时先创建的先执行
+type taskQueue []*TaskInfo
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority > q[j].Priority
+	}
+	return q[i].StartedAt.Before(q[j].StartedAt)
+}
+
+func (q taskQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *taskQueue) Push(x any) { *q = append(*q, x.(*TaskInfo)) }
+
+func (q *taskQueue) Pop() any {
+	old := *q
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return task
+}
+
+// enqueue 把任务放入队列, NextRunAt在之后时等到时间再放入
+//
+// 服务停止后不再放入, 等待重试的任务在下次启动时从数据库恢复。
+func (s *TaskService) enqueue(task *TaskInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return
+	}
+	if delay := time.Until(task.NextRunAt); delay > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(delay, func() {
+			s.mu.Lock()
+			delete(s.retries, timer)
+			s.mu.Unlock()
+			s.enqueue(task)
+		})
+		s.retries[timer] = struct{}{}
+		return
+	}
+	heap.Push(&s.queue, task)
+	s.signal()
+}
+
+// stopRetries 停止所有等待重试的定时器
+func (s *TaskService) stopRetries() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for timer := range s.retries {
+		timer.Stop()
+	}
+	clear(s.retries)
+}
+
+// signal 唤醒一个等待任务的worker
+func (s *TaskService) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next 取出下一个可以执行的任务, 服务停止时返回nil
+//
+// 用户正在执行的任务数达到MaxPerUser时跳过这个用户的任务, 让其他用户的任务先执行。
+func (s *TaskService) next() *TaskInfo {
+	for {
+		s.mu.Lock()
+		var skipped []*TaskInfo
+		var found *TaskInfo
+		for s.queue.Len() > 0 {
+			task := heap.Pop(&s.queue).(*TaskInfo)
+			// 排队时被取消或清理的任务直接跳过
+			if task.Status != models.TaskStatusPending || s.tasks[task.ID] != task {
+				continue
+			}
+			if s.userBusy(task.UserID) {
+				skipped = append(skipped, task)
+				continue
+			}
+			found = task
+			break
+		}
+		for _, task := range skipped {
+			heap.Push(&s.queue, task)
+		}
+		if found != nil {
+			s.running[found.UserID]++
+			remaining := s.queue.Len() > len(skipped)
+			s.mu.Unlock()
+			if remaining {
+				s.signal()
+			}
+			return found
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// userBusy 用户正在执行的任务数是否已达到限制, 调用时需要持有s.mu
+func (s *TaskService) userBusy(userID uint) bool {
+	return userID != 0 && s.config.MaxPerUser > 0 && s.running[userID] >= s.config.MaxPerUser
+}
+
+// worker 依次执行队列中的任务
+func (s *TaskService) worker() {
+	defer s.wg.Done()
+	for {
+		task := s.next()
+		if task == nil {
+			return
+		}
+		s.execute(task)
+
+		// 用户的任务执行完后, 之前跳过的任务可以执行了
+		s.mu.Lock()
+		if s.running[task.UserID]--; s.running[task.UserID] <= 0 {
+			delete(s.running, task.UserID)
+		}
+		s.mu.Unlock()
+		s.signal()
+	}
+}
+
+// retryDelay 第attempts次执行失败后重试前的等待时间
+func (s *TaskService) retryDelay(attempts int) time.Duration {
+	delay := s.retryBackoff
+	for i := 1; i < attempts && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, s.maxBackoff)
+}
+
+// persist 把任务的当前状态保存到数据库, 没有数据库时不保存
+func (s *TaskService) persist(taskID string) {
+	if s.db == nil {
+		return
+	}
+	// 按顺序写入, 保证数据库中是最后的状态
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	s.mu.RLock()
+	task, exists := s.tasks[taskID]
+	if !exists {
+		s.mu.RUnlock()
+		return
+	}
+	job := models.ConversionJob{
+		TaskID:      task.ID,
+		UserID:      task.UserID,
+		BatchID:     task.BatchID,
+		Status:      task.Status,
+		Priority:    task.Priority,
+		Attempts:    task.Attempts,
+		MaxAttempts: task.MaxAttempts,
+		NextRunAt:   task.NextRunAt,
+		Progress:    task.Progress,
+		Message:     task.Message,
+		Error:       task.Error,
+		Payload: models.JobPayload{
+			Request:  task.Request,
+			Sources:  task.Sources,
+			Files:    task.Files,
+			Logs:     append([]string(nil), task.Logs...),
+			Metadata: maps.Clone(task.Metadata),
+		},
+		CompletedAt: task.CompletedAt,
+		CreatedAt:   task.StartedAt,
+	}
+	s.mu.RUnlock()
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}},
+		UpdateAll: true,
+	}).Create(&job).Error
+	if err != nil {
+		log.Printf("保存任务 %s 失败: %v", taskID, err)
+	}
+}
+
+// loadTask 从数据库读取任务, 用于查询服务重启前的任务
+func (s *TaskService) loadTask(taskID string) (*TaskInfo, bool) {
+	if s.db == nil {
+		return nil, false
+	}
+	var job models.ConversionJob
+	if err := s.db.Where("task_id = ?", taskID).First(&job).Error; err != nil {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 读取时可能已被其他请求加载
+	if task, exists := s.tasks[taskID]; exists {
+		return task, true
+	}
+	task := taskFromJob(&job)
+	s.tasks[taskID] = task
+	return task, true
+}
+
+// deleteJob 删除数据库中的任务
+func (s *TaskService) deleteJob(taskID string) error {
+	if s.db == nil {
+		return nil
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	return s.db.Where("task_id = ?", taskID).Delete(&models.ConversionJob{}).Error
+}
+
+// recoverJobs 重新排队上次停止时没有完成的任务, 返回排队的任务数
+//
+// 执行中被中断的任务算作执行了一次, 已达到最多执行次数的标记为失败。
+// 启动前已经放入队列的任务在内存中, 不再重复排队。
+func (s *TaskService) recoverJobs() (int, error) {
+	if s.db == nil {
+		return 0, nil
+	}
+	var jobs []models.ConversionJob
+	err := s.db.Where("status IN ?", []string{models.TaskStatusPending, models.TaskStatusProcessing}).
+		Order("priority DESC, created_at ASC").
+		Find(&jobs).Error
+	if err != nil {
+		return 0, fmt.Errorf("读取未完成的任务失败: %w", err)
+	}
+
+	recovered := 0
+	for i := range jobs {
+		task := taskFromJob(&jobs[i])
+		interrupted := task.Status == models.TaskStatusProcessing
+
+		s.mu.Lock()
+		if _, exists := s.tasks[task.ID]; exists {
+			s.mu.Unlock()
+			continue
+		}
+		s.tasks[task.ID] = task
+		if interrupted && task.Attempts >= task.MaxAttempts {
+			now := time.Now()
+			task.Status = models.TaskStatusFailed
+			task.Message = "任务多次被中断"
+			task.Error = "服务重启时任务被中断, 已达到最多执行次数"
+			task.CompletedAt = &now
+			s.mu.Unlock()
+			s.persist(task.ID)
+			s.finishHistory(task.ID)
+			continue
+		}
+		task.Status = models.TaskStatusPending
+		task.Progress = 0
+		if interrupted {
+			task.Message = "服务重启, 任务重新排队"
+			task.Logs = append(task.Logs, fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), task.Message))
+		}
+		s.eventChannels[task.ID] = make(chan models.TaskEvent, 100)
+		s.mu.Unlock()
+
+		s.persist(task.ID)
+		s.enqueue(task)
+		recovered++
+	}
+	return recovered, nil
+}
+
+// taskFromJob 从数据库记录创建任务信息
+func taskFromJob(job *models.ConversionJob) *TaskInfo {
+	task := &TaskInfo{
+		ID:          job.TaskID,
+		Status:      job.Status,
+		Progress:    job.Progress,
+		Message:     job.Message,
+		StartedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+		Error:       job.Error,
+		Files:       job.Payload.Files,
+		Logs:        job.Payload.Logs,
+		Metadata:    job.Payload.Metadata,
+		Request:     job.Payload.Request,
+		Sources:     job.Payload.Sources,
+		UserID:      job.UserID,
+		BatchID:     job.BatchID,
+		Priority:    job.Priority,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		NextRunAt:   job.NextRunAt,
+	}
+	if task.Logs == nil {
+		task.Logs = []string{}
+	}
+	if task.Metadata == nil {
+		task.Metadata = make(map[string]interface{})
+	}
+	if task.Request == nil {
+		task.Request = &models.ConvertRequest{}
+	}
+	return task
+}
@@ -2,24 +2,48 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
 	"github.com/Deali-Axy/ebook-generator/internal/core"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
 	"github.com/Deali-Axy/ebook-generator/internal/storage"
 	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"gorm.io/gorm"
 )
 
 // TaskService 任务服务
+//
+// 任务按优先级排队, 由固定数量的worker执行, 失败后按配置重试。
+// 有数据库时任务状态会保存到数据库, 重启后继续执行未完成的任务, 已完成的任务也可以查询。
 type TaskService struct {
 	mu               sync.RWMutex
 	tasks            map[string]*TaskInfo
 	storageService   *storage.StorageService
 	converterService *ConverterService
 	eventChannels    map[string]chan models.TaskEvent
+
+	db      *gorm.DB        // 为nil时任务只保存在内存中
+	history *HistoryService // 为nil时不记录转换历史
+	dbMu    sync.Mutex
+	config  TaskQueueConfig
+	queue   taskQueue
+	retries map[*time.Timer]struct{} // 等待重试的任务的定时器, 服务停止时停止
+	running map[uint]int             // 每个用户正在执行的任务数
+	wake    chan struct{}
+	ctx     context.Context // 服务停止时取消, 正在执行的任务在下次启动时重新执行
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+
+	retryBackoff time.Duration // 从config解析的重试等待时间
+	maxBackoff   time.Duration
 }
 
 // TaskInfo 任务信息
@@ -35,18 +59,66 @@ type TaskInfo struct {
 	Logs        []string               `json:"logs,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 	Request     *models.ConvertRequest `json:"request,omitempty"`
+	Sources     []string               `json:"sources,omitempty"`  // 合并任务的源文件的任务ID
+	UserID      uint                   `json:"user_id,omitempty"`  // 匿名任务为0
+	BatchID     string                 `json:"batch_id,omitempty"` // 批量转换的任务所属的批次
+	Priority    int                    `json:"priority"`
+	Attempts    int                    `json:"attempts"`     // 已执行的次数
+	MaxAttempts int                    `json:"max_attempts"` // 最多执行的次数
+	NextRunAt   time.Time              `json:"-"`            // 重试的任务在这个时间之后执行
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
-// NewTaskService 创建任务服务
-func NewTaskService(storageService *storage.StorageService, converterService *ConverterService) *TaskService {
+// NewTaskService 创建任务服务, db为nil时任务只保存在内存中, 需要调用Start后才会执行任务
+func NewTaskService(storageService *storage.StorageService, converterService *ConverterService, db *gorm.DB, config TaskQueueConfig) *TaskService {
+	defaults := DefaultTaskQueueConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	retryBackoff, maxBackoff := config.backoff()
+	ctx, stop := context.WithCancel(context.Background())
 	return &TaskService{
 		tasks:            make(map[string]*TaskInfo),
 		storageService:   storageService,
 		converterService: converterService,
 		eventChannels:    make(map[string]chan models.TaskEvent),
+		db:               db,
+		config:           config,
+		retries:          make(map[*time.Timer]struct{}),
+		running:          make(map[uint]int),
+		wake:             make(chan struct{}, 1),
+		retryBackoff:     retryBackoff,
+		maxBackoff:       maxBackoff,
+		ctx:              ctx,
+		stop:             stop,
+	}
+}
+
+// Start 启动worker并重新排队上次没有完成的任务, 恢复失败时新任务仍然可以执行
+func (s *TaskService) Start() error {
+	for i := 0; i < s.config.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
 	}
+	recovered, err := s.recoverJobs()
+	if err != nil {
+		return err
+	}
+	if recovered > 0 {
+		log.Printf("恢复了%d个未完成的任务", recovered)
+	}
+	return nil
+}
+
+// Stop 停止worker并等待正在执行的任务退出, 这些任务在下次启动时重新执行
+func (s *TaskService) Stop() {
+	s.stop()
+	s.stopRetries()
+	s.wg.Wait()
 }
 
 // CreateTask 创建新任务
@@ -54,18 +126,16 @@ func (s *TaskService) CreateTask(taskID string, request *models.ConvertRequest)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	ctx, cancel := context.WithCancel(context.Background())
 	task := &TaskInfo{
-		ID:        taskID,
-		Status:    models.TaskStatusPending,
-		Progress:  0,
-		Message:   "任务已创建，等待处理",
-		StartedAt: time.Now(),
-		Logs:      []string{},
-		Metadata:  make(map[string]interface{}),
-		Request:   request,
-		ctx:       ctx,
-		cancel:    cancel,
+		ID:          taskID,
+		Status:      models.TaskStatusPending,
+		Progress:    0,
+		Message:     "任务已创建，等待处理",
+		StartedAt:   time.Now(),
+		Logs:        []string{},
+		Metadata:    make(map[string]interface{}),
+		Request:     request,
+		MaxAttempts: s.config.MaxAttempts,
 	}
 
 	s.tasks[taskID] = task
@@ -74,12 +144,47 @@ func (s *TaskService) CreateTask(taskID string, request *models.ConvertRequest)
 	return task
 }
 
-// GetTask 获取任务信息
+// CreateUserTask 创建属于用户的任务, 同一用户同时执行的任务数受MaxPerUser限制
+//
+// 只有登录用户的任务使用请求中的优先级, 匿名任务的优先级为0。
+func (s *TaskService) CreateUserTask(taskID string, userID uint, request *models.ConvertRequest) *TaskInfo {
+	task := s.CreateTask(taskID, request)
+	s.mu.Lock()
+	task.UserID = userID
+	if userID != 0 {
+		task.Priority = request.Priority
+	}
+	s.mu.Unlock()
+	return task
+}
+
+// CreateBatchTask 创建批量转换中一个文件的任务
+func (s *TaskService) CreateBatchTask(taskID string, userID uint, batchID string, request *models.ConvertRequest) *TaskInfo {
+	task := s.CreateUserTask(taskID, userID, request)
+	s.mu.Lock()
+	task.BatchID = batchID
+	s.mu.Unlock()
+	return task
+}
+
+// CreateMergeTask 创建合并任务, sources为已上传文件的任务ID, 按顺序合并, 匿名任务的userID为0
+func (s *TaskService) CreateMergeTask(taskID string, userID uint, request *models.ConvertRequest, sources []string) *TaskInfo {
+	task := s.CreateUserTask(taskID, userID, request)
+	s.mu.Lock()
+	task.Sources = sources
+	s.mu.Unlock()
+	return task
+}
+
+// GetTask 获取任务信息, 内存中没有时从数据库读取
 func (s *TaskService) GetTask(taskID string) (*TaskInfo, bool) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
 	task, exists := s.tasks[taskID]
-	return task, exists
+	s.mu.RUnlock()
+	if exists {
+		return task, true
+	}
+	return s.loadTask(taskID)
 }
 
 // GetTaskStatus 获取任务状态响应
@@ -102,6 +207,7 @@ func (s *TaskService) GetTaskStatus(taskID string) (*models.TaskStatusResponse,
 		Files:     task.Files,
 		Logs:      task.Logs,
 		Metadata:  task.Metadata,
+		Attempts:  task.Attempts,
 	}
 
 	if task.CompletedAt != nil {
@@ -112,27 +218,110 @@ func (s *TaskService) GetTaskStatus(taskID string) (*models.TaskStatusResponse,
 	return response, nil
 }
 
-// StartConversion 开始转换任务
+// StartConversion 把任务放入队列, 有空闲的worker时开始转换
 func (s *TaskService) StartConversion(taskID string) error {
 	task, exists := s.GetTask(taskID)
 	if !exists {
 		return fmt.Errorf("任务不存在: %s", taskID)
 	}
 
-	// 启动异步转换
-	go s.processConversion(task)
+	s.mu.RLock()
+	status := task.Status
+	s.mu.RUnlock()
+	if status != models.TaskStatusPending {
+		return fmt.Errorf("任务不是等待状态: %s", status)
+	}
 
+	s.persist(taskID)
+	s.startHistory(task)
+	s.enqueue(task)
 	return nil
 }
 
-// processConversion 处理转换任务
-func (s *TaskService) processConversion(task *TaskInfo) {
+// taskError 任务失败的原因, retry为true时可以重试
+type taskError struct {
+	message string // 任务的状态消息, 如"文件解析失败"
+	err     error
+	retry   bool
+}
+
+func (e *taskError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *taskError) Unwrap() error {
+	return e.err
+}
+
+// execute 执行任务, 可以重试的错误在等待一段时间后重新排队
+func (s *TaskService) execute(task *TaskInfo) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	// 取出后到开始执行前可能已被取消
+	if task.Status != models.TaskStatusPending {
+		s.mu.Unlock()
+		return
+	}
+	task.ctx, task.cancel = ctx, cancel
+	task.Attempts++
+	attempts := task.Attempts
+	s.mu.Unlock()
+
+	files, err := s.processConversion(ctx, task)
+	if err == nil {
+		s.completeTask(task, files)
+		return
+	}
+	if ctx.Err() != nil {
+		if s.ctx.Err() != nil {
+			// 服务停止, 任务保持处理中状态, 下次启动时重新执行
+			log.Printf("服务停止, 任务 %s 已中断", task.ID)
+			return
+		}
+		// 任务已被取消, 状态由CancelTask更新
+		s.closeEventChannel(task.ID)
+		return
+	}
+
+	var te *taskError
+	if !errors.As(err, &te) {
+		te = &taskError{message: "转换失败", err: err}
+	}
+	if te.retry && attempts < task.MaxAttempts {
+		delay := s.retryDelay(attempts)
+		message := fmt.Sprintf("%s, %s后第%d次重试", te.message, delay, attempts)
+		s.mu.Lock()
+		task.Status = models.TaskStatusPending
+		task.Progress = 0
+		task.Message = message
+		task.Error = te.err.Error()
+		task.NextRunAt = time.Now().Add(delay)
+		task.Logs = append(task.Logs, fmt.Sprintf("[%s] %s: %s", time.Now().Format("15:04:05"), message, te.err))
+		s.mu.Unlock()
+		s.persist(task.ID)
+		s.sendEvent(task.ID, models.EventTypeLog, message+": "+te.err.Error(), 0, map[string]interface{}{
+			"attempts":    attempts,
+			"retry_after": delay.Seconds(),
+		})
+		s.enqueue(task)
+		return
+	}
+
+	s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, te.message, te.err.Error())
+	s.finishHistory(task.ID)
+	s.sendEvent(task.ID, models.EventTypeError, te.Error(), 0, nil)
+	s.closeEventChannel(task.ID)
+}
+
+// processConversion 处理转换任务, 返回保存后的文件
+func (s *TaskService) processConversion(ctx context.Context, task *TaskInfo) (files []models.ConvertedFile, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("转换任务异常: %v", r)
-			s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, fmt.Sprintf("转换异常: %v", r), fmt.Sprintf("%v", r))
-			s.sendEvent(task.ID, models.EventTypeError, fmt.Sprintf("转换异常: %v", r), 0, nil)
-			s.closeEventChannel(task.ID)
+			// 异常一般由输入的内容引起, 重试也不会成功
+			err = &taskError{message: "转换异常", err: fmt.Errorf("%v", r)}
 		}
 	}()
 
@@ -140,76 +329,64 @@ func (s *TaskService) processConversion(task *TaskInfo) {
 	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 10, "开始转换任务", "")
 	s.sendEvent(task.ID, models.EventTypeStart, "开始转换任务", 10, nil)
 
-	// 获取上传的文件路径
-	filePath, err := s.storageService.GetUploadedFilePath(task.ID)
-	if err != nil {
-		s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, "获取文件失败", err.Error())
-		s.sendEvent(task.ID, models.EventTypeError, "获取文件失败: "+err.Error(), 0, nil)
-		s.closeEventChannel(task.ID)
-		return
+	var book *model.Book
+	if len(task.Sources) > 0 {
+		book, err = s.prepareMergedBook(task)
+	} else {
+		book, err = s.prepareBook(task)
 	}
-
-	// 创建Book对象
-	book := s.createBookFromRequest(task.Request, filePath)
-
-	// 检查和验证
-	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 20, "验证文件和参数", "")
-	s.sendEvent(task.ID, models.EventTypeProgress, "验证文件和参数", 20, nil)
-
-	if err := core.Check(book, "1.0.0"); err != nil {
-		s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, "文件验证失败", err.Error())
-		s.sendEvent(task.ID, models.EventTypeError, "文件验证失败: "+err.Error(), 0, nil)
-		s.closeEventChannel(task.ID)
-		return
-	}
-
-	// 解析文件
-	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 40, "解析文本文件", "")
-	s.sendEvent(task.ID, models.EventTypeProgress, "解析文本文件", 40, nil)
-
-	if err := core.Parse(book); err != nil {
-		s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, "文件解析失败", err.Error())
-		s.sendEvent(task.ID, models.EventTypeError, "文件解析失败: "+err.Error(), 0, nil)
-		s.closeEventChannel(task.ID)
-		return
+	if err != nil {
+		return nil, err
 	}
 
 	// 转换为电子书
 	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 60, "生成电子书文件", "")
 	s.sendEvent(task.ID, models.EventTypeProgress, "生成电子书文件", 60, nil)
 
-	convertedFiles, err := s.converterService.ConvertBook(book, task.Request.Format)
+	// 把章节进度映射到60-80的区间
+	progress := func(p converter.Progress) {
+		if p.Stage != converter.StageSection || p.Total == 0 {
+			return
+		}
+		percent := 60 + p.Current*20/p.Total
+		s.sendEvent(task.ID, models.EventTypeProgress, fmt.Sprintf("生成%s: %d/%d", p.Format, p.Current, p.Total), percent, nil)
+	}
+	convertedFiles, err := s.converterService.ConvertBook(ctx, book, task.Request.Format, progress)
 	if err != nil {
-		s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, "转换失败", err.Error())
-		s.sendEvent(task.ID, models.EventTypeError, "转换失败: "+err.Error(), 0, nil)
-		s.closeEventChannel(task.ID)
-		return
+		// 转换的错误由书的内容和参数引起, 不重试, 只重试保存文件的错误
+		return nil, &taskError{message: "转换失败", err: err}
 	}
 
 	// 保存转换后的文件
 	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 80, "保存转换结果", "")
 	s.sendEvent(task.ID, models.EventTypeProgress, "保存转换结果", 80, nil)
 
-	files, err := s.storageService.SaveConvertedFiles(task.ID, convertedFiles)
+	files, err = s.storageService.SaveConvertedFiles(task.ID, convertedFiles)
 	if err != nil {
-		s.updateTaskStatus(task.ID, models.TaskStatusFailed, 0, "保存文件失败", err.Error())
-		s.sendEvent(task.ID, models.EventTypeError, "保存文件失败: "+err.Error(), 0, nil)
-		s.closeEventChannel(task.ID)
-		return
+		return nil, &taskError{message: "保存文件失败", err: err, retry: true}
 	}
+	return files, nil
+}
 
-	// 更新任务完成状态
+// completeTask 更新任务完成状态并发送完成事件
+func (s *TaskService) completeTask(task *TaskInfo, files []models.ConvertedFile) {
 	s.mu.Lock()
-	if task, exists := s.tasks[task.ID]; exists {
-		task.Status = models.TaskStatusCompleted
-		task.Progress = 100
-		task.Message = "转换完成"
-		task.Files = files
-		now := time.Now()
-		task.CompletedAt = &now
-		task.Logs = append(task.Logs, fmt.Sprintf("[%s] 转换完成，生成了%d个文件", time.Now().Format("15:04:05"), len(files)))
+	if task.Status == models.TaskStatusCancelled {
+		s.mu.Unlock()
+		s.closeEventChannel(task.ID)
+		return
 	}
+	task.Status = models.TaskStatusCompleted
+	task.Progress = 100
+	task.Message = "转换完成"
+	task.Error = ""
+	task.Files = files
+	now := time.Now()
+	task.CompletedAt = &now
+	task.Logs = append(task.Logs, fmt.Sprintf("[%s] 转换完成，生成了%d个文件", time.Now().Format("15:04:05"), len(files)))
 	s.mu.Unlock()
+	s.persist(task.ID)
+	s.finishHistory(task.ID)
 
 	s.sendEvent(task.ID, models.EventTypeComplete, "转换完成", 100, map[string]interface{}{
 		"files": files,
@@ -219,12 +396,86 @@ func (s *TaskService) processConversion(task *TaskInfo) {
 	s.closeEventChannel(task.ID)
 }
 
+// prepareBook 验证并解析上传的txt文件, 参数和文件的错误重试也不会成功
+func (s *TaskService) prepareBook(task *TaskInfo) (*model.Book, error) {
+	// 获取上传的文件路径
+	filePath, err := s.storageService.GetUploadedFilePath(task.ID)
+	if err != nil {
+		return nil, &taskError{message: "获取文件失败", err: err}
+	}
+
+	// 创建Book对象
+	book := s.createBookFromRequest(task.Request, filePath)
+
+	// 检查和验证
+	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 20, "验证文件和参数", "")
+	s.sendEvent(task.ID, models.EventTypeProgress, "验证文件和参数", 20, nil)
+
+	if err := core.Check(book, "1.0.0"); err != nil {
+		return nil, &taskError{message: "文件验证失败", err: err}
+	}
+
+	// 解析文件
+	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 40, "解析文本文件", "")
+	s.sendEvent(task.ID, models.EventTypeProgress, "解析文本文件", 40, nil)
+
+	if err := core.Parse(book); err != nil {
+		return nil, &taskError{message: "文件解析失败", err: err}
+	}
+	return book, nil
+}
+
+// prepareMergedBook 验证并解析合并任务的所有源文件, 合并成一本书, 每个文件为一卷
+func (s *TaskService) prepareMergedBook(task *TaskInfo) (*model.Book, error) {
+	s.updateTaskStatus(task.ID, models.TaskStatusProcessing, 20, "解析并合并文本文件", "")
+	s.sendEvent(task.ID, models.EventTypeProgress, "解析并合并文本文件", 20, nil)
+
+	sources := make([]*model.Book, len(task.Sources))
+	for i, sourceID := range task.Sources {
+		filePath, err := s.storageService.GetUploadedFilePath(sourceID)
+		if err != nil {
+			return nil, &taskError{message: "获取文件失败", err: err}
+		}
+		// 上传的文件名为"任务ID_原文件名", 卷名使用原文件名
+		name := strings.TrimPrefix(filepath.Base(filePath), sourceID+"_")
+		sources[i] = &model.Book{
+			Filename: filePath,
+			Bookname: strings.TrimSuffix(name, filepath.Ext(name)),
+		}
+	}
+
+	book := s.createBookFromRequest(task.Request, "")
+	if err := core.Merge(book, sources, "1.0.0"); err != nil {
+		return nil, &taskError{message: "合并文件失败", err: err}
+	}
+	return book, nil
+}
+
 // createBookFromRequest 从请求创建Book对象
 func (s *TaskService) createBookFromRequest(req *models.ConvertRequest, filePath string) *model.Book {
+	return bookFromRequest(req, filePath)
+}
+
+// BookFromOptions 从转换选项创建Book对象, 选项的字段和转换请求相同, 如预设的Options
+func BookFromOptions(options map[string]interface{}) (*model.Book, error) {
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("转换选项格式错误: %w", err)
+	}
+	var req models.ConvertRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("转换选项格式错误: %w", err)
+	}
+	return bookFromRequest(&req, ""), nil
+}
+
+// bookFromRequest 从请求创建Book对象并设置默认值
+func bookFromRequest(req *models.ConvertRequest, filePath string) *model.Book {
 	book := &model.Book{
 		Filename:         filePath,
 		Bookname:         req.Bookname,
 		Author:           req.Author,
+		Description:      req.Description,
 		Match:            req.Match,
 		VolumeMatch:      req.VolumeMatch,
 		ExclusionPattern: req.ExclusionPattern,
@@ -232,15 +483,28 @@ func (s *TaskService) createBookFromRequest(req *models.ConvertRequest, filePath
 		Indent:           req.Indent,
 		Align:            req.Align,
 		UnknowTitle:      req.UnknowTitle,
+		TitleTemplate:    req.TitleTemplate,
+		VolumeTemplate:   req.VolumeTemplate,
+		Renumber:         req.Renumber,
+		TitleJunk:        req.TitleJunk,
 		Cover:            req.Cover,
 		CoverOrlyColor:   req.CoverOrlyColor,
 		CoverOrlyIdx:     req.CoverOrlyIdx,
 		Font:             req.Font,
+		TitleFont:        req.TitleFont,
 		Bottom:           req.Bottom,
 		LineHeight:       req.LineHeight,
 		Tips:             req.Tips,
 		Lang:             req.Lang,
 		Format:           req.Format,
+		MobiKF8:          req.MobiKF8,
+		Split:            req.Split,
+		SplitCount:       req.SplitCount,
+		SplitSize:        req.SplitSize,
+		SplitRanges:      req.SplitRanges,
+		Reproducible:     req.Reproducible,
+		Date:             req.Date,
+		EpubCheck:        req.EpubCheck,
 		Out:              req.Bookname,
 	}
 
@@ -250,28 +514,36 @@ func (s *TaskService) createBookFromRequest(req *models.ConvertRequest, filePath
 	return book
 }
 
-// updateTaskStatus 更新任务状态
+// updateTaskStatus 更新任务状态并保存, 已取消的任务不再更新
 func (s *TaskService) updateTaskStatus(taskID, status string, progress int, message, errorMsg string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if task, exists := s.tasks[taskID]; exists {
-		task.Status = status
-		task.Progress = progress
-		task.Message = message
-		if errorMsg != "" {
-			task.Error = errorMsg
-		}
-		task.Logs = append(task.Logs, fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), message))
+	task, exists := s.tasks[taskID]
+	if !exists || task.Status == models.TaskStatusCancelled {
+		s.mu.Unlock()
+		return
+	}
+	task.Status = status
+	task.Progress = progress
+	task.Message = message
+	if errorMsg != "" {
+		task.Error = errorMsg
+	}
+	if status == models.TaskStatusFailed {
+		now := time.Now()
+		task.CompletedAt = &now
 	}
+	task.Logs = append(task.Logs, fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), message))
+	s.mu.Unlock()
+
+	s.persist(taskID)
 }
 
 // sendEvent 发送SSE事件
 func (s *TaskService) sendEvent(taskID, eventType, message string, progress int, data map[string]interface{}) {
+	// 发送时持有读锁, 避免通道同时被关闭
 	s.mu.RLock()
+	defer s.mu.RUnlock()
 	ch, exists := s.eventChannels[taskID]
-	s.mu.RUnlock()
-
 	if !exists {
 		return
 	}
@@ -313,60 +585,73 @@ func (s *TaskService) GetEventChannel(taskID string) (chan models.TaskEvent, boo
 	return ch, exists
 }
 
-// CancelTask 取消任务
+// CancelTask 取消任务, 排队中的任务不再执行, 执行中的任务尽快停止
 func (s *TaskService) CancelTask(taskID string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	task, exists := s.tasks[taskID]
+	task, exists := s.GetTask(taskID)
 	if !exists {
 		return fmt.Errorf("任务不存在: %s", taskID)
 	}
 
-	if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusFailed {
+	s.mu.Lock()
+	if task.Status != models.TaskStatusPending && task.Status != models.TaskStatusProcessing {
+		s.mu.Unlock()
 		return fmt.Errorf("任务已完成，无法取消")
 	}
-
-	task.cancel()
+	if task.cancel != nil {
+		task.cancel()
+	}
+	queued := task.Status == models.TaskStatusPending
 	task.Status = models.TaskStatusCancelled
 	task.Message = "任务已取消"
 	now := time.Now()
 	task.CompletedAt = &now
+	progress := task.Progress
+	s.mu.Unlock()
 
-	s.sendEvent(taskID, models.EventTypeCancel, "任务已取消", task.Progress, nil)
+	s.persist(taskID)
+	s.finishHistory(taskID)
+	s.sendEvent(taskID, models.EventTypeCancel, "任务已取消", progress, nil)
+	// 排队或等待重试的任务不会再执行, 由这里关闭事件通道, 执行中的任务停止后由execute关闭
+	if queued {
+		s.closeEventChannel(taskID)
+	}
 
 	return nil
 }
 
 // CleanupTask 清理任务
 func (s *TaskService) CleanupTask(taskID string) (*models.CleanupResponse, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	task, exists := s.tasks[taskID]
+	task, exists := s.GetTask(taskID)
 	if !exists {
 		return nil, fmt.Errorf("任务不存在: %s", taskID)
 	}
 
-	// 取消任务（如果还在运行）
-	if task.Status == models.TaskStatusPending || task.Status == models.TaskStatusProcessing {
+	s.mu.Lock()
+	// 取消任务（如果还在运行）, 排队中的任务从s.tasks删除后不会再执行
+	if task.cancel != nil && (task.Status == models.TaskStatusPending || task.Status == models.TaskStatusProcessing) {
 		task.cancel()
 	}
+	s.mu.Unlock()
 
-	// 清理文件
+	// 清理文件, 删除文件时不持有锁, 避免阻塞其他任务
 	cleanedFiles, err := s.storageService.CleanupTask(taskID)
 	if err != nil {
 		return nil, fmt.Errorf("清理文件失败: %w", err)
 	}
 
+	s.mu.Lock()
 	// 关闭事件通道
 	if ch, exists := s.eventChannels[taskID]; exists {
 		close(ch)
 		delete(s.eventChannels, taskID)
 	}
 
-	// 删除任务记录
+	// 删除任务记录, 从s.tasks删除后persist不会再写入数据库
 	delete(s.tasks, taskID)
+	s.mu.Unlock()
+	if err := s.deleteJob(taskID); err != nil {
+		return nil, fmt.Errorf("删除任务记录失败: %w", err)
+	}
 
 	return &models.CleanupResponse{
 		TaskID:       taskID,
@@ -1,9 +1,12 @@
 package kafcli
 
 import (
+	"context"
+
 	"github.com/Deali-Axy/ebook-generator/internal/converter"
 	"github.com/Deali-Axy/ebook-generator/internal/core"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/pkg/kaf"
 )
 
 type Book = model.Book
@@ -11,13 +14,16 @@ type Book = model.Book
 // NewSimpleBook 是 model.NewBookSimple 的别名，用于快速创建简单书籍实例。
 var NewSimpleBook = model.NewBookSimple
 
+// Convert 转换书籍
+//
+// Deprecated: 使用 pkg/kaf 中的 Converter, 它不依赖 internal 包中的类型并且支持 context
 func Convert(book *Book) error {
-	if err := core.Check(book, "v1.0.0"); err != nil {
+	if err := core.Check(book, kaf.Version); err != nil {
 		return err
 	}
 	if err := core.Parse(book); err != nil {
 		return err
 	}
 	conv := converter.Dispatcher{Book: book}
-	return conv.Convert()
+	return conv.Convert(context.Background())
 }
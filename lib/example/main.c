@@ -0,0 +1,70 @@
+/*
+ * 动态库的使用示例
+ *
+ * 编译和运行(Linux):
+ *   go build -buildmode=c-shared -o libkaf.so ./lib
+ *   cc -Ilib -o kaf-example lib/example/main.c -L. -lkaf -lpthread
+ *   LD_LIBRARY_PATH=. ./kaf-example 小说.txt
+ */
+#include <stdio.h>
+#include <string.h>
+
+#include "kaf.h"
+
+static void on_progress(void *user, const char *format, const char *stage,
+                        int current, int total, const char *message) {
+    const char *name = user;
+    if (strcmp(stage, "section") == 0) {
+        printf("[%s] %s %d/%d\n", name, format, current, total);
+    } else if (message[0] != '\0') {
+        printf("[%s] %s: %s\n", name, stage, message);
+    }
+}
+
+int main(int argc, char **argv) {
+    if (argc < 2) {
+        fprintf(stderr, "用法: %s 小说.txt\n", argv[0]);
+        return 1;
+    }
+
+    char *caps = KafCapabilities();
+    printf("功能: %s\n", caps);
+    KafFree(caps);
+
+    /* 转换文件 */
+    char params[4096];
+    snprintf(params, sizeof(params), "{\"Filename\": \"%s\", \"Format\": \"epub\"}", argv[1]);
+    long long task = KafTaskNew(params);
+    if (task == 0) {
+        char *err = KafLastError();
+        fprintf(stderr, "创建任务失败: %s\n", err);
+        KafFree(err);
+        return 1;
+    }
+    KafTaskSetProgress(task, on_progress, "文件");
+    long long code = KafTaskConvert(task);
+    if (code != KAF_EXIT_OK) {
+        char *err = KafTaskError(task);
+        fprintf(stderr, "转换失败(%lld): %s\n", code, err);
+        KafFree(err);
+    } else {
+        char *result = KafTaskResult(task);
+        printf("结果: %s\n", result);
+        KafFree(result);
+    }
+    KafTaskFree(task);
+
+    /* 在内存中转换 */
+    char text[] = "第一章 开始\n正文\n第二章 结束\n正文\n";
+    task = KafTaskNew("{\"Filename\": \"内存.txt\", \"Format\": \"epub\"}");
+    KafTaskSetProgress(task, on_progress, "内存");
+    unsigned char *book = NULL;
+    size_t length = 0;
+    code = KafTaskConvertMemory(task, text, strlen(text), &book, &length);
+    if (code == KAF_EXIT_OK) {
+        printf("生成epub: %zu 字节\n", length);
+        KafFree(book);
+    }
+    KafTaskFree(task);
+    return (int)code;
+}
@@ -0,0 +1,119 @@
+/*
+ * kaf.h 动态库的C接口
+ *
+ * 编译动态库:
+ *   go build -buildmode=c-shared -o libkaf.so ./lib     (Windows为kaf.dll, macOS为libkaf.dylib)
+ *
+ * 参数都是UTF-8编码的JSON字符串, 字段和model.Book相同, 如:
+ *   {"Filename": "/path/小说.txt", "Author": "作者", "Format": "epub"}
+ *
+ * 返回的字符串和内存都由动态库分配, 使用后必须用KafFree释放。
+ * 返回退出码的函数和kaf-cli的退出码相同, 见KAF_EXIT_*。
+ */
+#ifndef KAF_H
+#define KAF_H
+
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* 退出码 */
+#define KAF_EXIT_OK       0 /* 转换成功 */
+#define KAF_EXIT_ARGS     1 /* 参数格式错误, 或任务不存在 */
+#define KAF_EXIT_CHECK    2 /* 检查文件和参数失败, 如文件不存在、不是txt、正则表达式错误 */
+#define KAF_EXIT_PARSE    3 /* 读取txt或识别章节失败 */
+#define KAF_EXIT_CONVERT  4 /* 生成电子书失败, 有一种格式失败时也返回这个值 */
+#define KAF_EXIT_CANCELED 5 /* 转换被KafTaskCancel中断 */
+
+/*
+ * 进度回调, 在动态库的线程中调用, 可能同时在多个线程中调用;
+ * 字符串只在回调期间有效。Qt中可以用QMetaObject::invokeMethod转到界面线程。
+ * stage: start、section、write、done、warning
+ */
+typedef void (*KafProgressFunc)(void *user, const char *format, const char *stage,
+                                int current, int total, const char *message);
+
+/* 释放动态库返回的字符串和内存, p为NULL时什么都不做 */
+void KafFree(void *p);
+
+/* 动态库的版本 */
+char *KafVersion(void);
+
+/*
+ * 动态库支持的功能, JSON格式:
+ *   {"version": "v1.0.0", "formats": ["epub", "mobi", "azw3"], "kindlegen": "/usr/bin/kindlegen",
+ *    "features": ["preview", "batch", "progress", "cancel", "memory"]}
+ */
+char *KafCapabilities(void);
+
+/*
+ * 最近一次失败的调用的错误信息, 没有错误时为空字符串。
+ * 多个线程同时调用时可能取到其他线程的错误, 这时请使用任务接口和KafTaskError。
+ */
+char *KafLastError(void);
+
+/* 转换txt, 返回退出码 */
+long long KafConvert(char *params);
+
+/* 识别章节, 返回JSON格式的目录; 失败时返回"ERROR: "开头的错误信息 */
+char *KafPreview(char *params);
+
+/*
+ * 批量转换, 参数在转换参数之外还有Inputs、OutDir、Workers、Force,
+ * 返回JSON格式的汇总; 失败时返回"ERROR: "开头的错误信息
+ */
+char *KafBatch(char *params);
+
+/*
+ * 任务接口: 一个任务对应一组转换参数, 可以设置进度回调、在其他线程中取消, 并单独记录错误。
+ * 没有设置的参数使用和kaf-cli相同的默认值。
+ *
+ *   long long task = KafTaskNew("{\"Format\": \"epub\"}");
+ *   KafTaskSetProgress(task, on_progress, window);
+ *   int code = KafTaskConvert(task);     // 在工作线程中调用, 转换完成后返回
+ *   KafTaskCancel(task);                 // 在界面线程中取消
+ *   char *err = KafTaskError(task);
+ *   KafFree(err);
+ *   KafTaskFree(task);
+ */
+
+/* 创建任务, 返回任务编号; 参数错误时返回0, 原因见KafLastError */
+long long KafTaskNew(char *params);
+
+/* 设置进度回调, callback为NULL时取消回调 */
+long long KafTaskSetProgress(long long task, KafProgressFunc callback, void *user);
+
+/* 转换参数中Filename指定的txt, 返回退出码 */
+long long KafTaskConvert(long long task);
+
+/*
+ * 在内存中转换: text为txt的内容(UTF-8或GBK), 长度为length;
+ * 成功时*out指向生成的电子书, 长度为*out_length, 使用后用KafFree释放。
+ * 只能生成一种格式并且不能分册, 参数中的Filename只用于识别书名和作者。
+ */
+long long KafTaskConvertMemory(long long task, char *text, size_t length,
+                               unsigned char **out, size_t *out_length);
+
+/* 中断任务, 可以在任意线程中调用; 中断后的任务不能再次转换 */
+void KafTaskCancel(long long task);
+
+/* 任务最近一次转换的错误信息, 没有错误时为空字符串 */
+char *KafTaskError(long long task);
+
+/*
+ * 任务最近一次转换的结果, JSON格式:
+ *   {"files": [{"format": "epub", "name": "小说.epub", "path": "/path/小说.epub", "size": 1024}],
+ *    "warnings": [], "seconds": 1.2}
+ */
+char *KafTaskResult(long long task);
+
+/* 释放任务, 正在转换时先中断 */
+void KafTaskFree(long long task);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* KAF_H */
@@ -1,13 +1,22 @@
 package main
 
+/*
+#include <stdlib.h>
+#include "kaf.h"
+*/
 import "C"
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"unsafe"
 
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
 	"github.com/Deali-Axy/ebook-generator/internal/converter"
 	"github.com/Deali-Axy/ebook-generator/internal/core"
 	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
 	"github.com/Deali-Axy/ebook-generator/pkg/analytics"
 )
 
@@ -17,27 +26,99 @@ var (
 	version     string
 )
 
+var (
+	lastErrMu sync.Mutex
+	lastErr   string
+)
+
+// setLastError 记录最近一次调用的错误, err为nil时清空
+func setLastError(err error) {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+	lastErr = ""
+	if err != nil {
+		lastErr = err.Error()
+	}
+}
+
+// errorString 返回"ERROR: "开头的错误信息并记录错误
+func errorString(prefix string, err error) *C.char {
+	setLastError(fmt.Errorf("%s, %w", prefix, err))
+	return C.CString(fmt.Sprintf("ERROR: %s, %s", prefix, err.Error()))
+}
+
+//export KafFree
+func KafFree(p unsafe.Pointer) {
+	C.free(p)
+}
+
+//export KafVersion
+func KafVersion() *C.char {
+	return C.CString(version)
+}
+
+// capabilities KafCapabilities的返回值
+type capabilities struct {
+	Version   string   `json:"version"`
+	Formats   []string `json:"formats"`
+	Kindlegen string   `json:"kindlegen"` // kindlegen的路径, 没有时mobi由内置的生成器生成
+	Features  []string `json:"features"`
+}
+
+// capabilitiesJSON 动态库支持的功能, JSON格式
+func capabilitiesJSON() string {
+	bs, _ := json.Marshal(capabilities{
+		Version:   version,
+		Formats:   []string{"epub", "mobi", "azw3"},
+		Kindlegen: utils.LookKindlegen(),
+		Features:  []string{"preview", "batch", "progress", "cancel", "memory"},
+	})
+	return string(bs)
+}
+
+//export KafCapabilities
+func KafCapabilities() *C.char {
+	return C.CString(capabilitiesJSON())
+}
+
+// getLastError 最近一次失败的调用的错误信息
+func getLastError() string {
+	lastErrMu.Lock()
+	defer lastErrMu.Unlock()
+	return lastErr
+}
+
+//export KafLastError
+func KafLastError() *C.char {
+	return C.CString(getLastError())
+}
+
 //export KafConvert
 func KafConvert(params *C.char) int64 {
 	var book model.Book
 	err := json.Unmarshal([]byte(C.GoString(params)), &book)
 	if err != nil {
-		return 1
+		setLastError(fmt.Errorf("参数错误: %w", err))
+		return core.ExitArgs
 	}
 	if err := core.Check(&book, version); err != nil {
-		return 2
+		setLastError(err)
+		return core.ExitCheck
 	}
 	analytics.Analytics(version, secret, measurement, book.Format)
 	if err := core.Parse(&book); err != nil {
-		return 3
+		setLastError(err)
+		return core.ExitParse
 	}
 	conv := converter.Dispatcher{
 		Book: &book,
 	}
-	if err := conv.Convert(); err != nil {
-		return 4
+	if err := conv.Convert(context.Background()); err != nil {
+		setLastError(err)
+		return core.ExitConvert
 	}
-	return 0
+	setLastError(nil)
+	return core.ExitOK
 }
 
 //export KafPreview
@@ -45,18 +126,64 @@ func KafPreview(params *C.char) *C.char {
 	var bookArg model.Book
 	err := json.Unmarshal([]byte(C.GoString(params)), &bookArg)
 	if err != nil {
-		return C.CString(fmt.Sprintf("ERROR: 参数错误, %s", err.Error()))
+		return errorString("参数错误", err)
 	}
 	if err := core.Check(&bookArg, version); err != nil {
-		return C.CString(fmt.Sprintf("ERROR: 参数错误, %s", err.Error()))
+		return errorString("参数错误", err)
 	}
 	if err := core.Parse(&bookArg); err != nil {
-		return C.CString(fmt.Sprintf("ERROR: 解析错误, %s", err.Error()))
+		return errorString("解析错误", err)
 	}
+	setLastError(nil)
 	bs, _ := json.Marshal(bookArg.SectionList)
 	return C.CString(string(bs))
+}
 
+// batchParams KafBatch的参数, 转换参数和KafConvert相同
+type batchParams struct {
+	model.Book
+	Inputs  []string // 目录、glob或txt文件
+	OutDir  string   // 输出目录, 按输入目录的结构生成子目录
+	Workers int      // 同时转换的文件数
+	Force   bool     // 输出文件比txt新时也重新转换
 }
+
+// runBatch 批量转换, 返回JSON格式的汇总并记录错误
+func runBatch(params string) (string, error) {
+	var arg batchParams
+	if err := json.Unmarshal([]byte(params), &arg); err != nil {
+		err = fmt.Errorf("参数错误, %w", err)
+		setLastError(err)
+		return "", err
+	}
+	analytics.Analytics(version, secret, measurement, arg.Format)
+	summary, err := batch.Run(context.Background(), batch.Options{
+		Book:    arg.Book,
+		Inputs:  arg.Inputs,
+		OutDir:  arg.OutDir,
+		Workers: arg.Workers,
+		Force:   arg.Force,
+		Version: version,
+	})
+	if err != nil {
+		err = fmt.Errorf("参数错误, %w", err)
+		setLastError(err)
+		return "", err
+	}
+	setLastError(nil)
+	bs, _ := json.Marshal(summary)
+	return string(bs), nil
+}
+
+//export KafBatch
+func KafBatch(params *C.char) *C.char {
+	summary, err := runBatch(C.GoString(params))
+	if err != nil {
+		return C.CString("ERROR: " + err.Error())
+	}
+	return C.CString(summary)
+}
+
 func main() {
 
 }
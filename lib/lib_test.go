@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+)
+
+// 动态库是main包, 不能在tests中导入, 这里测试导出函数背后的Go实现
+
+// writeTxt 在临时目录中写入有3章的txt, 返回文件路径
+func writeTxt(t *testing.T, dir, name string) string {
+	var content strings.Builder
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(&content, "第%d章 测试\n这是第%d章的正文内容。\n", i, i)
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0644))
+	return path
+}
+
+// taskParams 把参数编码为JSON
+func taskParams(t *testing.T, fields map[string]interface{}) []byte {
+	bs, err := json.Marshal(fields)
+	require.NoError(t, err)
+	return bs
+}
+
+func TestCapabilities(t *testing.T) {
+	var caps capabilities
+	require.NoError(t, json.Unmarshal([]byte(capabilitiesJSON()), &caps))
+	assert.Equal(t, []string{"epub", "mobi", "azw3"}, caps.Formats)
+	assert.Contains(t, caps.Features, "cancel")
+	assert.Contains(t, caps.Features, "memory")
+}
+
+func TestLastError(t *testing.T) {
+	assert.Zero(t, newTask([]byte("{")))
+	assert.Contains(t, getLastError(), "参数错误")
+
+	t.Run("成功的调用清空错误", func(t *testing.T) {
+		id := newTask([]byte("{}"))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+		assert.Empty(t, getLastError())
+	})
+
+	t.Run("任务不存在", func(t *testing.T) {
+		assert.Equal(t, int64(core.ExitArgs), KafTaskConvert(9999))
+		assert.Equal(t, "任务不存在: 9999", getLastError())
+		assert.Equal(t, "任务不存在: 9999", taskError(9999))
+		assert.Equal(t, "{}", taskResultJSON(9999))
+	})
+}
+
+func TestTask(t *testing.T) {
+	dir := t.TempDir()
+	txt := writeTxt(t, dir, "测试.txt")
+
+	t.Run("转换成功", func(t *testing.T) {
+		id := newTask(taskParams(t, map[string]interface{}{"Filename": txt, "Out": filepath.Join(dir, "测试"), "Format": "epub"}))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+		assert.Equal(t, "{}", taskResultJSON(id), "转换前没有结果")
+
+		require.Equal(t, int64(core.ExitOK), KafTaskConvert(id), taskError(id))
+		assert.Empty(t, taskError(id))
+		assert.Empty(t, getLastError())
+
+		var result taskResult
+		require.NoError(t, json.Unmarshal([]byte(taskResultJSON(id)), &result))
+		require.Len(t, result.Files, 1)
+		assert.Equal(t, "epub", result.Files[0].Format)
+		assert.Equal(t, filepath.Join(dir, "测试.epub"), result.Files[0].Path)
+		assert.FileExists(t, result.Files[0].Path)
+		assert.NotZero(t, result.Files[0].Size)
+	})
+
+	t.Run("检查失败时记录任务的错误", func(t *testing.T) {
+		id := newTask(taskParams(t, map[string]interface{}{"Filename": txt, "Match": "("}))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+
+		assert.Equal(t, int64(core.ExitCheck), KafTaskConvert(id))
+		assert.NotEmpty(t, taskError(id))
+		assert.Equal(t, taskError(id), getLastError())
+	})
+
+	t.Run("取消后不再转换", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "测试")
+		id := newTask(taskParams(t, map[string]interface{}{"Filename": txt, "Out": out}))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+
+		KafTaskCancel(id)
+		assert.Equal(t, int64(core.ExitCanceled), KafTaskConvert(id))
+		assert.Equal(t, "context canceled", taskError(id))
+		assert.Equal(t, "context canceled", getLastError())
+		assert.NoFileExists(t, out+".epub")
+	})
+
+	t.Run("释放后任务不存在", func(t *testing.T) {
+		id := newTask([]byte("{}"))
+		require.NotZero(t, id)
+		t.Cleanup(func() { KafTaskFree(id) })
+		task := getTask(id)
+		require.NotNil(t, task)
+
+		KafTaskFree(id)
+		assert.Nil(t, getTask(id))
+		assert.ErrorIs(t, task.ctx.Err(), context.Canceled, "释放时取消正在进行的转换")
+		assert.Equal(t, int64(core.ExitArgs), KafTaskConvert(id))
+	})
+}
+
+func TestTaskConvertMemory(t *testing.T) {
+	content, err := os.ReadFile(writeTxt(t, t.TempDir(), "测试.txt"))
+	require.NoError(t, err)
+
+	t.Run("生成epub", func(t *testing.T) {
+		id := newTask(taskParams(t, map[string]interface{}{"Bookname": "测试", "Format": "epub"}))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+
+		data, code := getTask(id).convertMemory(content)
+		require.Equal(t, int64(core.ExitOK), code, taskError(id))
+		assert.Equal(t, "PK", string(data[:2]))
+
+		var result taskResult
+		require.NoError(t, json.Unmarshal([]byte(taskResultJSON(id)), &result))
+		require.Len(t, result.Files, 1)
+		assert.Empty(t, result.Files[0].Path, "在内存中转换时没有路径")
+	})
+
+	t.Run("不能生成多种格式", func(t *testing.T) {
+		id := newTask(taskParams(t, map[string]interface{}{"Bookname": "测试", "Format": "all"}))
+		require.NotZero(t, id)
+		defer KafTaskFree(id)
+
+		data, code := getTask(id).convertMemory(content)
+		assert.Equal(t, int64(core.ExitCheck), code)
+		assert.Nil(t, data)
+		assert.Equal(t, "在内存中转换时只能生成一种格式, 并且不能分册", taskError(id))
+	})
+}
+
+func TestBatch(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	writeTxt(t, in, "一.txt")
+	writeTxt(t, in, "二.txt")
+
+	summary, err := runBatch(string(taskParams(t, map[string]interface{}{"Inputs": []string{in}, "OutDir": out, "Format": "epub"})))
+	require.NoError(t, err)
+	assert.Empty(t, getLastError())
+	assert.Contains(t, summary, "一.txt")
+	assert.FileExists(t, filepath.Join(out, "一.epub"))
+	assert.FileExists(t, filepath.Join(out, "二.epub"))
+
+	tests := []struct {
+		name   string
+		params string
+	}{
+		{"参数不是JSON", "{"},
+		{"没有输入", "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runBatch(tt.params)
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), "参数错误, "))
+			assert.Equal(t, err.Error(), getLastError())
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+/*
+#include <stdlib.h>
+#include "kaf.h"
+
+static inline void kafCallProgress(KafProgressFunc f, void *user, char *format, char *stage,
+                                   int current, int total, char *message) {
+	f(user, format, stage, current, total, message);
+}
+*/
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+	"github.com/Deali-Axy/ebook-generator/pkg/analytics"
+)
+
+// task 任务接口的一个任务, C程序只持有任务编号
+type task struct {
+	params []byte // 转换参数, 每次转换时重新解析
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	callback C.KafProgressFunc
+	user     unsafe.Pointer
+	err      string
+	result   []byte
+}
+
+// taskResult KafTaskResult的返回值
+type taskResult struct {
+	Files    []taskFile `json:"files"`
+	Warnings []string   `json:"warnings"`
+	Seconds  float64    `json:"seconds"`
+}
+
+// taskFile 生成的文件, 在内存中转换时没有路径和大小
+type taskFile struct {
+	Format string `json:"format"`
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+var (
+	tasksMu  sync.Mutex
+	tasks    = map[int64]*task{}
+	taskNext int64
+)
+
+func getTask(id int64) *task {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	return tasks[id]
+}
+
+// newTask 创建任务, 返回任务编号, 参数错误时返回0
+func newTask(bs []byte) int64 {
+	var book model.Book
+	if err := json.Unmarshal(bs, &book); err != nil {
+		setLastError(fmt.Errorf("参数错误: %w", err))
+		return 0
+	}
+	t := &task{params: bs}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	taskNext++
+	tasks[taskNext] = t
+	setLastError(nil)
+	return taskNext
+}
+
+//export KafTaskNew
+func KafTaskNew(params *C.char) int64 {
+	return newTask([]byte(C.GoString(params)))
+}
+
+//export KafTaskSetProgress
+func KafTaskSetProgress(id int64, callback C.KafProgressFunc, user unsafe.Pointer) int64 {
+	t := getTask(id)
+	if t == nil {
+		return core.ExitArgs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callback, t.user = callback, user
+	return core.ExitOK
+}
+
+//export KafTaskConvert
+func KafTaskConvert(id int64) int64 {
+	t := getTask(id)
+	if t == nil {
+		setLastError(fmt.Errorf("任务不存在: %d", id))
+		return core.ExitArgs
+	}
+	return t.run(func(book *model.Book) error {
+		return core.Check(book, version)
+	}, nil)
+}
+
+//export KafTaskConvertMemory
+func KafTaskConvertMemory(id int64, text *C.char, length C.size_t, out **C.uchar, outLength *C.size_t) int64 {
+	t := getTask(id)
+	if t == nil {
+		setLastError(fmt.Errorf("任务不存在: %d", id))
+		return core.ExitArgs
+	}
+	if out == nil || outLength == nil {
+		return t.finish(core.ExitArgs, errors.New("out和out_length不能为NULL"), nil)
+	}
+	*out, *outLength = nil, 0
+	data, code := t.convertMemory(C.GoBytes(unsafe.Pointer(text), C.int(length)))
+	if code == core.ExitOK {
+		*out = (*C.uchar)(C.CBytes(data))
+		*outLength = C.size_t(len(data))
+	}
+	return code
+}
+
+//export KafTaskCancel
+func KafTaskCancel(id int64) {
+	if t := getTask(id); t != nil {
+		t.cancel()
+	}
+}
+
+// taskError 任务最近一次转换的错误信息
+func taskError(id int64) string {
+	t := getTask(id)
+	if t == nil {
+		return fmt.Sprintf("任务不存在: %d", id)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+//export KafTaskError
+func KafTaskError(id int64) *C.char {
+	return C.CString(taskError(id))
+}
+
+// taskResultJSON 任务最近一次转换的结果, 没有结果时为"{}"
+func taskResultJSON(id int64) string {
+	t := getTask(id)
+	if t == nil {
+		return "{}"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.result == nil {
+		return "{}"
+	}
+	return string(t.result)
+}
+
+//export KafTaskResult
+func KafTaskResult(id int64) *C.char {
+	return C.CString(taskResultJSON(id))
+}
+
+//export KafTaskFree
+func KafTaskFree(id int64) {
+	tasksMu.Lock()
+	t := tasks[id]
+	delete(tasks, id)
+	tasksMu.Unlock()
+	if t != nil {
+		t.cancel()
+	}
+}
+
+// convertMemory 转换内存中的txt, 返回生成的电子书和退出码
+func (t *task) convertMemory(text []byte) ([]byte, int64) {
+	// 识别章节需要读取文件, 先把内容写到临时目录中
+	dir, err := os.MkdirTemp("", "kaf-")
+	if err != nil {
+		return nil, t.finish(core.ExitCheck, fmt.Errorf("创建临时目录失败: %w", err), nil)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	code := t.run(func(book *model.Book) error {
+		name := filepath.Base(book.Filename)
+		if book.Filename == "" {
+			name = "book.txt"
+		}
+		book.Filename = filepath.Join(dir, name)
+		if err := os.WriteFile(book.Filename, text, 0644); err != nil {
+			return fmt.Errorf("写入临时文件失败: %w", err)
+		}
+		if err := core.Check(book, version); err != nil {
+			return err
+		}
+		if book.Format == "all" || book.Split != model.SplitNone {
+			return errors.New("在内存中转换时只能生成一种格式, 并且不能分册")
+		}
+		return nil
+	}, &converter.WriterOutput{W: &buf})
+	if code != core.ExitOK {
+		return nil, code
+	}
+	return buf.Bytes(), code
+}
+
+// run 解析参数, 调用check检查后识别章节并生成电子书, output为空时写入Book.Out所在的目录
+func (t *task) run(check func(book *model.Book) error, output converter.Output) int64 {
+	start := time.Now()
+	if err := t.ctx.Err(); err != nil {
+		return t.finish(core.ExitCanceled, err, nil)
+	}
+	var book model.Book
+	if err := json.Unmarshal(t.params, &book); err != nil {
+		return t.finish(core.ExitArgs, fmt.Errorf("参数错误: %w", err), nil)
+	}
+	model.SetDefault(&book)
+	logger := &utils.WarningCollector{}
+	book.Log = logger
+	if err := check(&book); err != nil {
+		return t.finish(core.ExitCheck, err, nil)
+	}
+	analytics.Analytics(version, secret, measurement, book.Format)
+	if err := core.Parse(&book); err != nil {
+		return t.finish(core.ExitParse, err, nil)
+	}
+
+	conv := converter.Dispatcher{
+		Book:   &book,
+		Output: output,
+		Progress: func(p converter.Progress) {
+			converter.LogProgress(logger)(p)
+			t.progress(p)
+		},
+	}
+	result := &taskResult{Files: []taskFile{}}
+	dir := filepath.Dir(book.Out)
+	var errs []error
+	for _, r := range conv.Run(t.ctx) {
+		for _, name := range r.Files {
+			file := taskFile{Format: r.Format, Name: name}
+			if output == nil {
+				file.Path = filepath.Join(dir, name)
+				if info, err := os.Stat(file.Path); err == nil {
+					file.Size = info.Size()
+				}
+			}
+			result.Files = append(result.Files, file)
+		}
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("生成%s失败: %w", r.Format, r.Err))
+		}
+	}
+	result.Warnings = logger.Warnings()
+	if result.Warnings == nil {
+		result.Warnings = []string{}
+	}
+	result.Seconds = time.Since(start).Seconds()
+	if err := t.ctx.Err(); err != nil {
+		return t.finish(core.ExitCanceled, err, result)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return t.finish(core.ExitConvert, err, result)
+	}
+	return t.finish(core.ExitOK, nil, result)
+}
+
+// finish 记录转换的错误和结果, 返回退出码
+func (t *task) finish(code int64, err error, result *taskResult) int64 {
+	setLastError(err)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = ""
+	if err != nil {
+		t.err = err.Error()
+	}
+	t.result = nil
+	if result != nil {
+		t.result, _ = json.Marshal(result)
+	}
+	return code
+}
+
+// progress 把进度转给C的回调函数
+func (t *task) progress(p converter.Progress) {
+	t.mu.Lock()
+	callback, user := t.callback, t.user
+	t.mu.Unlock()
+	if callback == nil {
+		return
+	}
+	format, stage, message := C.CString(p.Format), C.CString(p.Stage), C.CString(p.Message)
+	defer C.free(unsafe.Pointer(format))
+	defer C.free(unsafe.Pointer(stage))
+	defer C.free(unsafe.Pointer(message))
+	C.kafCallProgress(callback, user, format, stage, C.int(p.Current), C.int(p.Total), message)
+}
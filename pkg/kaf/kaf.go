@@ -0,0 +1,190 @@
+// Package kaf 把txt小说转换为epub、mobi、azw3电子书, 供其他Go程序嵌入使用
+//
+// 用选项创建Converter, 之后可以并发地转换多个文件:
+//
+//	conv, err := kaf.New(
+//		kaf.WithFormat(kaf.EPUB),
+//		kaf.WithAuthor("作者"),
+//		kaf.WithOutputDir("books"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := conv.Convert(ctx, "小说.txt")
+//
+// 转换过程中的提示信息默认不输出, 可以用WithLogger和WithProgress获取。
+package kaf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// Version 写入电子书的软件版本, 可以在编译时用-ldflags "-X"修改
+var Version = "v1.0.0"
+
+// Format 电子书格式
+type Format string
+
+const (
+	EPUB Format = "epub"
+	MOBI Format = "mobi"
+	AZW3 Format = "azw3"
+	All  Format = "all" // 同时生成epub、mobi和azw3
+)
+
+// Logger 转换过程中的提示信息和警告
+type Logger interface {
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// Converter 转换器, 创建后参数不再改变, 可以在多个goroutine中使用
+type Converter struct {
+	book     model.Book // 转换参数, 每次转换时复制一份
+	outDir   string
+	writer   io.Writer
+	mu       sync.Mutex // 写入writer时只能有一个转换
+	logger   Logger
+	progress func(Progress)
+}
+
+// Option 创建Converter时的选项
+type Option func(c *Converter) error
+
+// New 创建转换器, 没有设置的参数使用和kaf-cli相同的默认值, 格式默认为epub, 不添加制作说明
+func New(opts ...Option) (*Converter, error) {
+	c := &Converter{
+		book: model.Book{
+			Match:            model.DefaultMatchTips,
+			VolumeMatch:      model.VolumeMatch,
+			ExclusionPattern: model.DefaultExclusion,
+			Max:              35,
+			Indent:           2,
+			Align:            "center",
+			UnknowTitle:      "章节正文",
+			CoverOrlyIdx:     -1,
+			Bottom:           "1em",
+			Lang:             "zh",
+			Format:           string(EPUB),
+		},
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.writer != nil && (c.book.Format == string(All) || c.book.Split != model.SplitNone) {
+		return nil, errors.New("输出到Writer时只能生成一种格式, 并且不能分册")
+	}
+	return c, nil
+}
+
+// Parse 识别章节, 返回的书籍包含正文
+func (c *Converter) Parse(ctx context.Context, filename string) (*Book, error) {
+	book, _, err := c.parse(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return newBook(book, true), nil
+}
+
+// Preview 识别章节, 返回的书籍只有目录和每章的字数
+func (c *Converter) Preview(ctx context.Context, filename string) (*Book, error) {
+	book, _, err := c.parse(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return newBook(book, false), nil
+}
+
+// Convert 识别章节并生成电子书
+//
+// 有格式生成失败时返回已生成的结果和错误, 错误为一个或多个*Error。ctx取消时尽快返回ctx.Err()。
+func (c *Converter) Convert(ctx context.Context, filename string) (*Result, error) {
+	start := time.Now()
+	book, logger, err := c.parse(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	info := newBook(book, false)
+	result := &Result{
+		Title:    info.Title,
+		Author:   info.Author,
+		Volumes:  info.Volumes,
+		Chapters: info.Chapters,
+	}
+
+	conv := converter.Dispatcher{
+		Book: book,
+		Progress: func(p converter.Progress) {
+			converter.LogProgress(logger)(p)
+			if c.progress != nil {
+				c.progress(Progress(p))
+			}
+		},
+	}
+	dir := filepath.Dir(book.Out)
+	if c.writer != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		conv.Output = &converter.WriterOutput{W: c.writer}
+		dir = ""
+	}
+	var errs []error
+	for _, r := range conv.Run(ctx) {
+		for _, name := range r.Files {
+			file := File{Format: Format(r.Format), Name: name}
+			if dir != "" {
+				file.Path = filepath.Join(dir, name)
+				if info, err := os.Stat(file.Path); err == nil {
+					file.Size = info.Size()
+				}
+			}
+			result.Files = append(result.Files, file)
+		}
+		if r.Err != nil {
+			errs = append(errs, &Error{Stage: StageConvert, Format: Format(r.Format), Err: r.Err})
+		}
+	}
+	result.Warnings = logger.Warnings()
+	result.Elapsed = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, errors.Join(errs...)
+}
+
+// parse 复制转换参数, 检查后识别章节
+func (c *Converter) parse(ctx context.Context, filename string) (*model.Book, *utils.WarningCollector, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	logger := &utils.WarningCollector{Next: c.logger}
+	book := c.book
+	book.Filename = filename
+	book.Log = logger
+	if err := core.Check(&book, Version); err != nil {
+		return nil, nil, &Error{Stage: StageCheck, Err: err}
+	}
+	if c.outDir != "" {
+		book.Out = filepath.Join(c.outDir, filepath.Base(book.Out))
+	}
+	if err := core.Parse(&book); err != nil {
+		return nil, nil, &Error{Stage: StageParse, Err: err}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &book, logger, nil
+}
@@ -0,0 +1,347 @@
+package kaf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// 重新编号的方式
+const (
+	RenumberVolume = model.RenumberVolume // 每卷从1开始
+	RenumberGlobal = model.RenumberGlobal // 全书连续编号
+)
+
+// WithFormat 设置生成的格式, 默认为epub
+func WithFormat(format Format) Option {
+	return func(c *Converter) error {
+		switch format {
+		case EPUB, MOBI, AZW3, All:
+			c.book.Format = string(format)
+			return nil
+		}
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+}
+
+// WithBookname 设置书名, 默认从文件名识别
+func WithBookname(name string) Option {
+	return func(c *Converter) error {
+		c.book.Bookname = name
+		return nil
+	}
+}
+
+// WithAuthor 设置作者, 默认从"《书名》作者：xx.txt"格式的文件名识别
+func WithAuthor(author string) Option {
+	return func(c *Converter) error {
+		c.book.Author = author
+		return nil
+	}
+}
+
+// WithDescription 设置简介
+func WithDescription(description string) Option {
+	return func(c *Converter) error {
+		c.book.Description = description
+		return nil
+	}
+}
+
+// WithLang 设置语言, 如zh、en、ja, 默认为zh
+func WithLang(lang string) Option {
+	return func(c *Converter) error {
+		c.book.Lang = lang
+		return nil
+	}
+}
+
+// WithDate 设置出版日期
+func WithDate(date time.Time) Option {
+	return func(c *Converter) error {
+		c.book.Date = date.Format("2006-01-02")
+		return nil
+	}
+}
+
+// WithCover 使用本地图片作为封面, 默认没有封面
+func WithCover(path string) Option {
+	return func(c *Converter) error {
+		c.book.Cover = path
+		return nil
+	}
+}
+
+// WithOrlyCover 生成orly风格的封面, 需要连接网络; color为1-16或hex颜色, idx为0-41的动物, 为空和-1时随机
+func WithOrlyCover(color string, idx int) Option {
+	return func(c *Converter) error {
+		c.book.Cover = "orly"
+		c.book.CoverOrlyColor = color
+		c.book.CoverOrlyIdx = idx
+		return nil
+	}
+}
+
+// WithIndent 设置段落缩进的字数, 默认为2
+func WithIndent(n uint) Option {
+	return func(c *Converter) error {
+		c.book.Indent = n
+		return nil
+	}
+}
+
+// WithAlign 设置标题对齐方式: left、center、right, 默认为center
+func WithAlign(align string) Option {
+	return func(c *Converter) error {
+		switch align {
+		case "left", "center", "right":
+			c.book.Align = align
+			return nil
+		}
+		return fmt.Errorf("不支持的对齐方式: %s", align)
+	}
+}
+
+// WithParagraphSpacing 设置段落间距, 如1em、10px, 默认为1em
+func WithParagraphSpacing(spacing string) Option {
+	return func(c *Converter) error {
+		c.book.Bottom = spacing
+		return nil
+	}
+}
+
+// WithLineHeight 设置行高, 如1.5rem
+func WithLineHeight(height string) Option {
+	return func(c *Converter) error {
+		c.book.LineHeight = height
+		return nil
+	}
+}
+
+// WithFont 嵌入正文字体, 只嵌入书中用到的字
+func WithFont(path string) Option {
+	return func(c *Converter) error {
+		c.book.Font = path
+		return nil
+	}
+}
+
+// WithTitleFont 嵌入书名和章节标题的字体
+func WithTitleFont(path string) Option {
+	return func(c *Converter) error {
+		c.book.TitleFont = path
+		return nil
+	}
+}
+
+// WithMatch 设置匹配章节标题的正则表达式, 默认自动识别常见的标题
+func WithMatch(pattern string) Option {
+	return func(c *Converter) error {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("章节规则错误: %w", err)
+		}
+		c.book.Match = pattern
+		return nil
+	}
+}
+
+// WithVolumeMatch 设置匹配卷标题的正则表达式
+func WithVolumeMatch(pattern string) Option {
+	return func(c *Converter) error {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("卷规则错误: %w", err)
+		}
+		c.book.VolumeMatch = pattern
+		return nil
+	}
+}
+
+// WithoutVolumes 不识别卷
+func WithoutVolumes() Option {
+	return func(c *Converter) error {
+		c.book.VolumeMatch = "false"
+		return nil
+	}
+}
+
+// WithExclude 设置排除无效标题的正则表达式, 为空时不排除
+func WithExclude(pattern string) Option {
+	return func(c *Converter) error {
+		if pattern == "" {
+			pattern = "false"
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("排除规则错误: %w", err)
+		}
+		c.book.ExclusionPattern = pattern
+		return nil
+	}
+}
+
+// WithMaxTitleLength 设置标题的最大字数, 超过的行不作为标题, 默认为35
+func WithMaxTitleLength(n uint) Option {
+	return func(c *Converter) error {
+		if n == 0 {
+			return errors.New("标题最大字数不能为0")
+		}
+		c.book.Max = n
+		return nil
+	}
+}
+
+// WithUnknownTitle 设置第一个标题前的内容的章节名, 默认为"章节正文"
+func WithUnknownTitle(title string) Option {
+	return func(c *Converter) error {
+		c.book.UnknowTitle = title
+		return nil
+	}
+}
+
+// WithTitleTemplate 设置章节和卷的标题模板, 如"第{n:cn}章 {title}", 为空时保留原标题
+func WithTitleTemplate(chapter, volume string) Option {
+	return func(c *Converter) error {
+		for _, template := range []string{chapter, volume} {
+			if _, err := model.ParseTitleTemplate(template); err != nil {
+				return err
+			}
+		}
+		c.book.TitleTemplate = chapter
+		c.book.VolumeTemplate = volume
+		return nil
+	}
+}
+
+// WithRenumber 使用标题模板时重新编号, mode为RenumberVolume或RenumberGlobal
+func WithRenumber(mode string) Option {
+	return func(c *Converter) error {
+		switch mode {
+		case RenumberVolume, RenumberGlobal:
+			c.book.Renumber = mode
+			return nil
+		}
+		return fmt.Errorf("不支持的编号方式: %s", mode)
+	}
+}
+
+// WithTitleJunk 设置整理标题时去掉的标题末尾内容(正则), 为空时不去掉; 默认去掉"(求月票)"之类的内容
+func WithTitleJunk(pattern string) Option {
+	return func(c *Converter) error {
+		if pattern == "" {
+			pattern = "false"
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("标题去除规则错误: %w", err)
+		}
+		c.book.TitleJunk = pattern
+		return nil
+	}
+}
+
+// WithSplitSections 按章节数分册, 每册n章
+func WithSplitSections(n int) Option {
+	return func(c *Converter) error {
+		c.book.Split, c.book.SplitCount = model.SplitSections, n
+		return nil
+	}
+}
+
+// WithSplitSize 按正文大小分册, 每册不超过kb KB
+func WithSplitSize(kb int) Option {
+	return func(c *Converter) error {
+		c.book.Split, c.book.SplitSize = model.SplitSize, kb
+		return nil
+	}
+}
+
+// WithSplitVolumes 按卷分册, 每册n卷
+func WithSplitVolumes(n int) Option {
+	return func(c *Converter) error {
+		c.book.Split, c.book.SplitCount = model.SplitVolume, n
+		return nil
+	}
+}
+
+// WithSplitRanges 按章节范围分册, 如"1-500,501-"
+func WithSplitRanges(ranges string) Option {
+	return func(c *Converter) error {
+		if _, err := model.ParseRanges(ranges); err != nil {
+			return err
+		}
+		c.book.Split, c.book.SplitRanges = model.SplitRange, ranges
+		return nil
+	}
+}
+
+// WithOutputDir 把电子书写到目录中, 默认写到txt所在的目录
+func WithOutputDir(dir string) Option {
+	return func(c *Converter) error {
+		c.outDir = dir
+		return nil
+	}
+}
+
+// WithOutputName 设置输出的文件名, 不包含格式后缀, 默认为书名
+func WithOutputName(name string) Option {
+	return func(c *Converter) error {
+		c.book.Out = name
+		return nil
+	}
+}
+
+// WithWriter 把电子书写到w, 只能生成一种格式并且不能分册; 同一个Converter的多次转换会依次写入w
+func WithWriter(w io.Writer) Option {
+	return func(c *Converter) error {
+		c.writer = w
+		return nil
+	}
+}
+
+// WithTips 在书的开头和结尾添加制作说明
+func WithTips(tips bool) Option {
+	return func(c *Converter) error {
+		c.book.Tips = tips
+		return nil
+	}
+}
+
+// WithMobiKF8 mobi生成同时包含MOBI7和KF8的双格式文件
+func WithMobiKF8() Option {
+	return func(c *Converter) error {
+		c.book.MobiKF8 = true
+		return nil
+	}
+}
+
+// WithReproducible 相同的txt和参数生成完全相同的文件, 时间取自SOURCE_DATE_EPOCH或WithDate
+func WithReproducible() Option {
+	return func(c *Converter) error {
+		c.book.Reproducible = true
+		return nil
+	}
+}
+
+// WithEpubCheck 生成epub后检查是否符合规范, 不符合时生成失败
+func WithEpubCheck() Option {
+	return func(c *Converter) error {
+		c.book.EpubCheck = true
+		return nil
+	}
+}
+
+// WithLogger 接收转换过程中的提示信息和警告, 默认不输出; 警告也会记录在Result.Warnings中
+func WithLogger(logger Logger) Option {
+	return func(c *Converter) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithProgress 接收生成电子书的进度, 可能在多个goroutine中被调用
+func WithProgress(progress func(Progress)) Option {
+	return func(c *Converter) error {
+		c.progress = progress
+		return nil
+	}
+}
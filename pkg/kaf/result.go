@@ -0,0 +1,130 @@
+package kaf
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// 转换失败的阶段
+const (
+	StageCheck   = "check"   // 检查文件和参数
+	StageParse   = "parse"   // 读取txt并识别章节
+	StageConvert = "convert" // 生成电子书
+)
+
+// Error 转换失败的原因
+type Error struct {
+	Stage  string // 失败的阶段, 为StageCheck、StageParse或StageConvert
+	Format Format // 生成失败的格式, 只在StageConvert时有
+	Err    error
+}
+
+func (e *Error) Error() string {
+	switch e.Stage {
+	case StageCheck:
+		return fmt.Sprintf("参数错误: %v", e.Err)
+	case StageParse:
+		return fmt.Sprintf("识别章节失败: %v", e.Err)
+	}
+	return fmt.Sprintf("生成%s失败: %v", e.Format, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Book 识别出的书籍
+type Book struct {
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Volumes  int       `json:"volumes"`  // 卷数
+	Chapters int       `json:"chapters"` // 章节数, 不计卷
+	Words    int       `json:"words"`    // 正文字数
+	Sections []Section `json:"sections"` // 卷和不在卷中的章节, 不包含制作说明
+}
+
+// Section 卷或章节
+type Section struct {
+	Title      string    `json:"title"`
+	Words      int       `json:"words"`                // 正文字数, 不包含卷中的章节
+	Paragraphs []string  `json:"paragraphs,omitempty"` // 正文段落, Preview时为空
+	Sections   []Section `json:"sections,omitempty"`   // 卷中的章节
+}
+
+// Result 转换结果
+type Result struct {
+	Title    string        `json:"title"`
+	Author   string        `json:"author"`
+	Volumes  int           `json:"volumes"`
+	Chapters int           `json:"chapters"`
+	Files    []File        `json:"files"`
+	Warnings []string      `json:"warnings,omitempty"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// File 生成的文件
+type File struct {
+	Format Format `json:"format"`
+	Name   string `json:"name"`           // 文件名
+	Path   string `json:"path,omitempty"` // 完整路径, 输出到Writer时为空
+	Size   int64  `json:"size,omitempty"` // 文件大小, 输出到Writer时为0
+}
+
+// Progress 生成电子书的进度
+type Progress struct {
+	Format  string        // 书籍格式
+	Stage   string        // 当前阶段: start、section、write、done、warning
+	Current int           // 已处理的章节数
+	Total   int           // 章节总数
+	Message string        // 提示信息
+	Elapsed time.Duration // 已耗时
+}
+
+var tagReg = regexp.MustCompile(`<[^>]*>`)
+
+// newBook 把识别结果转为公开的Book, 标题和正文都是未转义的文本
+func newBook(book *model.Book, content bool) *Book {
+	result := &Book{Title: book.Bookname, Author: book.Author}
+	for _, s := range book.SectionList {
+		if s.Content == model.Tutorial {
+			continue
+		}
+		section := newSection(s, content)
+		if len(s.Sections) > 0 {
+			result.Volumes++
+			result.Chapters += len(s.Sections)
+			for _, sub := range s.Sections {
+				section.Sections = append(section.Sections, newSection(sub, content))
+			}
+		} else {
+			result.Chapters++
+		}
+		result.Words += section.Words
+		for _, sub := range section.Sections {
+			result.Words += sub.Words
+		}
+		result.Sections = append(result.Sections, section)
+	}
+	return result
+}
+
+func newSection(s model.Section, content bool) Section {
+	section := Section{Title: html.UnescapeString(s.Title)}
+	for _, p := range strings.SplitAfter(s.Content, "</p>") {
+		text := strings.TrimSpace(html.UnescapeString(tagReg.ReplaceAllString(p, "")))
+		if text == "" {
+			continue
+		}
+		section.Words += utf8.RuneCountInString(strings.Join(strings.Fields(text), ""))
+		if content {
+			section.Paragraphs = append(section.Paragraphs, text)
+		}
+	}
+	return section
+}
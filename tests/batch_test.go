@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// batchBook 批量转换共用的参数, 只生成epub
+func batchBook() model.Book {
+	book := model.Book{Format: "epub", Log: utils.NopLogger{}}
+	model.SetDefault(&book)
+	return book
+}
+
+// writeTxt 在dir下写入txt, name可以包含子目录
+func writeTxt(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// batchInbox 两个txt在不同的目录中, 另有一个不是txt的文件
+func batchInbox(t *testing.T) string {
+	dir := t.TempDir()
+	writeTxt(t, dir, "甲.txt", "第1章 开始\n正文\n第2章 结束\n正文\n")
+	writeTxt(t, dir, "子目录/乙.txt", "第1章 开始\n正文\n")
+	writeTxt(t, dir, "子目录/说明.md", "不是txt")
+	return dir
+}
+
+// resultStatus 按文件名汇总每个文件的状态
+func resultStatus(summary *batch.Summary) map[string]string {
+	status := make(map[string]string)
+	for _, r := range summary.Results {
+		status[filepath.Base(r.File)] = r.Status
+	}
+	return status
+}
+
+// TestBatchRun 测试批量转换
+func TestBatchRun(t *testing.T) {
+	t.Run("目录按原来的结构输出", func(t *testing.T) {
+		inbox := batchInbox(t)
+		out := t.TempDir()
+		var progress int
+		summary, err := batch.Run(context.Background(), batch.Options{
+			Book:     batchBook(),
+			Inputs:   []string{inbox},
+			OutDir:   out,
+			Workers:  2,
+			Progress: func(batch.Result) { progress++ },
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Total)
+		assert.Equal(t, 2, summary.Succeeded)
+		assert.Equal(t, 2, progress, "每个文件完成时调用一次")
+		assert.FileExists(t, filepath.Join(out, "甲.epub"))
+		assert.FileExists(t, filepath.Join(out, "子目录", "乙.epub"))
+		for _, r := range summary.Results {
+			require.Len(t, r.Outputs, 1)
+			assert.FileExists(t, r.Outputs[0])
+		}
+		assert.Equal(t, map[string]int{"甲.txt": 2, "乙.txt": 1}, map[string]int{
+			filepath.Base(summary.Results[0].File): summary.Results[0].Chapters,
+			filepath.Base(summary.Results[1].File): summary.Results[1].Chapters,
+		})
+	})
+
+	t.Run("输出文件已是最新时跳过", func(t *testing.T) {
+		inbox := batchInbox(t)
+		opts := batch.Options{Book: batchBook(), Inputs: []string{inbox}}
+		_, err := batch.Run(context.Background(), opts)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(inbox, "甲.epub"), "没有输出目录时输出到txt所在的目录")
+
+		summary, err := batch.Run(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Skipped)
+
+		opts.Force = true
+		summary, err = batch.Run(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Succeeded, "-force时重新转换")
+	})
+
+	t.Run("单个文件失败不影响其它文件", func(t *testing.T) {
+		inbox := batchInbox(t)
+		summary, err := batch.Run(context.Background(), batch.Options{
+			Book: batchBook(),
+			Inputs: []string{
+				filepath.Join(inbox, "*.txt"),
+				filepath.Join(inbox, "甲.txt"),
+				filepath.Join(inbox, "子目录", "说明.md"),
+			},
+			OutDir: t.TempDir(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Total, "重复的文件只转换一次")
+		assert.Equal(t, map[string]string{"甲.txt": batch.StatusSuccess, "说明.md": batch.StatusFailed}, resultStatus(summary))
+		assert.Equal(t, 1, summary.Failed)
+		for _, r := range summary.Results {
+			if r.Status == batch.StatusFailed {
+				assert.NotEmpty(t, r.Error)
+			}
+		}
+	})
+
+	t.Run("取消后不再开始新的文件", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		summary, err := batch.Run(ctx, batch.Options{Book: batchBook(), Inputs: []string{batchInbox(t)}, OutDir: t.TempDir()})
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Failed)
+	})
+
+	inputErrors := []struct {
+		name   string
+		inputs func(dir string) []string
+	}{
+		{"没有txt", func(dir string) []string { return []string{dir} }},
+		{"文件不存在", func(dir string) []string { return []string{filepath.Join(dir, "不存在.txt")} }},
+		{"glob格式错误", func(dir string) []string { return []string{"[*.txt"} }},
+	}
+	for _, tt := range inputErrors {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := batch.Run(context.Background(), batch.Options{Book: batchBook(), Inputs: tt.inputs(t.TempDir())})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestBatchSummary 测试汇总的输出格式
+func TestBatchSummary(t *testing.T) {
+	summary := &batch.Summary{
+		Total: 3, Succeeded: 1, Failed: 1, Skipped: 1, Seconds: 1.5,
+		Results: []batch.Result{
+			{File: "a.txt", Status: batch.StatusSuccess, Chapters: 10, Seconds: 1},
+			{File: "b.txt", Status: batch.StatusFailed, Error: "不是txt文件"},
+			{File: "c.txt", Status: batch.StatusSkipped},
+		},
+	}
+
+	var text bytes.Buffer
+	summary.WriteText(&text)
+	assert.Contains(t, text.String(), "成功  a.txt  10章")
+	assert.Contains(t, text.String(), "失败  b.txt  不是txt文件")
+	assert.Contains(t, text.String(), "跳过  c.txt")
+	assert.Contains(t, text.String(), "共3个文件: 成功1个, 失败1个, 跳过1个")
+
+	var out bytes.Buffer
+	require.NoError(t, summary.WriteJSON(&out))
+	var decoded batch.Summary
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, summary.Results, decoded.Results)
+	assert.Equal(t, 1, decoded.Failed)
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/browser"
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+)
+
+// browserTxt 有一卷两章的txt内容
+const browserTxt = "第一卷 上卷\n第1章 开始\n开始的正文。\n第2章 结束\n结束的正文。\n"
+
+// TestBrowserParse 测试按浏览器传入的选项识别章节
+func TestBrowserParse(t *testing.T) {
+	t.Run("默认选项", func(t *testing.T) {
+		book, err := browser.Parse([]byte(browserTxt), "", "test")
+		require.NoError(t, err)
+		assert.Equal(t, "book", book.Bookname)
+		assert.Equal(t, "epub", book.Format)
+
+		contents := browser.Contents(book.SectionList)
+		require.Len(t, contents, 1)
+		assert.Equal(t, "第一卷 上卷", contents[0].Title)
+		require.Len(t, contents[0].Sections, 2)
+		assert.Equal(t, "第1章 开始", contents[0].Sections[0].Title)
+		assert.Empty(t, contents[0].Sections[0].Content, "目录不包含正文")
+		assert.NotEmpty(t, book.SectionList[0].Sections[0].Content, "不修改原来的章节")
+	})
+
+	t.Run("书名和作者", func(t *testing.T) {
+		book, err := browser.Parse([]byte(browserTxt), `{"Filename": "小说.txt", "Author": "作者"}`, "test")
+		require.NoError(t, err)
+		assert.Equal(t, "小说", book.Bookname)
+		assert.Equal(t, "作者", book.Author)
+	})
+
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{"选项不是JSON", "{", "参数错误"},
+		{"生成其他格式", `{"Format": "mobi"}`, "浏览器中只能生成epub: mobi"},
+		{"生成多种格式", `{"Format": "all"}`, "浏览器中只能生成epub: all"},
+		{"分册", `{"Split": "volume"}`, "浏览器中不能分册"},
+		{"章节规则错误", `{"Match": "("}`, "生成匹配规则出错"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book, err := browser.Parse([]byte(browserTxt), tt.options, "test")
+			require.Error(t, err)
+			assert.Nil(t, book)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestBrowserConvert 测试在内存中生成epub
+func TestBrowserConvert(t *testing.T) {
+	book, err := browser.Parse([]byte(browserTxt), `{"Filename": "小说.txt"}`, "test")
+	require.NoError(t, err)
+	var stages []string
+	result, err := browser.Convert(context.Background(), book, func(p converter.Progress) {
+		stages = append(stages, p.Stage)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "小说.epub", result.Name)
+	assert.Contains(t, stages, "done")
+
+	zr, err := zip.NewReader(bytes.NewReader(result.Data), int64(len(result.Data)))
+	require.NoError(t, err, "生成的应该是epub")
+	var text strings.Builder
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".xhtml") {
+			continue
+		}
+		rc, err := f.Open()
+		require.NoError(t, err)
+		bs, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		text.Write(bs)
+	}
+	assert.Contains(t, text.String(), "结束的正文。")
+
+	t.Run("没有进度回调", func(t *testing.T) {
+		book, err := browser.Parse([]byte(browserTxt), "", "test")
+		require.NoError(t, err)
+		result, err := browser.Convert(context.Background(), book, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "book.epub", result.Name)
+		assert.NotEmpty(t, result.Data)
+	})
+
+	t.Run("已取消", func(t *testing.T) {
+		book, err := browser.Parse([]byte(browserTxt), "", "test")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		result, err := browser.Convert(ctx, book, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, result)
+	})
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// parsedBook 解析有count章的txt, format为要生成的格式
+func parsedBook(t *testing.T, format string, count int) *model.Book {
+	book := &model.Book{Filename: testTxt(t, "测试.txt", count), Bookname: "测试", Format: format}
+	model.SetDefault(book)
+	require.NoError(t, core.Check(book, "1.0.0"))
+	require.NoError(t, core.Parse(book))
+	return book
+}
+
+var errCreateFailed = errors.New("磁盘已满")
+
+// failOutput 写入目录, 创建扩展名为ext的文件时失败
+//
+// 不是DirOutput, 生成时不会使用kindlegen, 结果和环境无关。
+type failOutput struct {
+	dir converter.DirOutput
+	ext string
+}
+
+func (o failOutput) Create(name string) (io.WriteCloser, error) {
+	if filepath.Ext(name) == o.ext {
+		return nil, errCreateFailed
+	}
+	return o.dir.Create(name)
+}
+
+// gateOutput 记录同时创建文件的格式数, 创建文件时最多等待wait, 直到曾有want个格式同时在创建
+type gateOutput struct {
+	dir    converter.DirOutput
+	want   int
+	wait   time.Duration
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (o *gateOutput) Create(name string) (io.WriteCloser, error) {
+	o.mu.Lock()
+	o.active++
+	o.max = max(o.max, o.active)
+	o.mu.Unlock()
+
+	for deadline := time.Now().Add(o.wait); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		o.mu.Lock()
+		reached := o.max >= o.want
+		o.mu.Unlock()
+		if reached {
+			break
+		}
+	}
+
+	o.mu.Lock()
+	o.active--
+	o.mu.Unlock()
+	return o.dir.Create(name)
+}
+
+// resultErrors 每个格式的错误
+func resultErrors(results []converter.Result) map[string]error {
+	errs := make(map[string]error)
+	for _, r := range results {
+		errs[r.Format] = r.Err
+	}
+	return errs
+}
+
+// TestDispatcherErrors 测试单个格式失败时返回错误, 其他格式正常生成
+func TestDispatcherErrors(t *testing.T) {
+	dir := t.TempDir()
+	d := converter.Dispatcher{
+		Book:     parsedBook(t, "all", 3),
+		Output:   failOutput{dir: converter.DirOutput{Dir: dir}, ext: ".azw3"},
+		Progress: func(converter.Progress) {},
+	}
+
+	results := d.Run(context.Background())
+	errs := resultErrors(results)
+	require.Len(t, errs, 3)
+	assert.NoError(t, errs["epub"])
+	assert.NoError(t, errs["mobi"])
+	assert.ErrorIs(t, errs["azw3"], errCreateFailed)
+	for _, r := range results {
+		if r.Err == nil {
+			assert.Equal(t, []string{"测试." + r.Format}, r.Files)
+			assert.FileExists(t, filepath.Join(dir, "测试."+r.Format))
+		}
+	}
+
+	t.Run("Convert合并所有格式的错误", func(t *testing.T) {
+		d := converter.Dispatcher{
+			Book:     parsedBook(t, "all", 3),
+			Output:   failOutput{dir: converter.DirOutput{Dir: t.TempDir()}, ext: ".mobi"},
+			Progress: func(converter.Progress) {},
+		}
+		err := d.Convert(context.Background())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, errCreateFailed)
+		assert.Contains(t, err.Error(), "生成mobi失败")
+		assert.NotContains(t, err.Error(), "生成epub失败")
+	})
+
+	t.Run("全部成功时没有错误", func(t *testing.T) {
+		d := converter.Dispatcher{
+			Book:     parsedBook(t, "epub", 3),
+			Output:   failOutput{dir: converter.DirOutput{Dir: t.TempDir()}, ext: ".mobi"},
+			Progress: func(converter.Progress) {},
+		}
+		assert.NoError(t, d.Convert(context.Background()))
+	})
+}
+
+// TestDispatcherCancel 测试生成时取消
+func TestDispatcherCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var done bool
+	d := converter.Dispatcher{
+		Book:   parsedBook(t, "epub", 20),
+		Output: failOutput{dir: converter.DirOutput{Dir: dir}},
+		Progress: func(p converter.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			// 添加第一章后取消
+			if p.Stage == converter.StageSection {
+				cancel()
+			}
+			if p.Stage == converter.StageDone {
+				done = true
+			}
+		},
+	}
+
+	err := d.Convert(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, done, "取消后不报告完成")
+	assert.NoFileExists(t, filepath.Join(dir, "测试.epub"))
+
+	t.Run("开始前已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		d := converter.Dispatcher{
+			Book:     parsedBook(t, "all", 3),
+			Output:   failOutput{dir: converter.DirOutput{Dir: t.TempDir()}},
+			Progress: func(converter.Progress) {},
+		}
+		results := d.Run(ctx)
+		require.NotEmpty(t, results)
+		for _, r := range results {
+			assert.ErrorIs(t, r.Err, context.Canceled, r.Format)
+			assert.Empty(t, r.Files, r.Format)
+		}
+		assert.ErrorIs(t, d.Convert(ctx), context.Canceled)
+	})
+}
+
+// TestDispatcherWorkers 测试Workers限制同时生成的格式数
+func TestDispatcherWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		wait    time.Duration
+		want    int
+	}{
+		{"每次生成一个格式", 1, 50 * time.Millisecond, 1},
+		{"同时生成所有格式", 3, 10 * time.Second, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &gateOutput{dir: converter.DirOutput{Dir: t.TempDir()}, want: 3, wait: tt.wait}
+			d := converter.Dispatcher{
+				Book:     parsedBook(t, "all", 3),
+				Output:   out,
+				Progress: func(converter.Progress) {},
+				Workers:  tt.workers,
+			}
+			require.NoError(t, d.Convert(context.Background()))
+			assert.Equal(t, tt.want, out.max)
+		})
+	}
+}
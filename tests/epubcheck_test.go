@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/epubcheck"
+)
+
+// epubFile epub中的一个文件
+type epubFile struct {
+	name    string
+	content string
+}
+
+const testContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="pub-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="pub-id">urn:uuid:8a3c1d4e-0000-4000-8000-000000000001</dc:identifier>
+    <dc:title>测试</dc:title>
+    <dc:language>zh-CN</dc:language>
+    <meta property="dcterms:modified">2024-01-01T00:00:00Z</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`
+
+const testNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>目录</title></head>
+<body><nav epub:type="toc"><ol><li><a href="ch1.xhtml#c1">第一章</a></li></ol></nav></body>
+</html>`
+
+const testChapter = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="zh">
+<head><title>第一章</title></head>
+<body><h1 id="c1">第一章</h1><p id="p1">正文</p></body>
+</html>`
+
+// testEpubFiles 最小的EPUB3文件, 检查没有问题
+func testEpubFiles() []epubFile {
+	return []epubFile{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", testContainer},
+		{"OEBPS/content.opf", testOPF},
+		{"OEBPS/nav.xhtml", testNav},
+		{"OEBPS/ch1.xhtml", testChapter},
+	}
+}
+
+// buildEpub 按顺序打包文件, mimetype不压缩
+func buildEpub(t *testing.T, files []epubFile, compressMimetype bool) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		method := zip.Deflate
+		if f.name == "mimetype" && !compressMimetype {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// editEpubFile 替换文件中的内容
+func editEpubFile(name, old, new string) func([]epubFile) []epubFile {
+	return func(files []epubFile) []epubFile {
+		for i := range files {
+			if files[i].name == name {
+				files[i].content = strings.Replace(files[i].content, old, new, 1)
+			}
+		}
+		return files
+	}
+}
+
+// TestEpubCheck 测试epub规范检查
+func TestEpubCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func([]epubFile) []epubFile
+		compress bool
+		severity epubcheck.Severity
+		code     string
+	}{
+		{name: "mimetype不是第一个文件", edit: func(files []epubFile) []epubFile {
+			files[0], files[1] = files[1], files[0]
+			return files
+		}, severity: epubcheck.SeverityError, code: epubcheck.CodeMimetype},
+		{name: "mimetype被压缩", compress: true, severity: epubcheck.SeverityError, code: epubcheck.CodeMimetype},
+		{name: "缺少container.xml", edit: func(files []epubFile) []epubFile {
+			return append(files[:1], files[2:]...)
+		}, severity: epubcheck.SeverityError, code: epubcheck.CodeContainer},
+		{name: "rootfile不存在", edit: editEpubFile("META-INF/container.xml", "content.opf", "book.opf"),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeContainer},
+		{name: "缺少书名", edit: editEpubFile("OEBPS/content.opf", "<dc:title>测试</dc:title>", ""),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeMetadata},
+		{name: "缺少修改时间", edit: editEpubFile("OEBPS/content.opf", `<meta property="dcterms:modified">2024-01-01T00:00:00Z</meta>`, ""),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeMetadata},
+		{name: "缺少语言", edit: editEpubFile("OEBPS/content.opf", "<dc:language>zh-CN</dc:language>", ""),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeLanguage},
+		{name: "语言标签无效", edit: editEpubFile("OEBPS/content.opf", "zh-CN", "中文"),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeLanguage},
+		{name: "XHTML语言标签无效", edit: editEpubFile("OEBPS/ch1.xhtml", `xml:lang="zh"`, `xml:lang="zh!!"`),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeLanguage},
+		{name: "清单中的文件不存在", edit: editEpubFile("OEBPS/content.opf", "</manifest>", `<item id="img" href="cover.jpg" media-type="image/jpeg"/></manifest>`),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeResource},
+		{name: "书脊为空", edit: editEpubFile("OEBPS/content.opf", `<itemref idref="ch1"/>`, ""),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeSpine},
+		{name: "导航文档缺少目录", edit: editEpubFile("OEBPS/nav.xhtml", `epub:type="toc"`, `epub:type="landmarks"`),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeNav},
+		{name: "XHTML格式错误", edit: editEpubFile("OEBPS/ch1.xhtml", "<p id=\"p1\">正文</p>", "<p>正文"),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeXHTML},
+		{name: "重复ID", edit: editEpubFile("OEBPS/ch1.xhtml", `id="p1"`, `id="c1"`),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeID},
+		{name: "链接的文件不存在", edit: editEpubFile("OEBPS/nav.xhtml", "ch1.xhtml#c1", "ch2.xhtml"),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeLink},
+		{name: "锚点不存在", edit: editEpubFile("OEBPS/nav.xhtml", "#c1", "#c2"),
+			severity: epubcheck.SeverityError, code: epubcheck.CodeLink},
+		{name: "图片没有在清单中声明", edit: func(files []epubFile) []epubFile {
+			files = editEpubFile("OEBPS/ch1.xhtml", "</body>", `<img src="a.png" alt=""/></body>`)(files)
+			return append(files, epubFile{"OEBPS/a.png", "png"})
+		}, severity: epubcheck.SeverityError, code: epubcheck.CodeResource},
+		{name: "文件没有在清单中声明", edit: func(files []epubFile) []epubFile {
+			return append(files, epubFile{"OEBPS/extra.css", "p {}"})
+		}, severity: epubcheck.SeverityWarning, code: epubcheck.CodeManifest},
+	}
+
+	t.Run("符合规范", func(t *testing.T) {
+		report, err := epubcheck.CheckBytes(buildEpub(t, testEpubFiles(), false))
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+		assert.Equal(t, "3.0", report.Version)
+		assert.NoError(t, report.Err())
+	})
+
+	t.Run("不是zip文件", func(t *testing.T) {
+		report, err := epubcheck.CheckBytes([]byte("not a zip"))
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		assert.Equal(t, epubcheck.CodeZip, report.Findings[0].Code)
+		assert.ErrorIs(t, report.Err(), epubcheck.ErrInvalid)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := testEpubFiles()
+			if tt.edit != nil {
+				files = tt.edit(files)
+			}
+			report, err := epubcheck.CheckBytes(buildEpub(t, files, tt.compress))
+			require.NoError(t, err)
+			found := false
+			for _, f := range report.Findings {
+				if f.Severity == tt.severity && f.Code == tt.code {
+					found = true
+				}
+			}
+			assert.True(t, found, "应该报告%s [%s], 实际为%v", tt.severity, tt.code, report.Findings)
+			assert.Equal(t, tt.severity == epubcheck.SeverityWarning, report.Valid())
+		})
+	}
+
+	t.Run("生成的epub符合规范", func(t *testing.T) {
+		report, err := epubcheck.CheckBytes(convertEpub(t, testTxt(t, "测试.txt", 3)))
+		require.NoError(t, err)
+		assert.Empty(t, report.Errors())
+	})
+}
@@ -0,0 +1,440 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/font"
+)
+
+// buildFont 按表名生成TrueType字体文件, 只用于构造测试数据
+func buildFont(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	out := make([]byte, 12+len(tags)*16)
+	binary.BigEndian.PutUint32(out, 0x00010000)
+	binary.BigEndian.PutUint16(out[4:], uint16(len(tags)))
+	for i, tag := range tags {
+		rec := out[12+i*16:]
+		copy(rec, tag)
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(tables[tag])))
+		out = append(out, tables[tag]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+// fontTable 从字体文件中取出指定的表
+func fontTable(t *testing.T, data []byte, tag string) []byte {
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := data[12+i*16:]
+		if string(rec[:4]) == tag {
+			offset := binary.BigEndian.Uint32(rec[8:])
+			length := binary.BigEndian.Uint32(rec[12:])
+			return data[offset : offset+length]
+		}
+	}
+	t.Fatalf("字体中没有%s表", tag)
+	return nil
+}
+
+// testFontTables 生成numGlyphs个空字形的最小字体, cmap由调用方提供
+func testFontTables(numGlyphs int, cmap []byte) map[string][]byte {
+	head := make([]byte, 54)
+	maxp := make([]byte, 6)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(numGlyphs))
+	return map[string][]byte{
+		"head": head,
+		"maxp": maxp,
+		"loca": make([]byte, (numGlyphs+1)*2),
+		"glyf": {},
+		"cmap": cmap,
+	}
+}
+
+// format12Cmap 生成只有一个format 12子表的cmap, groups为{起始字符, 结束字符, 起始字形}
+func format12Cmap(groups [][3]uint32, declared int) []byte {
+	cmap := make([]byte, 12)
+	binary.BigEndian.PutUint16(cmap[2:], 1)
+	binary.BigEndian.PutUint16(cmap[4:], 3)
+	binary.BigEndian.PutUint16(cmap[6:], 10)
+	binary.BigEndian.PutUint32(cmap[8:], 12)
+	sub := make([]byte, 16+len(groups)*12)
+	binary.BigEndian.PutUint16(sub, 12)
+	binary.BigEndian.PutUint32(sub[4:], uint32(len(sub)))
+	binary.BigEndian.PutUint32(sub[12:], uint32(declared))
+	for i, g := range groups {
+		binary.BigEndian.PutUint32(sub[16+i*12:], g[0])
+		binary.BigEndian.PutUint32(sub[20+i*12:], g[1])
+		binary.BigEndian.PutUint32(sub[24+i*12:], g[2])
+	}
+	return append(cmap, sub...)
+}
+
+// cffIndex 生成CFF的INDEX, 偏移固定为4字节
+func cffIndex(items ...[]byte) []byte {
+	if len(items) == 0 {
+		return []byte{0, 0}
+	}
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(items)))
+	out = append(out, 4)
+	offset := 1
+	out = binary.BigEndian.AppendUint32(out, uint32(offset))
+	for _, item := range items {
+		offset += len(item)
+		out = binary.BigEndian.AppendUint32(out, uint32(offset))
+	}
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// cffInt 生成CFF字典中定长5字节的整数
+func cffInt(v int) []byte {
+	return binary.BigEndian.AppendUint32([]byte{29}, uint32(v))
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// rlineto 生成画线的字形程序, 坐标在-107到107之间, 每个子程序画不同的线以便在结果中查找
+func rlineto(dx, dy int) []byte {
+	return []byte{byte(dx + 139), byte(dy + 139), 5}
+}
+
+// testSubrs 普通字体的局部子程序和全局子程序, 以及CID字体第二个FD的局部子程序
+var testSubrs = struct {
+	local, global, local2 [][]byte
+}{
+	local:  [][]byte{rlineto(51, 0), rlineto(0, 52), rlineto(33, 33)},
+	global: [][]byte{rlineto(-53, 0), rlineto(0, -54)},
+	local2: [][]byte{rlineto(61, 0), rlineto(0, 62), rlineto(43, 43)},
+}
+
+// testCFF 生成有4个字形的CFF表, cid为true时生成两个FD的CID字体, 2号字形起属于第二个FD
+//
+// 1号字形调用0号局部和全局子程序, 2号字形调用1号局部和全局子程序, 3号字形调用2号局部子程序。
+// 1号字形有8个字干, hintmask的掩码是0x0B(return), 不跳过掩码就找不到它调用的子程序。
+func testCFF(cid bool) []byte {
+	withReturn := func(subrs [][]byte) [][]byte {
+		out := make([][]byte, len(subrs))
+		for i, subr := range subrs {
+			out[i] = concat(subr, []byte{11})
+		}
+		return out
+	}
+	// call 调用第i个子程序, op为10(callsubr)或29(callgsubr), 子程序少于1240个时编号偏移107
+	call := func(op byte, i int) []byte { return []byte{byte(i - 107 + 139), op} }
+	moveto := []byte{139, 139, 21}
+	stems := []byte{139, 149, 159, 149, 179, 149, 199, 149}
+	glyphs := [][]byte{
+		concat(moveto, rlineto(10, 0), rlineto(0, 10), []byte{14}),
+		concat(stems, []byte{18}, stems, []byte{23, 19, 0x0B}, moveto, call(10, 0), call(29, 0), []byte{14}),
+		concat(moveto, call(10, 1), call(29, 1), []byte{14}),
+		concat(moveto, call(10, 2), []byte{14}),
+	}
+
+	// private 生成6字节的私有字典, 局部子程序紧跟在字典之后
+	private := func(subrs [][]byte) []byte {
+		dict := concat(cffInt(6), []byte{19})
+		return concat(dict, cffIndex(withReturn(subrs)...))
+	}
+	header := []byte{1, 0, 4, 4}
+	names := cffIndex([]byte("Test"))
+	strs := cffIndex()
+	if cid {
+		strs = cffIndex([]byte("Adobe"), []byte("Identity"))
+	}
+	gsubrs := cffIndex(withReturn(testSubrs.global)...)
+	charStrings := cffIndex(glyphs...)
+	// 字符集格式0, 1到3号字形的名字是标准字符串A、B、C
+	charset := []byte{0, 0, 34, 0, 35, 0, 36}
+	// FDSelect格式3, 两段: 0号字形起属于FD 0, 2号字形起属于FD 1, 共4个字形
+	fdSelect := []byte{3, 0, 2, 0, 0, 0, 0, 2, 1, 0, 4}
+	privates := [][]byte{private(testSubrs.local)}
+	if cid {
+		privates = append(privates, private(testSubrs.local2))
+	}
+
+	// 偏移都是定长的, 先用0计算顶层字典的长度
+	topDict := func(offsets ...int) []byte {
+		if cid {
+			return concat(cffInt(391), cffInt(392), cffInt(0), []byte{12, 30},
+				cffInt(offsets[0]), []byte{12, 37}, cffInt(offsets[1]), []byte{17}, cffInt(offsets[2]), []byte{12, 36})
+		}
+		return concat(cffInt(offsets[0]), []byte{15}, cffInt(offsets[1]), []byte{17}, cffInt(6), cffInt(offsets[2]), []byte{18})
+	}
+	pos := len(header) + len(names) + len(cffIndex(topDict(0, 0, 0))) + len(strs) + len(gsubrs)
+	if cid {
+		fdArray := func(offsets ...int) []byte {
+			dicts := make([][]byte, len(privates))
+			for i := range privates {
+				dicts[i] = concat(cffInt(6), cffInt(offsets[i]), []byte{18})
+			}
+			return cffIndex(dicts...)
+		}
+		selectPos := pos
+		charStringsPos := selectPos + len(fdSelect)
+		fdArrayPos := charStringsPos + len(charStrings)
+		private0 := fdArrayPos + len(fdArray(0, 0))
+		private1 := private0 + len(privates[0])
+		return concat(header, names, cffIndex(topDict(selectPos, charStringsPos, fdArrayPos)), strs, gsubrs,
+			fdSelect, charStrings, fdArray(private0, private1), privates[0], privates[1])
+	}
+	charsetPos := pos
+	charStringsPos := charsetPos + len(charset)
+	privatePos := charStringsPos + len(charStrings)
+	return concat(header, names, cffIndex(topDict(charsetPos, charStringsPos, privatePos)), strs, gsubrs,
+		charset, charStrings, privates[0])
+}
+
+// buildOTF 生成CFF轮廓的OpenType字体, A、B、C映射到1到3号字形
+func buildOTF(cff []byte) []byte {
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint16(head[18:], 1000)
+	maxp := make([]byte, 6)
+	binary.BigEndian.PutUint32(maxp, 0x00005000)
+	binary.BigEndian.PutUint16(maxp[4:], 4)
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint16(hhea[34:], 1)
+	post := make([]byte, 32)
+	binary.BigEndian.PutUint32(post, 0x00030000)
+	data := buildFont(map[string][]byte{
+		"head": head,
+		"maxp": maxp,
+		"hhea": hhea,
+		"hmtx": make([]byte, 4),
+		"post": post,
+		"cmap": format12Cmap([][3]uint32{{'A', 'C', 1}}, 1),
+		"CFF ": cff,
+	})
+	copy(data, "OTTO")
+	return data
+}
+
+// TestFontSubset 测试字体子集化
+func TestFontSubset(t *testing.T) {
+	t.Run("只保留用到的字形", func(t *testing.T) {
+		out, err := font.Subset(goregular.TTF, map[rune]bool{'A': true, 'b': true})
+		require.NoError(t, err)
+		assert.Less(t, len(out), len(goregular.TTF))
+
+		f, err := sfnt.Parse(out)
+		require.NoError(t, err)
+		var buf sfnt.Buffer
+		for _, r := range []rune{'A', 'b'} {
+			gid, err := f.GlyphIndex(&buf, r)
+			require.NoError(t, err)
+			assert.NotZero(t, gid, "字符%q应该保留", r)
+			segments, err := f.LoadGlyph(&buf, gid, 1000, nil)
+			require.NoError(t, err)
+			assert.NotEmpty(t, segments, "字符%q的轮廓应该保留", r)
+		}
+		gid, err := f.GlyphIndex(&buf, 'Z')
+		require.NoError(t, err)
+		assert.Zero(t, gid, "没有用到的字符不应该有映射")
+	})
+
+	t.Run("段数过多时只生成format 12", func(t *testing.T) {
+		// 每隔一个字符映射一个字形, 子集的每个字符都是单独的一段
+		const count = 9000
+		groups := make([][3]uint32, count)
+		runes := make(map[rune]bool, count)
+		for i := range groups {
+			c := uint32(0x4E00 + i*2)
+			groups[i] = [3]uint32{c, c, uint32(i + 1)}
+			runes[rune(c)] = true
+		}
+		data := buildFont(testFontTables(count+1, format12Cmap(groups, count)))
+
+		out, err := font.Subset(data, runes)
+		require.NoError(t, err)
+		cmap := fontTable(t, out, "cmap")
+		require.Equal(t, uint16(1), binary.BigEndian.Uint16(cmap[2:]))
+		offset := binary.BigEndian.Uint32(cmap[8:])
+		assert.Equal(t, uint16(12), binary.BigEndian.Uint16(cmap[offset:]))
+		assert.Equal(t, uint32(count), binary.BigEndian.Uint32(cmap[offset+12:]))
+	})
+
+	for _, tt := range []struct {
+		name            string
+		cid             bool
+		kept, discarded [][]byte
+	}{
+		{
+			name:      "CFF轮廓",
+			kept:      [][]byte{testSubrs.local[0], testSubrs.local[1], testSubrs.global[0], testSubrs.global[1]},
+			discarded: [][]byte{testSubrs.local[2]},
+		},
+		{
+			name:      "CID字体按字形所属的FD保留局部子程序",
+			cid:       true,
+			kept:      [][]byte{testSubrs.local[0], testSubrs.local2[1], testSubrs.global[0], testSubrs.global[1]},
+			discarded: [][]byte{testSubrs.local[1], testSubrs.local[2], testSubrs.local2[0], testSubrs.local2[2]},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildOTF(testCFF(tt.cid))
+			out, err := font.Subset(data, map[rune]bool{'A': true, 'B': true})
+			require.NoError(t, err)
+			assert.Equal(t, "OTTO", string(out[:4]))
+
+			cff := fontTable(t, out, "CFF ")
+			for _, subr := range tt.kept {
+				assert.True(t, bytes.Contains(cff, subr), "用到的子程序%v应该保留", subr)
+			}
+			for _, subr := range tt.discarded {
+				assert.False(t, bytes.Contains(cff, subr), "没有用到的子程序%v应该清空", subr)
+			}
+
+			orig, err := sfnt.Parse(data)
+			require.NoError(t, err)
+			f, err := sfnt.Parse(out)
+			require.NoError(t, err)
+			var buf sfnt.Buffer
+			for gid, r := range []rune{0, 'A', 'B'} {
+				want, err := orig.LoadGlyph(&buf, sfnt.GlyphIndex(gid), 1000, nil)
+				require.NoError(t, err)
+				want = append(sfnt.Segments(nil), want...)
+				got, err := f.LoadGlyph(&buf, sfnt.GlyphIndex(gid), 1000, nil)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "字形%d的轮廓应该不变", gid)
+				if r != 0 {
+					mapped, err := f.GlyphIndex(&buf, r)
+					require.NoError(t, err)
+					assert.Equal(t, sfnt.GlyphIndex(gid), mapped)
+				}
+			}
+			segments, err := f.LoadGlyph(&buf, 3, 1000, nil)
+			require.NoError(t, err)
+			assert.Empty(t, segments, "没有用到的字形应该清空")
+			gid, err := f.GlyphIndex(&buf, 'C')
+			require.NoError(t, err)
+			assert.Zero(t, gid, "没有用到的字符不应该有映射")
+		})
+	}
+
+	noGlyf := testFontTables(1, format12Cmap(nil, 0))
+	delete(noGlyf, "glyf")
+	truncatedCmap := make([]byte, 4)
+	cff2Font := buildFont(map[string][]byte{"head": make([]byte, 54), "maxp": make([]byte, 6), "cmap": format12Cmap(nil, 0), "CFF2": {2, 0, 5, 0, 0}})
+	copy(cff2Font, "OTTO")
+	// 把.notdef的画线改为带参数的endchar, 即seac组合字形
+	seacCFF := testCFF(false)
+	i := bytes.Index(seacCFF, concat(rlineto(10, 0), rlineto(0, 10), []byte{14}))
+	copy(seacCFF[i:], []byte{139, 139, 139, 139, 139, 139})
+	binary.BigEndian.PutUint16(truncatedCmap[2:], 5)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"文件过短", []byte("true"), font.ErrInvalidFont},
+		{"CFF2轮廓", cff2Font, font.ErrUnsupportedFont},
+		{"CFF表已损坏", buildOTF(testCFF(false)[:40]), font.ErrInvalidFont},
+		{"seac组合字形", buildOTF(seacCFF), font.ErrUnsupportedFont},
+		{"字体集合", append([]byte("ttcf"), make([]byte, 8)...), font.ErrUnsupportedFont},
+		{"缺少glyf表", buildFont(noGlyf), font.ErrUnsupportedFont},
+		{"cmap子表数量越界", buildFont(testFontTables(1, truncatedCmap)), font.ErrInvalidFont},
+		{"format 12分组数量越界", buildFont(testFontTables(1, format12Cmap(nil, 1000))), font.ErrInvalidFont},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := font.Subset(tt.data, map[rune]bool{'A': true})
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+// TestEmbedFont 测试生成电子书时嵌入字体子集, 不支持子集化的字体嵌入完整字体并给出警告
+func TestEmbedFont(t *testing.T) {
+	dir := t.TempDir()
+	otf := filepath.Join(dir, "font.otf")
+	require.NoError(t, os.WriteFile(otf, buildOTF(testCFF(true)), 0644))
+	ttc := filepath.Join(dir, "font.ttc")
+	ttcData := append([]byte("ttcf"), make([]byte, 8)...)
+	require.NoError(t, os.WriteFile(ttc, ttcData, 0644))
+
+	tests := []struct {
+		name        string
+		font        string
+		wantWarning bool
+	}{
+		{"CFF轮廓的字体嵌入子集", otf, false},
+		{"字体集合嵌入完整字体", ttc, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := parsedBook(t, "epub", 3)
+			book.Font = tt.font
+			var warnings []string
+			out := t.TempDir()
+			d := converter.Dispatcher{
+				Book:   book,
+				Output: converter.DirOutput{Dir: out},
+				Progress: func(p converter.Progress) {
+					if p.Stage == converter.StageWarning {
+						warnings = append(warnings, p.Message)
+					}
+				},
+			}
+			require.NoError(t, d.Convert(context.Background()))
+
+			src, err := os.ReadFile(tt.font)
+			require.NoError(t, err)
+			zr, err := zip.OpenReader(filepath.Join(out, "测试.epub"))
+			require.NoError(t, err)
+			defer zr.Close()
+			var embedded []byte
+			for _, f := range zr.File {
+				if filepath.Ext(f.Name) == filepath.Ext(tt.font) {
+					rc, err := f.Open()
+					require.NoError(t, err)
+					var buf bytes.Buffer
+					_, err = buf.ReadFrom(rc)
+					rc.Close()
+					require.NoError(t, err)
+					embedded = buf.Bytes()
+				}
+			}
+			require.NotNil(t, embedded, "epub中应该有嵌入的字体")
+
+			if tt.wantWarning {
+				assert.Equal(t, src, embedded)
+				require.Len(t, warnings, 1)
+				assert.Contains(t, warnings[0], "不支持子集化, 将嵌入完整字体")
+				return
+			}
+			assert.Empty(t, warnings)
+			assert.NotEqual(t, src, embedded)
+			_, err = sfnt.Parse(embedded)
+			assert.NoError(t, err)
+		})
+	}
+}
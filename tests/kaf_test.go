@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/pkg/kaf"
+)
+
+// kafLogger 记录转换过程中的警告
+type kafLogger struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (l *kafLogger) Infof(format string, args ...any) {}
+
+func (l *kafLogger) Warnf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+// TestKafOptions 测试创建转换器时检查选项
+func TestKafOptions(t *testing.T) {
+	var buf bytes.Buffer
+	tests := []struct {
+		name    string
+		opts    []kaf.Option
+		wantErr string
+	}{
+		{"不支持的格式", []kaf.Option{kaf.WithFormat("pdf")}, "不支持的格式: pdf"},
+		{"不支持的对齐方式", []kaf.Option{kaf.WithAlign("top")}, "不支持的对齐方式: top"},
+		{"章节规则错误", []kaf.Option{kaf.WithMatch("(")}, "章节规则错误"},
+		{"卷规则错误", []kaf.Option{kaf.WithVolumeMatch("(")}, "卷规则错误"},
+		{"排除规则错误", []kaf.Option{kaf.WithExclude("(")}, "排除规则错误"},
+		{"标题去除规则错误", []kaf.Option{kaf.WithTitleJunk("(")}, "标题去除规则错误"},
+		{"标题最大字数为0", []kaf.Option{kaf.WithMaxTitleLength(0)}, "标题最大字数不能为0"},
+		{"标题模板错误", []kaf.Option{kaf.WithTitleTemplate("第{n}章", "{x}")}, "标题模板中不支持{x}"},
+		{"不支持的编号方式", []kaf.Option{kaf.WithRenumber("book")}, "不支持的编号方式: book"},
+		{"章节范围错误", []kaf.Option{kaf.WithSplitRanges("abc")}, "章节范围格式错误"},
+		{"输出到Writer时生成多种格式", []kaf.Option{kaf.WithWriter(&buf), kaf.WithFormat(kaf.All)}, "只能生成一种格式"},
+		{"输出到Writer时分册", []kaf.Option{kaf.WithWriter(&buf), kaf.WithSplitSections(2)}, "不能分册"},
+		{
+			name: "有效的选项",
+			opts: []kaf.Option{
+				kaf.WithFormat(kaf.AZW3),
+				kaf.WithAlign("left"),
+				kaf.WithExclude(""),
+				kaf.WithTitleJunk(""),
+				kaf.WithTitleTemplate("第{n:cn}章 {title}", "第{n:roman}卷"),
+				kaf.WithRenumber(kaf.RenumberGlobal),
+				kaf.WithSplitRanges("1-500,501-"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv, err := kaf.New(tt.opts...)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				assert.NotNil(t, conv)
+				return
+			}
+			require.Error(t, err)
+			assert.Nil(t, conv)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestKafConvert 测试选项在转换时生效
+func TestKafConvert(t *testing.T) {
+	txt := testTxt(t, "测试.txt", 3)
+
+	t.Run("书名作者和输出位置", func(t *testing.T) {
+		dir := t.TempDir()
+		var mu sync.Mutex
+		var stages []string
+		conv, err := kaf.New(
+			kaf.WithBookname("书名"),
+			kaf.WithAuthor("作者"),
+			kaf.WithOutputDir(dir),
+			kaf.WithOutputName("输出"),
+			kaf.WithProgress(func(p kaf.Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+				stages = append(stages, p.Stage)
+			}),
+		)
+		require.NoError(t, err)
+		result, err := conv.Convert(context.Background(), txt)
+		require.NoError(t, err)
+
+		assert.Equal(t, "书名", result.Title)
+		assert.Equal(t, "作者", result.Author)
+		assert.Equal(t, 3, result.Chapters)
+		require.Len(t, result.Files, 1)
+		file := result.Files[0]
+		assert.Equal(t, kaf.EPUB, file.Format)
+		assert.Equal(t, filepath.Join(dir, "输出.epub"), file.Path)
+		info, err := os.Stat(file.Path)
+		require.NoError(t, err)
+		assert.Equal(t, info.Size(), file.Size)
+		assert.Contains(t, stages, "done")
+	})
+
+	t.Run("标题模板", func(t *testing.T) {
+		conv, err := kaf.New(kaf.WithTitleTemplate("第{n:cn}章 {title}", ""))
+		require.NoError(t, err)
+		book, err := conv.Parse(context.Background(), txt)
+		require.NoError(t, err)
+		require.Len(t, book.Sections, 3)
+		assert.Equal(t, "第一章 测试", book.Sections[0].Title)
+		assert.Equal(t, []string{"这是第1章的正文内容。"}, book.Sections[0].Paragraphs)
+	})
+
+	t.Run("超过最大字数的行不作为标题", func(t *testing.T) {
+		logger := &kafLogger{}
+		conv, err := kaf.New(kaf.WithMaxTitleLength(2), kaf.WithLogger(logger))
+		require.NoError(t, err)
+		book, err := conv.Preview(context.Background(), txt)
+		require.NoError(t, err)
+		assert.Len(t, book.Sections, 1)
+		assert.NotEmpty(t, logger.warnings, "没有识别到标题时给出警告")
+	})
+
+	t.Run("第一个标题前的内容", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "前言.txt")
+		require.NoError(t, os.WriteFile(path, []byte("前言内容\n第1章 开始\n正文\n"), 0644))
+		conv, err := kaf.New(kaf.WithUnknownTitle("前言"))
+		require.NoError(t, err)
+		book, err := conv.Preview(context.Background(), path)
+		require.NoError(t, err)
+		require.Len(t, book.Sections, 2)
+		assert.Equal(t, "前言", book.Sections[0].Title)
+		assert.Empty(t, book.Sections[0].Paragraphs, "预览时没有正文")
+		assert.Equal(t, 4, book.Sections[0].Words)
+	})
+
+	t.Run("不识别卷", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "分卷.txt")
+		require.NoError(t, os.WriteFile(path, []byte("第一卷 上卷\n第1章 开始\n正文\n第2章 结束\n正文\n"), 0644))
+		for _, tt := range []struct {
+			opts        []kaf.Option
+			wantVolumes int
+		}{
+			{nil, 1},
+			{[]kaf.Option{kaf.WithoutVolumes()}, 0},
+		} {
+			conv, err := kaf.New(tt.opts...)
+			require.NoError(t, err)
+			book, err := conv.Preview(context.Background(), path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantVolumes, book.Volumes)
+		}
+	})
+
+	t.Run("多次转换依次写入Writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		conv, err := kaf.New(kaf.WithWriter(&buf), kaf.WithReproducible())
+		require.NoError(t, err)
+		result, err := conv.Convert(context.Background(), txt)
+		require.NoError(t, err)
+		require.Len(t, result.Files, 1)
+		assert.Empty(t, result.Files[0].Path, "输出到Writer时没有路径")
+		size := buf.Len()
+
+		_, err = conv.Convert(context.Background(), txt)
+		require.NoError(t, err)
+		assert.Equal(t, 2*size, buf.Len())
+		assert.Equal(t, buf.Bytes()[:size], buf.Bytes()[size:])
+	})
+}
+
+// TestKafErrors 测试转换失败时返回的错误
+func TestKafErrors(t *testing.T) {
+	txt := testTxt(t, "测试.txt", 3)
+	conv, err := kaf.New(kaf.WithOutputDir(t.TempDir()))
+	require.NoError(t, err)
+
+	t.Run("已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		result, err := conv.Convert(ctx, txt)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, result)
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		_, err := conv.Convert(context.Background(), filepath.Join(t.TempDir(), "书.epub"))
+		var kafErr *kaf.Error
+		require.True(t, errors.As(err, &kafErr), "错误应该是*kaf.Error: %v", err)
+		assert.Equal(t, kaf.StageCheck, kafErr.Stage)
+		assert.Contains(t, kafErr.Error(), "参数错误")
+	})
+
+	t.Run("识别章节失败", func(t *testing.T) {
+		_, err := conv.Convert(context.Background(), filepath.Join(t.TempDir(), "不存在.txt"))
+		var kafErr *kaf.Error
+		require.True(t, errors.As(err, &kafErr), "错误应该是*kaf.Error: %v", err)
+		assert.Equal(t, kaf.StageParse, kafErr.Stage)
+		assert.Empty(t, kafErr.Format)
+	})
+
+	t.Run("生成失败", func(t *testing.T) {
+		// 输出目录是一个文件, 无法创建电子书
+		out := filepath.Join(t.TempDir(), "文件")
+		require.NoError(t, os.WriteFile(out, nil, 0644))
+		conv, err := kaf.New(kaf.WithOutputDir(out))
+		require.NoError(t, err)
+		result, err := conv.Convert(context.Background(), txt)
+		require.NotNil(t, result)
+		var kafErr *kaf.Error
+		require.True(t, errors.As(err, &kafErr), "错误应该是*kaf.Error: %v", err)
+		assert.Equal(t, kaf.StageConvert, kafErr.Stage)
+		assert.Equal(t, kaf.EPUB, kafErr.Format)
+		assert.Contains(t, kafErr.Error(), "生成epub失败")
+	})
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/leotaku/mobi/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"github.com/Deali-Axy/ebook-generator/internal/kf8"
+)
+
+// palmRecords 按PalmDB的记录列表切分文件
+func palmRecords(t *testing.T, data []byte) [][]byte {
+	require.Greater(t, len(data), 78)
+	count := int(binary.BigEndian.Uint16(data[76:]))
+	offsets := make([]int, count+1)
+	for i := 0; i < count; i++ {
+		offsets[i] = int(binary.BigEndian.Uint32(data[78+i*8:]))
+	}
+	offsets[count] = len(data)
+	recs := make([][]byte, count)
+	for i := range recs {
+		recs[i] = data[offsets[i]:offsets[i+1]]
+	}
+	return recs
+}
+
+// kf8Header 解析第0条记录的PalmDoc头和KF8头
+func kf8Header(t *testing.T, rec []byte) (types.PalmDocHeader, types.KF8Header) {
+	var palm types.PalmDocHeader
+	var header types.KF8Header
+	r := bytes.NewReader(rec)
+	require.NoError(t, binary.Read(r, binary.BigEndian, &palm))
+	require.NoError(t, binary.Read(r, binary.BigEndian, &header))
+	require.Equal(t, "MOBI", string(header.MOBI[:]))
+	return palm, header
+}
+
+// indxHeader 解析索引记录的INDX头
+func indxHeader(t *testing.T, rec []byte) types.INDXHeader {
+	var header types.INDXHeader
+	require.NoError(t, binary.Read(bytes.NewReader(rec), binary.BigEndian, &header))
+	require.Equal(t, "INDX", string(header.INDX[:]))
+	return header
+}
+
+// indexEntryCount 返回索引的条目总数, 并检查每个数据记录的条目数之和与之相等
+func indexEntryCount(t *testing.T, recs [][]byte, start int) int {
+	main := indxHeader(t, recs[start])
+	total := 0
+	for i := 1; i <= int(main.IndexRecordCount); i++ {
+		data := indxHeader(t, recs[start+i])
+		assert.Equal(t, uint32(1), data.HeaderType)
+		total += int(data.IndexRecordCount)
+	}
+	assert.Equal(t, int(main.IndexEntryCount), total)
+	return total
+}
+
+// testCover 生成纯色PNG封面
+func testCover(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 80, B: 40, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// TestKF8Layout 测试KF8文件的记录和索引布局
+func TestKF8Layout(t *testing.T) {
+	manyChapters := make([]kf8.Chapter, 2000)
+	for i := range manyChapters {
+		manyChapters[i] = kf8.Chapter{Title: fmt.Sprintf("第%d章", i+1), Body: "<p>正文</p>"}
+	}
+
+	tests := []struct {
+		name     string
+		chapters []kf8.Chapter
+		files    int
+	}{
+		{"单个章节", []kf8.Chapter{{Title: "第一章", Body: "<p>正文</p>"}}, 1},
+		{"嵌套目录", []kf8.Chapter{
+			{Title: "第一卷", Body: "<h1>第一卷</h1>", Children: []kf8.Chapter{
+				{Title: "第一章", Body: "<p>一</p>"},
+				{Title: "第二章", Body: "<p>二</p>"},
+			}},
+			{Title: "第二卷", Body: "<h1>第二卷</h1>", Children: []kf8.Chapter{
+				{Title: "第三章", Body: "<p>三</p>"},
+			}},
+		}, 5},
+		{"条目超过一个索引记录", manyChapters, 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := &kf8.Book{
+				Title:       "测试书籍",
+				Author:      "测试作者",
+				Language:    "zh",
+				UniqueID:    42,
+				CreatedDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				CSS:         "p { text-indent: 2em; }",
+				Chapters:    tt.chapters,
+			}
+			var buf bytes.Buffer
+			require.NoError(t, book.Write(&buf))
+			recs := palmRecords(t, buf.Bytes())
+			palm, header := kf8Header(t, recs[0])
+
+			assert.Equal(t, uint32(8), header.FileVersion)
+			assert.Equal(t, uint32(42), header.UniqueID)
+			assert.Equal(t, uint32(palm.TextRecordCount+1), header.FirstNonBookIndex)
+
+			// 文本记录末尾的字节是被截断字符的长度
+			var text []byte
+			for _, rec := range recs[1 : palm.TextRecordCount+1] {
+				overlap := int(rec[len(rec)-1])
+				text = append(text, rec[:len(rec)-1-overlap]...)
+			}
+			assert.Equal(t, int(palm.TextLength), len(text))
+			assert.True(t, strings.HasSuffix(string(text), book.CSS), "样式表应该在正文之后")
+
+			assert.Equal(t, tt.files, indexEntryCount(t, recs, int(header.ChunkIndex)))
+			assert.Equal(t, tt.files, indexEntryCount(t, recs, int(header.SkeletonIndex)))
+			assert.Equal(t, tt.files, indexEntryCount(t, recs, int(header.INDXRecordOffset)))
+			assert.NotZero(t, indxHeader(t, recs[header.INDXRecordOffset]).CNCXCount)
+
+			assert.Equal(t, "FDST", string(recs[header.LastContentRecordNumberOrFDSTNumberLSB][:4]))
+			assert.Equal(t, uint32(2), header.Unknown3OrFDSTEntryCount)
+			assert.Equal(t, "FLIS", string(recs[header.FLISRecordNumber][:4]))
+			assert.Equal(t, "FCIS", string(recs[header.FCISRecordNumber][:4]))
+			assert.Equal(t, []byte{0xE9, 0x8E, 0x0D, 0x0A}, recs[len(recs)-1])
+		})
+	}
+
+	t.Run("多字节字符跨文本记录", func(t *testing.T) {
+		body := "<p>" + strings.Repeat("中文", 5000) + "</p>"
+		book := &kf8.Book{Title: "长文本", Chapters: []kf8.Chapter{{Title: "第一章", Body: body}}}
+		var buf bytes.Buffer
+		require.NoError(t, book.Write(&buf))
+		recs := palmRecords(t, buf.Bytes())
+		palm, _ := kf8Header(t, recs[0])
+		require.Greater(t, palm.TextRecordCount, uint16(1))
+
+		var text []byte
+		for _, rec := range recs[1 : palm.TextRecordCount+1] {
+			overlap := int(rec[len(rec)-1])
+			last, _ := utf8.DecodeLastRune(rec[:len(rec)-1])
+			assert.NotEqual(t, utf8.RuneError, last, "文本记录末尾应该包含完整的字符")
+			text = append(text, rec[:len(rec)-1-overlap]...)
+		}
+		assert.Contains(t, string(text), body)
+	})
+
+	t.Run("封面和字体资源", func(t *testing.T) {
+		book := &kf8.Book{
+			Title:    "带封面",
+			Chapters: []kf8.Chapter{{Title: "第一章", Body: "<p>正文</p>"}},
+			Cover:    testCover(t, 600, 800),
+		}
+		url, err := book.AddFont(goregular.TTF)
+		require.NoError(t, err)
+		assert.Equal(t, "kindle:embed:0001", url)
+
+		var buf bytes.Buffer
+		require.NoError(t, book.Write(&buf))
+		recs := palmRecords(t, buf.Bytes())
+		_, header := kf8Header(t, recs[0])
+
+		first := int(header.FirstImageIndex)
+		assert.Equal(t, "FONT", string(recs[first][:4]))
+		// 封面和缩略图统一转换成JPEG
+		assert.Equal(t, []byte{0xFF, 0xD8}, recs[first+1][:2])
+		assert.Equal(t, []byte{0xFF, 0xD8}, recs[first+2][:2])
+		assert.Less(t, len(recs[first+2]), len(recs[first+1]), "缩略图应该比封面小")
+	})
+
+	t.Run("读取元数据", func(t *testing.T) {
+		book := &kf8.Book{
+			Title:       "元数据",
+			Author:      "作者",
+			Description: "简介",
+			PublishDate: "2024-01-02",
+			Language:    "zh",
+			Chapters:    []kf8.Chapter{{Title: "第一章", Body: "<p>正文</p>"}},
+		}
+		var buf bytes.Buffer
+		require.NoError(t, book.Write(&buf))
+		meta, err := kf8.ReadMetadata(buf.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, &kf8.Metadata{
+			Title:       "元数据",
+			Authors:     []string{"作者"},
+			Description: "简介",
+			PublishDate: "2024-01-02",
+			Language:    "zh",
+		}, meta)
+	})
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kafmcp "github.com/Deali-Axy/ebook-generator/internal/mcp"
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// fakeAuth 按token查找用户, 代替Web服务的AuthService
+type fakeAuth struct {
+	tokens map[string]uint
+	users  map[uint]*models.User
+}
+
+func (a fakeAuth) ValidateToken(token string) (*services.JWTClaims, error) {
+	id, ok := a.tokens[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &services.JWTClaims{UserID: id}, nil
+}
+
+func (a fakeAuth) GetUserByID(userID uint) (*models.User, error) {
+	user, ok := a.users[userID]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// testAuth 有两个正常用户和一个被禁用的用户, token4对应的用户不存在
+var testAuth = fakeAuth{
+	tokens: map[string]uint{"token1": 1, "token2": 2, "token3": 3, "token4": 4},
+	users: map[uint]*models.User{
+		1: {ID: 1, Username: "user1", IsActive: true},
+		2: {ID: 2, Username: "user2", IsActive: true},
+		3: {ID: 3, Username: "user3", IsActive: false},
+	},
+}
+
+// httpMCP 启动通过SSE提供MCP服务的测试服务器, 返回服务器和用户工作目录的根目录
+func httpMCP(t *testing.T, opts kafmcp.HTTPOptions) (*httptest.Server, string) {
+	opts.WorkDir = t.TempDir()
+	handler, err := kafmcp.NewHTTPHandler(testAuth, opts)
+	require.NoError(t, err)
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts, opts.WorkDir
+}
+
+// sseClient 用token连接MCP服务
+func sseClient(t *testing.T, ts *httptest.Server, token string) *client.Client {
+	c, err := client.NewSSEMCPClient(ts.URL+"/mcp/sse", transport.WithHeaders(map[string]string{"Authorization": "Bearer " + token}))
+	require.NoError(t, err)
+	return startMCPClient(t, c)
+}
+
+// postMCP 用token向path发送空消息, 返回响应
+func postMCP(t *testing.T, ts *httptest.Server, path, token string) (*http.Response, models.APIResponse) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader("{}"))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body models.APIResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body
+}
+
+// TestMCPHTTPAuth 测试连接时验证bearer token
+func TestMCPHTTPAuth(t *testing.T) {
+	ts, _ := httpMCP(t, kafmcp.HTTPOptions{})
+
+	tests := []struct {
+		name    string
+		header  string
+		message string
+	}{
+		{"没有token", "", "缺少认证token"},
+		{"不是bearer token", "Basic dXNlcjpwYXNz", "缺少认证token"},
+		{"空token", "Bearer ", "缺少认证token"},
+		{"无效的token", "Bearer invalid", "无效的token"},
+		{"用户被禁用", "Bearer token3", "用户不存在或已被禁用"},
+		{"用户不存在", "Bearer token4", "用户不存在或已被禁用"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/mcp/sse", "/mcp/message"} {
+				resp, body := postMCP(t, ts, path, tt.header)
+				assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+				assert.Equal(t, tt.message, body.Message, path)
+			}
+		})
+	}
+
+	t.Run("有效的token", func(t *testing.T) {
+		c := sseClient(t, ts, "token1")
+		text, isError := callTool(t, c, "kaf_list_files", map[string]any{})
+		require.False(t, isError, text)
+	})
+
+	t.Run("不能访问其他用户的会话", func(t *testing.T) {
+		c := sseClient(t, ts, "token1")
+		endpoint := c.GetTransport().(*transport.SSE).GetEndpoint()
+		require.NotNil(t, endpoint)
+		require.NotEmpty(t, endpoint.Query().Get("sessionId"))
+
+		resp, body := postMCP(t, ts, endpoint.RequestURI(), "Bearer token2")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "不能访问其他用户的会话", body.Message)
+	})
+}
+
+// TestMCPHTTPRateLimit 测试按用户限制请求频率
+func TestMCPHTTPRateLimit(t *testing.T) {
+	ts, _ := httpMCP(t, kafmcp.HTTPOptions{RequestsPerMinute: 1})
+
+	resp, _ := postMCP(t, ts, "/mcp/message", "Bearer token1")
+	assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp, body := postMCP(t, ts, "/mcp/message", "Bearer token1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "请求过于频繁，请稍后再试", body.Message)
+
+	resp, _ = postMCP(t, ts, "/mcp/message", "Bearer token2")
+	assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode, "每个用户单独计数")
+
+	resp, _ = postMCP(t, ts, "/mcp/message", "Bearer invalid")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "先验证token再限制频率")
+}
+
+// TestMCPHTTPWorkspace 测试远程用户只能读写自己工作目录下的文件
+func TestMCPHTTPWorkspace(t *testing.T) {
+	ts, workDir := httpMCP(t, kafmcp.HTTPOptions{})
+	user1 := sseClient(t, ts, "token1")
+	user2 := sseClient(t, ts, "token2")
+	txt, err := os.ReadFile(testTxt(t, "测试.txt", 3))
+	require.NoError(t, err)
+
+	t.Run("上传txt", func(t *testing.T) {
+		text, isError := callTool(t, user1, "kaf_upload", map[string]any{"filename": "书/测试.txt", "content": string(txt)})
+		require.False(t, isError, text)
+		assert.Contains(t, text, `"path": "书/测试.txt"`)
+		assert.FileExists(t, filepath.Join(workDir, "1", "书", "测试.txt"))
+
+		text, isError = callTool(t, user1, "kaf_upload", map[string]any{"filename": "书/测试.txt", "content": "覆盖"})
+		assert.True(t, isError)
+		assert.Contains(t, text, "文件已存在")
+
+		encoded := base64.StdEncoding.EncodeToString(txt)
+		text, isError = callTool(t, user1, "kaf_upload", map[string]any{"filename": "书/测试.txt", "content_base64": encoded, "overwrite": true})
+		require.False(t, isError, text)
+		bs, err := os.ReadFile(filepath.Join(workDir, "1", "书", "测试.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, txt, bs)
+
+		text, isError = callTool(t, user1, "kaf_upload", map[string]any{"filename": "测试.epub", "content": "内容"})
+		assert.True(t, isError)
+		assert.Equal(t, "只能上传txt文件", text)
+	})
+
+	t.Run("列出自己的文件", func(t *testing.T) {
+		text, isError := callTool(t, user1, "kaf_list_files", map[string]any{"recursive": true})
+		require.False(t, isError, text)
+		assert.Contains(t, text, `"path": "书/测试.txt"`)
+		assert.NotContains(t, text, workDir, "不暴露服务器的目录")
+
+		text, isError = callTool(t, user2, "kaf_list_files", map[string]any{"recursive": true})
+		require.False(t, isError, text)
+		assert.Contains(t, text, `"files": []`)
+	})
+
+	t.Run("转换自己的文件", func(t *testing.T) {
+		text, isError := callTool(t, user1, "kaf_convert", map[string]any{"filename": "书/测试.txt", "out": "书/测试"})
+		require.False(t, isError, text)
+		assert.Contains(t, text, `"path": "书/测试.epub"`)
+		assert.NotContains(t, text, workDir)
+		assert.FileExists(t, filepath.Join(workDir, "1", "书", "测试.epub"))
+	})
+
+	t.Run("不能访问工作目录外的文件", func(t *testing.T) {
+		outside := filepath.Join(workDir, "外部.txt")
+		require.NoError(t, os.WriteFile(outside, txt, 0644))
+
+		tests := []struct {
+			name string
+			tool string
+			args map[string]any
+		}{
+			{"上传到上级目录", "kaf_upload", map[string]any{"filename": "../逃逸.txt", "content": "内容"}},
+			{"上传到绝对路径", "kaf_upload", map[string]any{"filename": filepath.Join(workDir, "逃逸.txt"), "content": "内容"}},
+			{"预览其他用户的文件", "kaf_preview", map[string]any{"filename": "../1/书/测试.txt"}},
+			{"分析上级目录的文件", "kaf_inspect", map[string]any{"filename": "../外部.txt"}},
+			{"转换绝对路径", "kaf_convert", map[string]any{"filename": outside}},
+			{"输出到上级目录", "kaf_convert", map[string]any{"filename": "测试.txt", "out": "../输出"}},
+			{"使用上级目录的封面", "kaf_convert", map[string]any{"filename": "测试.txt", "cover": "../cover.jpg"}},
+			{"建议规则时读取上级目录", "kaf_suggest_match", map[string]any{"filename": "../外部.txt"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				text, isError := callTool(t, user2, tt.tool, tt.args)
+				assert.True(t, isError)
+				assert.Contains(t, text, "只能使用工作目录下的相对路径")
+			})
+		}
+		assert.NoFileExists(t, filepath.Join(workDir, "逃逸.txt"))
+		assert.NoFileExists(t, filepath.Join(workDir, "输出.epub"))
+
+		text, isError := callTool(t, user2, "kaf_list_files", map[string]any{"dir": "../1"})
+		assert.True(t, isError)
+		assert.Equal(t, "dir只能是配置目录下的子目录", text)
+	})
+
+	t.Run("读取资源", func(t *testing.T) {
+		// 资源URI中的路径是转义后的
+		uri := "kaf://library/" + url.PathEscape("书") + "/" + url.PathEscape("测试.txt")
+		contents, err := readResource(user1, uri)
+		require.NoError(t, err)
+		assert.Equal(t, string(txt), contents.(mcp.TextResourceContents).Text)
+
+		_, err = readResource(user2, uri)
+		assert.Error(t, err, "其他用户的工作目录中没有这个文件")
+		_, err = readResource(user2, "kaf://library/..%2F1%2F书%2F测试.txt")
+		assert.Error(t, err)
+		_, err = readResource(user2, "kaf://books/..%2F1%2F书%2F测试.epub")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kafmcp "github.com/Deali-Axy/ebook-generator/internal/mcp"
+)
+
+// readResource 读取资源的第一个内容
+func readResource(c *client.Client, uri string) (mcp.ResourceContents, error) {
+	req := mcp.ReadResourceRequest{}
+	req.Params.URI = uri
+	result, err := c.ReadResource(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) == 0 {
+		return nil, nil
+	}
+	return result.Contents[0], nil
+}
+
+// resourceURIs 列出已注册的资源
+func resourceURIs(t *testing.T, c *client.Client) []string {
+	result, err := c.ListResources(context.Background(), mcp.ListResourcesRequest{})
+	require.NoError(t, err)
+	var uris []string
+	for _, r := range result.Resources {
+		uris = append(uris, r.URI)
+	}
+	return uris
+}
+
+// TestMCPResources 测试配置目录下的txt和电子书资源
+func TestMCPResources(t *testing.T) {
+	// 资源相对当前目录(KAF_DIR)
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	require.NoError(t, os.Mkdir("books", 0755))
+	txt := testTxt(t, "test.txt", 3)
+	content, err := os.ReadFile(txt)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join("books", "test.txt"), content, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join("books", "note.md"), []byte("不是txt"), 0644))
+
+	srv := server.NewMCPServer("KAF Converter", "test", server.WithResourceCapabilities(false, true))
+	converter := &kafmcp.ConverterService{}
+	converter.RegisterTools(srv, "test")
+	converter.RegisterResources(srv)
+	c, err := client.NewInProcessClient(srv)
+	require.NoError(t, err)
+	startMCPClient(t, c)
+
+	t.Run("列出txt", func(t *testing.T) {
+		assert.Equal(t, []string{"kaf://library/books/test.txt"}, resourceURIs(t, c))
+	})
+
+	t.Run("读取txt", func(t *testing.T) {
+		contents, err := readResource(c, "kaf://library/books/test.txt")
+		require.NoError(t, err)
+		text, ok := contents.(mcp.TextResourceContents)
+		require.True(t, ok)
+		assert.Equal(t, "text/plain", text.MIMEType)
+		assert.Contains(t, text.Text, "第1章 测试")
+	})
+
+	t.Run("转换后添加电子书", func(t *testing.T) {
+		text, isError := callTool(t, c, "kaf_convert", map[string]any{"filename": "books/test.txt", "out": "books/test"})
+		require.False(t, isError, text)
+		assert.Contains(t, text, `"uri": "kaf://books/books/test.epub"`)
+		assert.ElementsMatch(t, []string{"kaf://library/books/test.txt", "kaf://books/books/test.epub"}, resourceURIs(t, c))
+
+		contents, err := readResource(c, "kaf://books/books/test.epub")
+		require.NoError(t, err)
+		blob, ok := contents.(mcp.BlobResourceContents)
+		require.True(t, ok)
+		assert.Equal(t, "application/epub+zip", blob.MIMEType)
+		data, err := base64.StdEncoding.DecodeString(blob.Blob)
+		require.NoError(t, err)
+		want, err := os.ReadFile(filepath.Join("books", "test.epub"))
+		require.NoError(t, err)
+		assert.Equal(t, want, data)
+	})
+
+	t.Run("资源模板", func(t *testing.T) {
+		result, err := c.ListResourceTemplates(context.Background(), mcp.ListResourceTemplatesRequest{})
+		require.NoError(t, err)
+		assert.Len(t, result.ResourceTemplates, 2)
+
+		// 没有列出的文件也可以通过模板读取
+		require.NoError(t, os.WriteFile(filepath.Join("books", "new.txt"), content, 0644))
+		contents, err := readResource(c, "kaf://library/books/new.txt")
+		require.NoError(t, err)
+		assert.Contains(t, contents.(mcp.TextResourceContents).Text, "第3章 测试")
+	})
+
+	t.Run("不能读取的资源", func(t *testing.T) {
+		outside := filepath.Join(filepath.Dir(dir), "outside.txt")
+		require.NoError(t, os.WriteFile(outside, content, 0644))
+		t.Cleanup(func() { os.Remove(outside) })
+
+		for _, uri := range []string{
+			"kaf://library/..%2Foutside.txt",
+			"kaf://library/books%2F..%2F..%2Foutside.txt",
+			"kaf://library/books/note.md",
+			"kaf://books/books/test.txt",
+			"kaf://library/books/test.epub",
+			"kaf://library/books/missing.txt",
+		} {
+			_, err := readResource(c, uri)
+			assert.Error(t, err, uri)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kafmcp "github.com/Deali-Axy/ebook-generator/internal/mcp"
+)
+
+// startMCPClient 初始化连接到MCP服务的客户端, 测试结束时关闭
+func startMCPClient(t *testing.T, c *client.Client) *client.Client {
+	t.Cleanup(func() { c.Close() })
+	require.NoError(t, c.Start(context.Background()))
+	req := mcp.InitializeRequest{}
+	req.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	req.Params.ClientInfo = mcp.Implementation{Name: "kaf-test", Version: "test"}
+	_, err := c.Initialize(context.Background(), req)
+	require.NoError(t, err)
+	return c
+}
+
+// inProcessMCP 在进程内创建注册了转换工具的MCP服务, 和通过stdio使用时相同
+func inProcessMCP(t *testing.T) *client.Client {
+	srv := server.NewMCPServer("KAF Converter", "test")
+	converter := &kafmcp.ConverterService{}
+	converter.RegisterTools(srv, "test")
+	c, err := client.NewInProcessClient(srv)
+	require.NoError(t, err)
+	return startMCPClient(t, c)
+}
+
+// callTool 调用工具, 返回结果中的文本和是否出错
+func callTool(t *testing.T, c *client.Client, name string, args map[string]any) (string, bool) {
+	req := mcp.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+	result, err := c.CallTool(context.Background(), req)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Content)
+	text, ok := result.Content[0].(mcp.TextContent)
+	require.True(t, ok, "第一个结果应该是文本: %#v", result.Content[0])
+	return text.Text, result.IsError
+}
+
+// TestMCPTools 测试转换前检查txt的工具
+func TestMCPTools(t *testing.T) {
+	c := inProcessMCP(t)
+	txt := testTxt(t, "测试.txt", 3)
+
+	t.Run("工具列表", func(t *testing.T) {
+		tools, err := c.ListTools(context.Background(), mcp.ListToolsRequest{})
+		require.NoError(t, err)
+		var names []string
+		for _, tool := range tools.Tools {
+			names = append(names, tool.Name)
+		}
+		assert.ElementsMatch(t, []string{"kaf_convert", "kaf_preview", "kaf_inspect", "kaf_suggest_match", "kaf_list_files"}, names)
+	})
+
+	t.Run("预览目录", func(t *testing.T) {
+		text, isError := callTool(t, c, "kaf_preview", map[string]any{"filename": txt})
+		require.False(t, isError, text)
+		assert.Contains(t, text, "第1章 测试  (11字)")
+		assert.Contains(t, text, "书名: 测试")
+		assert.Contains(t, text, "共0卷3章")
+
+		text, isError = callTool(t, c, "kaf_preview", map[string]any{"filename": txt, "limit": 1})
+		require.False(t, isError, text)
+		assert.NotContains(t, text, "第2章")
+		assert.Contains(t, text, "...省略2行")
+	})
+
+	t.Run("分析txt", func(t *testing.T) {
+		text, isError := callTool(t, c, "kaf_inspect", map[string]any{"filename": txt})
+		require.False(t, isError, text)
+		var result map[string]any
+		require.NoError(t, json.Unmarshal([]byte(text), &result))
+		assert.EqualValues(t, 3, result["chapters"])
+	})
+
+	t.Run("建议章节规则", func(t *testing.T) {
+		text, isError := callTool(t, c, "kaf_suggest_match", map[string]any{"filename": txt})
+		require.False(t, isError, text)
+		var suggestions []map[string]any
+		require.NoError(t, json.Unmarshal([]byte(text), &suggestions))
+		require.NotEmpty(t, suggestions)
+
+		text, isError = callTool(t, c, "kaf_suggest_match", map[string]any{})
+		assert.True(t, isError)
+		assert.Equal(t, "filename和sample不能都为空", text)
+	})
+
+	t.Run("文件不存在", func(t *testing.T) {
+		_, isError := callTool(t, c, "kaf_preview", map[string]any{"filename": filepath.Join(t.TempDir(), "不存在.txt")})
+		assert.True(t, isError)
+	})
+}
+
+// TestMCPConvert 测试kaf_convert生成电子书并嵌入在结果中
+func TestMCPConvert(t *testing.T) {
+	c := inProcessMCP(t)
+	txt := testTxt(t, "测试.txt", 3)
+	out := filepath.Join(t.TempDir(), "输出")
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = "kaf_convert"
+	req.Params.Arguments = map[string]any{"filename": txt, "out": out, "format": "all", "bookname": "书名"}
+	result, err := c.CallTool(context.Background(), req)
+	require.NoError(t, err)
+	require.False(t, result.IsError)
+
+	var summary struct {
+		Bookname string `json:"bookname"`
+		Chapters int    `json:"chapters"`
+		Files    []struct {
+			Format string `json:"format"`
+			Path   string `json:"path"`
+			Size   int64  `json:"size"`
+		} `json:"files"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &summary))
+	assert.Equal(t, "书名", summary.Bookname)
+	assert.Equal(t, 3, summary.Chapters)
+	require.Len(t, summary.Files, 3)
+	require.Len(t, result.Content, 4, "每个电子书嵌入一个资源")
+	for i, file := range summary.Files {
+		assert.Equal(t, out+"."+file.Format, file.Path)
+		bs, err := os.ReadFile(file.Path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(bs)), file.Size)
+
+		resource, ok := result.Content[i+1].(mcp.EmbeddedResource)
+		require.True(t, ok)
+		blob, ok := resource.Resource.(mcp.BlobResourceContents)
+		require.True(t, ok)
+		data, err := base64.StdEncoding.DecodeString(blob.Blob)
+		require.NoError(t, err)
+		assert.Equal(t, bs, data, file.Format)
+	}
+
+	t.Run("参数错误", func(t *testing.T) {
+		text, isError := callTool(t, c, "kaf_convert", map[string]any{"filename": txt, "match": "("})
+		assert.True(t, isError)
+		assert.Contains(t, text, "生成匹配规则出错")
+	})
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/core"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// sectionTitles 每一节的标题, 卷中的章节缩进
+func sectionTitles(sections []model.Section) []string {
+	var titles []string
+	for _, section := range sections {
+		titles = append(titles, section.Title)
+		for _, sub := range section.Sections {
+			titles = append(titles, "  "+sub.Title)
+		}
+	}
+	return titles
+}
+
+// TestBookMerge 测试合并已解析的书
+func TestBookMerge(t *testing.T) {
+	first := &model.Book{Bookname: "第一部", SectionList: []model.Section{
+		{Title: "第1章", Content: "<p>一</p>"},
+		{Title: "第2章", Content: "<p>二</p>"},
+	}}
+	first.AddTips()
+	second := &model.Book{Bookname: "<第二部>", SectionList: []model.Section{
+		testVolume("第一卷", "第", 2),
+		{Title: "尾声", Content: "<p>完</p>"},
+	}}
+
+	book := &model.Book{Bookname: "合集"}
+	book.Merge(first, second)
+	assert.Equal(t, []string{
+		"第一部", "  第1章", "  第2章",
+		"&lt;第二部&gt;", "  第一卷", "  第1", "  第2", "  尾声",
+	}, sectionTitles(book.SectionList), "制作说明应该去掉, 原书的卷展开为章节")
+	assert.Equal(t, "<p>第一卷</p>", book.SectionList[1].Sections[0].Content, "卷的内容应该保留")
+	assert.Empty(t, book.SectionList[1].Sections[0].Sections, "合并后卷只有一层")
+}
+
+// TestMergeFiles 测试合并多个txt
+func TestMergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"三体.txt", "黑暗森林.txt", "死神永生.txt"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("第1章 开始\n正文\n第2章 结束\n正文\n"), 0644))
+		files = append(files, path)
+	}
+
+	tests := []struct {
+		name     string
+		bookname string
+		tips     bool
+		want     string
+		sections int
+	}{
+		{"默认书名", "", false, "三体 合集", 3},
+		{"指定书名", "地球往事", false, "地球往事", 3},
+		{"添加制作说明", "", true, "三体 合集", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := &model.Book{Bookname: tt.bookname, Tips: tt.tips}
+			model.SetDefault(book)
+			require.NoError(t, core.MergeFiles(book, files, "test"))
+			assert.Equal(t, tt.want, book.Bookname)
+			require.Len(t, book.SectionList, tt.sections)
+			volumes := book.SectionList
+			if tt.tips {
+				volumes = volumes[1 : len(volumes)-1]
+			}
+			for i, name := range []string{"三体", "黑暗森林", "死神永生"} {
+				assert.Equal(t, name, volumes[i].Title)
+				assert.Len(t, volumes[i].Sections, 2)
+			}
+		})
+	}
+
+	t.Run("少于两个文件", func(t *testing.T) {
+		assert.Error(t, core.MergeFiles(&model.Book{}, files[:1], "test"))
+	})
+
+	t.Run("文件不存在", func(t *testing.T) {
+		err := core.MergeFiles(&model.Book{}, []string{files[0], filepath.Join(dir, "不存在.txt")}, "test")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "不存在.txt")
+	})
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/epubcheck"
+	"github.com/Deali-Axy/ebook-generator/internal/kf8"
+	"github.com/Deali-Axy/ebook-generator/internal/metadata"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/pkg/kaf"
+)
+
+// convertBook 生成指定格式的电子书, 返回文件路径
+func convertBook(t *testing.T, format kaf.Format, opts ...kaf.Option) string {
+	var buf bytes.Buffer
+	opts = append([]kaf.Option{
+		kaf.WithFormat(format),
+		kaf.WithWriter(&buf),
+		kaf.WithBookname("原书名"),
+		kaf.WithAuthor("原作者"),
+	}, opts...)
+	conv, err := kaf.New(opts...)
+	require.NoError(t, err)
+	_, err = conv.Convert(context.Background(), testTxt(t, "测试.txt", 3))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "测试."+string(format))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+// TestMetadataRoundTrip 测试修改元数据后能读回相同的值
+func TestMetadataRoundTrip(t *testing.T) {
+	cover := filepath.Join(t.TempDir(), "cover.png")
+	require.NoError(t, os.WriteFile(cover, testCover(t, 60, 80), 0644))
+
+	tests := []struct {
+		name   string
+		format kaf.Format
+		opts   []kaf.Option
+		cover  bool // 修改时替换或添加封面
+		series bool
+	}{
+		{"epub", kaf.EPUB, nil, false, true},
+		{"epub添加封面", kaf.EPUB, nil, true, true},
+		{"epub替换封面", kaf.EPUB, []kaf.Option{kaf.WithCover(cover)}, true, true},
+		{"azw3", kaf.AZW3, []kaf.Option{kaf.WithCover(cover)}, true, false},
+		{"双格式mobi", kaf.MOBI, []kaf.Option{kaf.WithCover(cover), kaf.WithMobiKF8()}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := convertBook(t, tt.format, tt.opts...)
+			meta := &model.Book{
+				Bookname:    "新书名",
+				Author:      "作者甲 & 作者乙",
+				Description: "新的简介",
+				Lang:        "en",
+				Date:        "2024-05-06",
+				Series:      "测试系列",
+				SeriesIndex: 2,
+			}
+			if tt.cover {
+				meta.Cover = cover
+			}
+			require.NoError(t, metadata.Update(path, meta))
+
+			got, err := metadata.Read(path)
+			require.NoError(t, err)
+			assert.Equal(t, "新书名", got.Bookname)
+			assert.Equal(t, "作者甲 & 作者乙", got.Author)
+			assert.Equal(t, "新的简介", got.Description)
+			assert.Equal(t, "en", got.Lang)
+			assert.Equal(t, "2024-05-06", got.Date)
+			assert.Equal(t, string(tt.format), got.Format)
+			if tt.series {
+				assert.Equal(t, "测试系列", got.Series)
+				assert.Equal(t, 2, got.SeriesIndex)
+			} else {
+				assert.Empty(t, got.Series, "azw3和mobi没有系列字段")
+			}
+
+			if tt.format == kaf.EPUB {
+				report, err := epubcheck.CheckFile(path)
+				require.NoError(t, err)
+				assert.Empty(t, report.Errors(), "修改后的epub应该符合规范")
+			}
+		})
+	}
+
+	t.Run("空字段保持不变", func(t *testing.T) {
+		path := convertBook(t, kaf.EPUB, kaf.WithDescription("原简介"))
+		require.NoError(t, metadata.Update(path, &model.Book{Bookname: "新书名"}))
+		got, err := metadata.Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "新书名", got.Bookname)
+		assert.Equal(t, "原作者", got.Author)
+		assert.Equal(t, "原简介", got.Description)
+	})
+
+	t.Run("只修改系列名时保留序号", func(t *testing.T) {
+		path := convertBook(t, kaf.EPUB)
+		require.NoError(t, metadata.Update(path, &model.Book{Series: "系列", SeriesIndex: 3}))
+		require.NoError(t, metadata.Update(path, &model.Book{Series: "新系列"}))
+		got, err := metadata.Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "新系列", got.Series)
+		assert.Equal(t, 3, got.SeriesIndex)
+	})
+
+	t.Run("可重复生成的epub修改后仍可重复生成", func(t *testing.T) {
+		t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+		edit := func() []byte {
+			path := convertBook(t, kaf.EPUB, kaf.WithReproducible())
+			require.NoError(t, metadata.Update(path, &model.Book{Bookname: "新书名"}))
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			return data
+		}
+		data := edit()
+		assert.Contains(t, epubOPF(t, data), `<meta property="dcterms:modified">2023-11-14T22:13:20Z</meta>`)
+		assert.Equal(t, data, edit())
+	})
+
+	t.Run("没有封面的azw3不能替换封面", func(t *testing.T) {
+		path := convertBook(t, kaf.AZW3)
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+		err = metadata.Update(path, &model.Book{Cover: cover})
+		assert.ErrorIs(t, err, kf8.ErrNoCover)
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, before, after, "失败时不能修改原文件")
+	})
+}
+
+// TestMetadataUniqueIDs 测试修改元数据时添加的id不和opf中已有的id重复
+func TestMetadataUniqueIDs(t *testing.T) {
+	// calibre等工具生成的opf可能已使用creator2这样的id
+	files := testEpubFiles()
+	files = editEpubFile("OEBPS/content.opf", "<dc:title>测试</dc:title>",
+		`<dc:title>测试</dc:title>
+    <dc:creator id="creator2">原作者</dc:creator>
+    <meta refines="#creator2" property="role" scheme="marc:relators">aut</meta>`)(files)
+	files = editEpubFile("OEBPS/content.opf", "</manifest>",
+		`<item id="creator3" href="a.css" media-type="text/css"/>
+    <item id="series" href="b.css" media-type="text/css"/>
+    <item id="kaf-cover-image" href="c.css" media-type="text/css"/>
+  </manifest>`)(files)
+	for _, name := range []string{"a.css", "b.css", "c.css"} {
+		files = append(files, epubFile{"OEBPS/" + name, "p { margin: 0; }"})
+	}
+	path := filepath.Join(t.TempDir(), "测试.epub")
+	require.NoError(t, os.WriteFile(path, buildEpub(t, files, false), 0644))
+
+	cover := filepath.Join(t.TempDir(), "cover.png")
+	require.NoError(t, os.WriteFile(cover, testCover(t, 60, 80), 0644))
+	require.NoError(t, metadata.Update(path, &model.Book{Author: "甲 & 乙 & 丙", Series: "系列", Cover: cover}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	ids := make(map[string]int)
+	for _, m := range regexp.MustCompile(`\sid="([^"]*)"`).FindAllStringSubmatch(epubOPF(t, data), -1) {
+		ids[m[1]]++
+	}
+	for id, count := range ids {
+		assert.Equal(t, 1, count, "重复的id: %s", id)
+	}
+	assert.Contains(t, ids, "creator4")
+	assert.Contains(t, ids, "creator5")
+	assert.Contains(t, ids, "series1")
+	assert.Contains(t, ids, "kaf-cover-image1")
+
+	got, err := metadata.Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, "甲 & 乙 & 丙", got.Author)
+	assert.Equal(t, "系列", got.Series)
+	assert.Equal(t, 1, got.SeriesIndex)
+
+	report, err := epubcheck.CheckFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors())
+}
+
+// TestMetadataErrors 测试元数据参数检查
+func TestMetadataErrors(t *testing.T) {
+	epub := convertBook(t, kaf.EPUB)
+	txt := testTxt(t, "测试.txt", 1)
+	notEpub := filepath.Join(t.TempDir(), "损坏.epub")
+	require.NoError(t, os.WriteFile(notEpub, []byte("not a zip"), 0644))
+
+	tests := []struct {
+		name string
+		path string
+		meta *model.Book
+		want error
+	}{
+		{"不支持的格式", txt, &model.Book{Bookname: "书名"}, metadata.ErrUnsupported},
+		{"不是epub文件", notEpub, &model.Book{Bookname: "书名"}, metadata.ErrInvalidEpub},
+		{"出版日期格式错误", epub, &model.Book{Date: "2024/05/06"}, nil},
+		{"系列序号小于0", epub, &model.Book{SeriesIndex: -1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := metadata.Update(tt.path, tt.meta)
+			require.Error(t, err)
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("拆分作者", func(t *testing.T) {
+		assert.Equal(t, []string{"甲", "乙"}, metadata.Authors(" 甲 &乙& "))
+		assert.Nil(t, metadata.Authors(""))
+		assert.Equal(t, "甲 & 乙", metadata.JoinAuthors([]string{"甲", "乙"}))
+	})
+}
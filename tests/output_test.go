@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+)
+
+// fakeKindlegen 在PATH中放一个假的kindlegen, 把epub"转换"成内容为"kindlegen"的mobi
+func fakeKindlegen(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("假的kindlegen是shell脚本")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\nprintf kindlegen > \"${last%.epub}.mobi\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kindlegen"), []byte(script), 0755))
+	t.Setenv("PATH", dir)
+}
+
+// TestWriterOutput 测试写入Writer和写入目录生成相同的文件
+func TestWriterOutput(t *testing.T) {
+	// 没有kindlegen, 写入目录时也使用内置的mobi生成器
+	t.Setenv("PATH", t.TempDir())
+
+	for _, format := range []string{"epub", "mobi", "azw3"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			book := parsedBook(t, format, 3)
+			book.Reproducible = true
+			record := &converter.RecordOutput{Output: converter.DirOutput{Dir: dir}}
+			d := converter.Dispatcher{Book: book, Output: record, Progress: func(converter.Progress) {}}
+			require.NoError(t, d.Convert(context.Background()))
+			assert.Equal(t, []string{"测试." + format}, record.Names())
+			want, err := os.ReadFile(filepath.Join(dir, "测试."+format))
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			book = parsedBook(t, format, 3)
+			book.Reproducible = true
+			d = converter.Dispatcher{Book: book, Output: &converter.WriterOutput{W: &buf}, Progress: func(converter.Progress) {}}
+			require.NoError(t, d.Convert(context.Background()))
+			assert.Equal(t, want, buf.Bytes())
+		})
+	}
+
+	t.Run("只能写入一个文件", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := &converter.WriterOutput{W: &buf}
+		w, err := out.Create("测试.epub")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("内容"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		_, err = out.Create("测试.mobi")
+		assert.Error(t, err)
+		assert.Equal(t, "内容", buf.String())
+	})
+
+	t.Run("多个格式写入同一个Writer时失败", func(t *testing.T) {
+		var buf bytes.Buffer
+		d := converter.Dispatcher{Book: parsedBook(t, "all", 3), Output: &converter.WriterOutput{W: &buf}, Progress: func(converter.Progress) {}}
+		assert.Error(t, d.Convert(context.Background()))
+	})
+}
+
+// TestRecordOutput 测试记录创建过的文件, 创建失败的不记录
+func TestRecordOutput(t *testing.T) {
+	dir := t.TempDir()
+	record := &converter.RecordOutput{Output: failOutput{dir: converter.DirOutput{Dir: dir}, ext: ".mobi"}}
+	for _, name := range []string{"测试.epub", "测试.mobi", "测试.azw3"} {
+		if w, err := record.Create(name); err == nil {
+			require.NoError(t, w.Close())
+		}
+	}
+	names := record.Names()
+	assert.Equal(t, []string{"测试.epub", "测试.azw3"}, names)
+
+	names[0] = "修改"
+	assert.Equal(t, "测试.epub", record.Names()[0], "返回的是副本")
+}
+
+// TestKindlegenOutput 测试只有输出到目录时才使用kindlegen
+func TestKindlegenOutput(t *testing.T) {
+	fakeKindlegen(t)
+
+	t.Run("输出到目录", func(t *testing.T) {
+		dir := t.TempDir()
+		d := converter.Dispatcher{Book: parsedBook(t, "mobi", 3), Output: converter.DirOutput{Dir: dir}, Progress: func(converter.Progress) {}}
+		results := d.Run(context.Background())
+		require.Len(t, results, 2, "kindlegen依赖先生成的epub")
+		for _, r := range results {
+			require.NoError(t, r.Err, r.Format)
+		}
+		mobi, err := os.ReadFile(filepath.Join(dir, "测试.mobi"))
+		require.NoError(t, err)
+		assert.Equal(t, "kindlegen", string(mobi))
+	})
+
+	t.Run("输出到Writer时使用内置的生成器", func(t *testing.T) {
+		var buf bytes.Buffer
+		d := converter.Dispatcher{Book: parsedBook(t, "mobi", 3), Output: &converter.WriterOutput{W: &buf}, Progress: func(converter.Progress) {}}
+		results := d.Run(context.Background())
+		require.Len(t, results, 1)
+		require.NoError(t, results[0].Err)
+		assert.Equal(t, "mobi", results[0].Format)
+		assert.NotEqual(t, "kindlegen", buf.String())
+		assert.Equal(t, "BOOKMOBI", string(buf.Bytes()[60:68]))
+	})
+
+	t.Run("双格式mobi不使用kindlegen", func(t *testing.T) {
+		dir := t.TempDir()
+		book := parsedBook(t, "mobi", 3)
+		book.MobiKF8 = true
+		d := converter.Dispatcher{Book: book, Output: converter.DirOutput{Dir: dir}, Progress: func(converter.Progress) {}}
+		require.NoError(t, d.Convert(context.Background()))
+		mobi, err := os.ReadFile(filepath.Join(dir, "测试.mobi"))
+		require.NoError(t, err)
+		assert.Equal(t, "BOOKMOBI", string(mobi[60:68]))
+		assert.NoFileExists(t, filepath.Join(dir, "测试.epub"))
+	})
+}
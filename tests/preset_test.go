@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// uploadTxt 上传txt, 返回任务ID
+func (ts *TestServer) uploadTxt(t *testing.T, name, content string) string {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", name)
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/api/upload", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response struct {
+		Data models.UploadResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Data.TaskID
+}
+
+// postJSON 发送JSON请求, auth为true时带上登录的token, 返回状态码和响应
+func (ts *TestServer) postJSON(t *testing.T, path string, data interface{}, auth bool) (int, models.APIResponse) {
+	body, err := json.Marshal(data)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+ts.token)
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+
+	var response models.APIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+// TestPresetConvertOptions 测试预设转换为转换请求的选项
+func TestPresetConvertOptions(t *testing.T) {
+	preset := &models.ConversionPreset{
+		OutputFormat: "epub",
+		Options: models.ConvertOptionsJSON{
+			"author":    "预设作者",
+			"format":    "mobi",
+			"task_id":   "task_old",
+			"preset_id": 3,
+		},
+	}
+
+	options := preset.ConvertOptions()
+	assert.Equal(t, map[string]interface{}{"author": "预设作者", "format": "epub"}, options)
+	assert.Equal(t, "task_old", preset.Options["task_id"], "不修改预设的选项")
+
+	t.Run("没有输出格式时使用选项中的格式", func(t *testing.T) {
+		preset := &models.ConversionPreset{Options: models.ConvertOptionsJSON{"format": "azw3"}}
+		assert.Equal(t, "azw3", preset.ConvertOptions()["format"])
+	})
+}
+
+// TestConvertWithPreset 测试转换时合并预设和请求中的选项
+func TestConvertWithPreset(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.registerTestUser(t)
+
+	historyService := services.NewHistoryService(ts.db)
+	createPreset := func(userID uint, req models.PresetCreateRequest) *models.ConversionPreset {
+		preset, err := historyService.CreatePreset(userID, &req)
+		require.NoError(t, err)
+		return preset
+	}
+	defaultPreset := createPreset(ts.userID, models.PresetCreateRequest{
+		Name:         "默认",
+		OutputFormat: "epub",
+		Options:      map[string]interface{}{"bookname": "预设书名", "author": "预设作者", "indent": 4},
+		IsDefault:    true,
+	})
+	publicPreset := createPreset(ts.userID+1, models.PresetCreateRequest{
+		Name:         "公开",
+		OutputFormat: "epub",
+		Options:      map[string]interface{}{"author": "公开作者"},
+		IsPublic:     true,
+	})
+	privatePreset := createPreset(ts.userID+1, models.PresetCreateRequest{
+		Name:         "私有",
+		OutputFormat: "epub",
+		Options:      map[string]interface{}{"author": "私有作者"},
+	})
+
+	tests := []struct {
+		name       string
+		auth       bool
+		fields     map[string]interface{}
+		wantCode   int
+		wantAuthor string
+		wantIndent uint
+		wantPreset uint
+	}{
+		{
+			name:       "登录用户使用默认预设",
+			auth:       true,
+			fields:     map[string]interface{}{},
+			wantCode:   http.StatusOK,
+			wantAuthor: "预设作者",
+			wantIndent: 4,
+			wantPreset: defaultPreset.ID,
+		},
+		{
+			name:       "请求中的字段覆盖预设",
+			auth:       true,
+			fields:     map[string]interface{}{"author": "请求作者", "indent": 0},
+			wantCode:   http.StatusOK,
+			wantAuthor: "请求作者",
+			wantPreset: defaultPreset.ID,
+		},
+		{
+			name:       "指定其他用户公开的预设",
+			auth:       true,
+			fields:     map[string]interface{}{"bookname": "书名", "preset_id": publicPreset.ID},
+			wantCode:   http.StatusOK,
+			wantAuthor: "公开作者",
+			wantPreset: publicPreset.ID,
+		},
+		{
+			name:     "匿名用户不使用默认预设",
+			fields:   map[string]interface{}{"bookname": "书名", "format": "epub"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "匿名用户使用公开的预设",
+			fields:     map[string]interface{}{"bookname": "书名", "preset_id": publicPreset.ID},
+			wantCode:   http.StatusOK,
+			wantAuthor: "公开作者",
+			wantPreset: publicPreset.ID,
+		},
+		{
+			name:     "不能使用其他用户私有的预设",
+			auth:     true,
+			fields:   map[string]interface{}{"preset_id": privatePreset.ID},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "预设不存在",
+			auth:     true,
+			fields:   map[string]interface{}{"preset_id": 9999},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := ts.uploadTxt(t, "书.txt", "第1章 开始\n正文\n")
+			tt.fields["task_id"] = taskID
+			code, response := ts.postJSON(t, "/api/convert", tt.fields, tt.auth)
+			require.Equal(t, tt.wantCode, code, response.Error)
+			if tt.wantCode != http.StatusOK {
+				assert.Equal(t, "读取预设失败", response.Message)
+				return
+			}
+
+			assert.Equal(t, models.TaskStatusCompleted, waitTask(t, ts.tasks, taskID).Status)
+			task, exists := ts.tasks.GetTask(taskID)
+			require.True(t, exists)
+			assert.Equal(t, "epub", task.Request.Format)
+			assert.Equal(t, tt.wantAuthor, task.Request.Author)
+			assert.Equal(t, tt.wantIndent, task.Request.Indent)
+			assert.Equal(t, tt.wantPreset, task.Request.PresetID)
+		})
+	}
+
+	t.Run("记录预设的使用次数", func(t *testing.T) {
+		for id, want := range map[uint]int64{defaultPreset.ID: 2, publicPreset.ID: 2, privatePreset.ID: 0} {
+			var preset models.ConversionPreset
+			require.NoError(t, ts.db.First(&preset, id).Error)
+			assert.Equal(t, want, preset.UsageCount, preset.Name)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/pkg/kaf"
+)
+
+// testTxt 在临时目录生成有count章的txt
+func testTxt(t *testing.T, name string, count int) string {
+	var content strings.Builder
+	for i := 1; i <= count; i++ {
+		fmt.Fprintf(&content, "第%d章 测试\n这是第%d章的正文内容。\n", i, i)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0644))
+	return path
+}
+
+// convertEpub 可重复生成epub, 返回文件内容
+func convertEpub(t *testing.T, txt string, opts ...kaf.Option) []byte {
+	return convertReproducible(t, txt, kaf.EPUB, opts...)
+}
+
+// convertReproducible 可重复生成format格式的电子书, 返回文件内容
+func convertReproducible(t *testing.T, txt string, format kaf.Format, opts ...kaf.Option) []byte {
+	var buf bytes.Buffer
+	opts = append([]kaf.Option{kaf.WithFormat(format), kaf.WithReproducible(), kaf.WithWriter(&buf)}, opts...)
+	conv, err := kaf.New(opts...)
+	require.NoError(t, err)
+	_, err = conv.Convert(context.Background(), txt)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+var uuidReg = regexp.MustCompile(`urn:uuid:([0-9a-f-]{36})`)
+
+// epubOPF 读取epub中的opf
+func epubOPF(t *testing.T, data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".opf") {
+			continue
+		}
+		rc, err := f.Open()
+		require.NoError(t, err)
+		opf, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		return string(opf)
+	}
+	t.Fatal("epub中没有opf文件")
+	return ""
+}
+
+// epubUUID 读取epub的opf中的UUID
+func epubUUID(t *testing.T, data []byte) string {
+	m := uuidReg.FindStringSubmatch(epubOPF(t, data))
+	require.NotNil(t, m, "opf中没有UUID")
+	return m[1]
+}
+
+// TestReproducibleEpub 测试可重复生成
+func TestReproducibleEpub(t *testing.T) {
+	txt := testTxt(t, "测试.txt", 3)
+
+	t.Run("相同输入生成相同文件", func(t *testing.T) {
+		assert.Equal(t, convertEpub(t, txt), convertEpub(t, txt))
+	})
+
+	tests := []struct {
+		name  string
+		epoch string
+		year  int
+	}{
+		{"正常时间", "1700000000", 2023},
+		{"早于1980年", "0", 1980},
+		{"超出zip的范围", "5000000000", 2106},
+	}
+	for _, tt := range tests {
+		t.Run("SOURCE_DATE_EPOCH "+tt.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", tt.epoch)
+			data := convertEpub(t, txt)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			for _, f := range zr.File {
+				assert.Equal(t, tt.year, f.Modified.Year(), f.Name)
+			}
+		})
+	}
+
+	t.Run("封面不同时ID不同", func(t *testing.T) {
+		dir := t.TempDir()
+		red := filepath.Join(dir, "red.png")
+		blue := filepath.Join(dir, "blue.png")
+		require.NoError(t, os.WriteFile(red, testCover(t, 60, 80), 0644))
+		require.NoError(t, os.WriteFile(blue, testCover(t, 80, 60), 0644))
+
+		first := epubUUID(t, convertEpub(t, txt, kaf.WithCover(red)))
+		second := epubUUID(t, convertEpub(t, txt, kaf.WithCover(blue)))
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, first, epubUUID(t, convertEpub(t, txt, kaf.WithCover(red))))
+	})
+}
+
+// TestReproducibleKindle 测试可重复生成mobi和azw3, PalmDB头中的时间由生成器写入
+func TestReproducibleKindle(t *testing.T) {
+	txt := testTxt(t, "测试.txt", 3)
+	formats := []struct {
+		name   string
+		format kaf.Format
+		opts   []kaf.Option
+		kf8    bool // KF8生成器的时间从1904年开始计算
+	}{
+		{"mobi", kaf.MOBI, nil, false},
+		{"mobi双格式", kaf.MOBI, []kaf.Option{kaf.WithMobiKF8()}, true},
+		{"azw3", kaf.AZW3, nil, true},
+	}
+	// 1904年到1970年的秒数
+	const palmOffset = 2082844800
+	epochs := []struct {
+		name  string
+		epoch string
+		mobi  uint32
+		kf8   uint32
+	}{
+		{"正常时间", "1700000000", 1700000000, 1700000000 + palmOffset},
+		{"早于1970年", "-100", 0, palmOffset - 100},
+		{"超出32位的范围", "5000000000", math.MaxUint32, math.MaxUint32},
+	}
+	for _, f := range formats {
+		for _, e := range epochs {
+			t.Run(f.name+" "+e.name, func(t *testing.T) {
+				t.Setenv("SOURCE_DATE_EPOCH", e.epoch)
+				data := convertReproducible(t, txt, f.format, f.opts...)
+				assert.Equal(t, data, convertReproducible(t, txt, f.format, f.opts...), "相同输入生成相同文件")
+
+				// 创建时间和修改时间位于PalmDB头的偏移36和40
+				want := e.mobi
+				if f.kf8 {
+					want = e.kf8
+				}
+				require.Greater(t, len(data), 44)
+				assert.Equal(t, want, binary.BigEndian.Uint32(data[36:40]))
+				assert.Equal(t, want, binary.BigEndian.Uint32(data[40:44]))
+			})
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// responseTaskID 读取开始转换的响应中的任务ID
+func responseTaskID(t *testing.T, response models.APIResponse) string {
+	data, ok := response.Data.(map[string]interface{})
+	require.True(t, ok, "响应中没有数据")
+	taskID, _ := data["task_id"].(string)
+	require.NotEmpty(t, taskID)
+	return taskID
+}
+
+// TestRerunHistory 测试使用转换历史的选项重新转换
+func TestRerunHistory(t *testing.T) {
+	ts := setupTestServer(t)
+	ts.registerTestUser(t)
+	historyService := services.NewHistoryService(ts.db)
+
+	// convert 转换并等待结束, 返回任务的转换历史
+	convert := func(t *testing.T, path string, fields map[string]interface{}) *models.ConversionHistory {
+		code, response := ts.postJSON(t, path, fields, true)
+		require.Equal(t, http.StatusOK, code, response.Error)
+		taskID := responseTaskID(t, response)
+		require.Equal(t, models.TaskStatusCompleted, waitTask(t, ts.tasks, taskID).Status)
+		history, err := historyService.GetHistoryByTaskID(taskID)
+		require.NoError(t, err)
+		return history
+	}
+	// rerun 重新转换, 返回状态码和响应
+	rerun := func(t *testing.T, historyID interface{}, fields map[string]interface{}) (int, models.APIResponse) {
+		return ts.postJSON(t, fmt.Sprintf("/api/history/%v/rerun", historyID), fields, true)
+	}
+
+	book := convert(t, "/api/convert", map[string]interface{}{
+		"task_id":  ts.uploadTxt(t, "书.txt", "第1章 开始\n正文\n"),
+		"bookname": "原书名",
+		"author":   "原作者",
+		"format":   "epub",
+	})
+
+	t.Run("使用原来的选项重新转换", func(t *testing.T) {
+		code, response := rerun(t, book.ID, nil)
+		require.Equal(t, http.StatusOK, code, response.Error)
+		taskID := responseTaskID(t, response)
+		assert.NotEqual(t, book.TaskID, taskID, "重新转换使用新的任务")
+
+		assert.Equal(t, models.TaskStatusCompleted, waitTask(t, ts.tasks, taskID).Status)
+		task, exists := ts.tasks.GetTask(taskID)
+		require.True(t, exists)
+		assert.Equal(t, "原书名", task.Request.Bookname)
+		assert.Equal(t, "原作者", task.Request.Author)
+		assert.Equal(t, "epub", task.Request.Format)
+
+		history, err := historyService.GetHistoryByTaskID(taskID)
+		require.NoError(t, err, "重新转换记录到转换历史")
+		assert.Equal(t, book.OriginalFileName, history.OriginalFileName)
+		assert.Equal(t, book.OriginalFileHash, history.OriginalFileHash)
+	})
+
+	t.Run("请求中的字段覆盖原来的选项", func(t *testing.T) {
+		code, response := rerun(t, book.ID, map[string]interface{}{"author": "新作者"})
+		require.Equal(t, http.StatusOK, code, response.Error)
+		taskID := responseTaskID(t, response)
+
+		assert.Equal(t, models.TaskStatusCompleted, waitTask(t, ts.tasks, taskID).Status)
+		task, _ := ts.tasks.GetTask(taskID)
+		assert.Equal(t, "原书名", task.Request.Bookname)
+		assert.Equal(t, "新作者", task.Request.Author)
+	})
+
+	t.Run("合并任务重新合并原来的源文件", func(t *testing.T) {
+		sources := []string{
+			ts.uploadTxt(t, "上卷.txt", "第1章 开始\n正文\n"),
+			ts.uploadTxt(t, "下卷.txt", "第1章 结束\n正文\n"),
+		}
+		merged := convert(t, "/api/merge", map[string]interface{}{
+			"task_id":  fmt.Sprintf("merge_%d", time.Now().UnixNano()),
+			"bookname": "合集",
+			"format":   "epub",
+			"sources":  sources,
+		})
+
+		code, response := rerun(t, merged.ID, nil)
+		require.Equal(t, http.StatusOK, code, response.Error)
+		taskID := responseTaskID(t, response)
+
+		assert.Equal(t, models.TaskStatusCompleted, waitTask(t, ts.tasks, taskID).Status)
+		task, _ := ts.tasks.GetTask(taskID)
+		assert.Equal(t, sources, task.Sources)
+		assert.Equal(t, "合集", task.Request.Bookname)
+	})
+
+	// 不能重新转换的历史记录
+	cleaned := &models.ConversionHistory{
+		UserID:         ts.userID,
+		TaskID:         "task_cleaned",
+		OutputFormat:   "epub",
+		Status:         "completed",
+		ConvertOptions: models.ConvertOptionsJSON{"bookname": "书名", "format": "epub"},
+		StartTime:      time.Now(),
+	}
+	other := &models.ConversionHistory{
+		UserID:         ts.userID + 1,
+		TaskID:         "task_other",
+		OutputFormat:   "epub",
+		Status:         "completed",
+		ConvertOptions: models.ConvertOptionsJSON{"bookname": "书名", "format": "epub"},
+		StartTime:      time.Now(),
+	}
+	require.NoError(t, ts.db.Create(cleaned).Error)
+	require.NoError(t, ts.db.Create(other).Error)
+
+	tests := []struct {
+		name        string
+		historyID   interface{}
+		fields      map[string]interface{}
+		wantCode    int
+		wantMessage string
+	}{
+		{"选项错误", book.ID, map[string]interface{}{"format": "pdf"}, http.StatusBadRequest, "请求参数错误"},
+		{"原文件已被清理", cleaned.ID, nil, http.StatusNotFound, "原文件已被清理, 请重新上传"},
+		{"其他用户的历史记录", other.ID, nil, http.StatusNotFound, "历史记录不存在"},
+		{"历史记录不存在", 9999, nil, http.StatusNotFound, "历史记录不存在"},
+		{"无效的历史记录ID", "abc", nil, http.StatusBadRequest, "无效的历史记录ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := rerun(t, tt.historyID, tt.fields)
+			assert.Equal(t, tt.wantCode, code, response.Error)
+			assert.Equal(t, tt.wantMessage, response.Message)
+		})
+	}
+}
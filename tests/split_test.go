@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/converter"
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+)
+
+// testVolume 生成有count章的卷, 章节名为"前缀1"、"前缀2"...
+func testVolume(title, prefix string, count int) model.Section {
+	volume := model.Section{Title: title, Content: "<p>" + title + "</p>"}
+	for i := 1; i <= count; i++ {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		volume.Sections = append(volume.Sections, model.Section{Title: name, Content: "<p>" + name + "</p>"})
+	}
+	return volume
+}
+
+// splitTestBook 第一卷3章, 两个同名的"番外"卷分别有3章和2章
+func splitTestBook() *model.Book {
+	return &model.Book{
+		Bookname: "测试",
+		Out:      "测试",
+		SectionList: []model.Section{
+			testVolume("第一卷", "第一卷第", 3),
+			testVolume("番外", "番外甲", 3),
+			testVolume("番外", "番外乙", 2),
+		},
+	}
+}
+
+// partNames 每一册的书名
+func partNames(parts []*model.Book) []string {
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = part.Bookname
+	}
+	return names
+}
+
+// TestParseRanges 测试章节范围解析
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []model.ChapterRange
+		wantErr bool
+	}{
+		{"1-500,501-1000,1001-", []model.ChapterRange{{Start: 1, End: 500}, {Start: 501, End: 1000}, {Start: 1001, End: 0}}, false},
+		{" 3 , 5-6 ", []model.ChapterRange{{Start: 3, End: 3}, {Start: 5, End: 6}}, false},
+		{"1-", []model.ChapterRange{{Start: 1, End: 0}}, false},
+		{"", nil, true},
+		{",", nil, true},
+		{"0-5", nil, true},
+		{"5-3", nil, true},
+		{"a-b", nil, true},
+		{"-5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := model.ParseRanges(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestSplitBook 测试各种分册方式
+func TestSplitBook(t *testing.T) {
+	tests := []struct {
+		name  string
+		split func(book *model.Book) []*model.Book
+		want  []string
+	}{
+		{
+			"按章节数",
+			func(book *model.Book) []*model.Book { return book.SplitBySections(4) },
+			[]string{"测试 第一卷", "测试 番外", "测试 番外"},
+		},
+		{
+			"按章节数拆开卷",
+			func(book *model.Book) []*model.Book { return book.SplitBySections(3) },
+			[]string{"测试 第一卷 (第一卷第1–第一卷第2)", "测试 第一卷", "测试 番外", "测试 番外 (番外甲2–番外甲3)", "测试 番外"},
+		},
+		{
+			"按卷",
+			func(book *model.Book) []*model.Book { return book.SplitByVolume(2) },
+			[]string{"测试 第一卷–番外", "测试 番外"},
+		},
+		{
+			// 同名的卷各自判断是否被拆开
+			"按章节范围",
+			func(book *model.Book) []*model.Book {
+				return book.SplitByRanges([]model.ChapterRange{{Start: 1, End: 2}, {Start: 3, End: 3}, {Start: 4, End: 6}, {Start: 7, End: 0}})
+			},
+			[]string{"测试 第一卷 (第一卷第1–第一卷第2)", "测试 第一卷", "测试 番外", "测试 番外"},
+		},
+		{
+			"只有一册时返回原书",
+			func(book *model.Book) []*model.Book {
+				return book.SplitByRanges([]model.ChapterRange{{Start: 1, End: 0}})
+			},
+			[]string{"测试"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := splitTestBook()
+			parts := tt.split(book)
+			assert.Equal(t, tt.want, partNames(parts))
+			if len(parts) == 1 {
+				assert.Same(t, book, parts[0])
+				return
+			}
+			for i, part := range parts {
+				assert.Equal(t, "测试", part.Series)
+				assert.Equal(t, i+1, part.SeriesIndex)
+				assert.Equal(t, len(parts), part.SeriesTotal)
+				assert.Equal(t, fmt.Sprintf("测试_%d", i+1), part.Out)
+			}
+		})
+	}
+
+	t.Run("按大小", func(t *testing.T) {
+		book := &model.Book{Bookname: "测试", Out: "测试"}
+		for i := 1; i <= 10; i++ {
+			book.SectionList = append(book.SectionList, model.Section{
+				Title:   fmt.Sprintf("第%d章", i),
+				Content: strings.Repeat("字", 200),
+			})
+		}
+		parts := book.SplitBySize(1)
+		require.Greater(t, len(parts), 1)
+		var count int
+		for _, part := range parts {
+			count += model.SectionCount(part.SectionList)
+		}
+		assert.Equal(t, 10, count, "分册后章节不能丢失")
+	})
+
+	t.Run("按章节范围时保留制作说明", func(t *testing.T) {
+		book := splitTestBook()
+		book.AddTips()
+		parts := book.SplitByRanges([]model.ChapterRange{{Start: 1, End: 3}, {Start: 4, End: 0}})
+		require.Len(t, parts, 2)
+		assert.Equal(t, model.Tutorial, parts[0].SectionList[0].Content)
+		last := parts[1].SectionList
+		assert.Equal(t, model.Tutorial, last[len(last)-1].Content)
+	})
+}
+
+// TestConverterSplitBook 测试转换时的分册设置
+func TestConverterSplitBook(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(book *model.Book)
+		parts   int
+		wantErr bool
+	}{
+		{"不分册", func(book *model.Book) {}, 1, false},
+		{"按章节范围", func(book *model.Book) {
+			book.Split, book.SplitRanges = model.SplitRange, "1-3,4-"
+		}, 2, false},
+		{"章节范围格式错误", func(book *model.Book) {
+			book.Split, book.SplitRanges = model.SplitRange, "3-1"
+		}, 0, true},
+		{"大小未设置时不分册", func(book *model.Book) {
+			book.Split = model.SplitSize
+		}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := splitTestBook()
+			tt.setup(book)
+			parts, err := converter.SplitBook(book, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, parts, tt.parts)
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/Deali-Axy/ebook-generator/internal/storage"
+	"github.com/Deali-Axy/ebook-generator/internal/web/models"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// taskQueueEnv 任务服务使用的目录, 重启服务时使用相同的目录和数据库
+type taskQueueEnv struct {
+	uploadDir string
+	outputDir string
+	db        *gorm.DB
+}
+
+func newTaskQueueEnv(t *testing.T) *taskQueueEnv {
+	dir := t.TempDir()
+	env := &taskQueueEnv{
+		uploadDir: filepath.Join(dir, "uploads"),
+		outputDir: filepath.Join(dir, "outputs"),
+		db:        testDB(t),
+	}
+	require.NoError(t, os.MkdirAll(env.uploadDir, 0755))
+	require.NoError(t, os.MkdirAll(env.outputDir, 0755))
+	return env
+}
+
+// service 创建任务服务, convertDir为生成电子书的目录, 为空时和保存结果的目录相同
+func (env *taskQueueEnv) service(convertDir string, config services.TaskQueueConfig) *services.TaskService {
+	if convertDir == "" {
+		convertDir = env.outputDir
+	}
+	storageService := storage.NewStorageService(env.uploadDir, env.outputDir, 50<<20)
+	return services.NewTaskService(storageService, services.NewConverterService(convertDir), env.db, config)
+}
+
+// blockOutput 把保存结果的目录换成文件, 保存转换结果时失败, unblockOutput恢复
+func (env *taskQueueEnv) blockOutput(t *testing.T) {
+	require.NoError(t, os.RemoveAll(env.outputDir))
+	require.NoError(t, os.WriteFile(env.outputDir, nil, 0644))
+}
+
+func (env *taskQueueEnv) unblockOutput(t *testing.T) {
+	require.NoError(t, os.Remove(env.outputDir))
+	require.NoError(t, os.Mkdir(env.outputDir, 0755))
+}
+
+// upload 模拟已上传的txt
+func (env *taskQueueEnv) upload(t *testing.T, taskID string) {
+	writeTxt(t, env.uploadDir, taskID+"_book.txt", "第1章 开始\n正文\n第2章 结束\n正文\n")
+}
+
+// queueTask 上传txt并创建任务放入队列
+func (env *taskQueueEnv) queueTask(t *testing.T, ts *services.TaskService, taskID string, userID uint, priority int) {
+	env.upload(t, taskID)
+	ts.CreateUserTask(taskID, userID, &models.ConvertRequest{Format: "epub", Priority: priority})
+	require.NoError(t, ts.StartConversion(taskID))
+}
+
+// waitTask 等待任务结束, 返回最后的状态
+func waitTask(t *testing.T, ts *services.TaskService, taskID string) *models.TaskStatusResponse {
+	var status *models.TaskStatusResponse
+	require.Eventually(t, func() bool {
+		var err error
+		status, err = ts.GetTaskStatus(taskID)
+		require.NoError(t, err)
+		return status.Status == models.TaskStatusCompleted || status.Status == models.TaskStatusFailed
+	}, 10*time.Second, 5*time.Millisecond, "任务%s没有结束", taskID)
+	return status
+}
+
+// TestTaskQueuePriority 测试按优先级执行任务
+func TestTaskQueuePriority(t *testing.T) {
+	env := newTaskQueueEnv(t)
+	ts := env.service("", services.TaskQueueConfig{Workers: 1})
+	defer ts.Stop()
+
+	// 启动前放入队列, 启动后按优先级依次执行
+	env.queueTask(t, ts, "anonymous", 0, 9)
+	env.queueTask(t, ts, "low", 1, 1)
+	env.queueTask(t, ts, "high", 2, 5)
+	require.NoError(t, ts.Start())
+
+	completed := make(map[string]time.Time)
+	for _, id := range []string{"anonymous", "low", "high"} {
+		assert.Equal(t, models.TaskStatusCompleted, waitTask(t, ts, id).Status)
+		task, _ := ts.GetTask(id)
+		completed[id] = *task.CompletedAt
+	}
+	assert.True(t, completed["high"].Before(completed["low"]), "优先级高的先执行")
+	assert.True(t, completed["low"].Before(completed["anonymous"]), "匿名任务不使用请求中的优先级")
+
+	task, _ := ts.GetTask("anonymous")
+	assert.Equal(t, 0, task.Priority)
+	task, _ = ts.GetTask("high")
+	assert.Equal(t, 5, task.Priority)
+}
+
+// TestTaskQueueRetry 测试保存文件失败后重试
+func TestTaskQueueRetry(t *testing.T) {
+	t.Run("达到最多执行次数后失败", func(t *testing.T) {
+		env := newTaskQueueEnv(t)
+		env.blockOutput(t)
+		ts := env.service(t.TempDir(), services.TaskQueueConfig{Workers: 1, MaxAttempts: 3, RetryBackoff: "1ms", MaxBackoff: "2ms"})
+		require.NoError(t, ts.Start())
+		defer ts.Stop()
+
+		env.queueTask(t, ts, "task", 1, 0)
+		status := waitTask(t, ts, "task")
+		assert.Equal(t, models.TaskStatusFailed, status.Status)
+		assert.Equal(t, 3, status.Attempts)
+		assert.Equal(t, "保存文件失败", status.Message)
+
+		var job models.ConversionJob
+		require.NoError(t, env.db.Where("task_id = ?", "task").First(&job).Error)
+		assert.Equal(t, models.TaskStatusFailed, job.Status)
+		assert.Equal(t, 3, job.Attempts)
+	})
+
+	t.Run("重试时成功", func(t *testing.T) {
+		env := newTaskQueueEnv(t)
+		env.blockOutput(t)
+		ts := env.service(t.TempDir(), services.TaskQueueConfig{Workers: 1, MaxAttempts: 3, RetryBackoff: "50ms"})
+		require.NoError(t, ts.Start())
+		defer ts.Stop()
+
+		env.queueTask(t, ts, "task", 1, 0)
+		require.Eventually(t, func() bool {
+			status, err := ts.GetTaskStatus("task")
+			require.NoError(t, err)
+			return status.Attempts == 1 && status.Status == models.TaskStatusPending
+		}, 10*time.Second, time.Millisecond, "第一次失败后应该等待重试")
+
+		// 等待重试时修复错误
+		env.unblockOutput(t)
+		status := waitTask(t, ts, "task")
+		assert.Equal(t, models.TaskStatusCompleted, status.Status)
+		assert.Equal(t, 2, status.Attempts)
+		assert.Empty(t, status.Error)
+	})
+
+	t.Run("停止后不再重试", func(t *testing.T) {
+		env := newTaskQueueEnv(t)
+		env.blockOutput(t)
+		ts := env.service(t.TempDir(), services.TaskQueueConfig{Workers: 1, MaxAttempts: 3, RetryBackoff: "200ms"})
+		require.NoError(t, ts.Start())
+
+		env.queueTask(t, ts, "task", 1, 0)
+		require.Eventually(t, func() bool {
+			status, err := ts.GetTaskStatus("task")
+			require.NoError(t, err)
+			return status.Attempts == 1 && status.Status == models.TaskStatusPending
+		}, 10*time.Second, time.Millisecond)
+		ts.Stop()
+
+		time.Sleep(300 * time.Millisecond)
+		status, err := ts.GetTaskStatus("task")
+		require.NoError(t, err)
+		assert.Equal(t, models.TaskStatusPending, status.Status, "等待重试的任务在下次启动时恢复")
+		assert.Equal(t, 1, status.Attempts)
+	})
+
+	t.Run("重试的等待时间格式错误时使用默认值", func(t *testing.T) {
+		env := newTaskQueueEnv(t)
+		env.blockOutput(t)
+		ts := env.service(t.TempDir(), services.TaskQueueConfig{Workers: 1, MaxAttempts: 2, RetryBackoff: "五秒"})
+		require.NoError(t, ts.Start())
+		defer ts.Stop()
+
+		env.queueTask(t, ts, "task", 1, 0)
+		require.Eventually(t, func() bool {
+			status, err := ts.GetTaskStatus("task")
+			require.NoError(t, err)
+			return status.Attempts == 1 && status.Status == models.TaskStatusPending
+		}, 10*time.Second, time.Millisecond)
+		status, err := ts.GetTaskStatus("task")
+		require.NoError(t, err)
+		assert.Contains(t, status.Message, "5s后第1次重试")
+	})
+}
+
+// TestTaskQueueNoRetry 测试重试也不会成功的错误直接失败
+func TestTaskQueueNoRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		blocked     bool // 生成电子书的目录是文件
+		request     models.ConvertRequest
+		wantMessage string
+	}{
+		{"参数错误", false, models.ConvertRequest{Format: "epub", TitleJunk: "("}, "文件验证失败"},
+		{"生成电子书失败", true, models.ConvertRequest{Format: "epub"}, "转换失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTaskQueueEnv(t)
+			convertDir := t.TempDir()
+			if tt.blocked {
+				convertDir = writeTxt(t, convertDir, "blocked", "")
+			}
+			ts := env.service(convertDir, services.TaskQueueConfig{Workers: 1, MaxAttempts: 3, RetryBackoff: "1ms"})
+			require.NoError(t, ts.Start())
+			defer ts.Stop()
+
+			env.upload(t, "task")
+			ts.CreateUserTask("task", 1, &tt.request)
+			require.NoError(t, ts.StartConversion("task"))
+			status := waitTask(t, ts, "task")
+			assert.Equal(t, models.TaskStatusFailed, status.Status)
+			assert.Equal(t, 1, status.Attempts, "不重试")
+			assert.Equal(t, tt.wantMessage, status.Message)
+		})
+	}
+}
+
+// TestTaskQueueCancel 测试取消任务
+func TestTaskQueueCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  services.TaskQueueConfig
+		blocked bool // 保存结果的目录是文件, 任务等待重试
+	}{
+		{"排队中的任务", services.TaskQueueConfig{Workers: 1}, false},
+		{"等待重试的任务", services.TaskQueueConfig{Workers: 1, MaxAttempts: 3, RetryBackoff: "1m"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTaskQueueEnv(t)
+			if tt.blocked {
+				env.blockOutput(t)
+			}
+			ts := env.service(t.TempDir(), tt.config)
+			defer ts.Stop()
+
+			env.queueTask(t, ts, "task", 1, 0)
+			if tt.blocked {
+				require.NoError(t, ts.Start())
+				require.Eventually(t, func() bool {
+					status, err := ts.GetTaskStatus("task")
+					require.NoError(t, err)
+					return status.Attempts == 1 && status.Status == models.TaskStatusPending
+				}, 10*time.Second, time.Millisecond)
+			}
+			ch, exists := ts.GetEventChannel("task")
+			require.True(t, exists)
+
+			require.NoError(t, ts.CancelTask("task"))
+			// 读完取消事件后通道关闭, 事件流可以结束
+			timeout := time.After(5 * time.Second)
+			var events []string
+			for closed := false; !closed; {
+				select {
+				case event, ok := <-ch:
+					if !ok {
+						closed = true
+						break
+					}
+					events = append(events, event.EventType)
+				case <-timeout:
+					t.Fatal("取消后事件通道没有关闭")
+				}
+			}
+			assert.Contains(t, events, models.EventTypeCancel)
+			_, exists = ts.GetEventChannel("task")
+			assert.False(t, exists)
+
+			status, err := ts.GetTaskStatus("task")
+			require.NoError(t, err)
+			assert.Equal(t, models.TaskStatusCancelled, status.Status)
+		})
+	}
+}
+
+// TestTaskQueueRecover 测试重启后恢复未完成的任务
+func TestTaskQueueRecover(t *testing.T) {
+	env := newTaskQueueEnv(t)
+	config := services.TaskQueueConfig{Workers: 1, MaxAttempts: 2}
+
+	// 没有启动worker, 任务停在队列中
+	first := env.service("", config)
+	env.queueTask(t, first, "queued", 1, 0)
+	first.Stop()
+
+	// 执行中被中断的任务, 其中一个已达到最多执行次数
+	env.upload(t, "interrupted")
+	for _, job := range []models.ConversionJob{
+		{TaskID: "interrupted", UserID: 1, Status: models.TaskStatusProcessing, Attempts: 1, MaxAttempts: 2,
+			Payload: models.JobPayload{Request: &models.ConvertRequest{Format: "epub"}}},
+		{TaskID: "exhausted", UserID: 1, Status: models.TaskStatusProcessing, Attempts: 2, MaxAttempts: 2,
+			Payload: models.JobPayload{Request: &models.ConvertRequest{Format: "epub"}}},
+	} {
+		require.NoError(t, env.db.Create(&job).Error)
+	}
+
+	second := env.service("", config)
+	require.NoError(t, second.Start())
+	defer second.Stop()
+
+	tests := []struct {
+		taskID   string
+		status   string
+		attempts int
+	}{
+		{"queued", models.TaskStatusCompleted, 1},
+		{"interrupted", models.TaskStatusCompleted, 2},
+		{"exhausted", models.TaskStatusFailed, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskID, func(t *testing.T) {
+			status := waitTask(t, second, tt.taskID)
+			assert.Equal(t, tt.status, status.Status)
+			assert.Equal(t, tt.attempts, status.Attempts)
+		})
+	}
+
+	t.Run("任务不存在", func(t *testing.T) {
+		_, err := second.GetTaskStatus("missing")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Deali-Axy/ebook-generator/internal/model"
+	"github.com/Deali-Axy/ebook-generator/internal/utils"
+)
+
+// TestChineseNumber 测试中文数字的解析和输出
+func TestChineseNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"一", 1, true},
+		{"十", 10, true},
+		{"十二", 12, true},
+		{"二十", 20, true},
+		{"一百零五", 105, true},
+		{"两千", 2000, true},
+		{"两百二十", 220, true},
+		{"一千零一十", 1010, true},
+		{"二〇二一", 2021, true},
+		{"零零三", 3, true},
+		{"一 百 零 五", 105, true},
+		{"三万零五", 30005, true},
+		{"十二万三千", 123000, true},
+		{"", 0, false},
+		{"万", 0, false},
+		{"一二三章", 0, false},
+		{"第一", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := utils.ParseChineseNumber(tt.input)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("输出后能解析回原数字", func(t *testing.T) {
+		for _, n := range []int{1, 10, 11, 20, 105, 110, 1010, 2000, 9999, 10000, 10010, 123456} {
+			s := utils.FormatChineseNumber(n)
+			got, ok := utils.ParseChineseNumber(s)
+			assert.True(t, ok, s)
+			assert.Equal(t, n, got, s)
+		}
+		assert.Equal(t, "十", utils.FormatChineseNumber(10))
+		assert.Equal(t, "一百零五", utils.FormatChineseNumber(105))
+		assert.Equal(t, "一万零一十", utils.FormatChineseNumber(10010))
+	})
+}
+
+// TestRomanNumber 测试罗马数字的解析和输出
+func TestRomanNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"I", 1, true},
+		{"iv", 4, true},
+		{"IX", 9, true},
+		{"XIV", 14, true},
+		{"MCMXCIV", 1994, true},
+		{"MMMCMXCIX", 3999, true},
+		{"IIII", 0, false},
+		{"IC", 0, false},
+		{"ABC", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := utils.ParseRoman(tt.input)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("超出范围时输出阿拉伯数字", func(t *testing.T) {
+		assert.Equal(t, "XL", utils.FormatRoman(40))
+		assert.Equal(t, "0", utils.FormatRoman(0))
+		assert.Equal(t, "4000", utils.FormatRoman(4000))
+	})
+}
+
+// TestTitleTemplate 测试标题模板
+func TestTitleTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"第{n}章 {title}", "第12章 标题", false},
+		{"第{n:cn}章 {title}", "第十二章 标题", false},
+		{"Chapter {n:roman}: {title}", "Chapter XII: 标题", false},
+		{"{n:03}. {title}", "012. 标题", false},
+		{"<{n}>", "&lt;12&gt;", false},
+		{"{n:hex}", "", true},
+		{"{title:cn}", "", true},
+		{"{name}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := model.ParseTitleTemplate(tt.template)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tmpl.Render(12, "标题"))
+		})
+	}
+
+	t.Run("标题为空时去掉多余的空格", func(t *testing.T) {
+		tmpl, err := model.ParseTitleTemplate("第{n}章 {title}")
+		require.NoError(t, err)
+		assert.Equal(t, "第3章", tmpl.Render(3, ""))
+	})
+}
+
+// TestNormalizeTitles 测试整理章节标题
+func TestNormalizeTitles(t *testing.T) {
+	chapters := func(titles ...string) []model.Section {
+		sections := make([]model.Section, len(titles))
+		for i, title := range titles {
+			sections[i] = model.Section{Title: title, Content: "<p>正文</p>"}
+		}
+		return sections
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(book *model.Book)
+		sections []model.Section
+		want     []string
+	}{
+		{
+			"按模板统一写法",
+			func(book *model.Book) { book.TitleTemplate = "第{n:cn}章 {title}" },
+			chapters("第1章 开始", "第 二 章", "第003章 继续 ", "Chapter4 结束", "5、尾声", "楔子"),
+			[]string{"第一章 开始", "第二章", "第三章 继续", "第四章 结束", "第五章 尾声", "楔子"},
+		},
+		{
+			"没有设置时不修改",
+			func(book *model.Book) {},
+			chapters("第1章 开始（求月票）", "第 二 章"),
+			[]string{"第1章 开始（求月票）", "第 二 章"},
+		},
+		{
+			"去掉标题末尾的无用内容",
+			func(book *model.Book) { book.TitleJunk = model.DefaultTitleJunk },
+			chapters("第1章 开始（求月票）", "第2章 继续【加更】", "第3章 结束。。。", "第4章 (上)"),
+			[]string{"第1章 开始", "第2章 继续", "第3章 结束", "第4章 (上)"},
+		},
+		{
+			"不去掉无用内容",
+			func(book *model.Book) { book.TitleJunk = "false"; book.TitleTemplate = "{n}. {title}" },
+			chapters("第1章 开始（求月票）"),
+			[]string{"1. 开始（求月票）"},
+		},
+		{
+			"自定义去除规则",
+			func(book *model.Book) { book.TitleJunk = `\s*--.*$` },
+			chapters("第1章 开始 -- 作者的话"),
+			[]string{"第1章 开始"},
+		},
+		{
+			"全书连续编号保留原来的写法",
+			func(book *model.Book) { book.Renumber = model.RenumberGlobal },
+			[]model.Section{
+				{Title: "第一卷", Sections: chapters("第一章 甲", "第五章 乙")},
+				{Title: "第二卷", Sections: chapters("第001章 丙", "番外", "Chapter IX 丁")},
+			},
+			[]string{"第一卷", "第一章 甲", "第二章 乙", "第二卷", "第003章 丙", "番外", "Chapter IV 丁"},
+		},
+		{
+			"每卷从1开始编号",
+			func(book *model.Book) { book.Renumber = model.RenumberVolume; book.TitleTemplate = "第{n}章 {title}" },
+			[]model.Section{
+				{Title: "第一卷", Sections: chapters("第10章 甲", "第11章 乙")},
+				{Title: "第三卷", Sections: chapters("第12章 丙")},
+			},
+			[]string{"第一卷", "第1章 甲", "第2章 乙", "第二卷", "第1章 丙"},
+		},
+		{
+			"卷标题模板",
+			func(book *model.Book) { book.VolumeTemplate = "卷{n:roman} {title}" },
+			[]model.Section{
+				{Title: "第一卷 起", Sections: chapters("第1章")},
+				{Title: "第二卷 承", Sections: chapters("第2章")},
+			},
+			[]string{"卷I 起", "第1章", "卷II 承", "第2章"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := &model.Book{SectionList: tt.sections}
+			tt.setup(book)
+			require.NoError(t, book.NormalizeTitles())
+			var titles []string
+			for _, section := range book.SectionList {
+				titles = append(titles, section.Title)
+				for _, sub := range section.Sections {
+					titles = append(titles, sub.Title)
+				}
+			}
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+
+	t.Run("不整理制作说明", func(t *testing.T) {
+		book := &model.Book{TitleTemplate: "{n}", SectionList: chapters("第1章")}
+		book.AddTips()
+		require.NoError(t, book.NormalizeTitles())
+		assert.Equal(t, "制作说明", book.SectionList[0].Title)
+		assert.Equal(t, "1", book.SectionList[1].Title)
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		for _, book := range []*model.Book{
+			{TitleTemplate: "{x}"},
+			{VolumeTemplate: "{n:x}"},
+			{TitleJunk: "("},
+		} {
+			assert.Error(t, book.NormalizeTitles())
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/Deali-Axy/ebook-generator/internal/batch"
+	"github.com/Deali-Axy/ebook-generator/internal/database"
+	"github.com/Deali-Axy/ebook-generator/internal/watch"
+	"github.com/Deali-Axy/ebook-generator/internal/web/services"
+)
+
+// testDB 创建并迁移内存数据库
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		DSN:        ":memory:",
+	}), &gorm.Config{})
+	require.NoError(t, err)
+	// 每个连接是单独的内存数据库, 只使用一个连接
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, database.AutoMigrate(db))
+	return db
+}
+
+// testRecorder 记录Start和Finish时的任务
+type testRecorder struct {
+	started  []string
+	finished []*watch.Job
+}
+
+func (r *testRecorder) Start(job *watch.Job) error {
+	r.started = append(r.started, filepath.Base(job.File))
+	return nil
+}
+
+func (r *testRecorder) Finish(job *watch.Job) error {
+	r.finished = append(r.finished, job)
+	return nil
+}
+
+// newTestWatcher 创建文件写完后立即转换的监视器
+func newTestWatcher(t *testing.T, inbox string, recorder watch.Recorder) *watch.Watcher {
+	w, err := watch.New(watch.Options{
+		Book:     batchBook(),
+		Inbox:    inbox,
+		Settle:   time.Nanosecond,
+		Recorder: recorder,
+	})
+	require.NoError(t, err)
+	return w
+}
+
+// scanTwice 第一次扫描记录文件的大小和修改时间, 第二次转换没有变化的文件
+func scanTwice(w *watch.Watcher) []*watch.Job {
+	w.Scan(context.Background())
+	time.Sleep(time.Millisecond)
+	return w.Scan(context.Background())
+}
+
+// TestWatchNew 测试创建监视器
+func TestWatchNew(t *testing.T) {
+	inbox := t.TempDir()
+	w, err := watch.New(watch.Options{Inbox: inbox})
+	require.NoError(t, err)
+	opts := w.Options()
+	assert.Equal(t, filepath.Join(inbox, "out"), opts.OutDir)
+	assert.Equal(t, 2*time.Second, opts.Interval)
+	assert.Equal(t, 5*time.Second, opts.Settle)
+	for _, dir := range []string{"out", "done", "failed"} {
+		assert.DirExists(t, filepath.Join(inbox, dir))
+	}
+
+	file := writeTxt(t, inbox, "a.txt", "")
+	for name, inbox := range map[string]string{
+		"没有收件目录": "",
+		"目录不存在":  filepath.Join(inbox, "不存在"),
+		"不是目录":   file,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := watch.New(watch.Options{Inbox: inbox})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestWatchScan 测试转换收件目录中的txt
+func TestWatchScan(t *testing.T) {
+	t.Run("写完后转换并移到done目录", func(t *testing.T) {
+		inbox := t.TempDir()
+		recorder := &testRecorder{}
+		w := newTestWatcher(t, inbox, recorder)
+		writeTxt(t, inbox, "甲.txt", "第1章 开始\n正文\n")
+		writeTxt(t, inbox, ".隐藏.txt", "第1章 开始\n正文\n")
+		writeTxt(t, inbox, "说明.md", "不是txt")
+
+		assert.Empty(t, w.Scan(context.Background()), "第一次发现文件时可能还没写完")
+		time.Sleep(time.Millisecond)
+		jobs := w.Scan(context.Background())
+		require.Len(t, jobs, 1)
+		job := jobs[0]
+		assert.Equal(t, batch.StatusSuccess, job.Result.Status)
+		assert.Equal(t, filepath.Join(inbox, "done", "甲.txt"), job.Moved)
+		assert.NotEmpty(t, job.Hash)
+		assert.FileExists(t, filepath.Join(inbox, "out", "甲.epub"))
+		assert.NoFileExists(t, filepath.Join(inbox, "甲.txt"))
+		assert.FileExists(t, filepath.Join(inbox, ".隐藏.txt"), "隐藏文件不转换")
+		assert.FileExists(t, filepath.Join(inbox, "说明.md"))
+
+		assert.Equal(t, []string{"甲.txt"}, recorder.started)
+		require.Len(t, recorder.finished, 1)
+		assert.Same(t, job, recorder.finished[0])
+	})
+
+	t.Run("文件还在写入时不转换", func(t *testing.T) {
+		inbox := t.TempDir()
+		w := newTestWatcher(t, inbox, nil)
+		path := writeTxt(t, inbox, "甲.txt", "第1章 开始\n")
+		w.Scan(context.Background())
+		require.NoError(t, os.WriteFile(path, []byte("第1章 开始\n正文\n"), 0644))
+		assert.Empty(t, w.Scan(context.Background()), "大小变化后重新等待")
+		time.Sleep(time.Millisecond)
+		assert.Len(t, w.Scan(context.Background()), 1)
+	})
+
+	t.Run("失败时移到failed目录并保存原因", func(t *testing.T) {
+		inbox := t.TempDir()
+		book := batchBook()
+		book.Match = "("
+		w, err := watch.New(watch.Options{Book: book, Inbox: inbox, Settle: time.Nanosecond})
+		require.NoError(t, err)
+		writeTxt(t, inbox, "甲.txt", "第1章 开始\n正文\n")
+
+		jobs := scanTwice(w)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, batch.StatusFailed, jobs[0].Result.Status)
+		assert.Equal(t, filepath.Join(inbox, "failed", "甲.txt"), jobs[0].Moved)
+		reason, err := os.ReadFile(filepath.Join(inbox, "failed", "甲.log"))
+		require.NoError(t, err)
+		assert.Contains(t, string(reason), "生成匹配规则出错")
+	})
+
+	t.Run("重名时不覆盖done中的文件", func(t *testing.T) {
+		inbox := t.TempDir()
+		w := newTestWatcher(t, inbox, nil)
+		writeTxt(t, inbox, "done/甲.txt", "旧文件")
+		writeTxt(t, inbox, "甲.txt", "第1章 开始\n正文\n")
+
+		jobs := scanTwice(w)
+		require.Len(t, jobs, 1)
+		assert.NotEqual(t, filepath.Join(inbox, "done", "甲.txt"), jobs[0].Moved)
+		assert.FileExists(t, jobs[0].Moved)
+		old, err := os.ReadFile(filepath.Join(inbox, "done", "甲.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "旧文件", string(old))
+	})
+
+	t.Run("停止后不再转换", func(t *testing.T) {
+		inbox := t.TempDir()
+		w := newTestWatcher(t, inbox, nil)
+		w.Start(context.Background())
+		w.Stop()
+		writeTxt(t, inbox, "甲.txt", "第1章 开始\n正文\n")
+		time.Sleep(10 * time.Millisecond)
+		assert.FileExists(t, filepath.Join(inbox, "甲.txt"))
+	})
+}
+
+// TestWatchHistoryRecorder 测试把收件目录的转换记录到转换历史
+func TestWatchHistoryRecorder(t *testing.T) {
+	history := services.NewHistoryService(testDB(t))
+
+	t.Run("没有用户ID", func(t *testing.T) {
+		_, err := watch.NewHistoryRecorder(history, 0, "epub", nil)
+		assert.ErrorIs(t, err, watch.ErrNoUser)
+	})
+
+	tests := []struct {
+		name   string
+		match  string
+		status string
+	}{
+		{"转换成功", "", "completed"},
+		{"转换失败", "(", "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, err := watch.NewHistoryRecorder(history, 7, "epub", map[string]interface{}{"format": "epub"})
+			require.NoError(t, err)
+			inbox := t.TempDir()
+			book := batchBook()
+			book.Match = tt.match
+			w, err := watch.New(watch.Options{Book: book, Inbox: inbox, Settle: time.Nanosecond, Recorder: recorder})
+			require.NoError(t, err)
+			writeTxt(t, inbox, "甲.txt", "第1章 开始\n正文\n")
+
+			jobs := scanTwice(w)
+			require.Len(t, jobs, 1)
+			record, err := history.GetHistoryByTaskID(jobs[0].ID)
+			require.NoError(t, err)
+			assert.Equal(t, uint(7), record.UserID)
+			assert.Equal(t, "甲.txt", record.OriginalFileName)
+			assert.Equal(t, jobs[0].Hash, record.OriginalFileHash)
+			assert.Equal(t, tt.status, record.Status)
+			if tt.status == "completed" {
+				assert.Equal(t, "甲.epub", record.OutputFileName)
+				assert.NotZero(t, record.OutputFileSize)
+			} else {
+				assert.NotEmpty(t, record.ErrorMessage)
+			}
+		})
+	}
+}